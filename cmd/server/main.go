@@ -3,6 +3,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"math/rand"
@@ -19,6 +20,7 @@ import (
 const defaultRootID bptree2.RootID = 0
 
 // Server holds the BPTree instance and provides HTTP handlers.
+// The tree does its own locking; mu only guards opening and closing it.
 type Server struct {
 	tree   *bptree2.BPTree
 	path   string
@@ -54,6 +56,23 @@ type InsertRequest struct {
 	Value uint64 `json:"value"`
 }
 
+// SwapResult is the response of the PUTIFABSENT, REPLACE and SWAP operations.
+// Old is the value the key held before, if Found is set.
+type SwapResult struct {
+	Key1  uint64 `json:"key1"`
+	Key2  uint64 `json:"key2"`
+	Value uint64 `json:"value"`
+	Old   uint64 `json:"old"`
+	Found bool   `json:"found"`
+}
+
+// TreeInfo describes a named tree.
+type TreeInfo struct {
+	Name   string `json:"name"`
+	RootID uint64 `json:"rootId"`
+	Count  int    `json:"count"`
+}
+
 // OpenRequest is the request body for opening a database.
 type OpenRequest struct {
 	Path string `json:"path"`
@@ -117,10 +136,14 @@ func main() {
 	http.HandleFunc("/api/find", corsHandler(server.handleFind))
 	http.HandleFunc("/api/insert", corsHandler(server.handleInsert))
 	http.HandleFunc("/api/delete", corsHandler(server.handleDelete))
+	http.HandleFunc("/api/putifabsent", corsHandler(server.handlePutIfAbsent))
+	http.HandleFunc("/api/replace", corsHandler(server.handleReplace))
+	http.HandleFunc("/api/swap", corsHandler(server.handleSwap))
 	http.HandleFunc("/api/findrange", corsHandler(server.handleFindRange))
 	http.HandleFunc("/api/flash", corsHandler(server.handleFlash))
 	http.HandleFunc("/api/count", corsHandler(server.handleCount))
 	http.HandleFunc("/api/benchmark", corsHandler(server.handleBenchmark))
+	http.HandleFunc("/api/trees", corsHandler(server.handleTrees))
 
 	// Legacy endpoints for backward compatibility
 	http.HandleFunc("/api/get", corsHandler(server.handleFind))
@@ -147,7 +170,12 @@ func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if s.tree != nil {
-		status.Count = s.tree.Count(s.rootID)
+		count, err := s.tree.Count(s.rootID)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, Response{Error: fmt.Sprintf("count failed: %v", err)})
+			return
+		}
+		status.Count = count
 	}
 
 	writeJSON(w, http.StatusOK, Response{Success: true, Data: status})
@@ -200,12 +228,18 @@ func (s *Server) handleOpen(w http.ResponseWriter, r *http.Request) {
 	s.path = req.Path
 	s.rootID = rootID
 
+	count, err := tree.Count(rootID)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, Response{Error: fmt.Sprintf("count failed: %v", err)})
+		return
+	}
+
 	writeJSON(w, http.StatusOK, Response{
 		Success: true,
 		Data: StatusResponse{
 			Connected: true,
 			Path:      req.Path,
-			Count:     tree.Count(rootID),
+			Count:     count,
 		},
 	})
 }
@@ -268,7 +302,16 @@ func (s *Server) handleFind(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	val, found := s.tree.Find(s.rootID, key1, key2)
+	rootID, ok := s.selectRoot(w, r)
+	if !ok {
+		return
+	}
+
+	val, found, err := s.tree.Find(rootID, key1, key2)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, Response{Error: fmt.Sprintf("find failed: %v", err)})
+		return
+	}
 	if !found {
 		writeJSON(w, http.StatusNotFound, Response{Error: "key not found"})
 		return
@@ -292,15 +335,20 @@ func (s *Server) handleInsert(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
 	if s.tree == nil {
 		writeJSON(w, http.StatusBadRequest, Response{Error: "no database open"})
 		return
 	}
 
-	if err := s.tree.Insert(s.rootID, req.Key1, req.Key2, req.Value); err != nil {
+	rootID, ok := s.selectRoot(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.tree.Insert(rootID, req.Key1, req.Key2, req.Value); err != nil {
 		writeJSON(w, http.StatusInternalServerError, Response{Error: fmt.Sprintf("insert failed: %v", err)})
 		return
 	}
@@ -317,6 +365,74 @@ func (s *Server) handleInsert(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+func (s *Server) handlePutIfAbsent(w http.ResponseWriter, r *http.Request) {
+	s.handleSwapWrite(w, r, func(rootID bptree2.RootID, req InsertRequest) (uint64, bool, error) {
+		return s.tree.PutIfAbsent(rootID, req.Key1, req.Key2, req.Value)
+	})
+}
+
+func (s *Server) handleReplace(w http.ResponseWriter, r *http.Request) {
+	s.handleSwapWrite(w, r, func(rootID bptree2.RootID, req InsertRequest) (uint64, bool, error) {
+		old, err := s.tree.Replace(rootID, req.Key1, req.Key2, req.Value)
+		return old, err == nil, err
+	})
+}
+
+func (s *Server) handleSwap(w http.ResponseWriter, r *http.Request) {
+	s.handleSwapWrite(w, r, func(rootID bptree2.RootID, req InsertRequest) (uint64, bool, error) {
+		return s.tree.Swap(rootID, req.Key1, req.Key2, req.Value)
+	})
+}
+
+// handleSwapWrite decodes an InsertRequest, runs op on the selected root and
+// reports the old value. op returns the old value and whether the key existed.
+func (s *Server) handleSwapWrite(w http.ResponseWriter, r *http.Request, op func(rootID bptree2.RootID, req InsertRequest) (uint64, bool, error)) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, Response{Error: "method not allowed"})
+		return
+	}
+
+	var req InsertRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, Response{Error: "invalid request body"})
+		return
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.tree == nil {
+		writeJSON(w, http.StatusBadRequest, Response{Error: "no database open"})
+		return
+	}
+
+	rootID, ok := s.selectRoot(w, r)
+	if !ok {
+		return
+	}
+
+	old, found, err := op(rootID, req)
+	if errors.Is(err, bptree2.ErrKeyNotFound) {
+		writeJSON(w, http.StatusNotFound, Response{Error: "key not found"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, Response{Error: fmt.Sprintf("write failed: %v", err)})
+		return
+	}
+
+	// Auto-flash to ensure data is persisted
+	if err := s.tree.Flash(); err != nil {
+		writeJSON(w, http.StatusInternalServerError, Response{Error: fmt.Sprintf("flash failed: %v", err)})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, Response{
+		Success: true,
+		Data:    SwapResult{Key1: req.Key1, Key2: req.Key2, Value: req.Value, Old: old, Found: found},
+	})
+}
+
 func (s *Server) handleDelete(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodDelete {
 		writeJSON(w, http.StatusMethodNotAllowed, Response{Error: "method not allowed"})
@@ -342,15 +458,24 @@ func (s *Server) handleDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
 	if s.tree == nil {
 		writeJSON(w, http.StatusBadRequest, Response{Error: "no database open"})
 		return
 	}
 
-	deleted := s.tree.Delete(s.rootID, key1, key2)
+	rootID, ok := s.selectRoot(w, r)
+	if !ok {
+		return
+	}
+
+	deleted, err := s.tree.Delete(rootID, key1, key2)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, Response{Error: fmt.Sprintf("delete failed: %v", err)})
+		return
+	}
 
 	// Auto-flash to ensure data is persisted
 	if deleted {
@@ -414,8 +539,13 @@ func (s *Server) handleFindRange(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	rootID, ok := s.selectRoot(w, r)
+	if !ok {
+		return
+	}
+
 	var items []KeyValue
-	err = s.tree.FindRange(s.rootID, start1, start2, end1, end2, func(key1, key2, value uint64) bool {
+	err = s.tree.FindRange(rootID, start1, start2, end1, end2, func(key1, key2, value uint64) bool {
 		items = append(items, KeyValue{Key1: key1, Key2: key2, Value: value})
 		return true
 	})
@@ -437,8 +567,8 @@ func (s *Server) handleFlash(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
 	if s.tree == nil {
 		writeJSON(w, http.StatusBadRequest, Response{Error: "no database open"})
@@ -467,7 +597,16 @@ func (s *Server) handleCount(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	count := s.tree.Count(s.rootID)
+	rootID, ok := s.selectRoot(w, r)
+	if !ok {
+		return
+	}
+
+	count, err := s.tree.Count(rootID)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, Response{Error: fmt.Sprintf("count failed: %v", err)})
+		return
+	}
 	writeJSON(w, http.StatusOK, Response{
 		Success: true,
 		Data:    map[string]int{"count": count},
@@ -496,14 +635,19 @@ func (s *Server) handleBenchmark(w http.ResponseWriter, r *http.Request) {
 		req.Key2Range = 1000000
 	}
 
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
 	if s.tree == nil {
 		writeJSON(w, http.StatusBadRequest, Response{Error: "no database open"})
 		return
 	}
 
+	rootID, ok := s.selectRoot(w, r)
+	if !ok {
+		return
+	}
+
 	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
 
 	// Generate random keys for testing (key1 and key2 with separate ranges)
@@ -517,7 +661,7 @@ func (s *Server) handleBenchmark(w http.ResponseWriter, r *http.Request) {
 	// Benchmark Insert
 	insertStart := time.Now()
 	for i := 0; i < len(keys1); i++ {
-		if err := s.tree.Insert(s.rootID, keys1[i], keys2[i], uint64(i)); err != nil {
+		if err := s.tree.Insert(rootID, keys1[i], keys2[i], uint64(i)); err != nil {
 			writeJSON(w, http.StatusInternalServerError, Response{Error: fmt.Sprintf("insert failed at %d: %v", i, err)})
 			return
 		}
@@ -528,12 +672,23 @@ func (s *Server) handleBenchmark(w http.ResponseWriter, r *http.Request) {
 	hits := 0
 	searchStart := time.Now()
 	for i := 0; i < len(keys1); i++ {
-		if _, found := s.tree.Find(s.rootID, keys1[i], keys2[i]); found {
+		_, found, err := s.tree.Find(rootID, keys1[i], keys2[i])
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, Response{Error: fmt.Sprintf("search failed at %d: %v", i, err)})
+			return
+		}
+		if found {
 			hits++
 		}
 	}
 	searchDuration := time.Since(searchStart)
 
+	finalCount, err := s.tree.Count(rootID)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, Response{Error: fmt.Sprintf("count failed: %v", err)})
+		return
+	}
+
 	// Calculate metrics
 	insertTotalMs := float64(insertDuration.Microseconds()) / 1000.0
 	searchTotalMs := float64(searchDuration.Microseconds()) / 1000.0
@@ -548,8 +703,103 @@ func (s *Server) handleBenchmark(w http.ResponseWriter, r *http.Request) {
 		SearchAvgUs:     float64(searchDuration.Microseconds()) / float64(req.Count),
 		SearchOpsPerSec: float64(req.Count) / searchDuration.Seconds(),
 		SearchHitRate:   float64(hits) / float64(req.Count) * 100,
-		FinalCount:      s.tree.Count(s.rootID),
+		FinalCount:      finalCount,
 	}
 
 	writeJSON(w, http.StatusOK, Response{Success: true, Data: result})
 }
+
+func (s *Server) handleTrees(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.tree == nil {
+		writeJSON(w, http.StatusBadRequest, Response{Error: "no database open"})
+		return
+	}
+
+	name := r.URL.Query().Get("tree")
+	if r.Method != http.MethodGet && name == "" {
+		writeJSON(w, http.StatusBadRequest, Response{Error: "tree is required"})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		names, err := s.tree.ListTrees()
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, Response{Error: fmt.Sprintf("list failed: %v", err)})
+			return
+		}
+		trees := make([]TreeInfo, 0, len(names))
+		for _, name := range names {
+			rootID, err := s.tree.OpenTree(name)
+			if err != nil {
+				continue // Dropped meanwhile
+			}
+			count, err := s.tree.Count(rootID)
+			if err != nil {
+				writeJSON(w, http.StatusInternalServerError, Response{Error: fmt.Sprintf("count failed: %v", err)})
+				return
+			}
+			trees = append(trees, TreeInfo{Name: name, RootID: rootID, Count: count})
+		}
+		writeJSON(w, http.StatusOK, Response{Success: true, Data: trees})
+
+	case http.MethodPost:
+		rootID, err := s.tree.CreateTree(name)
+		if errors.Is(err, bptree2.ErrTreeExists) {
+			writeJSON(w, http.StatusConflict, Response{Error: fmt.Sprintf("tree %q already exists", name)})
+			return
+		}
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, Response{Error: fmt.Sprintf("create failed: %v", err)})
+			return
+		}
+		if err := s.tree.Flash(); err != nil {
+			writeJSON(w, http.StatusInternalServerError, Response{Error: fmt.Sprintf("flash failed: %v", err)})
+			return
+		}
+		writeJSON(w, http.StatusOK, Response{Success: true, Data: TreeInfo{Name: name, RootID: rootID}})
+
+	case http.MethodDelete:
+		err := s.tree.DropTree(name)
+		if errors.Is(err, bptree2.ErrTreeNotFound) {
+			writeJSON(w, http.StatusNotFound, Response{Error: fmt.Sprintf("tree %q not found", name)})
+			return
+		}
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, Response{Error: fmt.Sprintf("drop failed: %v", err)})
+			return
+		}
+		if err := s.tree.Flash(); err != nil {
+			writeJSON(w, http.StatusInternalServerError, Response{Error: fmt.Sprintf("flash failed: %v", err)})
+			return
+		}
+		writeJSON(w, http.StatusOK, Response{Success: true})
+
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, Response{Error: "method not allowed"})
+	}
+}
+
+// selectRoot returns the root a request works on: the named tree given by
+// the tree query parameter, or the default root. If there is no such tree
+// it writes an error response and returns false. s.mu must be held.
+func (s *Server) selectRoot(w http.ResponseWriter, r *http.Request) (bptree2.RootID, bool) {
+	name := r.URL.Query().Get("tree")
+	if name == "" {
+		return s.rootID, true
+	}
+
+	rootID, err := s.tree.OpenTree(name)
+	if errors.Is(err, bptree2.ErrTreeNotFound) {
+		writeJSON(w, http.StatusNotFound, Response{Error: fmt.Sprintf("tree %q not found", name)})
+		return 0, false
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, Response{Error: fmt.Sprintf("failed to open tree: %v", err)})
+		return 0, false
+	}
+	return rootID, true
+}
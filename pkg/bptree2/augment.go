@@ -0,0 +1,174 @@
+package bptree2
+
+import (
+	"errors"
+	"fmt"
+
+	"bptree2/bnode"
+	"bptree2/bpager"
+)
+
+// ErrNotAugmented is returned when an aggregate is asked of a root that was
+// not created with CreateAugmentedRoot.
+var ErrNotAugmented = errors.New("root is not augmented")
+
+// Aggregate summarizes the values of a key range.
+// Min and Max are 0 if Count is 0. Sum wraps around on overflow.
+// The value of a blob entry is the ID of its first overflow page.
+type Aggregate struct {
+	Count int
+	Sum   uint64
+	Min   uint64
+	Max   uint64
+}
+
+// CreateAugmentedRoot creates a new, augmented root tree and returns its ID.
+// The internal nodes of an augmented tree keep the sum, minimum and maximum
+// of the values in each child subtree, so that Aggregate runs in O(log n).
+// They hold fewer keys than those of a plain tree, which makes the tree
+// somewhat deeper.
+func (t *BPTree) CreateAugmentedRoot() (RootID, error) {
+	var rootID RootID
+	err := t.update(func(tx *Tx) (err error) {
+		if rootID, err = tx.pages.CreateRoot(); err != nil {
+			return err
+		}
+		return tx.createAugmentedLeaf(rootID)
+	})
+	return rootID, err
+}
+
+// Aggregate returns the count, sum, minimum and maximum of the values where
+// (start1,start2) <= (key1,key2) <= (end1,end2).
+// This is an O(log n) operation on a root created with CreateAugmentedRoot;
+// other roots return ErrNotAugmented.
+func (t *BPTree) Aggregate(rootID RootID, start1, start2, end1, end2 uint64) (Aggregate, error) {
+	var agg Aggregate
+	err := t.view(func(tx *Tx) (err error) {
+		agg, err = tx.Aggregate(rootID, start1, start2, end1, end2)
+		return err
+	})
+	return agg, err
+}
+
+// Aggregate returns the count, sum, minimum and maximum of the values where
+// (start1,start2) <= (key1,key2) <= (end1,end2). See BPTree.Aggregate.
+func (tx *Tx) Aggregate(rootID RootID, start1, start2, end1, end2 uint64) (_ Aggregate, err error) {
+	if err := tx.acquire(); err != nil {
+		return Aggregate{}, err
+	}
+	defer tx.release()
+	defer recoverCorrupt(&err)
+
+	rootPageID := tx.rootPage(rootID)
+	if rootPageID == 0 || !bnode.IsAugmented(tx.page(rootPageID)) {
+		return Aggregate{}, ErrNotAugmented
+	}
+	if err := tx.checkKeyType(rootPageID, false); err != nil {
+		return Aggregate{}, err
+	}
+
+	start := Key{Key1: start1, Key2: start2}
+	end := Key{Key1: end1, Key2: end2}
+	if start.Compare(end) > 0 {
+		return Aggregate{}, nil
+	}
+
+	s, err := tx.aggregate(rootPageID, &start, &end)
+	if err != nil {
+		return Aggregate{}, err
+	}
+	if s.Count == 0 {
+		return Aggregate{}, nil
+	}
+	return Aggregate{Count: int(s.Count), Sum: s.Sum, Min: s.Min, Max: s.Max}, nil
+}
+
+// aggregate summarizes the entries of the subtree at pageID between low and
+// high. A nil bound is open. Only the children holding a bound are descended
+// into; those strictly between them are summarized by their parent.
+func (tx *Tx) aggregate(pageID bpager.PageID, low, high *Key) (bnode.Stats, error) {
+	data := tx.page(pageID)
+	if data == nil {
+		return bnode.Stats{}, fmt.Errorf("failed to read page %d", pageID)
+	}
+
+	if bnode.GetNodeType(data) == bnode.NodeTypeLeaf {
+		leaf := bnode.NewLeafNode(data, false)
+		var s bnode.Stats
+		for i := 0; i < leaf.KeyCount(); i++ {
+			key := leaf.GetKeyAt(i)
+			if low != nil && key.Compare(*low) < 0 {
+				continue
+			}
+			if high != nil && key.Compare(*high) > 0 {
+				break
+			}
+			s = s.Add(leaf.GetValueAt(i))
+		}
+		return s, nil
+	}
+
+	internal := bnode.NewInternalNode(data, false)
+	if low == nil && high == nil {
+		return internal.TotalStats(), nil
+	}
+
+	first, last := 0, internal.KeyCount()
+	if low != nil {
+		first = internal.Search(low.Key1, low.Key2)
+	}
+	if high != nil {
+		last = internal.Search(high.Key1, high.Key2)
+	}
+	if first == last {
+		return tx.aggregate(internal.GetChild(first), low, high)
+	}
+
+	s, err := tx.aggregate(internal.GetChild(first), low, nil)
+	if err != nil {
+		return bnode.Stats{}, err
+	}
+	for i := first + 1; i < last; i++ {
+		s = s.Merge(internal.GetStats(i))
+	}
+	right, err := tx.aggregate(internal.GetChild(last), nil, high)
+	if err != nil {
+		return bnode.Stats{}, err
+	}
+	return s.Merge(right), nil
+}
+
+// createAugmentedLeaf makes an empty, augmented leaf the root page of rootID.
+// An augmented root always keeps a page, since its nodes carry the mode.
+func (tx *Tx) createAugmentedLeaf(rootID RootID) error {
+	pageID, err := tx.pages.AllocatePage()
+	if err != nil {
+		return fmt.Errorf("failed to allocate root: %w", err)
+	}
+	data := tx.pageForWrite(pageID)
+	bnode.NewLeafNode(data, true)
+	bnode.SetAugmented(data, true)
+	return tx.pages.SetRootPage(rootID, pageID)
+}
+
+// nodeStats returns the summary of the subtree of a page.
+// Leaves of plain trees report only their count.
+func (tx *Tx) nodeStats(pageID bpager.PageID) bnode.Stats {
+	data := tx.page(pageID)
+	if data == nil {
+		return bnode.Stats{}
+	}
+	if bnode.GetNodeType(data) != bnode.NodeTypeLeaf {
+		return bnode.NewInternalNode(data, false).TotalStats()
+	}
+	if bnode.IsAugmented(data) {
+		return bnode.NewLeafNode(data, false).Stats()
+	}
+	return bnode.Stats{Count: uint64(bnode.GetKeyCount(data))}
+}
+
+// syncChild refreshes the summary of child i of a writable parent.
+func (tx *Tx) syncChild(parent *bnode.InternalNode, i int) {
+	parent.SetStats(i, tx.nodeStats(parent.GetChild(i)))
+}
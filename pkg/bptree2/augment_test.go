@@ -0,0 +1,140 @@
+package bptree2_test
+
+import (
+	"bptree2"
+	"errors"
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+// aggregateModel computes the expected aggregate of a key range by brute force.
+func aggregateModel(model map[bptree2.Key]uint64, start, end bptree2.Key) bptree2.Aggregate {
+	var agg bptree2.Aggregate
+	for key, value := range model {
+		if key.Compare(start) < 0 || key.Compare(end) > 0 {
+			continue
+		}
+		if agg.Count == 0 || value < agg.Min {
+			agg.Min = value
+		}
+		if agg.Count == 0 || value > agg.Max {
+			agg.Max = value
+		}
+		agg.Count++
+		agg.Sum += value
+	}
+	return agg
+}
+
+func TestAggregate(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer tree.Close()
+
+	rootID, err := tree.CreateAugmentedRoot()
+	if err != nil {
+		t.Fatalf("CreateAugmentedRoot failed: %v", err)
+	}
+	if agg, err := tree.Aggregate(rootID, 0, 0, ^uint64(0), ^uint64(0)); err != nil || agg != (bptree2.Aggregate{}) {
+		t.Fatalf("empty root: got %+v, %v", agg, err)
+	}
+
+	// Inserts, deletes and batches change the aggregates along different paths
+	rng := rand.New(rand.NewSource(1))
+	model := make(map[bptree2.Key]uint64)
+	check := func(name string) {
+		t.Helper()
+		for i := 0; i < 200; i++ {
+			start := bptree2.Key{Key1: uint64(rng.Intn(50)), Key2: uint64(rng.Intn(2000))}
+			end := bptree2.Key{Key1: uint64(rng.Intn(50)), Key2: uint64(rng.Intn(2000))}
+			agg, err := tree.Aggregate(rootID, start.Key1, start.Key2, end.Key1, end.Key2)
+			if err != nil {
+				t.Fatalf("%s: Aggregate failed: %v", name, err)
+			}
+			if expected := aggregateModel(model, start, end); agg != expected {
+				t.Fatalf("%s: Aggregate(%v, %v) = %+v, expected %+v", name, start, end, agg, expected)
+			}
+		}
+	}
+
+	for round := 0; round < 10; round++ {
+		for i := 0; i < 3000; i++ {
+			key := bptree2.Key{Key1: uint64(rng.Intn(50)), Key2: uint64(rng.Intn(2000))}
+			if rng.Intn(3) == 0 {
+				if deleted, err := tree.Delete(rootID, key.Key1, key.Key2); err != nil || deleted {
+					delete(model, key)
+				}
+				continue
+			}
+			value := uint64(rng.Intn(1_000_000))
+			tree.Insert(rootID, key.Key1, key.Key2, value)
+			model[key] = value
+		}
+
+		batch := &bptree2.Batch{}
+		for i := 0; i < 1000; i++ {
+			key := bptree2.Key{Key1: uint64(rng.Intn(50)), Key2: uint64(rng.Intn(2000))}
+			if rng.Intn(2) == 0 {
+				batch.Delete(key.Key1, key.Key2)
+				delete(model, key)
+			} else {
+				value := uint64(rng.Intn(1_000_000))
+				batch.Put(key.Key1, key.Key2, value)
+				model[key] = value
+			}
+		}
+		if _, err := tree.ApplyBatch(rootID, batch); err != nil {
+			t.Fatalf("ApplyBatch failed: %v", err)
+		}
+		check("random")
+	}
+
+	// Deleting everything shrinks the tree but keeps the root augmented
+	for key := range model {
+		tree.Delete(rootID, key.Key1, key.Key2)
+		delete(model, key)
+		if len(model)%5000 == 0 {
+			check("shrink")
+		}
+	}
+	if agg, err := tree.Aggregate(rootID, 0, 0, ^uint64(0), ^uint64(0)); err != nil || agg.Count != 0 {
+		t.Fatalf("emptied root: got %+v, %v", agg, err)
+	}
+	tree.Insert(rootID, 1, 1, 42)
+	model[bptree2.Key{Key1: 1, Key2: 1}] = 42
+	check("refill")
+
+	// A bulk load replacing the root keeps it augmented
+	clear(model)
+	for key, value := range sequence(100000, 1) {
+		model[key] = value
+	}
+	if err := tree.BulkLoad(rootID, sequence(100000, 1), &bptree2.BulkLoadOptions{Replace: true}); err != nil {
+		t.Fatalf("BulkLoad failed: %v", err)
+	}
+	agg, err := tree.Aggregate(rootID, 0, 0, ^uint64(0), ^uint64(0))
+	if err != nil || agg != (bptree2.Aggregate{Count: 100000, Sum: 99999 * 100000 / 2, Min: 0, Max: 99999}) {
+		t.Fatalf("bulk loaded root: got %+v, %v", agg, err)
+	}
+	for i := 0; i < 200; i++ {
+		start := bptree2.Key{Key1: uint64(rng.Intn(100000)), Key2: uint64(rng.Intn(100000))}
+		end := bptree2.Key{Key1: uint64(rng.Intn(100000)), Key2: uint64(rng.Intn(100000))}
+		agg, _ := tree.Aggregate(rootID, start.Key1, start.Key2, end.Key1, end.Key2)
+		if expected := aggregateModel(model, start, end); agg != expected {
+			t.Fatalf("bulk: Aggregate(%v, %v) = %+v, expected %+v", start, end, agg, expected)
+		}
+	}
+
+	// Plain roots have no aggregates
+	plainID, _ := tree.CreateRoot()
+	tree.Insert(plainID, 1, 1, 1)
+	if _, err := tree.Aggregate(plainID, 0, 0, 10, 10); !errors.Is(err, bptree2.ErrNotAugmented) {
+		t.Errorf("expected ErrNotAugmented, got %v", err)
+	}
+}
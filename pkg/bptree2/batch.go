@@ -0,0 +1,239 @@
+package bptree2
+
+import (
+	"fmt"
+	"slices"
+
+	"bptree2/bnode"
+	"bptree2/bpager"
+)
+
+// Batch collects puts and deletes to be applied to a root tree at once.
+// The zero value is an empty batch ready to use.
+type Batch struct {
+	ops []batchOp
+}
+
+// batchOp is a single operation of a batch.
+type batchOp struct {
+	key    Key
+	value  uint64
+	delete bool
+	index  int // position in the batch, for the results
+}
+
+// BatchResult is the outcome of one batch operation.
+type BatchResult struct {
+	// Found reports whether the key existed before the operation: for a
+	// delete, whether it was removed; for a put, whether it was an update.
+	Found bool
+}
+
+// Put adds an insert or update of a key-value pair to the batch.
+func (b *Batch) Put(key1, key2, value uint64) {
+	b.ops = append(b.ops, batchOp{key: Key{Key1: key1, Key2: key2}, value: value, index: len(b.ops)})
+}
+
+// Delete adds the removal of a key to the batch.
+func (b *Batch) Delete(key1, key2 uint64) {
+	b.ops = append(b.ops, batchOp{key: Key{Key1: key1, Key2: key2}, delete: true, index: len(b.ops)})
+}
+
+// Len returns the number of operations in the batch.
+func (b *Batch) Len() int {
+	return len(b.ops)
+}
+
+// Reset empties the batch so that it can be reused.
+func (b *Batch) Reset() {
+	b.ops = b.ops[:0]
+}
+
+// ApplyBatch applies all operations of a batch to a root tree in a single
+// transaction. See Tx.ApplyBatch.
+func (t *BPTree) ApplyBatch(rootID RootID, b *Batch) ([]BatchResult, error) {
+	var results []BatchResult
+	err := t.update(func(tx *Tx) (err error) {
+		results, err = tx.ApplyBatch(rootID, b)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// ApplyBatch applies all operations of a batch to a root tree.
+//
+// The operations are sorted by key and applied in one ordered pass:
+// consecutive keys that belong to the same leaf reuse the path found for the
+// previous key instead of descending from the root again. Operations on the
+// same key take effect in the order they were added. The results are in the
+// order the operations were added.
+// If ApplyBatch fails the transaction should be rolled back.
+func (tx *Tx) ApplyBatch(rootID RootID, b *Batch) (_ []BatchResult, err error) {
+	defer recoverCorrupt(&err)
+	if err := tx.checkWritable(); err != nil {
+		return nil, err
+	}
+	if rootPageID := tx.rootPage(rootID); rootPageID != 0 {
+		if err := tx.checkKeyType(rootPageID, false); err != nil {
+			return nil, err
+		}
+	}
+
+	ops := slices.Clone(b.ops)
+	slices.SortStableFunc(ops, func(a, b batchOp) int {
+		return a.key.Compare(b.key)
+	})
+
+	results := make([]BatchResult, len(ops))
+	var cur batchLeaf
+
+	for _, op := range ops {
+		key1, key2 := op.key.Key1, op.key.Key2
+		if !cur.covers(op.key) {
+			var err error
+			if cur, err = tx.batchDescend(rootID, op.key); err != nil {
+				return nil, err
+			}
+		}
+
+		// Fast path: the cached leaf takes the change without a split or underflow
+		if cur.leaf != nil {
+			idx, found := cur.leaf.Search(key1, key2)
+			if op.delete {
+				if !found {
+					continue
+				}
+				if cur.leaf.KeyCount() > bnode.MinLeafKeys || (cur.isRoot && cur.leaf.KeyCount() > 1) {
+					if err := tx.freeValueAt(cur.leaf, idx); err != nil {
+						return nil, err
+					}
+					cur.writable(tx).Delete(key1, key2)
+					cur.syncPath(tx)
+					results[op.index].Found = true
+					continue
+				}
+			} else if found || !cur.leaf.IsFull() {
+				if found {
+					if err := tx.freeValueAt(cur.leaf, idx); err != nil {
+						return nil, err
+					}
+				}
+				cur.writable(tx).Put(key1, key2, op.value)
+				cur.syncPath(tx)
+				results[op.index].Found = found
+				continue
+			}
+		}
+
+		// Slow path: the tree changes shape, so the cached path is dropped
+		if op.delete {
+			deleted, err := tx.Delete(rootID, key1, key2)
+			if err != nil {
+				return nil, err
+			}
+			results[op.index].Found = deleted
+		} else if err := tx.Insert(rootID, key1, key2, op.value); err != nil {
+			return nil, err
+		}
+		cur = batchLeaf{}
+	}
+
+	return results, nil
+}
+
+// batchLeaf is the leaf reached by the last descent of ApplyBatch and the
+// range of keys routed to it.
+type batchLeaf struct {
+	pageID  bpager.PageID
+	leaf    *bnode.LeafNode // nil if the tree is empty
+	path    []cursorElem    // internal nodes above the leaf and the child taken
+	isRoot  bool
+	copied  bool // leaf wraps the transaction's writable copy
+	low     Key
+	high    Key
+	hasLow  bool
+	hasHigh bool
+}
+
+// covers returns true if key is routed to the cached leaf.
+func (c *batchLeaf) covers(key Key) bool {
+	if c.leaf == nil {
+		return false
+	}
+	if c.hasLow && key.Compare(c.low) < 0 {
+		return false
+	}
+	if c.hasHigh && key.Compare(c.high) >= 0 {
+		return false
+	}
+	return true
+}
+
+// writable returns the leaf backed by the transaction's writable copy.
+func (c *batchLeaf) writable(tx *Tx) *bnode.LeafNode {
+	if !c.copied {
+		c.leaf = bnode.NewLeafNode(tx.pageForWrite(c.pageID), false)
+		c.copied = true
+	}
+	return c.leaf
+}
+
+// syncPath refreshes the subtree summaries along the path after the leaf changed.
+// Once a summary is unchanged, so are those above it.
+func (c *batchLeaf) syncPath(tx *Tx) {
+	childID := c.pageID
+	for i := len(c.path) - 1; i >= 0; i-- {
+		elem := c.path[i]
+		stats := tx.nodeStats(childID)
+		if bnode.NewInternalNode(tx.page(elem.pageID), false).GetStats(elem.index) == stats {
+			return
+		}
+		bnode.NewInternalNode(tx.pageForWrite(elem.pageID), false).SetStats(elem.index, stats)
+		childID = elem.pageID
+	}
+}
+
+// batchDescend finds the leaf for key and the key range routed to it,
+// narrowed by the separators on either side of the path. The pages on the
+// path are made private to the tree, as the batch writes to them.
+func (tx *Tx) batchDescend(rootID RootID, key Key) (batchLeaf, error) {
+	c := batchLeaf{isRoot: true}
+	pageID := tx.rootPage(rootID)
+	if pageID == 0 {
+		return c, nil // Empty tree
+	}
+	pageID, err := tx.unshareRoot(rootID, pageID)
+	if err != nil {
+		return batchLeaf{}, err
+	}
+
+	for {
+		data := tx.page(pageID)
+		if data == nil {
+			return batchLeaf{}, fmt.Errorf("failed to get page %d", pageID)
+		}
+
+		if bnode.GetNodeType(data) == bnode.NodeTypeLeaf {
+			c.pageID = pageID
+			c.leaf = bnode.NewLeafNode(data, false)
+			return c, nil
+		}
+
+		internal := bnode.NewInternalNode(data, false)
+		idx := internal.Search(key.Key1, key.Key2)
+		if idx > 0 {
+			c.low, c.hasLow = internal.GetKeyAt(idx-1), true
+		}
+		if idx < internal.KeyCount() {
+			c.high, c.hasHigh = internal.GetKeyAt(idx), true
+		}
+		c.path = append(c.path, cursorElem{pageID: pageID, index: idx})
+		c.isRoot = false
+		if pageID, err = tx.unshareChild(pageID, idx); err != nil {
+			return batchLeaf{}, err
+		}
+	}
+}
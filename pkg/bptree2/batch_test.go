@@ -0,0 +1,137 @@
+package bptree2_test
+
+import (
+	"bptree2"
+	"errors"
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+func TestApplyBatch(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer tree.Close()
+
+	rootID, _ := tree.CreateRoot()
+	rng := rand.New(rand.NewSource(1))
+	model := make(map[bptree2.Key]uint64)
+
+	// Batches of random puts and deletes, checked against a map
+	for round := 0; round < 20; round++ {
+		var b bptree2.Batch
+		var expected []bool
+		for i := 0; i < 2000; i++ {
+			key := bptree2.Key{Key1: uint64(rng.Intn(5000)), Key2: 7}
+			_, exists := model[key]
+			expected = append(expected, exists)
+			if rng.Intn(3) == 0 {
+				b.Delete(key.Key1, key.Key2)
+				delete(model, key)
+			} else {
+				value := rng.Uint64()
+				b.Put(key.Key1, key.Key2, value)
+				model[key] = value
+			}
+		}
+
+		results, err := tree.ApplyBatch(rootID, &b)
+		if err != nil {
+			t.Fatalf("round %d: ApplyBatch failed: %v", round, err)
+		}
+		if len(results) != b.Len() {
+			t.Fatalf("round %d: expected %d results, got %d", round, b.Len(), len(results))
+		}
+		for i, r := range results {
+			if r.Found != expected[i] {
+				t.Fatalf("round %d: op %d: expected found=%v, got %v", round, i, expected[i], r.Found)
+			}
+		}
+
+		if count, err := tree.Count(rootID); err != nil || count != len(model) {
+			t.Fatalf("round %d: expected %d entries, got %d", round, len(model), count)
+		}
+		for key, value := range model {
+			if val, found, err := tree.Find(rootID, key.Key1, key.Key2); err != nil || !found || val != value {
+				t.Fatalf("round %d: key %v: expected %d, got %d (found=%v)", round, key, value, val, found)
+			}
+		}
+
+		// A backward scan sees every entry
+		n := 0
+		seq, errf := tree.BackwardErr(rootID, bptree2.Key{}, bptree2.MaxKey)
+		for range seq {
+			n++
+		}
+		if n != len(model) || errf() != nil {
+			t.Fatalf("round %d: backward scan saw %d entries, expected %d (err=%v)", round, n, len(model), errf())
+		}
+	}
+}
+
+func TestApplyBatchOrder(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer tree.Close()
+
+	rootID, _ := tree.CreateRoot()
+	tree.Insert(rootID, 1, 1, 10)
+
+	// Operations on the same key apply in the order they were added
+	var b bptree2.Batch
+	b.Delete(2, 2)
+	b.Put(2, 2, 20)
+	b.Put(2, 2, 21)
+	b.Delete(1, 1)
+	b.Delete(1, 1)
+	b.Put(1, 1, 11)
+
+	results, err := tree.ApplyBatch(rootID, &b)
+	if err != nil {
+		t.Fatalf("ApplyBatch failed: %v", err)
+	}
+	expected := []bool{false, false, true, true, false, false}
+	for i, r := range results {
+		if r.Found != expected[i] {
+			t.Errorf("op %d: expected found=%v, got %v", i, expected[i], r.Found)
+		}
+	}
+
+	if val, _, err := tree.Find(rootID, 1, 1); err != nil || val != 11 {
+		t.Errorf("expected 11 for (1,1), got %d", val)
+	}
+	if val, _, err := tree.Find(rootID, 2, 2); err != nil || val != 21 {
+		t.Errorf("expected 21 for (2,2), got %d", val)
+	}
+
+	// Deleting everything empties the tree
+	b.Reset()
+	b.Delete(1, 1)
+	b.Delete(2, 2)
+	if _, err := tree.ApplyBatch(rootID, &b); err != nil {
+		t.Fatalf("ApplyBatch failed: %v", err)
+	}
+	if count, err := tree.Count(rootID); err != nil || count != 0 {
+		t.Errorf("expected empty tree, got %d entries", count)
+	}
+
+	// Read-only transactions reject batches
+	tx, err := tree.Begin(false)
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.ApplyBatch(rootID, &b); !errors.Is(err, bptree2.ErrTxReadOnly) {
+		t.Errorf("expected ErrTxReadOnly, got %v", err)
+	}
+}
@@ -0,0 +1,109 @@
+package bptree2
+
+import (
+	"fmt"
+
+	"bptree2/bnode"
+)
+
+// InsertBlob inserts or updates a key whose value is an arbitrary byte slice.
+// See Tx.InsertBlob.
+func (t *BPTree) InsertBlob(rootID RootID, key1, key2 uint64, data []byte) error {
+	return t.update(func(tx *Tx) error {
+		return tx.InsertBlob(rootID, key1, key2, data)
+	})
+}
+
+// FindBlob retrieves the byte slice stored with InsertBlob.
+// Returns (data, true) if found, (nil, false) otherwise.
+func (t *BPTree) FindBlob(rootID RootID, key1, key2 uint64) (data []byte, found bool, err error) {
+	err = t.view(func(tx *Tx) (err error) {
+		data, found, err = tx.FindBlob(rootID, key1, key2)
+		return err
+	})
+	return data, found, err
+}
+
+// InsertBlob inserts or updates a key whose value is an arbitrary byte slice.
+//
+// The data is written to a chain of overflow pages and the leaf entry refers
+// to its first page. The chain is freed when the key is deleted or its value
+// is overwritten, by Insert or InsertBlob. Find and the range scans return
+// the page ID of a blob entry; use FindBlob to read the data.
+// If InsertBlob fails the transaction should be rolled back.
+func (tx *Tx) InsertBlob(rootID RootID, key1, key2 uint64, data []byte) (err error) {
+	defer recoverCorrupt(&err)
+	if err := tx.checkWritable(); err != nil {
+		return err
+	}
+
+	pageID, err := tx.pages.WriteOverflow(data)
+	if err != nil {
+		return err
+	}
+	return tx.put(rootID, key1, key2, pageID, bnode.FlagBlob)
+}
+
+// FindBlob retrieves the byte slice stored with InsertBlob.
+// Returns (nil, false) if the key is not found or holds a plain value.
+func (tx *Tx) FindBlob(rootID RootID, key1, key2 uint64) (_ []byte, _ bool, err error) {
+	if err := tx.acquire(); err != nil {
+		return nil, false, err
+	}
+	defer tx.release()
+	defer recoverCorrupt(&err)
+
+	rootPageID := tx.rootPage(rootID)
+	if rootPageID == 0 {
+		return nil, false, nil
+	}
+	if err := tx.checkKeyType(rootPageID, false); err != nil {
+		return nil, false, err
+	}
+	leafID, err := tx.findLeaf(rootPageID, key1, key2)
+	if err != nil {
+		return nil, false, err
+	}
+
+	leaf := bnode.NewLeafNode(tx.page(leafID), false)
+	idx, found := leaf.Search(key1, key2)
+	if !found || leaf.GetFlagsAt(idx)&bnode.FlagBlob == 0 {
+		return nil, false, nil
+	}
+
+	data, err := tx.pages.ReadOverflow(leaf.GetValueAt(idx))
+	if err != nil {
+		return nil, false, err
+	}
+	return data, true, nil
+}
+
+// freeValueAt frees what the value of the entry at idx owns: the overflow
+// chain of a blob, or the tree of a bucket, which is reclaimed like a
+// deleted root.
+func (tx *Tx) freeValueAt(leaf *bnode.LeafNode, idx int) error {
+	flags := leaf.GetFlagsAt(idx)
+	if flags&bnode.FlagBucket != 0 {
+		if err := tx.DeleteRoot(leaf.GetValueAt(idx)); err != nil {
+			return fmt.Errorf("failed to delete bucket (%d,%d): %w", leaf.GetKey1At(idx), leaf.GetKey2At(idx), err)
+		}
+		return nil
+	}
+	if flags&bnode.FlagBlob == 0 {
+		return nil
+	}
+	if err := tx.pages.FreeOverflow(leaf.GetValueAt(idx)); err != nil {
+		return fmt.Errorf("failed to free blob (%d,%d): %w", leaf.GetKey1At(idx), leaf.GetKey2At(idx), err)
+	}
+	return nil
+}
+
+// freeValues frees what the values of all entries of a leaf own.
+func (tx *Tx) freeValues(leaf *bnode.LeafNode) error {
+	for i := 0; i < leaf.KeyCount(); i++ {
+		if err := tx.freeValueAt(leaf, i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
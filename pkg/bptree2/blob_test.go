@@ -0,0 +1,101 @@
+package bptree2_test
+
+import (
+	"bptree2"
+	"bptree2/bpager"
+	"bytes"
+	"path/filepath"
+	"testing"
+)
+
+func TestBlob(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	rootID, _ := tree.CreateRoot()
+	for i := uint64(0); i < 300; i++ {
+		data := bytes.Repeat([]byte{byte(i)}, int(i*97))
+		if err := tree.InsertBlob(rootID, i, 0, data); err != nil {
+			t.Fatalf("InsertBlob failed: %v", err)
+		}
+		tree.Insert(rootID, i+1000, 0, i)
+	}
+
+	// Blobs survive reopening, plain values are not blobs
+	tree.Close()
+	tree, err = bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer tree.Close()
+
+	for i := uint64(0); i < 300; i++ {
+		data, found, err := tree.FindBlob(rootID, i, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !found || !bytes.Equal(data, bytes.Repeat([]byte{byte(i)}, int(i*97))) {
+			t.Fatalf("blob %d: wrong data (found=%v, %d bytes)", i, found, len(data))
+		}
+		if _, found, err := tree.FindBlob(rootID, i+1000, 0); err != nil || found {
+			t.Fatalf("plain value %d should not be a blob", i)
+		}
+		if val, _, err := tree.Find(rootID, i+1000, 0); err != nil || val != i {
+			t.Fatalf("plain value %d: got %d", i, val)
+		}
+	}
+	if _, found, err := tree.FindBlob(rootID, 5000, 0); err != nil || found {
+		t.Error("missing key should not be found")
+	}
+}
+
+func TestBlobPagesFreed(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	rootID, _ := tree.CreateRoot()
+	big := bytes.Repeat([]byte("x"), 10*bpager.OverflowCapacity)
+	for i := uint64(0); i < 100; i++ {
+		tree.Insert(rootID, i, 0, i)
+	}
+
+	// Overwrites and deletes through every write path give the chains back
+	for round := 0; round < 50; round++ {
+		tree.InsertBlob(rootID, 1, 0, big)
+		tree.InsertBlob(rootID, 1, 0, big)
+		tree.Insert(rootID, 1, 0, 1)
+		tree.InsertBlob(rootID, 2, 0, big)
+		tree.Delete(rootID, 2, 0)
+		tree.InsertBlob(rootID, 3, 0, big)
+		var b bptree2.Batch
+		b.Delete(3, 0)
+		b.Put(4, 0, 4)
+		if _, err := tree.ApplyBatch(rootID, &b); err != nil {
+			t.Fatalf("ApplyBatch failed: %v", err)
+		}
+		tree.InsertBlob(rootID, 4, 0, big)
+		tree.Insert(rootID, 4, 0, 4)
+	}
+	tree.Close()
+
+	p, err := bpager.Open(path)
+	if err != nil {
+		t.Fatalf("bpager.Open failed: %v", err)
+	}
+	defer p.Close()
+
+	// Meta page, one leaf and a few chains' worth of recycled pages
+	if count := p.PageCount(); count > 40 {
+		t.Errorf("overwritten blobs were not freed: file has %d pages", count)
+	}
+}
@@ -0,0 +1,11 @@
+package bmmap
+
+import "os"
+
+// SetTruncate replaces the function Shrink truncates the file with, and
+// returns a function that restores it.
+func SetTruncate(fn func(*os.File, int64) error) (restore func()) {
+	old := truncate
+	truncate = fn
+	return func() { truncate = old }
+}
@@ -133,3 +133,49 @@ func (m *MMap) Grow(newSize int64) error {
 	m.size = newSize
 	return nil
 }
+
+// Shrink truncates the file and remaps it.
+// This invalidates any previously returned slices. The new size is mapped
+// before the old mapping is dropped, and if the file cannot be truncated the
+// old size is mapped again, so the MMap keeps a mapping whether Shrink
+// fails or not.
+func (m *MMap) Shrink(newSize int64) error {
+	if newSize >= m.size || newSize <= 0 {
+		return nil // No need to shrink
+	}
+
+	// Map the new size next to the current mapping
+	data, err := m.mmap(newSize)
+	if err != nil {
+		return fmt.Errorf("failed to remap during shrink: %w", err)
+	}
+	if err := unix.Munmap(m.data); err != nil {
+		unix.Munmap(data)
+		return fmt.Errorf("failed to munmap during shrink: %w", err)
+	}
+	oldSize := m.size
+	m.data = data
+	m.size = newSize
+
+	// Truncate file
+	if err := truncate(m.file, newSize); err != nil {
+		// The file keeps its old size, so all of it can be mapped again;
+		// failing that, the new size stays mapped
+		if data, mapErr := m.mmap(oldSize); mapErr == nil {
+			unix.Munmap(m.data)
+			m.data = data
+			m.size = oldSize
+		}
+		return fmt.Errorf("failed to truncate file during shrink: %w", err)
+	}
+	return nil
+}
+
+// mmap maps the first size bytes of the file.
+func (m *MMap) mmap(size int64) ([]byte, error) {
+	return unix.Mmap(int(m.file.Fd()), 0, int(size),
+		unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+}
+
+// truncate changes the size of a file; tests replace it to make Shrink fail.
+var truncate = (*os.File).Truncate
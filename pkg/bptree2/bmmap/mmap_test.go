@@ -2,6 +2,7 @@ package bmmap_test
 
 import (
 	"bptree2/bmmap"
+	"errors"
 	"os"
 	"path/filepath"
 	"testing"
@@ -128,3 +129,94 @@ func TestGrow(t *testing.T) {
 		t.Errorf("file size should be 8192, got %d", info.Size())
 	}
 }
+
+func TestShrink(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	m, err := bmmap.Open(path, 8192)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer m.Close()
+
+	copy(m.Data()[0:5], []byte("hello"))
+
+	if err := m.Shrink(4096); err != nil {
+		t.Fatalf("Shrink failed: %v", err)
+	}
+	if m.Size() != 4096 {
+		t.Errorf("expected size 4096, got %d", m.Size())
+	}
+	if string(m.Data()[0:5]) != "hello" {
+		t.Errorf("data should be preserved after shrink")
+	}
+	if m.Slice(4096, 1) != nil {
+		t.Errorf("slice past the new size should be nil")
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if info.Size() != 4096 {
+		t.Errorf("file size should be 4096, got %d", info.Size())
+	}
+
+	// Growing again works from the smaller size
+	if err := m.Grow(16384); err != nil {
+		t.Fatalf("Grow failed: %v", err)
+	}
+	if string(m.Data()[0:5]) != "hello" {
+		t.Errorf("data should be preserved after grow")
+	}
+}
+
+func TestShrinkTruncateFails(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	m, err := bmmap.Open(path, 8192)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer m.Close()
+
+	copy(m.Data()[0:5], []byte("hello"))
+	copy(m.Data()[8187:8192], []byte("world"))
+
+	failed := errors.New("truncate failed")
+	restore := bmmap.SetTruncate(func(*os.File, int64) error { return failed })
+	err = m.Shrink(4096)
+	restore()
+	if !errors.Is(err, failed) {
+		t.Fatalf("Shrink should fail with the truncate error, got %v", err)
+	}
+
+	// The old size is mapped again
+	if m.Size() != 8192 {
+		t.Errorf("expected size 8192, got %d", m.Size())
+	}
+	if string(m.Slice(0, 5)) != "hello" || string(m.Slice(8187, 5)) != "world" {
+		t.Errorf("data should be preserved after a failed shrink")
+	}
+	copy(m.Slice(8187, 5), []byte("again"))
+	if err := m.Sync(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	if len(data) != 8192 || string(data[8187:]) != "again" {
+		t.Errorf("writes after a failed shrink should reach the file")
+	}
+
+	// A later shrink goes through
+	if err := m.Shrink(4096); err != nil {
+		t.Fatalf("Shrink failed: %v", err)
+	}
+	if m.Size() != 4096 || string(m.Slice(0, 5)) != "hello" {
+		t.Errorf("shrink after a failed one should keep the data")
+	}
+}
@@ -9,11 +9,26 @@ import (
 // The layout is:
 //   - Header: 16 bytes
 //   - Children: [(N+1) × uint64] starting at offset 16
-//   - Keys: [N × uint64] starting after children
+//   - Counts: [(N+1) × uint64] starting after children
+//   - Keys: [N × (Key1 uint64, Key2 uint64)] starting after counts
 //
-// For MaxInternalKeys=254:
-//   - Children (255): bytes 16-2055 (255 * 8 = 2040 bytes)
-//   - Keys (254): bytes 2056-4087 (254 * 8 = 2032 bytes)
+// For MaxInternalKeys=127:
+//   - Children (128): bytes 16-1039 (128 * 8 = 1024 bytes)
+//   - Counts (128): bytes 1040-2063 (128 * 8 = 1024 bytes)
+//   - Keys (127): bytes 2064-4095 (127 * 16 = 2032 bytes)
+//
+// A key is the smallest composite key of the child to its right, so a
+// single key1 with many key2 values can span any number of children.
+// The count of a child is the number of entries in its subtree; it moves
+// with the child pointer, and the tree layer keeps it up to date.
+//
+// A node of an augmented tree also keeps the sum, minimum and maximum of
+// the values in each child subtree, 24 bytes after the counts, and holds at
+// most MaxAugInternalKeys keys:
+//   - Children (72): bytes 16-591
+//   - Counts (72): bytes 592-1167
+//   - Aggregates (72): bytes 1168-2895 (72 * 24 = 1728 bytes)
+//   - Keys (71): bytes 2896-4031
 type InternalNode struct {
 	data []byte
 }
@@ -39,9 +54,22 @@ func (n *InternalNode) KeyCount() int {
 	return int(GetKeyCount(n.data))
 }
 
+// IsAugmented returns true if the node keeps value aggregates of its children.
+func (n *InternalNode) IsAugmented() bool {
+	return IsAugmented(n.data)
+}
+
+// maxKeys returns the key capacity of the node.
+func (n *InternalNode) maxKeys() int {
+	if n.IsAugmented() {
+		return MaxAugInternalKeys
+	}
+	return MaxInternalKeys
+}
+
 // IsFull returns true if the node cannot accept more keys.
 func (n *InternalNode) IsFull() bool {
-	return n.KeyCount() >= MaxInternalKeys
+	return n.KeyCount() >= n.maxKeys()
 }
 
 // childOffset returns the byte offset for child pointer at index i.
@@ -50,10 +78,26 @@ func (n *InternalNode) childOffset(i int) int {
 	return HeaderSize + i*8
 }
 
+// countOffset returns the byte offset for the subtree count of child i.
+func (n *InternalNode) countOffset(i int) int {
+	// Counts start after all possible children
+	return HeaderSize + (n.maxKeys()+1)*8 + i*8
+}
+
+// aggregateOffset returns the byte offset for the value aggregates of
+// child i: sum, minimum and maximum. Only augmented nodes have them.
+func (n *InternalNode) aggregateOffset(i int) int {
+	return HeaderSize + 2*(n.maxKeys()+1)*8 + i*24
+}
+
 // keyOffset returns the byte offset for key at index i.
 func (n *InternalNode) keyOffset(i int) int {
-	// Keys start after all possible children (255 children max)
-	return HeaderSize + (MaxInternalKeys+1)*8 + i*8
+	// Keys start after all possible children, counts and aggregates
+	perChild := 16
+	if n.IsAugmented() {
+		perChild += 24
+	}
+	return HeaderSize + (n.maxKeys()+1)*perChild + i*16
 }
 
 // GetChild returns the child page ID at index i.
@@ -68,82 +112,155 @@ func (n *InternalNode) setChild(i int, pageID uint64) {
 	binary.BigEndian.PutUint64(n.data[off:off+8], pageID)
 }
 
-// getKey returns the key at index i.
-func (n *InternalNode) GetKey(i int) uint64 {
-	off := n.keyOffset(i)
+// GetCount returns the number of entries in the subtree of child i.
+func (n *InternalNode) GetCount(i int) uint64 {
+	off := n.countOffset(i)
 	return binary.BigEndian.Uint64(n.data[off : off+8])
 }
 
-// setKey sets the key at index i.
-func (n *InternalNode) SetKey(i int, key uint64) {
+// SetCount sets the number of entries in the subtree of child i.
+func (n *InternalNode) SetCount(i int, count uint64) {
+	off := n.countOffset(i)
+	binary.BigEndian.PutUint64(n.data[off:off+8], count)
+}
+
+// TotalCount returns the number of entries in the subtree of this node.
+func (n *InternalNode) TotalCount() uint64 {
+	var total uint64
+	for i := 0; i <= n.KeyCount(); i++ {
+		total += n.GetCount(i)
+	}
+	return total
+}
+
+// GetStats returns the summary of the subtree of child i.
+// Only the count is set unless the node is augmented.
+func (n *InternalNode) GetStats(i int) Stats {
+	s := Stats{Count: n.GetCount(i)}
+	if n.IsAugmented() {
+		off := n.aggregateOffset(i)
+		s.Sum = binary.BigEndian.Uint64(n.data[off : off+8])
+		s.Min = binary.BigEndian.Uint64(n.data[off+8 : off+16])
+		s.Max = binary.BigEndian.Uint64(n.data[off+16 : off+24])
+	}
+	return s
+}
+
+// SetStats sets the summary of the subtree of child i.
+// The aggregates are ignored unless the node is augmented.
+func (n *InternalNode) SetStats(i int, s Stats) {
+	n.SetCount(i, s.Count)
+	if n.IsAugmented() {
+		off := n.aggregateOffset(i)
+		binary.BigEndian.PutUint64(n.data[off:off+8], s.Sum)
+		binary.BigEndian.PutUint64(n.data[off+8:off+16], s.Min)
+		binary.BigEndian.PutUint64(n.data[off+16:off+24], s.Max)
+	}
+}
+
+// TotalStats returns the summary of the subtree of this node.
+func (n *InternalNode) TotalStats() Stats {
+	var total Stats
+	for i := 0; i <= n.KeyCount(); i++ {
+		total = total.Merge(n.GetStats(i))
+	}
+	return total
+}
+
+// copyChild copies child i of src, pointer and summary, to child dst of this node.
+// Both nodes must be of the same tree.
+func (n *InternalNode) copyChild(dst int, src *InternalNode, i int) {
+	n.setChild(dst, src.GetChild(i))
+	n.SetStats(dst, src.GetStats(i))
+}
+
+// GetKey returns the key at index i.
+func (n *InternalNode) GetKey(i int) Key {
+	off := n.keyOffset(i)
+	return Key{
+		Key1: binary.BigEndian.Uint64(n.data[off : off+8]),
+		Key2: binary.BigEndian.Uint64(n.data[off+8 : off+16]),
+	}
+}
+
+// SetKey sets the key at index i.
+func (n *InternalNode) SetKey(i int, key Key) {
 	off := n.keyOffset(i)
-	binary.BigEndian.PutUint64(n.data[off:off+8], key)
+	binary.BigEndian.PutUint64(n.data[off:off+8], key.Key1)
+	binary.BigEndian.PutUint64(n.data[off+8:off+16], key.Key2)
 }
 
-// Search finds the child index for the given key.
+// Search finds the child index for the given composite key.
 // Returns the index of the child pointer to follow.
-func (n *InternalNode) Search(key uint64) int {
+func (n *InternalNode) Search(key1, key2 uint64) int {
 	count := n.KeyCount()
 
 	// Find the first key greater than the search key
 	idx := sort.Search(count, func(i int) bool {
-		return n.GetKey(i) > key
+		k := n.GetKey(i)
+		return compareKeys(k.Key1, k.Key2, key1, key2) > 0
 	})
 
 	return idx
 }
 
 // GetChildForKey returns the child page ID that should contain the given key.
-func (n *InternalNode) GetChildForKey(key uint64) uint64 {
-	idx := n.Search(key)
+func (n *InternalNode) GetChildForKey(key1, key2 uint64) uint64 {
+	idx := n.Search(key1, key2)
 	return n.GetChild(idx)
 }
 
-// Insert inserts a key with its right child pointer.
+// Insert inserts a key with its right child pointer and subtree summary.
 // The left child should already be in place.
 // Returns true if inserted successfully.
-func (n *InternalNode) Insert(key uint64, rightChild uint64) bool {
+func (n *InternalNode) Insert(key Key, rightChild uint64, rightStats Stats) bool {
 	count := n.KeyCount()
-	if count >= MaxInternalKeys {
+	if count >= n.maxKeys() {
 		return false
 	}
 
 	// Find insertion point
-	idx := sort.Search(count, func(i int) bool {
-		return n.GetKey(i) > key
-	})
+	idx := n.Search(key.Key1, key.Key2)
 
 	// Shift keys and children to make room
 	for i := count; i > idx; i-- {
 		n.SetKey(i, n.GetKey(i-1))
-		n.setChild(i+1, n.GetChild(i))
+		n.copyChild(i+1, n, i)
 	}
 
 	n.SetKey(idx, key)
 	n.setChild(idx+1, rightChild)
+	n.SetStats(idx+1, rightStats)
 	SetKeyCount(n.data, uint16(count+1))
 
 	return true
 }
 
-// InitRoot initializes an internal node as a root with one key and two children.
-func (n *InternalNode) InitRoot(leftChild, rightChild uint64, key uint64) {
-	n.data[0] = byte(NodeTypeInternal)
+// InitRoot initializes an internal node as a root with one key and two
+// children, summarized by leftStats and rightStats.
+// The type byte keeps its augmented flag, so mark an augmented root with
+// SetAugmented first.
+func (n *InternalNode) InitRoot(leftChild, rightChild uint64, key Key, leftStats, rightStats Stats) {
+	SetNodeType(n.data, NodeTypeInternal)
 	SetKeyCount(n.data, 1)
 	n.setChild(0, leftChild)
 	n.setChild(1, rightChild)
+	n.SetStats(0, leftStats)
+	n.SetStats(1, rightStats)
 	n.SetKey(0, key)
 }
 
 // Split splits the node into two, returning the middle key and new node.
 // The middle key should be promoted to the parent.
 // Caller is responsible for providing the new node's data buffer.
-func (n *InternalNode) Split(newData []byte) (uint64, *InternalNode) {
+// The new node is augmented if this node is.
+func (n *InternalNode) Split(newData []byte) (Key, *InternalNode) {
 	count := n.KeyCount()
 	mid := count / 2
 
 	// Create new node
 	newNode := NewInternalNode(newData, true)
+	SetAugmented(newData, n.IsAugmented())
 
 	// The middle key will be promoted
 	midKey := n.GetKey(mid)
@@ -154,7 +271,7 @@ func (n *InternalNode) Split(newData []byte) (uint64, *InternalNode) {
 		newNode.SetKey(i, n.GetKey(mid+1+i))
 	}
 	for i := 0; i <= newKeyCount; i++ {
-		newNode.setChild(i, n.GetChild(mid+1+i))
+		newNode.copyChild(i, n, mid+1+i)
 	}
 	SetKeyCount(newData, uint16(newKeyCount))
 
@@ -165,12 +282,12 @@ func (n *InternalNode) Split(newData []byte) (uint64, *InternalNode) {
 }
 
 // GetKeyAt returns the key at the given index.
-func (n *InternalNode) GetKeyAt(idx int) uint64 {
+func (n *InternalNode) GetKeyAt(idx int) Key {
 	return n.GetKey(idx)
 }
 
 // SetKeyAt sets the key at the given index.
-func (n *InternalNode) SetKeyAt(idx int, key uint64) {
+func (n *InternalNode) SetKeyAt(idx int, key Key) {
 	n.SetKey(idx, key)
 }
 
@@ -181,12 +298,12 @@ func (n *InternalNode) SetChild(i int, pageID uint64) {
 
 // IsUnderflow returns true if the node has fewer than minimum keys.
 func (n *InternalNode) IsUnderflow() bool {
-	return n.KeyCount() < MinInternalKeys
+	return n.KeyCount() < n.maxKeys()/2
 }
 
 // CanLendTo returns true if this node can lend a key to a sibling.
 func (n *InternalNode) CanLendTo() bool {
-	return n.KeyCount() > MinInternalKeys
+	return n.KeyCount() > n.maxKeys()/2
 }
 
 // DeleteKeyAt removes the key and its right child at the given index.
@@ -196,22 +313,43 @@ func (n *InternalNode) DeleteKeyAt(idx int) {
 	// Shift keys and children to fill the gap
 	for i := idx; i < count-1; i++ {
 		n.SetKey(i, n.GetKey(i+1))
-		n.setChild(i+1, n.GetChild(i+2))
+		n.copyChild(i+1, n, i+2)
 	}
 
 	SetKeyCount(n.data, uint16(count-1))
 }
 
+// RemoveChildren removes the children in [from, to) and the keys that
+// separate them from the rest. At least one child must remain.
+func (n *InternalNode) RemoveChildren(from, to int) {
+	count := n.KeyCount()
+	removed := to - from
+	if removed <= 0 {
+		return
+	}
+
+	// Without the first child, the key in front of the next one goes too
+	first := max(from-1, 0)
+	for i := first; i < count-removed; i++ {
+		n.SetKey(i, n.GetKey(i+removed))
+	}
+	for i := from; i <= count-removed; i++ {
+		n.copyChild(i, n, i+removed)
+	}
+
+	SetKeyCount(n.data, uint16(count-removed))
+}
+
 // BorrowFromRight borrows the first key from the right sibling.
 // parentKey is the current separator in parent between this and right.
 // Returns the new separator key for the parent.
-func (n *InternalNode) BorrowFromRight(right *InternalNode, parentKey uint64) uint64 {
+func (n *InternalNode) BorrowFromRight(right *InternalNode, parentKey Key) Key {
 	count := n.KeyCount()
 
 	// Add parent key to end of this node
 	n.SetKey(count, parentKey)
 	// Add right's first child as our new last child
-	n.setChild(count+1, right.GetChild(0))
+	n.copyChild(count+1, right, 0)
 	SetKeyCount(n.data, uint16(count+1))
 
 	// New parent key is right's first key
@@ -223,7 +361,7 @@ func (n *InternalNode) BorrowFromRight(right *InternalNode, parentKey uint64) ui
 		right.SetKey(i, right.GetKey(i+1))
 	}
 	for i := 0; i < rightCount; i++ {
-		right.setChild(i, right.GetChild(i+1))
+		right.copyChild(i, right, i+1)
 	}
 	SetKeyCount(right.data, uint16(rightCount-1))
 
@@ -233,7 +371,7 @@ func (n *InternalNode) BorrowFromRight(right *InternalNode, parentKey uint64) ui
 // BorrowFromLeft borrows the last key from the left sibling.
 // parentKey is the current separator in parent between left and this.
 // Returns the new separator key for the parent.
-func (n *InternalNode) BorrowFromLeft(left *InternalNode, parentKey uint64) uint64 {
+func (n *InternalNode) BorrowFromLeft(left *InternalNode, parentKey Key) Key {
 	count := n.KeyCount()
 	leftCount := left.KeyCount()
 
@@ -242,13 +380,13 @@ func (n *InternalNode) BorrowFromLeft(left *InternalNode, parentKey uint64) uint
 		n.SetKey(i, n.GetKey(i-1))
 	}
 	for i := count + 1; i > 0; i-- {
-		n.setChild(i, n.GetChild(i-1))
+		n.copyChild(i, n, i-1)
 	}
 
 	// Insert parent key at position 0
 	n.SetKey(0, parentKey)
 	// Insert left's last child as our new first child
-	n.setChild(0, left.GetChild(leftCount))
+	n.copyChild(0, left, leftCount)
 	SetKeyCount(n.data, uint16(count+1))
 
 	// New parent key is left's last key
@@ -262,7 +400,7 @@ func (n *InternalNode) BorrowFromLeft(left *InternalNode, parentKey uint64) uint
 
 // MergeWith merges the right sibling into this node using parentKey as separator.
 // After merge, the right sibling should be freed.
-func (n *InternalNode) MergeWith(right *InternalNode, parentKey uint64) {
+func (n *InternalNode) MergeWith(right *InternalNode, parentKey Key) {
 	count := n.KeyCount()
 	rightCount := right.KeyCount()
 
@@ -276,7 +414,7 @@ func (n *InternalNode) MergeWith(right *InternalNode, parentKey uint64) {
 
 	// Copy all children from right
 	for i := 0; i <= rightCount; i++ {
-		n.setChild(count+1+i, right.GetChild(i))
+		n.copyChild(count+1+i, right, i)
 	}
 
 	SetKeyCount(n.data, uint16(count+1+rightCount))
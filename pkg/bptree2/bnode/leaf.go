@@ -8,9 +8,9 @@ import (
 // LeafNode provides operations on a leaf node's raw byte slice.
 // The layout is:
 //   - Header: 16 bytes
-//   - Entries: [Key1: 8, Key2: 8, Value: 8] × KeyCount starting at offset 16
+//   - Entries: [Key1: 8, Key2: 8, Value: 8, Flags: 1] × KeyCount starting at offset 16
 //
-// For MaxLeafKeys=170, each entry is 24 bytes, total data = 4080 bytes.
+// For MaxLeafKeys=163, each entry is 25 bytes, total data = 4075 bytes.
 type LeafNode struct {
 	data []byte
 }
@@ -22,7 +22,6 @@ func NewLeafNode(data []byte, init bool) *LeafNode {
 	if init {
 		data[0] = byte(NodeTypeLeaf)
 		SetKeyCount(data, 0)
-		setNextLeaf(data, 0)
 	}
 	return n
 }
@@ -42,20 +41,9 @@ func (n *LeafNode) IsFull() bool {
 	return n.KeyCount() >= MaxLeafKeys
 }
 
-// NextLeaf returns the page ID of the next leaf node.
-func (n *LeafNode) NextLeaf() uint64 {
-	return getNextLeaf(n.data)
-}
-
-// SetNextLeaf sets the next leaf page ID.
-func (n *LeafNode) SetNextLeaf(pageID uint64) {
-	setNextLeaf(n.data, pageID)
-}
-
 // entryOffset returns the byte offset for entry at index i.
-// Each entry is 24 bytes (Key1: 8 + Key2: 8 + Value: 8).
 func (n *LeafNode) entryOffset(i int) int {
-	return HeaderSize + i*24
+	return HeaderSize + i*LeafEntrySize
 }
 
 // GetKey1 returns the key1 at index i.
@@ -94,6 +82,22 @@ func (n *LeafNode) setValue(i int, value uint64) {
 	binary.BigEndian.PutUint64(n.data[off:off+8], value)
 }
 
+// getFlags returns the flags at index i.
+func (n *LeafNode) getFlags(i int) EntryFlags {
+	return EntryFlags(n.data[n.entryOffset(i)+24])
+}
+
+// setFlags sets the flags at index i.
+func (n *LeafNode) setFlags(i int, flags EntryFlags) {
+	n.data[n.entryOffset(i)+24] = byte(flags)
+}
+
+// copyEntries copies count entries starting at index from of src to index to of n.
+// The ranges may overlap.
+func (n *LeafNode) copyEntries(to int, src *LeafNode, from, count int) {
+	copy(n.data[n.entryOffset(to):n.entryOffset(to+count)], src.data[src.entryOffset(from):src.entryOffset(from+count)])
+}
+
 // compareKeys compares two composite keys.
 // Returns -1 if (a1,a2) < (b1,b2), 0 if equal, 1 if greater.
 func compareKeys(a1, a2, b1, b2 uint64) int {
@@ -137,11 +141,17 @@ func (n *LeafNode) Get(key1, key2 uint64) (uint64, bool) {
 // Returns true if a new key was inserted, false if updated.
 // Panics if the node is full and key doesn't exist.
 func (n *LeafNode) Put(key1, key2, value uint64) bool {
+	return n.PutWithFlags(key1, key2, value, 0)
+}
+
+// PutWithFlags is like Put, but also sets the flags of the entry.
+func (n *LeafNode) PutWithFlags(key1, key2, value uint64, flags EntryFlags) bool {
 	idx, found := n.Search(key1, key2)
 
 	if found {
 		// Update existing key
 		n.setValue(idx, value)
+		n.setFlags(idx, flags)
 		return false
 	}
 
@@ -152,15 +162,12 @@ func (n *LeafNode) Put(key1, key2, value uint64) bool {
 	}
 
 	// Shift entries to make room
-	for i := count; i > idx; i-- {
-		n.setKey1(i, n.GetKey1(i-1))
-		n.setKey2(i, n.GetKey2(i-1))
-		n.setValue(i, n.getValue(i-1))
-	}
+	n.copyEntries(idx+1, n, idx, count-idx)
 
 	n.setKey1(idx, key1)
 	n.setKey2(idx, key2)
 	n.setValue(idx, value)
+	n.setFlags(idx, flags)
 	SetKeyCount(n.data, uint16(count+1))
 
 	return true
@@ -177,42 +184,43 @@ func (n *LeafNode) Delete(key1, key2 uint64) bool {
 	count := n.KeyCount()
 
 	// Shift entries to fill the gap
-	for i := idx; i < count-1; i++ {
-		n.setKey1(i, n.GetKey1(i+1))
-		n.setKey2(i, n.GetKey2(i+1))
-		n.setValue(i, n.getValue(i+1))
-	}
+	n.copyEntries(idx, n, idx+1, count-idx-1)
 
 	SetKeyCount(n.data, uint16(count-1))
 	return true
 }
 
-// Split splits the node into two, returning the middle key1 and new node.
+// DeleteRange removes the entries in [from, to).
+func (n *LeafNode) DeleteRange(from, to int) {
+	count := n.KeyCount()
+	if from >= to {
+		return
+	}
+
+	n.copyEntries(from, n, to, count-to)
+	SetKeyCount(n.data, uint16(count-(to-from)))
+}
+
+// Split splits the node into two, returning the first key of the new node and the new node.
 // The new node contains the upper half of keys.
 // Caller is responsible for providing the new node's data buffer.
-func (n *LeafNode) Split(newData []byte) (uint64, *LeafNode) {
+func (n *LeafNode) Split(newData []byte) (Key, *LeafNode) {
 	count := n.KeyCount()
 	mid := count / 2
 
-	// Create new node
+	// Create new node, augmented if this one is
 	newNode := NewLeafNode(newData, true)
+	SetAugmented(newData, IsAugmented(n.data))
 
 	// Copy upper half to new node
-	for i := mid; i < count; i++ {
-		newNode.setKey1(i-mid, n.GetKey1(i))
-		newNode.setKey2(i-mid, n.GetKey2(i))
-		newNode.setValue(i-mid, n.getValue(i))
-	}
+	newNode.copyEntries(0, n, mid, count-mid)
 	SetKeyCount(newData, uint16(count-mid))
 
 	// Update original node count
 	SetKeyCount(n.data, uint16(mid))
 
-	// Link leaves
-	newNode.SetNextLeaf(n.NextLeaf())
-
-	// Return the first key1 of the new node (used for separator)
-	return newNode.GetKey1(0), newNode
+	// Return the first key of the new node (used for separator)
+	return newNode.GetKeyAt(0), newNode
 }
 
 // Range returns all key-value pairs where (start1,start2) <= (key1,key2) <= (end1,end2).
@@ -233,6 +241,7 @@ func (n *LeafNode) Range(start1, start2, end1, end2 uint64) []KVPair {
 			Key1:  key1,
 			Key2:  key2,
 			Value: n.getValue(i),
+			Flags: n.getFlags(i),
 		})
 	}
 
@@ -249,11 +258,30 @@ func (n *LeafNode) GetKey2At(idx int) uint64 {
 	return n.GetKey2(idx)
 }
 
+// Stats returns the summary of the entries in this node.
+func (n *LeafNode) Stats() Stats {
+	var s Stats
+	for i := 0; i < n.KeyCount(); i++ {
+		s = s.Add(n.getValue(i))
+	}
+	return s
+}
+
+// GetKeyAt returns the composite key at the given index.
+func (n *LeafNode) GetKeyAt(idx int) Key {
+	return Key{Key1: n.GetKey1(idx), Key2: n.GetKey2(idx)}
+}
+
 // GetValueAt returns the value at the given index.
 func (n *LeafNode) GetValueAt(idx int) uint64 {
 	return n.getValue(idx)
 }
 
+// GetFlagsAt returns the flags at the given index.
+func (n *LeafNode) GetFlagsAt(idx int) EntryFlags {
+	return n.getFlags(idx)
+}
+
 // IsUnderflow returns true if the node has fewer than minimum keys.
 // Root nodes are exempt from minimum key requirements.
 func (n *LeafNode) IsUnderflow() bool {
@@ -266,54 +294,38 @@ func (n *LeafNode) CanLendTo() bool {
 }
 
 // BorrowFromRight borrows the first key from the right sibling.
-// Returns the new separator key1 for the parent.
-func (n *LeafNode) BorrowFromRight(right *LeafNode) uint64 {
-	// Get the first entry from right sibling
-	key1 := right.GetKey1(0)
-	key2 := right.GetKey2(0)
-	value := right.getValue(0)
-
-	// Append to this node
+// Returns the new separator key for the parent.
+func (n *LeafNode) BorrowFromRight(right *LeafNode) Key {
+	// Append the first entry of the right sibling to this node
 	count := n.KeyCount()
-	n.setKey1(count, key1)
-	n.setKey2(count, key2)
-	n.setValue(count, value)
+	n.copyEntries(count, right, 0, 1)
 	SetKeyCount(n.data, uint16(count+1))
 
 	// Remove from right sibling
-	right.Delete(key1, key2)
+	right.Delete(right.GetKey1(0), right.GetKey2(0))
 
-	// Return the new separator (first key1 of right sibling after borrow)
-	return right.GetKey1(0)
+	// Return the new separator (first key of right sibling after borrow)
+	return right.GetKeyAt(0)
 }
 
 // BorrowFromLeft borrows the last key from the left sibling.
-// Returns the new separator key1 for the parent.
-func (n *LeafNode) BorrowFromLeft(left *LeafNode) uint64 {
+// Returns the new separator key for the parent.
+func (n *LeafNode) BorrowFromLeft(left *LeafNode) Key {
 	leftCount := left.KeyCount()
-	key1 := left.GetKey1(leftCount - 1)
-	key2 := left.GetKey2(leftCount - 1)
-	value := left.getValue(leftCount - 1)
 
 	// Shift all entries in this node to make room at position 0
 	count := n.KeyCount()
-	for i := count; i > 0; i-- {
-		n.setKey1(i, n.GetKey1(i-1))
-		n.setKey2(i, n.GetKey2(i-1))
-		n.setValue(i, n.getValue(i-1))
-	}
+	n.copyEntries(1, n, 0, count)
 
 	// Insert borrowed entry at position 0
-	n.setKey1(0, key1)
-	n.setKey2(0, key2)
-	n.setValue(0, value)
+	n.copyEntries(0, left, leftCount-1, 1)
 	SetKeyCount(n.data, uint16(count+1))
 
 	// Remove from left sibling
 	SetKeyCount(left.data, uint16(leftCount-1))
 
-	// Return the new separator (first key1 of this node)
-	return n.GetKey1(0)
+	// Return the new separator (first key of this node)
+	return n.GetKeyAt(0)
 }
 
 // MergeWith merges the right sibling into this node.
@@ -323,14 +335,18 @@ func (n *LeafNode) MergeWith(right *LeafNode) {
 	rightCount := right.KeyCount()
 
 	// Copy all entries from right to this node
-	for i := 0; i < rightCount; i++ {
-		n.setKey1(count+i, right.GetKey1(i))
-		n.setKey2(count+i, right.GetKey2(i))
-		n.setValue(count+i, right.getValue(i))
-	}
+	n.copyEntries(count, right, 0, rightCount)
 
 	SetKeyCount(n.data, uint16(count+rightCount))
+}
 
-	// Update next leaf pointer
-	n.SetNextLeaf(right.NextLeaf())
+// LegacyLeafEntry returns entry i of a leaf written by a version 2 file,
+// with 24-byte entries and no flags. GetKeyCount returns the number of entries.
+func LegacyLeafEntry(data []byte, i int) KVPair {
+	off := HeaderSize + i*LegacyLeafEntrySize
+	return KVPair{
+		Key1:  binary.BigEndian.Uint64(data[off : off+8]),
+		Key2:  binary.BigEndian.Uint64(data[off+8 : off+16]),
+		Value: binary.BigEndian.Uint64(data[off+16 : off+24]),
+	}
 }
@@ -11,21 +11,39 @@ const (
 	// UsableSize is the space available for keys/values in a page.
 	UsableSize = 4096 - HeaderSize // 4080 bytes
 
+	// LeafEntrySize is the size of a leaf entry (Key1: 8 + Key2: 8 + Value: 8 + Flags: 1).
+	LeafEntrySize = 25
+
+	// LegacyLeafEntrySize is the size of a leaf entry in version 2 files,
+	// which had no flags.
+	LegacyLeafEntrySize = 24
+
 	// MaxLeafKeys is the maximum number of keys in a leaf node.
-	// Each key-value entry is 24 bytes (Key1: 8 + Key2: 8 + Value: 8).
-	MaxLeafKeys = UsableSize / 24 // 170
+	MaxLeafKeys = UsableSize / LeafEntrySize // 163
 
 	// MinLeafKeys is the minimum number of keys in a leaf node (except root).
 	// Should be at least ceil(MaxLeafKeys/2) - 1 for B+Tree invariant.
-	MinLeafKeys = MaxLeafKeys / 2 // 127
+	MinLeafKeys = MaxLeafKeys / 2 // 81
 
 	// MaxInternalKeys is the maximum number of keys in an internal node.
-	// Each key is 8 bytes, plus we need (N+1) child pointers at 8 bytes each.
-	// So: 8*N + 8*(N+1) = 16N + 8 <= 4080 => N <= 254
-	MaxInternalKeys = 254
+	// Each key is 16 bytes (Key1 + Key2), plus we need (N+1) child pointers
+	// and (N+1) subtree counts at 8 bytes each.
+	// So: 16*N + 16*(N+1) = 32N + 16 <= 4080 => N <= 127
+	MaxInternalKeys = 127
 
 	// MinInternalKeys is the minimum number of keys in an internal node (except root).
-	MinInternalKeys = MaxInternalKeys / 2 // 127
+	MinInternalKeys = MaxInternalKeys / 2 // 63
+
+	// MaxAugInternalKeys is the maximum number of keys in an internal node of
+	// an augmented tree, whose children also carry a sum, minimum and maximum.
+	// So: 16*N + 40*(N+1) = 56N + 40 <= 4080 => N <= 72
+	// N is odd, like MaxInternalKeys, so that both halves of a split node
+	// keep MinAugInternalKeys keys after the new key goes into one of them.
+	MaxAugInternalKeys = 71
+
+	// MinAugInternalKeys is the minimum number of keys in an internal node of
+	// an augmented tree (except root).
+	MinAugInternalKeys = MaxAugInternalKeys / 2 // 35
 )
 
 // NodeType indicates the type of node.
@@ -36,6 +54,23 @@ const (
 	NodeTypeInternal NodeType = 0
 	// NodeTypeLeaf represents a leaf node.
 	NodeTypeLeaf NodeType = 1
+	// NodeTypeVarInternal represents an internal node with variable-length keys.
+	NodeTypeVarInternal NodeType = 2
+	// NodeTypeVarLeaf represents a leaf node with variable-length keys and values.
+	NodeTypeVarLeaf NodeType = 3
+
+	// augmentedBit in the type byte marks a node of an augmented tree.
+	augmentedBit = 0x80
+)
+
+// EntryFlags describe how the value of a leaf entry is to be interpreted.
+type EntryFlags uint8
+
+const (
+	// FlagBlob marks a value that is the first page of an overflow chain.
+	FlagBlob EntryFlags = 1 << iota
+	// FlagBucket marks a value that is the root ID of a nested tree.
+	FlagBucket
 )
 
 // KVPair represents a key-value pair with composite key (Key1, Key2).
@@ -43,17 +78,86 @@ type KVPair struct {
 	Key1  uint64
 	Key2  uint64
 	Value uint64
+	Flags EntryFlags
+}
+
+// Stats summarizes the entries of a subtree: their number and, in augmented
+// trees, the sum, minimum and maximum of their values. The sum wraps around
+// on overflow. Min and Max are meaningless if Count is 0.
+type Stats struct {
+	Count uint64
+	Sum   uint64
+	Min   uint64
+	Max   uint64
+}
+
+// Add returns the summary with one more value.
+func (s Stats) Add(value uint64) Stats {
+	return s.Merge(Stats{Count: 1, Sum: value, Min: value, Max: value})
+}
+
+// Merge returns the summary of the entries of both s and other.
+func (s Stats) Merge(other Stats) Stats {
+	if s.Count == 0 {
+		return other
+	}
+	if other.Count == 0 {
+		return s
+	}
+	return Stats{
+		Count: s.Count + other.Count,
+		Sum:   s.Sum + other.Sum,
+		Min:   min(s.Min, other.Min),
+		Max:   max(s.Max, other.Max),
+	}
+}
+
+// Key is a composite key (Key1, Key2), as stored in internal nodes.
+type Key struct {
+	Key1 uint64
+	Key2 uint64
+}
+
+// Compare compares two composite keys.
+// Returns -1 if k < other, 0 if equal, 1 if greater.
+func (k Key) Compare(other Key) int {
+	return compareKeys(k.Key1, k.Key2, other.Key1, other.Key2)
 }
 
 // Header layout:
-// Byte 0: NodeType (1 byte)
+// Byte 0: NodeType (low 7 bits) and the augmented flag (high bit)
 // Byte 1-2: KeyCount (2 bytes, little endian)
-// Byte 3-10: NextLeaf for leaf, unused for internal (8 bytes)
-// Byte 11-15: Reserved (5 bytes)
+// Byte 3-10: child 0 for var internal, reserved for the others (8 bytes)
+// Byte 11: reserved
+// Byte 12-15: page checksum, written by the pager (see bpager.ChecksumOffset)
+//
+// In version 2 files leaves were linked to the next leaf in bytes 3-10.
+// Leaves no longer write the link.
 
 // GetNodeType returns the type of the node from raw bytes.
 func GetNodeType(data []byte) NodeType {
-	return NodeType(data[0])
+	return NodeType(data[0] &^ augmentedBit)
+}
+
+// SetNodeType sets the type of the node, keeping the augmented flag.
+func SetNodeType(data []byte, t NodeType) {
+	data[0] = data[0]&augmentedBit | byte(t)
+}
+
+// IsAugmented returns true if the node belongs to an augmented tree.
+func IsAugmented(data []byte) bool {
+	return data[0]&augmentedBit != 0
+}
+
+// SetAugmented marks the node as belonging to an augmented tree or not.
+// For an internal node this changes the layout, so it must be set while
+// the node is empty.
+func SetAugmented(data []byte, augmented bool) {
+	if augmented {
+		data[0] |= augmentedBit
+	} else {
+		data[0] &^= augmentedBit
+	}
 }
 
 // GetKeyCount returns the number of keys in the node.
@@ -65,13 +169,3 @@ func GetKeyCount(data []byte) uint16 {
 func SetKeyCount(data []byte, count uint16) {
 	binary.BigEndian.PutUint16(data[1:3], count)
 }
-
-// getNextLeaf returns the next leaf pointer (only valid for leaf nodes).
-func getNextLeaf(data []byte) uint64 {
-	return binary.BigEndian.Uint64(data[3:11])
-}
-
-// setNextLeaf sets the next leaf pointer.
-func setNextLeaf(data []byte, next uint64) {
-	binary.BigEndian.PutUint64(data[3:11], next)
-}
@@ -114,6 +114,26 @@ func TestLeafNodeDelete(t *testing.T) {
 	}
 }
 
+func TestLeafNodeDeleteRange(t *testing.T) {
+	data := make([]byte, 4096)
+	leaf := bnode.NewLeafNode(data, true)
+	for i := uint64(0); i < 10; i++ {
+		leaf.Put(i, 0, i*10)
+	}
+
+	leaf.DeleteRange(2, 7)
+	leaf.DeleteRange(3, 3)
+
+	if leaf.KeyCount() != 5 {
+		t.Fatalf("expected 5 keys, got %d", leaf.KeyCount())
+	}
+	for i, key1 := range []uint64{0, 1, 7, 8, 9} {
+		if leaf.GetKey1(i) != key1 || leaf.GetValueAt(i) != key1*10 {
+			t.Errorf("entry %d: expected key %d, got %d=%d", i, key1, leaf.GetKey1(i), leaf.GetValueAt(i))
+		}
+	}
+}
+
 func TestLeafNodeRange(t *testing.T) {
 	data := make([]byte, 4096)
 	leaf := bnode.NewLeafNode(data, true)
@@ -153,22 +173,22 @@ func TestLeafNodeSplit(t *testing.T) {
 			leaf.KeyCount(), newNode.KeyCount())
 	}
 
-	// Mid key should be the first key1 of new node
-	if midKey != newNode.GetKey1(0) {
-		t.Errorf("midKey %d != first key1 of new node %d", midKey, newNode.GetKey1(0))
+	// Mid key should be the first key of new node
+	if midKey != newNode.GetKeyAt(0) {
+		t.Errorf("midKey %v != first key of new node %v", midKey, newNode.GetKeyAt(0))
 	}
 
-	// All key1s in original should be less than midKey
+	// All keys in original should be less than midKey
 	for i := 0; i < leaf.KeyCount(); i++ {
-		if leaf.GetKey1(i) >= midKey {
-			t.Errorf("original node key1 %d >= midKey %d", leaf.GetKey1(i), midKey)
+		if leaf.GetKeyAt(i).Compare(midKey) >= 0 {
+			t.Errorf("original node key %v >= midKey %v", leaf.GetKeyAt(i), midKey)
 		}
 	}
 
-	// All key1s in new node should be >= midKey
+	// All keys in new node should be >= midKey
 	for i := 0; i < newNode.KeyCount(); i++ {
-		if newNode.GetKey1(i) < midKey {
-			t.Errorf("new node key1 %d < midKey %d", newNode.GetKey1(i), midKey)
+		if newNode.GetKeyAt(i).Compare(midKey) < 0 {
+			t.Errorf("new node key %v < midKey %v", newNode.GetKeyAt(i), midKey)
 		}
 	}
 }
@@ -189,7 +209,7 @@ func TestInternalNodeInitRoot(t *testing.T) {
 	data := make([]byte, 4096)
 	node := bnode.NewInternalNode(data, true)
 
-	node.InitRoot(1, 2, 100)
+	node.InitRoot(1, 2, bnode.Key{Key1: 100, Key2: 5}, bnode.Stats{Count: 10}, bnode.Stats{Count: 20})
 
 	if node.KeyCount() != 1 {
 		t.Errorf("expected 1 key, got %d", node.KeyCount())
@@ -200,8 +220,11 @@ func TestInternalNodeInitRoot(t *testing.T) {
 	if node.GetChild(1) != 2 {
 		t.Error("right child should be 2")
 	}
-	if node.GetKey(0) != 100 {
-		t.Error("key should be 100")
+	if node.GetKey(0) != (bnode.Key{Key1: 100, Key2: 5}) {
+		t.Error("key should be (100,5)")
+	}
+	if node.GetCount(0) != 10 || node.GetCount(1) != 20 || node.TotalCount() != 30 {
+		t.Errorf("expected counts 10 and 20, got %d and %d", node.GetCount(0), node.GetCount(1))
 	}
 }
 
@@ -217,11 +240,11 @@ func TestInternalNodeSearch(t *testing.T) {
 	// C3 for keys >= 60
 
 	node.SetChild(0, 10) // page 10
-	node.SetKey(0, 20)
+	node.SetKey(0, bnode.Key{Key1: 20})
 	node.SetChild(1, 11) // page 11
-	node.SetKey(1, 40)
+	node.SetKey(1, bnode.Key{Key1: 40})
 	node.SetChild(2, 12) // page 12
-	node.SetKey(2, 60)
+	node.SetKey(2, bnode.Key{Key1: 60})
 	node.SetChild(3, 13) // page 13
 	bnode.SetKeyCount(data, 3)
 
@@ -239,7 +262,7 @@ func TestInternalNodeSearch(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		child := node.GetChildForKey(tt.key)
+		child := node.GetChildForKey(tt.key, 0)
 		if child != tt.expectedChild {
 			t.Errorf("key %d: expected child %d, got %d",
 				tt.key, tt.expectedChild, child)
@@ -247,23 +270,60 @@ func TestInternalNodeSearch(t *testing.T) {
 	}
 }
 
+func TestInternalNodeSearchComposite(t *testing.T) {
+	data := make([]byte, 4096)
+	node := bnode.NewInternalNode(data, true)
+
+	// Separators within a single key1: [(7,100), (7,200), (8,0)]
+	node.SetChild(0, 10)
+	node.SetKey(0, bnode.Key{Key1: 7, Key2: 100})
+	node.SetChild(1, 11)
+	node.SetKey(1, bnode.Key{Key1: 7, Key2: 200})
+	node.SetChild(2, 12)
+	node.SetKey(2, bnode.Key{Key1: 8, Key2: 0})
+	node.SetChild(3, 13)
+	bnode.SetKeyCount(data, 3)
+
+	tests := []struct {
+		key1, key2    uint64
+		expectedChild uint64
+	}{
+		{6, ^uint64(0), 10},
+		{7, 0, 10},
+		{7, 99, 10},
+		{7, 100, 11},
+		{7, 199, 11},
+		{7, 200, 12},
+		{7, ^uint64(0), 12},
+		{8, 0, 13},
+	}
+
+	for _, tt := range tests {
+		child := node.GetChildForKey(tt.key1, tt.key2)
+		if child != tt.expectedChild {
+			t.Errorf("key (%d,%d): expected child %d, got %d",
+				tt.key1, tt.key2, tt.expectedChild, child)
+		}
+	}
+}
+
 func TestInternalNodeInsert(t *testing.T) {
 	data := make([]byte, 4096)
 	node := bnode.NewInternalNode(data, true)
 
-	node.InitRoot(1, 2, 50)
+	node.InitRoot(1, 2, bnode.Key{Key1: 50}, bnode.Stats{Count: 10}, bnode.Stats{Count: 20})
 
 	// Insert more keys
-	node.Insert(30, 3) // key 30, right child 3
-	node.Insert(70, 4) // key 70, right child 4
+	node.Insert(bnode.Key{Key1: 30}, 3, bnode.Stats{Count: 30}) // key 30, right child 3
+	node.Insert(bnode.Key{Key1: 70}, 4, bnode.Stats{Count: 40}) // key 70, right child 4
 
 	if node.KeyCount() != 3 {
 		t.Errorf("expected 3 keys, got %d", node.KeyCount())
 	}
 
 	// Check order: keys should be [30, 50, 70]
-	if node.GetKey(0) != 30 || node.GetKey(1) != 50 || node.GetKey(2) != 70 {
-		t.Errorf("keys out of order: %d, %d, %d",
+	if node.GetKey(0).Key1 != 30 || node.GetKey(1).Key1 != 50 || node.GetKey(2).Key1 != 70 {
+		t.Errorf("keys out of order: %v, %v, %v",
 			node.GetKey(0), node.GetKey(1), node.GetKey(2))
 	}
 
@@ -272,6 +332,12 @@ func TestInternalNodeInsert(t *testing.T) {
 		node.GetChild(2) != 2 || node.GetChild(3) != 4 {
 		t.Error("children out of order")
 	}
+
+	// Counts move with their children: [10, 30, 20, 40]
+	if node.GetCount(0) != 10 || node.GetCount(1) != 30 ||
+		node.GetCount(2) != 20 || node.GetCount(3) != 40 {
+		t.Error("counts out of order")
+	}
 }
 
 func TestInternalNodeSplit(t *testing.T) {
@@ -282,7 +348,7 @@ func TestInternalNodeSplit(t *testing.T) {
 	// Build a node with many keys
 	node.SetChild(0, 100)
 	for i := 0; i < 10; i++ {
-		node.SetKey(i, uint64((i+1)*10))
+		node.SetKey(i, bnode.Key{Key1: 5, Key2: uint64((i + 1) * 10)})
 		node.SetChild(i+1, uint64(101+i))
 	}
 	bnode.SetKeyCount(data1, 10)
@@ -297,15 +363,171 @@ func TestInternalNodeSplit(t *testing.T) {
 
 	// All keys in original should be less than midKey
 	for i := 0; i < node.KeyCount(); i++ {
-		if node.GetKey(i) >= midKey {
-			t.Errorf("original node key %d >= midKey %d", node.GetKey(i), midKey)
+		if node.GetKey(i).Compare(midKey) >= 0 {
+			t.Errorf("original node key %v >= midKey %v", node.GetKey(i), midKey)
 		}
 	}
 
 	// All keys in new node should be greater than midKey
 	for i := 0; i < newNode.KeyCount(); i++ {
-		if newNode.GetKey(i) <= midKey {
-			t.Errorf("new node key %d <= midKey %d", newNode.GetKey(i), midKey)
+		if newNode.GetKey(i).Compare(midKey) <= 0 {
+			t.Errorf("new node key %v <= midKey %v", newNode.GetKey(i), midKey)
 		}
 	}
 }
+
+func TestInternalNodeRemoveChildren(t *testing.T) {
+	// Children 0..10, child i counts i entries and starts at key i
+	newNode := func() *bnode.InternalNode {
+		data := make([]byte, 4096)
+		node := bnode.NewInternalNode(data, true)
+		node.InitRoot(0, 1, bnode.Key{Key1: 1}, bnode.Stats{}, bnode.Stats{Count: 1})
+		for i := uint64(2); i <= 10; i++ {
+			node.Insert(bnode.Key{Key1: i}, i, bnode.Stats{Count: i})
+		}
+		return node
+	}
+
+	tests := []struct {
+		from, to int
+		children []uint64
+	}{
+		{0, 3, []uint64{3, 4, 5, 6, 7, 8, 9, 10}},
+		{4, 7, []uint64{0, 1, 2, 3, 7, 8, 9, 10}},
+		{8, 11, []uint64{0, 1, 2, 3, 4, 5, 6, 7}},
+		{5, 5, []uint64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10}},
+	}
+	for _, tt := range tests {
+		node := newNode()
+		node.RemoveChildren(tt.from, tt.to)
+
+		if node.KeyCount() != len(tt.children)-1 {
+			t.Errorf("RemoveChildren(%d, %d): expected %d keys, got %d", tt.from, tt.to, len(tt.children)-1, node.KeyCount())
+			continue
+		}
+		for i, child := range tt.children {
+			if node.GetChild(i) != child || node.GetCount(i) != child {
+				t.Errorf("RemoveChildren(%d, %d): child %d is %d with count %d, expected %d",
+					tt.from, tt.to, i, node.GetChild(i), node.GetCount(i), child)
+			}
+			if i > 0 && node.GetKey(i-1).Key1 != child {
+				t.Errorf("RemoveChildren(%d, %d): key %d is %v, expected %d", tt.from, tt.to, i-1, node.GetKey(i-1), child)
+			}
+		}
+	}
+}
+
+func TestInternalNodeCounts(t *testing.T) {
+	newNode := func(first uint64, n int) *bnode.InternalNode {
+		data := make([]byte, 4096)
+		node := bnode.NewInternalNode(data, true)
+		node.SetChild(0, first)
+		node.SetCount(0, first)
+		for i := 1; i <= n; i++ {
+			node.SetKey(i-1, bnode.Key{Key1: first + uint64(i)})
+			node.SetChild(i, first+uint64(i))
+			node.SetCount(i, first+uint64(i))
+		}
+		bnode.SetKeyCount(data, uint16(n))
+		return node
+	}
+
+	// Every child holds as many entries as its page ID
+	check := func(name string, nodes ...*bnode.InternalNode) {
+		t.Helper()
+		for _, node := range nodes {
+			var total uint64
+			for i := 0; i <= node.KeyCount(); i++ {
+				if node.GetCount(i) != node.GetChild(i) {
+					t.Errorf("%s: child %d has count %d", name, node.GetChild(i), node.GetCount(i))
+				}
+				total += node.GetChild(i)
+			}
+			if node.TotalCount() != total {
+				t.Errorf("%s: expected total %d, got %d", name, total, node.TotalCount())
+			}
+		}
+	}
+
+	node := newNode(100, 10)
+	_, right := node.Split(make([]byte, 4096))
+	check("split", node, right)
+
+	left, right := newNode(100, 5), newNode(200, 5)
+	right.BorrowFromLeft(left, bnode.Key{Key1: 200})
+	left.BorrowFromRight(right, bnode.Key{Key1: 200})
+	check("borrow", left, right)
+
+	left.MergeWith(right, bnode.Key{Key1: 200})
+	left.DeleteKeyAt(0)
+	check("merge", left)
+
+	left.SetStats(0, bnode.Stats{Count: 5, Sum: 7, Min: 1, Max: 3})
+	if s := left.GetStats(0); s != (bnode.Stats{Count: 5}) {
+		t.Errorf("plain node should keep only the count, got %+v", s)
+	}
+}
+
+func TestInternalNodeAugmented(t *testing.T) {
+	// Child i holds i+1 entries with values 10*i .. 10*i+i, summing to sum(i)
+	stats := func(i uint64) bnode.Stats {
+		return bnode.Stats{Count: i + 1, Sum: (i+1)*10*i + i*(i+1)/2, Min: 10 * i, Max: 11 * i}
+	}
+
+	data := make([]byte, 4096)
+	node := bnode.NewInternalNode(data, true)
+	bnode.SetAugmented(data, true)
+	if !node.IsAugmented() || node.Type() != bnode.NodeTypeInternal {
+		t.Fatal("node should be an augmented internal node")
+	}
+
+	node.InitRoot(0, 1, bnode.Key{Key1: 1}, stats(0), stats(1))
+	for i := uint64(2); ; i++ {
+		if !node.Insert(bnode.Key{Key1: i}, i, stats(i)) {
+			break
+		}
+	}
+	if node.KeyCount() != bnode.MaxAugInternalKeys || !node.IsFull() {
+		t.Fatalf("expected %d keys, got %d", bnode.MaxAugInternalKeys, node.KeyCount())
+	}
+
+	check := func(name string, nodes ...*bnode.InternalNode) {
+		t.Helper()
+		for _, node := range nodes {
+			var total bnode.Stats
+			for i := 0; i <= node.KeyCount(); i++ {
+				if got, want := node.GetStats(i), stats(node.GetChild(i)); got != want {
+					t.Errorf("%s: child %d has stats %+v, want %+v", name, node.GetChild(i), got, want)
+				}
+				if i > 0 && node.GetKey(i-1).Key1 != node.GetChild(i) {
+					t.Errorf("%s: key %d is %v", name, i-1, node.GetKey(i-1))
+				}
+				total = total.Merge(stats(node.GetChild(i)))
+			}
+			if node.TotalStats() != total {
+				t.Errorf("%s: expected total %+v, got %+v", name, total, node.TotalStats())
+			}
+		}
+	}
+	check("insert", node)
+
+	splitKey, right := node.Split(make([]byte, 4096))
+	if !right.IsAugmented() {
+		t.Fatal("split should keep the node augmented")
+	}
+	if node.KeyCount() < bnode.MinAugInternalKeys || right.KeyCount() < bnode.MinAugInternalKeys {
+		t.Errorf("split of a full node left %d and %d keys", node.KeyCount(), right.KeyCount())
+	}
+	check("split", node, right)
+
+	splitKey = node.BorrowFromRight(right, splitKey)
+	check("borrow right", node, right)
+	splitKey = right.BorrowFromLeft(node, splitKey)
+	check("borrow left", node, right)
+
+	node.MergeWith(right, splitKey)
+	check("merge", node)
+	if node.KeyCount() != bnode.MaxAugInternalKeys {
+		t.Errorf("expected %d keys after merge, got %d", bnode.MaxAugInternalKeys, node.KeyCount())
+	}
+}
@@ -0,0 +1,235 @@
+package bnode
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+)
+
+const (
+	// SlottedHeaderSize is the header size of nodes with variable-length cells:
+	// the common 16-byte header plus the cell area start and the fragmented byte count.
+	SlottedHeaderSize = HeaderSize + 4
+
+	// VarUsableSize is the space available for slots and cells in a page.
+	VarUsableSize = 4096 - SlottedHeaderSize // 4076 bytes
+
+	// MaxVarEntrySize is the largest key length plus value length a
+	// variable-length leaf accepts. It keeps at least four entries in a page,
+	// so that a split always leaves both halves non-empty.
+	MaxVarEntrySize = 1000
+
+	// cellHeaderSize is the size of the key and value lengths in front of a cell.
+	cellHeaderSize = 4
+
+	// slotSize is the size of one slot, the offset of its cell.
+	slotSize = 2
+
+	// maxLeafCellSize and maxInternalCellSize are the largest space a leaf
+	// entry or a separator key with its child can take, slot included.
+	maxLeafCellSize     = slotSize + cellHeaderSize + MaxVarEntrySize
+	maxInternalCellSize = maxLeafCellSize + 8
+)
+
+// Slotted page layout, shared by VarLeafNode and VarInternalNode:
+//   - Header: 16 bytes (see node.go)
+//   - Byte 16-17: start of the cell area
+//   - Byte 18-19: bytes lost to deleted cells, reclaimed by compaction
+//   - Slots: [uint16 cell offset] × KeyCount starting at offset 20, in key order
+//   - Cells: [keyLen: 2, valueLen: 2, key, value], growing down from the page end
+//
+// Slots stay sorted while cells are placed wherever there is room, so an
+// insert only shifts slots. Deleted cells leave holes that are compacted
+// away once the contiguous free space runs out.
+
+// entry is a key-value pair copied out of a slotted page.
+type entry struct {
+	key   []byte
+	value []byte
+}
+
+// size returns the space the entry takes in a page, slot included.
+func (e entry) size() int {
+	return slotSize + cellHeaderSize + len(e.key) + len(e.value)
+}
+
+// initSlotted initializes an empty slotted page of the given type.
+func initSlotted(data []byte, nodeType NodeType) {
+	data[0] = byte(nodeType)
+	SetKeyCount(data, 0)
+	setCellStart(data, len(data))
+	setFragmented(data, 0)
+}
+
+func cellStart(data []byte) int {
+	return int(binary.BigEndian.Uint16(data[16:18]))
+}
+
+func setCellStart(data []byte, off int) {
+	binary.BigEndian.PutUint16(data[16:18], uint16(off))
+}
+
+func fragmented(data []byte) int {
+	return int(binary.BigEndian.Uint16(data[18:20]))
+}
+
+func setFragmented(data []byte, n int) {
+	binary.BigEndian.PutUint16(data[18:20], uint16(n))
+}
+
+// slotOffset returns the byte offset of slot i.
+func slotOffset(i int) int {
+	return SlottedHeaderSize + i*slotSize
+}
+
+// cellOffset returns the byte offset of the cell of slot i.
+func cellOffset(data []byte, i int) int {
+	off := slotOffset(i)
+	return int(binary.BigEndian.Uint16(data[off : off+2]))
+}
+
+// cellKey returns the key of cell i. The slice aliases the page.
+func cellKey(data []byte, i int) []byte {
+	off := cellOffset(data, i)
+	keyLen := int(binary.BigEndian.Uint16(data[off : off+2]))
+	start := off + cellHeaderSize
+	return data[start : start+keyLen]
+}
+
+// cellValue returns the value of cell i. The slice aliases the page.
+func cellValue(data []byte, i int) []byte {
+	off := cellOffset(data, i)
+	keyLen := int(binary.BigEndian.Uint16(data[off : off+2]))
+	valueLen := int(binary.BigEndian.Uint16(data[off+2 : off+4]))
+	start := off + cellHeaderSize + keyLen
+	return data[start : start+valueLen]
+}
+
+// cellEntry returns a copy of cell i.
+func cellEntry(data []byte, i int) entry {
+	return entry{
+		key:   bytes.Clone(cellKey(data, i)),
+		value: bytes.Clone(cellValue(data, i)),
+	}
+}
+
+// cellSize returns the space cell i takes, slot included.
+func cellSize(data []byte, i int) int {
+	off := cellOffset(data, i)
+	keyLen := int(binary.BigEndian.Uint16(data[off : off+2]))
+	valueLen := int(binary.BigEndian.Uint16(data[off+2 : off+4]))
+	return slotSize + cellHeaderSize + keyLen + valueLen
+}
+
+// freeSpace returns the free bytes of a slotted page, holes included.
+func freeSpace(data []byte) int {
+	count := int(GetKeyCount(data))
+	return cellStart(data) - slotOffset(count) + fragmented(data)
+}
+
+// usedSpace returns the bytes taken by the slots and cells of a page.
+func usedSpace(data []byte) int {
+	return len(data) - SlottedHeaderSize - freeSpace(data)
+}
+
+// searchCells returns the index of the first cell whose key is >= key,
+// and whether it is equal.
+func searchCells(data []byte, key []byte) (int, bool) {
+	count := int(GetKeyCount(data))
+	idx := sort.Search(count, func(i int) bool {
+		return bytes.Compare(cellKey(data, i), key) >= 0
+	})
+	return idx, idx < count && bytes.Equal(cellKey(data, idx), key)
+}
+
+// insertCell inserts a cell at slot index i.
+// Returns false if the page does not have enough free space.
+func insertCell(data []byte, i int, key, value []byte) bool {
+	e := entry{key: key, value: value}
+	if freeSpace(data) < e.size() {
+		return false
+	}
+
+	count := int(GetKeyCount(data))
+	cellLen := e.size() - slotSize
+	if cellStart(data)-slotOffset(count+1) < cellLen {
+		compactCells(data)
+	}
+
+	// Write the cell at the bottom of the cell area
+	off := cellStart(data) - cellLen
+	binary.BigEndian.PutUint16(data[off:off+2], uint16(len(key)))
+	binary.BigEndian.PutUint16(data[off+2:off+4], uint16(len(value)))
+	copy(data[off+cellHeaderSize:], key)
+	copy(data[off+cellHeaderSize+len(key):], value)
+	setCellStart(data, off)
+
+	// Shift the slots after i and point slot i at the cell
+	copy(data[slotOffset(i+1):slotOffset(count+1)], data[slotOffset(i):slotOffset(count)])
+	binary.BigEndian.PutUint16(data[slotOffset(i):slotOffset(i)+2], uint16(off))
+	SetKeyCount(data, uint16(count+1))
+	return true
+}
+
+// removeCell removes the cell at slot index i.
+func removeCell(data []byte, i int) {
+	count := int(GetKeyCount(data))
+	off := cellOffset(data, i)
+	size := cellSize(data, i) - slotSize
+
+	if off == cellStart(data) {
+		setCellStart(data, off+size) // Bottom cell: give the space back directly
+	} else {
+		setFragmented(data, fragmented(data)+size)
+	}
+
+	copy(data[slotOffset(i):slotOffset(count-1)], data[slotOffset(i+1):slotOffset(count)])
+	SetKeyCount(data, uint16(count-1))
+}
+
+// compactCells moves all cells to the end of the page, removing holes.
+func compactCells(data []byte) {
+	count := int(GetKeyCount(data))
+	entries := make([]entry, count)
+	for i := range entries {
+		entries[i] = cellEntry(data, i)
+	}
+	writeCells(data, entries)
+}
+
+// writeCells replaces all cells of a page with entries, which must fit.
+func writeCells(data []byte, entries []entry) {
+	SetKeyCount(data, 0)
+	setCellStart(data, len(data))
+	setFragmented(data, 0)
+	for i, e := range entries {
+		insertCell(data, i, e.key, e.value)
+	}
+}
+
+// splitPoint returns the index at which to split entries into two runs of
+// about equal size. Both runs hold at least minLeft and minRight entries.
+func splitPoint(entries []entry, minLeft, minRight int) int {
+	total := 0
+	for _, e := range entries {
+		total += e.size()
+	}
+
+	m, left := 0, 0
+	for m < len(entries)-minRight && (m < minLeft || left+entries[m].size()/2 < total/2) {
+		left += entries[m].size()
+		m++
+	}
+	return m
+}
+
+// Separator returns the shortest key that is greater than left and not
+// greater than right, given left < right. Internal nodes store these
+// truncated separators instead of full keys.
+func Separator(left, right []byte) []byte {
+	n := 0
+	for n < len(left) && n < len(right) && left[n] == right[n] {
+		n++
+	}
+	return bytes.Clone(right[:n+1])
+}
@@ -0,0 +1,219 @@
+package bnode
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+)
+
+// VarInternalNode provides operations on an internal node with
+// variable-length separator keys. It is a slotted page (see slotted.go):
+//   - Byte 3-10 of the header: the leftmost child
+//   - Cells: [separator key, right child: 8] in lexicographic key order
+//
+// Child i+1 holds the keys that are >= separator i.
+type VarInternalNode struct {
+	data []byte
+}
+
+// NewVarInternalNode creates a new variable-length internal node wrapper around raw bytes.
+// If init is true, initializes the node as empty.
+func NewVarInternalNode(data []byte, init bool) *VarInternalNode {
+	n := &VarInternalNode{data: data}
+	if init {
+		initSlotted(data, NodeTypeVarInternal)
+		n.SetChild(0, 0)
+	}
+	return n
+}
+
+// Type returns the node type.
+func (n *VarInternalNode) Type() NodeType {
+	return GetNodeType(n.data)
+}
+
+// KeyCount returns the number of keys in this node.
+func (n *VarInternalNode) KeyCount() int {
+	return int(GetKeyCount(n.data))
+}
+
+// GetChild returns the child page ID at index i.
+func (n *VarInternalNode) GetChild(i int) uint64 {
+	if i == 0 {
+		return binary.BigEndian.Uint64(n.data[3:11])
+	}
+	return binary.BigEndian.Uint64(cellValue(n.data, i-1))
+}
+
+// SetChild sets the child page ID at index i.
+func (n *VarInternalNode) SetChild(i int, pageID uint64) {
+	if i == 0 {
+		binary.BigEndian.PutUint64(n.data[3:11], pageID)
+		return
+	}
+	binary.BigEndian.PutUint64(cellValue(n.data, i-1), pageID)
+}
+
+// GetKeyAt returns the separator key at the given index. The slice aliases the page.
+func (n *VarInternalNode) GetKeyAt(idx int) []byte {
+	return cellKey(n.data, idx)
+}
+
+// CanSetKeyAt returns true if the separator key at the given index can be
+// replaced by key without running out of room.
+func (n *VarInternalNode) CanSetKeyAt(idx int, key []byte) bool {
+	return freeSpace(n.data)+cellSize(n.data, idx) >= internalCellSize(key)
+}
+
+// SetKeyAt replaces the separator key at the given index.
+// Returns false if the node does not have room for the new key.
+func (n *VarInternalNode) SetKeyAt(idx int, key []byte) bool {
+	if !n.CanSetKeyAt(idx, key) {
+		return false
+	}
+	child := binary.BigEndian.Uint64(cellValue(n.data, idx))
+	removeCell(n.data, idx)
+	return insertCell(n.data, idx, key, childBytes(child))
+}
+
+// Search finds the child index for the given key.
+// Returns the index of the child pointer to follow.
+func (n *VarInternalNode) Search(key []byte) int {
+	// Find the first key greater than the search key
+	return sort.Search(n.KeyCount(), func(i int) bool {
+		return bytes.Compare(n.GetKeyAt(i), key) > 0
+	})
+}
+
+// Fits returns true if a separator key can be inserted without splitting the node.
+func (n *VarInternalNode) Fits(key []byte) bool {
+	return freeSpace(n.data) >= internalCellSize(key)
+}
+
+// Insert inserts a key with its right child pointer.
+// The left child should already be in place.
+// Returns false if the node does not have room for it; see Split.
+func (n *VarInternalNode) Insert(key []byte, rightChild uint64) bool {
+	return insertCell(n.data, n.Search(key), key, childBytes(rightChild))
+}
+
+// InitRoot initializes an internal node as a root with one key and two children.
+func (n *VarInternalNode) InitRoot(leftChild, rightChild uint64, key []byte) {
+	initSlotted(n.data, NodeTypeVarInternal)
+	n.SetChild(0, leftChild)
+	insertCell(n.data, 0, key, childBytes(rightChild))
+}
+
+// Split inserts a key with its right child pointer that does not fit and
+// splits the node into two of about equal size, moving the upper half to a
+// new node. Returns the middle key, which moves up to the parent, and the new node.
+func (n *VarInternalNode) Split(newData []byte, key []byte, rightChild uint64) ([]byte, *VarInternalNode) {
+	entries := n.entries()
+	idx := n.Search(key)
+	e := entry{key: bytes.Clone(key), value: childBytes(rightChild)}
+	entries = append(entries[:idx], append([]entry{e}, entries[idx:]...)...)
+
+	// The middle entry's key moves up; its child becomes the new node's leftmost
+	m := splitPoint(entries, 1, 2)
+	mid := entries[m]
+	child0 := n.GetChild(0)
+
+	initSlotted(n.data, NodeTypeVarInternal)
+	n.SetChild(0, child0)
+	writeCells(n.data, entries[:m])
+
+	newNode := NewVarInternalNode(newData, true)
+	newNode.SetChild(0, binary.BigEndian.Uint64(mid.value))
+	writeCells(newData, entries[m+1:])
+
+	return mid.key, newNode
+}
+
+// UsedSpace returns the number of bytes taken by the keys and children of the node.
+func (n *VarInternalNode) UsedSpace() int {
+	return usedSpace(n.data)
+}
+
+// IsUnderflow returns true if less than a quarter of the node is in use.
+func (n *VarInternalNode) IsUnderflow() bool {
+	return n.UsedSpace() < VarUsableSize/4
+}
+
+// CanLendTo returns true if this node can lend a key to a sibling and stay
+// at least half full.
+func (n *VarInternalNode) CanLendTo() bool {
+	return n.KeyCount() > 1 && n.UsedSpace()-maxInternalCellSize >= VarUsableSize/2
+}
+
+// CanMergeWith returns true if this node, the parent key and the right
+// sibling fit in one node.
+func (n *VarInternalNode) CanMergeWith(right *VarInternalNode, parentKey []byte) bool {
+	return n.UsedSpace()+internalCellSize(parentKey)+right.UsedSpace() <= VarUsableSize
+}
+
+// DeleteKeyAt removes the key and its right child at the given index.
+func (n *VarInternalNode) DeleteKeyAt(idx int) {
+	removeCell(n.data, idx)
+}
+
+// BorrowFromRight borrows the first key from the right sibling.
+// parentKey is the current separator in parent between this and right.
+// Returns the new separator key for the parent.
+func (n *VarInternalNode) BorrowFromRight(right *VarInternalNode, parentKey []byte) []byte {
+	// Parent key comes down with right's first child
+	insertCell(n.data, n.KeyCount(), parentKey, childBytes(right.GetChild(0)))
+
+	// Right's first key goes up, its child becomes right's leftmost
+	first := cellEntry(right.data, 0)
+	right.SetChild(0, binary.BigEndian.Uint64(first.value))
+	removeCell(right.data, 0)
+
+	return first.key
+}
+
+// BorrowFromLeft borrows the last key from the left sibling.
+// parentKey is the current separator in parent between left and this.
+// Returns the new separator key for the parent.
+func (n *VarInternalNode) BorrowFromLeft(left *VarInternalNode, parentKey []byte) []byte {
+	// Parent key comes down in front of our current leftmost child
+	insertCell(n.data, 0, parentKey, childBytes(n.GetChild(0)))
+
+	// Left's last child becomes our leftmost, its key goes up
+	last := left.KeyCount() - 1
+	e := cellEntry(left.data, last)
+	n.SetChild(0, binary.BigEndian.Uint64(e.value))
+	removeCell(left.data, last)
+
+	return e.key
+}
+
+// MergeWith merges the right sibling into this node using parentKey as
+// separator. The node must have room for it (see CanMergeWith).
+// After merge, the right sibling should be freed.
+func (n *VarInternalNode) MergeWith(right *VarInternalNode, parentKey []byte) {
+	insertCell(n.data, n.KeyCount(), parentKey, childBytes(right.GetChild(0)))
+	for i := 0; i < right.KeyCount(); i++ {
+		insertCell(n.data, n.KeyCount(), right.GetKeyAt(i), cellValue(right.data, i))
+	}
+}
+
+// entries returns copies of all keys and their right children.
+func (n *VarInternalNode) entries() []entry {
+	entries := make([]entry, n.KeyCount())
+	for i := range entries {
+		entries[i] = cellEntry(n.data, i)
+	}
+	return entries
+}
+
+// internalCellSize returns the space a separator key takes, slot and child included.
+func internalCellSize(key []byte) int {
+	return entry{key: key, value: make([]byte, 8)}.size()
+}
+
+// childBytes encodes a child page ID as a cell value.
+func childBytes(pageID uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, pageID)
+	return b
+}
@@ -0,0 +1,174 @@
+package bnode
+
+import (
+	"bytes"
+)
+
+// VarLeafNode provides operations on a leaf node with variable-length keys
+// and values. It is a slotted page (see slotted.go), with keys in
+// lexicographic order and the same leaf links as LeafNode.
+//
+// Nodes are balanced by bytes rather than by entry count: a node underflows
+// when less than a quarter of its space is in use.
+type VarLeafNode struct {
+	data []byte
+}
+
+// NewVarLeafNode creates a new variable-length leaf node wrapper around raw bytes.
+// If init is true, initializes the node as empty.
+func NewVarLeafNode(data []byte, init bool) *VarLeafNode {
+	n := &VarLeafNode{data: data}
+	if init {
+		initSlotted(data, NodeTypeVarLeaf)
+	}
+	return n
+}
+
+// Type returns the node type.
+func (n *VarLeafNode) Type() NodeType {
+	return GetNodeType(n.data)
+}
+
+// KeyCount returns the number of keys in this node.
+func (n *VarLeafNode) KeyCount() int {
+	return int(GetKeyCount(n.data))
+}
+
+// GetKeyAt returns the key at the given index. The slice aliases the page.
+func (n *VarLeafNode) GetKeyAt(idx int) []byte {
+	return cellKey(n.data, idx)
+}
+
+// GetValueAt returns the value at the given index. The slice aliases the page.
+func (n *VarLeafNode) GetValueAt(idx int) []byte {
+	return cellValue(n.data, idx)
+}
+
+// Search finds the position for key.
+// Returns (index, found) where index is the position of the key or where it would be inserted.
+func (n *VarLeafNode) Search(key []byte) (int, bool) {
+	return searchCells(n.data, key)
+}
+
+// Get retrieves the value for key. The slice aliases the page.
+func (n *VarLeafNode) Get(key []byte) ([]byte, bool) {
+	idx, found := n.Search(key)
+	if !found {
+		return nil, false
+	}
+	return n.GetValueAt(idx), true
+}
+
+// Fits returns true if key and value can be put without splitting the node.
+func (n *VarLeafNode) Fits(key, value []byte) bool {
+	free := freeSpace(n.data)
+	if idx, found := n.Search(key); found {
+		free += cellSize(n.data, idx) // An update reuses the old cell's space
+	}
+	return free >= entry{key: key, value: value}.size()
+}
+
+// Put inserts or updates a key-value pair.
+// Returns false if the node does not have room for it; see Split.
+func (n *VarLeafNode) Put(key, value []byte) bool {
+	if !n.Fits(key, value) {
+		return false
+	}
+
+	idx, found := n.Search(key)
+	if found {
+		removeCell(n.data, idx)
+	}
+	return insertCell(n.data, idx, key, value)
+}
+
+// Delete removes a key from the node.
+// Returns true if the key was found and deleted.
+func (n *VarLeafNode) Delete(key []byte) bool {
+	idx, found := n.Search(key)
+	if !found {
+		return false
+	}
+	removeCell(n.data, idx)
+	return true
+}
+
+// Split puts a key-value pair that does not fit and splits the node into
+// two of about equal size, moving the upper half to a new node.
+// Returns the separator for the parent and the new node.
+// The caller links the new node into the leaf chain.
+func (n *VarLeafNode) Split(newData []byte, key, value []byte) ([]byte, *VarLeafNode) {
+	entries := n.entries()
+	idx, found := n.Search(key)
+	e := entry{key: bytes.Clone(key), value: bytes.Clone(value)}
+	if found {
+		entries[idx] = e
+	} else {
+		entries = append(entries[:idx], append([]entry{e}, entries[idx:]...)...)
+	}
+
+	m := splitPoint(entries, 1, 1)
+	writeCells(n.data, entries[:m])
+	newNode := NewVarLeafNode(newData, true)
+	writeCells(newData, entries[m:])
+
+	return Separator(entries[m-1].key, entries[m].key), newNode
+}
+
+// UsedSpace returns the number of bytes taken by the entries of the node.
+func (n *VarLeafNode) UsedSpace() int {
+	return usedSpace(n.data)
+}
+
+// IsUnderflow returns true if less than a quarter of the node is in use.
+// Root nodes are exempt from minimum size requirements.
+func (n *VarLeafNode) IsUnderflow() bool {
+	return n.UsedSpace() < VarUsableSize/4
+}
+
+// CanLendTo returns true if this node can lend its first or last entry to a
+// sibling and stay at least half full.
+func (n *VarLeafNode) CanLendTo() bool {
+	return n.KeyCount() > 1 && n.UsedSpace()-maxLeafCellSize >= VarUsableSize/2
+}
+
+// CanMergeWith returns true if the entries of both nodes fit in one node.
+func (n *VarLeafNode) CanMergeWith(right *VarLeafNode) bool {
+	return n.UsedSpace()+right.UsedSpace() <= VarUsableSize
+}
+
+// BorrowFromRight borrows the first entry from the right sibling.
+// Returns the new separator for the parent.
+func (n *VarLeafNode) BorrowFromRight(right *VarLeafNode) []byte {
+	e := cellEntry(right.data, 0)
+	removeCell(right.data, 0)
+	insertCell(n.data, n.KeyCount(), e.key, e.value)
+	return Separator(e.key, right.GetKeyAt(0))
+}
+
+// BorrowFromLeft borrows the last entry from the left sibling.
+// Returns the new separator for the parent.
+func (n *VarLeafNode) BorrowFromLeft(left *VarLeafNode) []byte {
+	last := left.KeyCount() - 1
+	e := cellEntry(left.data, last)
+	removeCell(left.data, last)
+	insertCell(n.data, 0, e.key, e.value)
+	return Separator(left.GetKeyAt(last-1), e.key)
+}
+
+// MergeWith merges the right sibling into this node, which must have room
+// for it (see CanMergeWith). After merge, the right sibling should be freed.
+func (n *VarLeafNode) MergeWith(right *VarLeafNode) {
+	for i := 0; i < right.KeyCount(); i++ {
+		insertCell(n.data, n.KeyCount(), right.GetKeyAt(i), right.GetValueAt(i))
+	}
+}
+
+// entries returns copies of all entries of the node.
+func (n *VarLeafNode) entries() []entry {
+	entries := make([]entry, n.KeyCount())
+	for i := range entries {
+		entries[i] = cellEntry(n.data, i)
+	}
+	return entries
+}
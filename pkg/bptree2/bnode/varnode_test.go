@@ -0,0 +1,139 @@
+package bnode_test
+
+import (
+	"bptree2/bnode"
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestVarLeafNodePutGet(t *testing.T) {
+	data := make([]byte, 4096)
+	leaf := bnode.NewVarLeafNode(data, true)
+
+	if leaf.Type() != bnode.NodeTypeVarLeaf {
+		t.Error("expected var leaf type")
+	}
+
+	leaf.Put([]byte("banana"), []byte("yellow"))
+	leaf.Put([]byte("apple"), []byte("red"))
+	leaf.Put([]byte("cherry"), []byte("dark red"))
+	leaf.Put([]byte(""), []byte("empty key"))
+
+	// Keys are kept in lexicographic order
+	expected := []string{"", "apple", "banana", "cherry"}
+	for i, key := range expected {
+		if got := string(leaf.GetKeyAt(i)); got != key {
+			t.Errorf("key %d: expected %q, got %q", i, key, got)
+		}
+	}
+
+	// Update with a longer value
+	leaf.Put([]byte("apple"), []byte("green or red"))
+	if val, found := leaf.Get([]byte("apple")); !found || string(val) != "green or red" {
+		t.Errorf("expected updated value, got %q (found=%v)", val, found)
+	}
+	if leaf.KeyCount() != 4 {
+		t.Errorf("expected 4 keys, got %d", leaf.KeyCount())
+	}
+
+	if !leaf.Delete([]byte("banana")) {
+		t.Error("Delete failed")
+	}
+	if _, found := leaf.Get([]byte("banana")); found {
+		t.Error("banana should be deleted")
+	}
+}
+
+func TestVarLeafNodeReusesSpace(t *testing.T) {
+	data := make([]byte, 4096)
+	leaf := bnode.NewVarLeafNode(data, true)
+	value := bytes.Repeat([]byte("x"), 90)
+
+	// Deleting and inserting many times only works if holes are compacted
+	for i := 0; i < 1000; i++ {
+		key := []byte(fmt.Sprintf("key%03d", i%40))
+		if !leaf.Put(key, value) {
+			t.Fatalf("Put %d failed with %d keys, %d bytes used", i, leaf.KeyCount(), leaf.UsedSpace())
+		}
+		if i%3 == 0 {
+			leaf.Delete(key)
+		}
+	}
+}
+
+func TestVarLeafNodeSplit(t *testing.T) {
+	data := make([]byte, 4096)
+	leaf := bnode.NewVarLeafNode(data, true)
+	value := bytes.Repeat([]byte("v"), 50)
+
+	i := 0
+	for ; leaf.Put([]byte(fmt.Sprintf("key%04d", i)), value); i++ {
+	}
+	total := i + 1
+
+	newData := make([]byte, 4096)
+	sep, right := leaf.Split(newData, []byte(fmt.Sprintf("key%04d", i)), value)
+
+	if leaf.KeyCount()+right.KeyCount() != total {
+		t.Errorf("expected %d keys after split, got %d", total, leaf.KeyCount()+right.KeyCount())
+	}
+	if diff := leaf.UsedSpace() - right.UsedSpace(); diff < -200 || diff > 200 {
+		t.Errorf("unbalanced split: %d vs %d bytes", leaf.UsedSpace(), right.UsedSpace())
+	}
+
+	// The separator lies between both halves
+	last := leaf.GetKeyAt(leaf.KeyCount() - 1)
+	first := right.GetKeyAt(0)
+	if bytes.Compare(last, sep) >= 0 || bytes.Compare(sep, first) > 0 {
+		t.Errorf("separator %q not between %q and %q", sep, last, first)
+	}
+}
+
+func TestSeparator(t *testing.T) {
+	tests := []struct{ left, right, expected string }{
+		{"apple", "banana", "b"},
+		{"apple", "apricot", "apr"},
+		{"app", "apple", "appl"},
+		{"", "a", "a"},
+	}
+	for _, tt := range tests {
+		if got := string(bnode.Separator([]byte(tt.left), []byte(tt.right))); got != tt.expected {
+			t.Errorf("Separator(%q, %q) = %q, expected %q", tt.left, tt.right, got, tt.expected)
+		}
+	}
+}
+
+func TestVarInternalNode(t *testing.T) {
+	data := make([]byte, 4096)
+	node := bnode.NewVarInternalNode(data, true)
+	node.InitRoot(1, 2, []byte("m"))
+	node.Insert([]byte("t"), 3)
+	node.Insert([]byte("f"), 4)
+
+	tests := []struct {
+		key   string
+		child uint64
+	}{
+		{"a", 1}, {"f", 4}, {"g", 4}, {"m", 2}, {"s", 2}, {"t", 3}, {"zzz", 3},
+	}
+	for _, tt := range tests {
+		if got := node.GetChild(node.Search([]byte(tt.key))); got != tt.child {
+			t.Errorf("key %q: expected child %d, got %d", tt.key, tt.child, got)
+		}
+	}
+
+	// Fill up and split
+	i := 0
+	for ; node.Insert([]byte(fmt.Sprintf("u%0100d", i)), uint64(100+i)); i++ {
+	}
+	count := node.KeyCount() + 1
+	mid, right := node.Split(make([]byte, 4096), []byte(fmt.Sprintf("u%0100d", i)), uint64(100+i))
+
+	if node.KeyCount()+right.KeyCount()+1 != count {
+		t.Errorf("expected %d keys across split, got %d", count, node.KeyCount()+right.KeyCount()+1)
+	}
+	if bytes.Compare(node.GetKeyAt(node.KeyCount()-1), mid) >= 0 || bytes.Compare(mid, right.GetKeyAt(0)) >= 0 {
+		t.Errorf("middle key %q out of order", mid)
+	}
+}
@@ -0,0 +1,168 @@
+package bpager
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"sync/atomic"
+
+	"bptree2/bwal"
+)
+
+// Every page of a file of the current Version carries a CRC32C checksum of
+// its contents and its page ID, so that bit rot, a torn write or a page
+// written to the wrong place is detected when the page is read. Flash
+// computes the checksums of the pages it writes. Pages are verified when
+// they are read from the file; pages not flashed yet are trusted.
+//
+// The checksum takes bytes 12 to 16 of a page, which the headers of nodes
+// (see bnode), stacks and free pages leave unused, and which overflow and
+// radix table pages reserve in their PageHeaderSize header. The meta page
+// keeps it after the meta page header.
+
+const (
+	// ChecksumOffset is the offset of the checksum in every page but the meta page.
+	ChecksumOffset = 12
+
+	// MetaChecksumOffset is the offset of the checksum in the meta page.
+	MetaChecksumOffset = MetaPageHeaderSize
+
+	// PageHeaderSize is the size of the header of overflow and radix table
+	// pages, which ends with the checksum.
+	PageHeaderSize = ChecksumOffset + 4 // 16 bytes
+
+	// rewriteBatch is the number of pages logged at a time when Flash gives
+	// every page a checksum after an upgrade.
+	rewriteBatch = 256
+)
+
+// ErrCorruptPage reports a page that does not match its checksum.
+// Every read of a page, such as GetPage or GetRootPage, returns it.
+type ErrCorruptPage struct {
+	PageID PageID
+}
+
+func (e ErrCorruptPage) Error() string {
+	return fmt.Sprintf("page %d is corrupt: checksum mismatch", e.PageID)
+}
+
+// VerifyLevel sets which reads of a page verify its checksum.
+type VerifyLevel int
+
+const (
+	// VerifyNone never verifies checksums.
+	VerifyNone VerifyLevel = iota
+
+	// VerifyOnce verifies a page the first time it is read from the file
+	// after Open. This is the default.
+	VerifyOnce
+
+	// VerifyAlways verifies a page every time it is read from the file.
+	VerifyAlways
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// checksumOffset returns the offset of the checksum in page id.
+func checksumOffset(id PageID) int {
+	if id == MetaPageID {
+		return MetaChecksumOffset
+	}
+	return ChecksumOffset
+}
+
+// pageChecksum returns the checksum of page id, leaving out the bytes that hold it.
+func pageChecksum(id PageID, data []byte) uint32 {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], id)
+	off := checksumOffset(id)
+	sum := crc32.Update(0, castagnoli, buf[:])
+	sum = crc32.Update(sum, castagnoli, data[:off])
+	return crc32.Update(sum, castagnoli, data[off+4:])
+}
+
+// setChecksum stores the checksum of page id in it.
+func setChecksum(id PageID, data []byte) {
+	off := checksumOffset(id)
+	binary.BigEndian.PutUint32(data[off:off+4], pageChecksum(id, data))
+}
+
+// validChecksum returns true if page id matches the checksum it holds.
+func validChecksum(id PageID, data []byte) bool {
+	off := checksumOffset(id)
+	return binary.BigEndian.Uint32(data[off:off+4]) == pageChecksum(id, data)
+}
+
+// SetVerify sets which reads verify the checksum of a page. Version 2 files
+// have no checksums and are never verified.
+func (p *Pager) SetVerify(level VerifyLevel) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.verify = level
+}
+
+// verifyPage returns ErrCorruptPage if a page read from the file does not
+// match its checksum, unless the verification level skips the read.
+// Must be called with p.mu held, for reading at least.
+func (p *Pager) verifyPage(id PageID, data []byte) error {
+	if p.verify == VerifyNone || p.meta.Version < Version || p.rewrite {
+		return nil
+	}
+
+	// The bitset is only resized with p.mu held for writing
+	word, bit := id/64, uint64(1)<<(id%64)
+	once := p.verify == VerifyOnce && word < uint64(len(p.verified))
+	if once && atomic.LoadUint64(&p.verified[word])&bit != 0 {
+		return nil
+	}
+	if !validChecksum(id, data) {
+		return ErrCorruptPage{PageID: id}
+	}
+	if once {
+		atomic.OrUint64(&p.verified[word], bit)
+	}
+	return nil
+}
+
+// resizeVerified sizes the bitset of verified pages to the file.
+// Must be called with p.mu held.
+func (p *Pager) resizeVerified() {
+	words := int((p.mmap.Size()/PageSize + 63) / 64)
+	if words > len(p.verified) {
+		p.verified = append(p.verified, make([]uint64, words-len(p.verified))...)
+	}
+}
+
+// logRewrite logs a copy with a checksum of every page that is not dirty,
+// as part of the group that the next Append commits. Only rewriteBatch
+// pages are held in memory at a time. Must be called with p.mu held.
+func (p *Pager) logRewrite() error {
+	buf := make([]byte, rewriteBatch*PageSize)
+	frames := make([]bwal.Frame, 0, rewriteBatch)
+	for id := PageID(1); id < p.meta.PageCount; id++ {
+		if _, ok := p.dirty[id]; ok {
+			continue
+		}
+		data := buf[len(frames)*PageSize : (len(frames)+1)*PageSize]
+		copy(data, p.mmap.Slice(int64(id)*PageSize, PageSize))
+		setChecksum(id, data)
+		frames = append(frames, bwal.Frame{PageID: id, Data: data})
+		if len(frames) == rewriteBatch {
+			if err := p.wal.Write(frames); err != nil {
+				return err
+			}
+			frames = frames[:0]
+		}
+	}
+	return p.wal.Write(frames)
+}
+
+// applyRewrite sets the checksums logged by logRewrite in the file, once the
+// log has committed them. Must be called with p.mu held.
+func (p *Pager) applyRewrite() {
+	for id := PageID(1); id < p.meta.PageCount; id++ {
+		if _, ok := p.dirty[id]; !ok {
+			setChecksum(id, p.mmap.Slice(int64(id)*PageSize, PageSize))
+		}
+	}
+}
@@ -0,0 +1,388 @@
+package bpager
+
+import (
+	"encoding/binary"
+	"fmt"
+	"slices"
+)
+
+// Compaction moves the pages in use to the front of the file, so that the
+// free pages gather at its end, where Truncate cuts them off. Every page
+// past the relocation limit is copied to a free page before it and freed;
+// whoever refers to the page then refers to the copy. The pager moves its
+// own pages with RelocateTables. The pages of trees are moved by the tree
+// layer, which knows how they refer to each other, with RelocatePage.
+
+// RelocationLimit puts the free list in page order, so that the front of
+// the file is allocated first, and returns the number of pages in use.
+// Once every page in use at or past the limit has been relocated, the
+// pages from the limit on are free.
+func (tx *Tx) RelocationLimit() (PageID, error) {
+	if err := tx.checkWritable(); err != nil {
+		return 0, err
+	}
+
+	free, err := freePages(tx.meta, tx.GetPage)
+	if err != nil {
+		return 0, err
+	}
+	slices.Sort(free)
+
+	tx.meta.FreeList = 0
+	for _, id := range slices.Backward(free) {
+		data := make([]byte, PageSize)
+		binary.BigEndian.PutUint64(data[0:8], tx.meta.FreeList)
+		tx.pages[id] = data
+		tx.meta.FreeList = id
+	}
+	return tx.meta.PageCount - uint64(len(free)), nil
+}
+
+// Relocation is a relocation that commits its progress in several
+// transactions, where RelocationLimit would rewrite the whole free list in
+// one. The free list is kept in three parts: the free pages before the
+// limit, which are allocated first, the free pages at or past it, and the
+// pages not sorted into either yet. SortFreePages sorts a number of pages
+// at a time; pages put on the free list between transactions, such as
+// those a committed transaction freed, are sorted by ResumeRelocation.
+// A Relocation must not be used after a transaction that used it is rolled
+// back.
+type Relocation struct {
+	limit PageID
+	low   freeChain // free pages before limit
+	high  freeChain // free pages at or past limit
+	rest  PageID    // head of the free pages not sorted yet
+}
+
+// freeChain is a part of the free list.
+type freeChain struct {
+	head, tail PageID
+}
+
+// BeginRelocation starts a relocation with the number of pages in use as
+// its limit, like RelocationLimit, but leaves the free list to
+// SortFreePages.
+func (tx *Tx) BeginRelocation() (*Relocation, error) {
+	if err := tx.checkWritable(); err != nil {
+		return nil, err
+	}
+	free, err := freePages(tx.meta, tx.GetPage)
+	if err != nil {
+		return nil, err
+	}
+	r := &Relocation{
+		limit: tx.meta.PageCount - uint64(len(free)),
+		rest:  tx.meta.FreeList,
+	}
+	tx.relocation = r
+	return r, nil
+}
+
+// Limit returns the relocation limit.
+func (r *Relocation) Limit() PageID {
+	return r.limit
+}
+
+// ResumeRelocation continues r in tx, a transaction that began after the
+// one that left off. The pages put on the free list in between are sorted
+// into the free pages before or past the limit.
+func (tx *Tx) ResumeRelocation(r *Relocation) error {
+	if err := tx.checkWritable(); err != nil {
+		return err
+	}
+	tx.relocation = r
+
+	first := r.rest
+	for _, c := range []freeChain{r.high, r.low} {
+		if c.head != 0 {
+			first = c.head
+		}
+	}
+	for id, steps := tx.meta.FreeList, uint64(0); id != first; steps++ {
+		// The list cannot be longer than the file; anything else is a loop
+		if id == 0 || steps >= tx.meta.PageCount {
+			return fmt.Errorf("free list lost page %d", first)
+		}
+		next, err := tx.nextFree(id)
+		if err != nil {
+			return err
+		}
+		if err := tx.sortFree(id); err != nil {
+			return err
+		}
+		id = next
+	}
+	return tx.linkFree()
+}
+
+// SortFreePages sorts up to n of the free pages the relocation has not
+// sorted yet into those before or past the limit. Returns true if pages
+// are left to sort.
+func (tx *Tx) SortFreePages(n int) (bool, error) {
+	if err := tx.checkWritable(); err != nil {
+		return false, err
+	}
+	r := tx.relocation
+	if r == nil {
+		return false, fmt.Errorf("no relocation in progress")
+	}
+
+	for ; n > 0 && r.rest != 0; n-- {
+		id := r.rest
+		next, err := tx.nextFree(id)
+		if err != nil {
+			return false, err
+		}
+		if err := tx.sortFree(id); err != nil {
+			return false, err
+		}
+		r.rest = next
+	}
+	return r.rest != 0, tx.linkFree()
+}
+
+// sortFree adds a free page to the part of the free list it belongs to.
+// The free list is whole again after linkFree.
+func (tx *Tx) sortFree(id PageID) error {
+	r := tx.relocation
+	c := &r.high
+	if id < r.limit {
+		c = &r.low
+	}
+	if c.head == 0 {
+		c.head = id
+	} else {
+		data, err := tx.GetPageForWrite(c.tail)
+		if err != nil {
+			return err
+		}
+		binary.BigEndian.PutUint64(data[0:8], id)
+	}
+	c.tail = id
+	return nil
+}
+
+// linkFree joins the parts of the free list of the relocation.
+func (tx *Tx) linkFree() error {
+	r := tx.relocation
+	next := r.rest
+	for _, c := range []freeChain{r.high, r.low} {
+		if c.head == 0 {
+			continue
+		}
+		data, err := tx.GetPageForWrite(c.tail)
+		if err != nil {
+			return err
+		}
+		binary.BigEndian.PutUint64(data[0:8], next)
+		next = c.head
+	}
+	tx.meta.FreeList = next
+	return nil
+}
+
+// nextFree returns the page after id on the free list.
+func (tx *Tx) nextFree(id PageID) (PageID, error) {
+	if id == MetaPageID || id >= tx.meta.PageCount {
+		return 0, fmt.Errorf("invalid free page %d", id)
+	}
+	data, err := tx.GetPage(id)
+	if err != nil {
+		return 0, err
+	}
+	if data == nil {
+		return 0, fmt.Errorf("failed to get free page %d", id)
+	}
+	return binary.BigEndian.Uint64(data[0:8]), nil
+}
+
+// allocated updates r for a page taken off the front of the free list.
+func (r *Relocation) allocated(id, next PageID) {
+	switch id {
+	case r.low.head:
+		r.low.head = next
+		if id == r.low.tail {
+			r.low = freeChain{}
+		}
+	case r.high.head:
+		r.high.head = next
+		if id == r.high.tail {
+			r.high = freeChain{}
+		}
+	case r.rest:
+		r.rest = next
+	}
+}
+
+// RelocatePage moves a page at or past limit to the first free page, if
+// that lies before it, and frees the page. Returns the ID of the page to
+// use from now on, which is id itself if the page stays. A shared page
+// takes its references along.
+func (tx *Tx) RelocatePage(id, limit PageID) (PageID, error) {
+	if err := tx.checkWritable(); err != nil {
+		return 0, err
+	}
+	if id < limit || tx.meta.FreeList == 0 || tx.meta.FreeList > id {
+		return id, nil
+	}
+	data, err := tx.GetPage(id)
+	if err != nil {
+		return 0, err
+	}
+	if data == nil {
+		return 0, fmt.Errorf("failed to get page %d for relocation", id)
+	}
+
+	newID, err := tx.AllocatePage()
+	if err != nil {
+		return 0, err
+	}
+	newData, err := tx.GetPageForWrite(newID)
+	if err != nil {
+		return 0, err
+	}
+	copy(newData, data)
+
+	extra, err := radixLookup(tx.meta.Refs, tx.meta.RefLimit, id, tx.GetPage)
+	if err != nil {
+		return 0, err
+	}
+	if extra != 0 {
+		if err := tx.setRefs(id, 0); err != nil {
+			return 0, err
+		}
+		if err := tx.setRefs(newID, extra); err != nil {
+			return 0, err
+		}
+	}
+	return newID, tx.FreePage(id)
+}
+
+// RelocateOverflow relocates the pages of the overflow chain starting at id
+// like RelocatePage, and returns the ID of its first page.
+func (tx *Tx) RelocateOverflow(id, limit PageID) (PageID, error) {
+	err := tx.relocateChain(&id, limit)
+	return id, err
+}
+
+// RelocateTables relocates the pages of the root directory, the reference
+// table and the stack of deleted root IDs. The reclaim queue must be empty,
+// as the pages it holds would not be relocated.
+func (tx *Tx) RelocateTables(limit PageID) error {
+	if err := tx.checkWritable(); err != nil {
+		return err
+	}
+	if tx.meta.Reclaim != 0 {
+		return fmt.Errorf("the reclaim queue must be empty for relocation")
+	}
+
+	if err := tx.radixRelocate(&tx.meta.RootDir, tx.meta.NextRoot, limit); err != nil {
+		return fmt.Errorf("failed to relocate root directory: %w", err)
+	}
+	if err := tx.radixRelocate(&tx.meta.Refs, tx.meta.RefLimit, limit); err != nil {
+		return fmt.Errorf("failed to relocate reference table: %w", err)
+	}
+	if err := tx.relocateChain(&tx.meta.FreeRoots, limit); err != nil {
+		return fmt.Errorf("failed to relocate deleted root IDs: %w", err)
+	}
+	return nil
+}
+
+// relocateChain relocates the pages of a chain starting at *head, in which
+// each page holds the ID of the next in its first 8 bytes, as stacks and
+// overflow chains do. Each page is relocated before the next is read.
+func (tx *Tx) relocateChain(head *PageID, limit PageID) error {
+	prev := PageID(0)
+	for id, steps := *head, uint64(0); id != 0; steps++ {
+		// A chain cannot be longer than the file; anything else is a loop
+		if id == MetaPageID || id >= tx.meta.PageCount || steps >= tx.meta.PageCount {
+			return fmt.Errorf("invalid chain page %d", id)
+		}
+		newID, err := tx.RelocatePage(id, limit)
+		if err != nil {
+			return err
+		}
+		if prev == 0 {
+			*head = newID
+		} else if newID != id {
+			data, err := tx.GetPageForWrite(prev)
+			if err != nil {
+				return err
+			}
+			binary.BigEndian.PutUint64(data[0:8], newID)
+		}
+		prev = newID
+		data, err := tx.GetPage(newID)
+		if err != nil {
+			return err
+		}
+		id = binary.BigEndian.Uint64(data[0:8])
+	}
+	return nil
+}
+
+// Truncate cuts the free pages at the end of the file off and shrinks the
+// file to the pages left, though not below InitialFileSize. The free pages
+// left are put on the free list in page order. Like Flash, Truncate waits
+// for the active writable transaction, and flashes all changes.
+func (p *Pager) Truncate() error {
+	p.wmu.Lock()
+	defer p.wmu.Unlock()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.reclaimPending(p.oldestSnapshot())
+	meta := *p.meta
+	free, err := freePages(&meta, p.page)
+	if err != nil {
+		return err
+	}
+	slices.Sort(free)
+
+	// Pages freed while snapshots are open are not on the free list yet,
+	// so the cut stops before them
+	for len(free) > 0 && free[len(free)-1] == meta.PageCount-1 {
+		free = free[:len(free)-1]
+		meta.PageCount--
+		delete(p.dirty, meta.PageCount)
+	}
+
+	meta.FreeList = 0
+	for _, id := range slices.Backward(free) {
+		data := make([]byte, PageSize)
+		binary.BigEndian.PutUint64(data[0:8], meta.FreeList)
+		p.dirty[id] = data
+		meta.FreeList = id
+	}
+	p.meta = &meta
+	p.writeMeta()
+
+	if err := p.flash(); err != nil {
+		return err
+	}
+	if err := p.mmap.Shrink(max(int64(meta.PageCount)*PageSize, InitialFileSize)); err != nil {
+		return fmt.Errorf("failed to shrink file: %w", err)
+	}
+	return nil
+}
+
+// freePages returns the pages on the free list of meta, read with page.
+func freePages(meta *MetaPage, page func(PageID) ([]byte, error)) ([]PageID, error) {
+	var free []PageID
+	for id := meta.FreeList; id != 0; {
+		// The list cannot be longer than the file; anything else is a loop
+		if id == MetaPageID || id >= meta.PageCount || uint64(len(free)) >= meta.PageCount {
+			return nil, fmt.Errorf("invalid free page %d", id)
+		}
+		data, err := page(id)
+		if err != nil {
+			return nil, err
+		}
+		if data == nil {
+			return nil, fmt.Errorf("failed to get free page %d", id)
+		}
+		free = append(free, id)
+		id = binary.BigEndian.Uint64(data[0:8])
+	}
+	return free, nil
+}
@@ -0,0 +1,119 @@
+package bpager
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	// OverflowHeaderSize is the header size of an overflow page: the next
+	// page of the chain (8 bytes), the payload length in this page (4 bytes)
+	// and the page checksum (4 bytes).
+	OverflowHeaderSize = PageHeaderSize
+
+	// OverflowCapacity is the payload a single overflow page holds.
+	OverflowCapacity = PageSize - OverflowHeaderSize // 4080 bytes
+)
+
+// WriteOverflow stores data in a new chain of overflow pages and returns
+// the ID of its first page. An empty slice takes one page.
+func (tx *Tx) WriteOverflow(data []byte) (PageID, error) {
+	if err := tx.checkWritable(); err != nil {
+		return 0, err
+	}
+
+	// Allocate the whole chain first, so that each page can point at the next
+	n := max((len(data)+OverflowCapacity-1)/OverflowCapacity, 1)
+	ids := make([]PageID, n)
+	for i := range ids {
+		id, err := tx.AllocatePage()
+		if err != nil {
+			return 0, fmt.Errorf("failed to allocate overflow page: %w", err)
+		}
+		ids[i] = id
+	}
+
+	for i, id := range ids {
+		chunk := data[min(i*OverflowCapacity, len(data)):min((i+1)*OverflowCapacity, len(data))]
+		page, err := tx.GetPageForWrite(id)
+		if err != nil {
+			return 0, err
+		}
+		next := PageID(0)
+		if i+1 < n {
+			next = ids[i+1]
+		}
+		binary.BigEndian.PutUint64(page[0:8], next)
+		binary.BigEndian.PutUint32(page[8:12], uint32(len(chunk)))
+		copy(page[OverflowHeaderSize:], chunk)
+	}
+
+	return ids[0], nil
+}
+
+// ReadOverflow returns a copy of the data stored in the overflow chain starting at id.
+func (tx *Tx) ReadOverflow(id PageID) ([]byte, error) {
+	var data []byte
+	err := tx.walkOverflow(id, func(id PageID, page []byte) error {
+		length := binary.BigEndian.Uint32(page[8:12])
+		if length > OverflowCapacity {
+			return fmt.Errorf("invalid overflow page %d: length %d", id, length)
+		}
+		data = append(data, page[OverflowHeaderSize:OverflowHeaderSize+length]...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// FreeOverflow frees every page of the overflow chain starting at id.
+// A chain is shared as a whole through its first page: if that is shared,
+// it only loses a reference.
+func (tx *Tx) FreeOverflow(id PageID) error {
+	if err := tx.checkWritable(); err != nil {
+		return err
+	}
+	if dropped, err := tx.dropRef(id); dropped || err != nil {
+		return err
+	}
+
+	var ids []PageID
+	err := tx.walkOverflow(id, func(id PageID, page []byte) error {
+		ids = append(ids, id)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if err := tx.FreePage(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkOverflow calls fn for every page of the overflow chain starting at id.
+func (tx *Tx) walkOverflow(id PageID, fn func(id PageID, page []byte) error) error {
+	// A chain cannot be longer than the file; anything else is a loop
+	for steps := uint64(0); id != 0; steps++ {
+		if id == MetaPageID || id >= tx.meta.PageCount || steps >= tx.meta.PageCount {
+			return fmt.Errorf("invalid overflow page %d", id)
+		}
+		page, err := tx.GetPage(id)
+		if err != nil {
+			return err
+		}
+		if page == nil {
+			return fmt.Errorf("failed to get overflow page %d", id)
+		}
+		if err := fn(id, page); err != nil {
+			return err
+		}
+		id = binary.BigEndian.Uint64(page[0:8])
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package bpager_test
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"bptree2/bpager"
+)
+
+func TestOverflowChain(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	p, err := bpager.Open(path)
+	if err != nil {
+		t.Fatalf("bpager.Open failed: %v", err)
+	}
+	defer p.Close()
+
+	for _, size := range []int{0, 1, bpager.OverflowCapacity, bpager.OverflowCapacity + 1, 3*bpager.OverflowCapacity + 100} {
+		data := bytes.Repeat([]byte{byte(size)}, size)
+
+		tx := p.Begin(true)
+		id, err := tx.WriteOverflow(data)
+		if err != nil {
+			t.Fatalf("size %d: WriteOverflow failed: %v", size, err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatalf("Commit failed: %v", err)
+		}
+
+		tx = p.Begin(false)
+		got, err := tx.ReadOverflow(id)
+		tx.Rollback()
+		if err != nil {
+			t.Fatalf("size %d: ReadOverflow failed: %v", size, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("size %d: read back %d bytes", size, len(got))
+		}
+
+		// Freed pages return to the free list and are reused
+		before := p.PageCount()
+		tx = p.Begin(true)
+		if err := tx.FreeOverflow(id); err != nil {
+			t.Fatalf("size %d: FreeOverflow failed: %v", size, err)
+		}
+		tx.Commit()
+
+		tx = p.Begin(true)
+		if _, err := tx.WriteOverflow(data); err != nil {
+			t.Fatalf("size %d: WriteOverflow failed: %v", size, err)
+		}
+		tx.Commit()
+		if after := p.PageCount(); after != before {
+			t.Errorf("size %d: rewriting after free grew the file from %d to %d pages", size, before, after)
+		}
+	}
+}
@@ -16,11 +16,21 @@ const (
 	// Magic number to identify BPTree files
 	Magic uint32 = 0x42505452 // "BPTR"
 
-	// Version of the file format (2 = multi-root support)
-	Version uint32 = 2
+	// Version of the file format (2 = multi-root support, 3 = roots in a root
+	// directory, page checksums, and the node layouts of package bnode)
+	Version uint32 = 3
 
-	// MaxRoots is the maximum number of root trees supported
-	MaxRoots = 500
+	// MinVersion is the oldest file format that can still be opened.
+	// Version 2 files keep the roots in a table in the meta page, have no
+	// checksums, and are upgraded by the tree layer.
+	MinVersion uint32 = 2
+
+	// LegacyMaxRoots is the number of roots the meta page table of version 2 files holds.
+	LegacyMaxRoots = 500
+
+	// CatalogRoot is the root ID of the tree catalog, which maps tree names
+	// to root IDs. It is never handed out by CreateRoot, nor counted as a root.
+	CatalogRoot RootID = ^RootID(0)
 )
 
 // PageID is the identifier for a page.
@@ -32,67 +42,81 @@ type RootID = uint64
 // MetaPage represents the file header and metadata.
 // Stored at page 0.
 type MetaPage struct {
-	Reserved  [8]byte          // Reserved for future use
-	Magic     uint32           // File format magic number
-	Version   uint32           // File format version
-	RootCount uint64           // Number of active roots
-	PageCount uint64           // Total number of allocated pages
-	FreeList  PageID           // Head of free page list (0 if none)
-	RootTable [MaxRoots]PageID // rootID → root page mapping
+	Reclaim   PageID // Head of the reclaim queue (0 if none)
+	Magic     uint32 // File format magic number
+	Version   uint32 // File format version
+	RootCount uint64 // Number of active roots
+	PageCount uint64 // Total number of allocated pages
+	FreeList  PageID // Head of free page list (0 if none)
+	RootDir   PageID // Top page of the root directory (0 if none)
+	NextRoot  RootID // Root IDs below NextRoot have been handed out
+	FreeRoots PageID // Head of the stack of deleted root IDs (0 if none)
+	Catalog   PageID // Root page of the tree catalog (0 if none)
+	Refs      PageID // Top page of the reference table (0 if none)
+	RefLimit  PageID // The reference table covers page IDs below RefLimit
+
+	// RootTable maps rootIDs to root pages in version 2 files, and is nil
+	// otherwise.
+	RootTable []PageID
 }
 
-// MetaPageSize is the serialized size of MetaPage header (before RootTable).
-const MetaPageHeaderSize = 8 + 4 + 4 + 8 + 8 + 8 // 40 bytes
+// MetaPageHeaderSize is the serialized size of the MetaPage header.
+// In version 2 files the root table starts at LegacyMetaPageHeaderSize.
+const (
+	MetaPageHeaderSize       = 8 + 4 + 4 + 8 + 8 + 8 + 8 + 8 + 8 + 8 + 8 + 8 // 88 bytes
+	LegacyMetaPageHeaderSize = 8 + 4 + 4 + 8 + 8 + 8                         // 40 bytes
+)
 
 // Serialize writes the meta page to a byte slice.
 func (m *MetaPage) Serialize(buf []byte) {
-	// Bytes 0-7 are reserved
+	binary.BigEndian.PutUint64(buf[0:8], m.Reclaim)
 	binary.BigEndian.PutUint32(buf[8:12], m.Magic)
 	binary.BigEndian.PutUint32(buf[12:16], m.Version)
 	binary.BigEndian.PutUint64(buf[16:24], m.RootCount)
 	binary.BigEndian.PutUint64(buf[24:32], m.PageCount)
 	binary.BigEndian.PutUint64(buf[32:40], m.FreeList)
 
-	// Serialize RootTable
-	offset := 40
-	for i := 0; i < MaxRoots && offset+8 <= PageSize; i++ {
-		binary.BigEndian.PutUint64(buf[offset:offset+8], m.RootTable[i])
-		offset += 8
+	if m.RootTable != nil {
+		offset := LegacyMetaPageHeaderSize
+		for i := range m.RootTable {
+			binary.BigEndian.PutUint64(buf[offset:offset+8], m.RootTable[i])
+			offset += 8
+		}
+		return
 	}
+	binary.BigEndian.PutUint64(buf[40:48], m.RootDir)
+	binary.BigEndian.PutUint64(buf[48:56], m.NextRoot)
+	binary.BigEndian.PutUint64(buf[56:64], m.FreeRoots)
+	binary.BigEndian.PutUint64(buf[64:72], m.Catalog)
+	binary.BigEndian.PutUint64(buf[72:80], m.Refs)
+	binary.BigEndian.PutUint64(buf[80:88], m.RefLimit)
+	clear(buf[MetaPageHeaderSize:PageSize]) // The root table of an upgraded file
 }
 
 // Deserialize reads the meta page from a byte slice.
 func (m *MetaPage) Deserialize(buf []byte) {
-	// Bytes 0-7 are reserved
+	m.Reclaim = binary.BigEndian.Uint64(buf[0:8])
 	m.Magic = binary.BigEndian.Uint32(buf[8:12])
 	m.Version = binary.BigEndian.Uint32(buf[12:16])
 	m.RootCount = binary.BigEndian.Uint64(buf[16:24])
 	m.PageCount = binary.BigEndian.Uint64(buf[24:32])
 	m.FreeList = binary.BigEndian.Uint64(buf[32:40])
 
-	// Deserialize RootTable
-	offset := 40
-	for i := 0; i < MaxRoots && offset+8 <= PageSize; i++ {
-		m.RootTable[i] = binary.BigEndian.Uint64(buf[offset : offset+8])
-		offset += 8
-	}
-}
-
-// GetRootPage returns the root page for a given rootID.
-// Returns 0 if the rootID is invalid or not set.
-func (m *MetaPage) GetRootPage(rootID RootID) PageID {
-	if rootID >= MaxRoots {
-		return 0
-	}
-	return m.RootTable[rootID]
-}
-
-// SetRootPage sets the root page for a given rootID.
-// Returns false if rootID is invalid.
-func (m *MetaPage) SetRootPage(rootID RootID, pageID PageID) bool {
-	if rootID >= MaxRoots {
-		return false
+	// A new file (Magic 0) gets the current layout
+	if m.Magic != 0 && m.Version < Version {
+		m.RootTable = make([]PageID, LegacyMaxRoots)
+		offset := LegacyMetaPageHeaderSize
+		for i := range m.RootTable {
+			m.RootTable[i] = binary.BigEndian.Uint64(buf[offset : offset+8])
+			offset += 8
+		}
+		return
 	}
-	m.RootTable[rootID] = pageID
-	return true
+	m.RootTable = nil
+	m.RootDir = binary.BigEndian.Uint64(buf[40:48])
+	m.NextRoot = binary.BigEndian.Uint64(buf[48:56])
+	m.FreeRoots = binary.BigEndian.Uint64(buf[56:64])
+	m.Catalog = binary.BigEndian.Uint64(buf[64:72])
+	m.Refs = binary.BigEndian.Uint64(buf[72:80])
+	m.RefLimit = binary.BigEndian.Uint64(buf[80:88])
 }
@@ -1,10 +1,12 @@
 package bpager
 
 import (
-	"encoding/binary"
 	"fmt"
+	"sort"
+	"sync"
 
 	"bptree2/bmmap"
+	"bptree2/bwal"
 )
 
 const (
@@ -16,16 +18,43 @@ const (
 
 	// ReservedMarker is a special value marking a root slot as reserved but empty.
 	ReservedMarker PageID = ^PageID(0)
+
+	// WALSuffix is appended to the database path to name its write-ahead log.
+	WALSuffix = "-wal"
 )
 
 // Pager manages page-based I/O using memory-mapped files.
+//
+// Pages are modified through transactions (see Tx). Committed pages are kept
+// in a dirty-page buffer and never written to the mapping directly, nor to
+// the log: a commit is not durable until the next Flash. Flash first logs
+// every dirty page to the write-ahead log and only then copies them into the
+// file, so a crash at any point leaves either the state of the previous or
+// of this Flash once the log is replayed by Open.
+//
+// Freed pages that an open snapshot can still reach are only put on the free
+// list once the snapshot is released. Pages still pending when the process
+// dies are lost to the free list.
 type Pager struct {
-	mmap *bmmap.MMap
-	meta *MetaPage
-	// mu   sync.RWMutex // Protects meta and page allocation
+	mmap  *bmmap.MMap
+	wal   *bwal.WAL
+	meta  *MetaPage         // committed metadata, replaced (never modified) by Commit
+	dirty map[PageID][]byte // pages committed since the last Flash
+	mu    sync.RWMutex      // Protects meta, dirty pages and the snapshot state
+	wmu   sync.Mutex        // Held by the active writable transaction
+
+	seq       uint64                   // sequence number of the last commit
+	snapshots map[uint64]int           // open snapshots by sequence number
+	versions  map[PageID][]pageVersion // old page images kept for snapshots
+	pending   []pendingFree            // freed pages not yet on the free list
+
+	verify   VerifyLevel // which reads of the file verify checksums
+	verified []uint64    // bitset of the pages verified since Open, for VerifyOnce
+	rewrite  bool        // the next Flash gives every page a checksum, see Tx.SetVersion
 }
 
 // Open opens or creates a database file.
+// Any committed changes left in the write-ahead log are replayed first.
 func Open(path string) (*Pager, error) {
 	// Open the mmap file
 	m, err := bmmap.Open(path, InitialFileSize)
@@ -33,13 +62,33 @@ func Open(path string) (*Pager, error) {
 		return nil, fmt.Errorf("failed to open mmap: %w", err)
 	}
 
+	w, err := bwal.Open(path+WALSuffix, PageSize)
+	if err != nil {
+		m.Close()
+		return nil, fmt.Errorf("failed to open wal: %w", err)
+	}
+
 	p := &Pager{
-		mmap: m,
-		meta: &MetaPage{},
+		mmap:  m,
+		wal:   w,
+		meta:  &MetaPage{},
+		dirty: make(map[PageID][]byte),
+
+		snapshots: make(map[uint64]int),
+		versions:  make(map[PageID][]pageVersion),
+		verify:    VerifyOnce,
+	}
+	p.resizeVerified()
+
+	if err := p.recover(); err != nil {
+		w.Close()
+		m.Close()
+		return nil, err
 	}
 
 	// Read or initialize metadata
 	if err := p.loadOrInitMeta(); err != nil {
+		w.Close()
 		m.Close()
 		return nil, err
 	}
@@ -47,6 +96,29 @@ func Open(path string) (*Pager, error) {
 	return p, nil
 }
 
+// recover replays committed frames from the write-ahead log into the file.
+func (p *Pager) recover() error {
+	replayed := false
+	err := p.wal.Recover(func(pageID PageID, data []byte) error {
+		if err := p.ensureSize(pageID + 1); err != nil {
+			return err
+		}
+		copy(p.mmap.Slice(int64(pageID)*PageSize, PageSize), data)
+		replayed = true
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to recover wal: %w", err)
+	}
+
+	if replayed {
+		if err := p.mmap.Sync(); err != nil {
+			return fmt.Errorf("failed to sync recovered pages: %w", err)
+		}
+	}
+	return p.wal.Reset()
+}
+
 // loadOrInitMeta loads existing metadata or initializes a new file.
 func (p *Pager) loadOrInitMeta() error {
 	data := p.mmap.Slice(0, PageSize)
@@ -64,12 +136,13 @@ func (p *Pager) loadOrInitMeta() error {
 		p.meta.RootCount = 0
 		p.meta.PageCount = 1 // Meta page is page 0
 		p.meta.FreeList = 0
-		// RootTable is already zeroed
 		p.writeMeta()
 	} else if p.meta.Magic != Magic {
 		return fmt.Errorf("invalid file format: bad magic number")
-	} else if p.meta.Version != Version {
-		return fmt.Errorf("unsupported version: %d (expected %d)", p.meta.Version, Version)
+	} else if p.meta.Version < MinVersion || p.meta.Version > Version {
+		return fmt.Errorf("unsupported version: %d (expected %d to %d)", p.meta.Version, MinVersion, Version)
+	} else if p.meta.Version == Version && !validChecksum(MetaPageID, data) {
+		return ErrCorruptPage{PageID: MetaPageID}
 	}
 
 	return nil
@@ -77,182 +150,281 @@ func (p *Pager) loadOrInitMeta() error {
 
 // writeMeta writes the metadata to the meta page.
 func (p *Pager) writeMeta() {
-	data := p.mmap.Slice(0, PageSize)
+	data := p.pageForWrite(MetaPageID)
 	p.meta.Serialize(data)
 }
 
-// Close closes the pager and underlying file.
+// ensureSize grows the mapping so that it holds at least pageCount pages.
+// This invalidates any previously returned mmap slices.
+func (p *Pager) ensureSize(pageCount uint64) error {
+	requiredSize := int64(pageCount) * PageSize
+	if requiredSize <= p.mmap.Size() {
+		return nil
+	}
+
+	newSize := p.mmap.Size() * GrowthFactor
+	for newSize < requiredSize {
+		newSize *= GrowthFactor
+	}
+	if err := p.mmap.Grow(newSize); err != nil {
+		return fmt.Errorf("failed to grow file: %w", err)
+	}
+	p.resizeVerified()
+	return nil
+}
+
+// Close flashes any pending changes and closes the pager and underlying files.
 func (p *Pager) Close() error {
-	//	p.mu.Lock()
-	//	defer p.mu.Unlock()
+	p.wmu.Lock()
+	defer p.wmu.Unlock()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Snapshots end with the pager, so every freed page can be reused
+	p.reclaimPending(^uint64(0))
 
+	if err := p.flash(); err != nil {
+		return err
+	}
+	if err := p.wal.Close(); err != nil {
+		return err
+	}
 	return p.mmap.Close()
 }
 
 // GetPage returns a byte slice for the given page ID.
-// The returned slice is only valid until Close or Grow is called.
-func (p *Pager) GetPage(id PageID) []byte {
-	//	p.mu.RLock()
-	//	defer p.mu.RUnlock()
-
-	offset := int64(id) * PageSize
-	return p.mmap.Slice(offset, PageSize)
+// The slice must be treated as read-only; pages are modified through a Tx.
+// The returned slice is only valid until Close or Flash is called.
+// Returns nil for a page past the end of the file.
+func (p *Pager) GetPage(id PageID) ([]byte, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.page(id)
 }
 
-// AllocatePage allocates a new page and returns its ID.
-func (p *Pager) AllocatePage() (PageID, error) {
-	//	p.mu.Lock()
-	//	defer p.mu.Unlock()
-
-	// Check free list first
-	if p.meta.FreeList != 0 {
-		pageID := p.meta.FreeList
-
-		// Get the next free page from the freed page's header
-		data := p.mmap.Slice(int64(pageID)*PageSize, PageSize)
-		nextFree := binary.BigEndian.Uint64(data[0:8])
-
-		p.meta.FreeList = nextFree
-		p.writeMeta()
-
-		// Clear the page
-		for i := range data {
-			data[i] = 0
+// page returns the current contents of a page without locking.
+// A page read from the file is verified (see verifyPage).
+func (p *Pager) page(id PageID) ([]byte, error) {
+	if data, ok := p.dirty[id]; ok {
+		return data, nil
+	}
+	data := p.mmap.Slice(int64(id)*PageSize, PageSize)
+	if data != nil {
+		if err := p.verifyPage(id, data); err != nil {
+			return nil, err
 		}
-
-		return pageID, nil
 	}
+	return data, nil
+}
 
-	// Calculate required size for new page
-	newPageID := PageID(p.meta.PageCount)
-	requiredSize := int64(newPageID+1) * PageSize
+// unverifiedPage returns the current contents of a page without locking
+// or verifying it.
+func (p *Pager) unverifiedPage(id PageID) []byte {
+	if data, ok := p.dirty[id]; ok {
+		return data
+	}
+	return p.mmap.Slice(int64(id)*PageSize, PageSize)
+}
 
-	// Grow file if necessary
-	if requiredSize > p.mmap.Size() {
-		newSize := p.mmap.Size() * GrowthFactor
-		for newSize < requiredSize {
-			newSize *= GrowthFactor
-		}
-		if err := p.mmap.Grow(newSize); err != nil {
-			return 0, fmt.Errorf("failed to grow file: %w", err)
-		}
+// pageForWrite returns the dirty buffer for a page without locking.
+func (p *Pager) pageForWrite(id PageID) []byte {
+	if data, ok := p.dirty[id]; ok {
+		return data
 	}
 
-	p.meta.PageCount++
-	p.writeMeta()
+	data := make([]byte, PageSize)
+	if src := p.mmap.Slice(int64(id)*PageSize, PageSize); src != nil {
+		copy(data, src)
+	}
+	p.dirty[id] = data
+	return data
+}
 
-	return newPageID, nil
+// AllocatePage allocates a new page in its own transaction and returns its ID.
+func (p *Pager) AllocatePage() (PageID, error) {
+	var id PageID
+	err := p.update(func(tx *Tx) (err error) {
+		id, err = tx.AllocatePage()
+		return err
+	})
+	return id, err
 }
 
 // GetRootPage returns the root page ID for a given rootID.
 // Returns 0 if the rootID is invalid or the tree doesn't exist.
-func (p *Pager) GetRootPage(rootID RootID) PageID {
-	//	p.mu.RLock()
-	//	defer p.mu.RUnlock()
-	page := p.meta.GetRootPage(rootID)
+func (p *Pager) GetRootPage(rootID RootID) (PageID, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	page, err := lookupRoot(p.meta, rootID, p.page)
 	// Reserved marker means empty tree
 	if page == ReservedMarker {
-		return 0
+		return 0, err
 	}
-	return page
+	return page, err
 }
 
-// SetRootPage sets the root page ID for a given rootID.
+// SetRootPage sets the root page ID for a given rootID in its own transaction.
 func (p *Pager) SetRootPage(rootID RootID, pageID PageID) error {
-	//	p.mu.Lock()
-	//	defer p.mu.Unlock()
-
-	if !p.meta.SetRootPage(rootID, pageID) {
-		return fmt.Errorf("invalid rootID: %d (max: %d)", rootID, MaxRoots-1)
-	}
-	p.writeMeta()
-	return nil
+	return p.update(func(tx *Tx) error {
+		return tx.SetRootPage(rootID, pageID)
+	})
 }
 
-// CreateRoot creates a new root and returns its ID.
-// Returns error if maximum roots reached.
+// CreateRoot creates a new root in its own transaction and returns its ID.
 func (p *Pager) CreateRoot() (RootID, error) {
-	//	p.mu.Lock()
-	//	defer p.mu.Unlock()
-
-	// Find first available slot (0 means unused)
-	for i := RootID(0); i < MaxRoots; i++ {
-		if p.meta.RootTable[i] == 0 {
-			// Mark as reserved (not free, but empty tree)
-			p.meta.RootTable[i] = ReservedMarker
-			p.meta.RootCount++
-			p.writeMeta()
-			return i, nil
-		}
-	}
-
-	return 0, fmt.Errorf("maximum roots reached: %d", MaxRoots)
+	var id RootID
+	err := p.update(func(tx *Tx) (err error) {
+		id, err = tx.CreateRoot()
+		return err
+	})
+	return id, err
 }
 
-// DeleteRoot deletes a root tree.
+// DeleteRoot deletes a root tree in its own transaction.
 // Note: This only removes the root reference, does not free pages.
+// bptree2.Tx.DeleteRoot queues them for reclaiming first.
 func (p *Pager) DeleteRoot(rootID RootID) error {
-	//	p.mu.Lock()
-	//	defer p.mu.Unlock()
-
-	if rootID >= MaxRoots {
-		return fmt.Errorf("invalid rootID: %d", rootID)
-	}
-
-	if p.meta.RootTable[rootID] != 0 {
-		p.meta.RootTable[rootID] = 0
-		if p.meta.RootCount > 0 {
-			p.meta.RootCount--
-		}
-		p.writeMeta()
-	}
+	return p.update(func(tx *Tx) error {
+		return tx.DeleteRoot(rootID)
+	})
+}
 
-	return nil
+// Version returns the file format version of the committed metadata.
+// It is lower than Version for an older file that has not been upgraded yet.
+func (p *Pager) Version() uint32 {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.meta.Version
 }
 
 // RootCount returns the number of active roots.
 func (p *Pager) RootCount() uint64 {
-	//	p.mu.RLock()
-	//	defer p.mu.RUnlock()
+	p.mu.RLock()
+	defer p.mu.RUnlock()
 	return p.meta.RootCount
 }
 
+// ReclaimPending returns true if the committed reclaim queue is not empty.
+func (p *Pager) ReclaimPending() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.meta.Reclaim != 0
+}
+
+// DirtyCount returns the number of committed pages that wait for Flash.
+func (p *Pager) DirtyCount() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return len(p.dirty)
+}
+
 // PageCount returns the total number of allocated pages.
 func (p *Pager) PageCount() uint64 {
-	//	p.mu.RLock()
-	//	defer p.mu.RUnlock()
+	p.mu.RLock()
+	defer p.mu.RUnlock()
 	return p.meta.PageCount
 }
 
 // Flash syncs all changes to disk.
+// Dirty pages are committed to the write-ahead log, copied into the file,
+// and the log is reset once the file has been synced (a checkpoint).
+// Flash waits for the active writable transaction to finish, because growing
+// the file invalidates page slices the transaction may still be holding.
 func (p *Pager) Flash() error {
-	//p.mu.Lock()
-	//defer p.mu.Unlock()
+	p.wmu.Lock()
+	defer p.wmu.Unlock()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.reclaimPending(p.oldestSnapshot())
+	return p.flash()
+}
 
+// reclaimPending puts freed pages that no snapshot can reach on the free list.
+func (p *Pager) reclaimPending(limit uint64) {
+	if len(p.pending) == 0 {
+		return
+	}
+	meta := *p.meta
+	p.reclaim(&meta, limit)
+	p.meta = &meta
 	p.writeMeta()
-	return p.mmap.Sync()
 }
 
-// FreePage adds a page to the free list.
-func (p *Pager) FreePage(id PageID) error {
-	//	p.mu.Lock()
-	//	defer p.mu.Unlock()
+// flash performs a checkpoint without locking.
+func (p *Pager) flash() error {
+	if len(p.dirty) == 0 {
+		return p.mmap.Sync()
+	}
 
-	// Store the current free list head in this page
-	data := p.mmap.Slice(int64(id)*PageSize, PageSize)
-	if data == nil {
-		return fmt.Errorf("failed to get page %d for freeing", id)
+	p.writeMeta()
+
+	// After an upgrade the pages not rewritten by it are logged with a
+	// checksum first, in the same group as the dirty pages
+	if p.rewrite {
+		if err := p.ensureSize(p.meta.PageCount); err != nil {
+			return err
+		}
+		if err := p.logRewrite(); err != nil {
+			return fmt.Errorf("failed to write wal: %w", err)
+		}
 	}
 
-	// Clear page and store next free page pointer
-	for i := range data {
-		data[i] = 0
+	// Log dirty pages in page order so the file is written sequentially
+	ids := make([]PageID, 0, len(p.dirty))
+	for id := range p.dirty {
+		ids = append(ids, id)
 	}
-	binary.BigEndian.PutUint64(data[0:8], p.meta.FreeList)
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
 
-	// Update free list head
-	p.meta.FreeList = id
-	p.writeMeta()
+	checksums := p.meta.Version == Version
+	frames := make([]bwal.Frame, len(ids))
+	for i, id := range ids {
+		if checksums {
+			setChecksum(id, p.dirty[id])
+		}
+		frames[i] = bwal.Frame{PageID: id, Data: p.dirty[id]}
+	}
+	if err := p.wal.Append(frames); err != nil {
+		return fmt.Errorf("failed to write wal: %w", err)
+	}
 
+	// The changes are durable now; apply them to the file
+	if err := p.ensureSize(p.meta.PageCount); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		copy(p.mmap.Slice(int64(id)*PageSize, PageSize), p.dirty[id])
+	}
+	if p.rewrite {
+		p.applyRewrite()
+	}
+	if err := p.mmap.Sync(); err != nil {
+		return err
+	}
+	p.rewrite = false
+	if err := p.wal.Reset(); err != nil {
+		return fmt.Errorf("failed to checkpoint wal: %w", err)
+	}
+
+	p.dirty = make(map[PageID][]byte)
 	return nil
 }
+
+// FreePage adds a page to the free list in its own transaction.
+func (p *Pager) FreePage(id PageID) error {
+	return p.update(func(tx *Tx) error {
+		return tx.FreePage(id)
+	})
+}
+
+// update runs fn in a writable transaction, committing it if fn succeeds.
+func (p *Pager) update(fn func(tx *Tx) error) error {
+	tx := p.Begin(true)
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
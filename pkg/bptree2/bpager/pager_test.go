@@ -1,10 +1,14 @@
 package bpager_test
 
 import (
+	"encoding/binary"
+	"os"
 	"path/filepath"
+	"sync"
 	"testing"
 
 	"bptree2/bpager"
+	"bptree2/bwal"
 )
 
 func TestOpenClose(t *testing.T) {
@@ -73,7 +77,7 @@ func TestGetPage(t *testing.T) {
 		t.Fatalf("AllocatePage failed: %v", err)
 	}
 
-	page := p.GetPage(id)
+	page := getPage(t, p.GetPage, id)
 	if page == nil {
 		t.Fatal("GetPage returned nil")
 	}
@@ -101,7 +105,7 @@ func TestPersistence(t *testing.T) {
 	}
 
 	id, _ := p1.AllocatePage()
-	page := p1.GetPage(id)
+	page := getPage(t, p1.GetPage, id)
 	copy(page[0:5], []byte("hello"))
 
 	// Use rootID 0
@@ -117,11 +121,11 @@ func TestPersistence(t *testing.T) {
 	}
 	defer p2.Close()
 
-	if p2.GetRootPage(rootID) != id {
-		t.Errorf("root page should be %d, got %d", id, p2.GetRootPage(rootID))
+	if rootPage(t, p2.GetRootPage, rootID) != id {
+		t.Errorf("root page should be %d, got %d", id, rootPage(t, p2.GetRootPage, rootID))
 	}
 
-	page2 := p2.GetPage(id)
+	page2 := getPage(t, p2.GetPage, id)
 	if string(page2[0:5]) != "hello" {
 		t.Errorf("data should persist, got '%s'", string(page2[0:5]))
 	}
@@ -179,19 +183,562 @@ func TestMultiRoots(t *testing.T) {
 	p.SetRootPage(root2, page2)
 
 	// Verify
-	if p.GetRootPage(root1) != page1 {
+	if rootPage(t, p.GetRootPage, root1) != page1 {
 		t.Errorf("root1 should point to page %d", page1)
 	}
-	if p.GetRootPage(root2) != page2 {
+	if rootPage(t, p.GetRootPage, root2) != page2 {
 		t.Errorf("root2 should point to page %d", page2)
 	}
 
 	// Delete root1
 	p.DeleteRoot(root1)
-	if p.GetRootPage(root1) != 0 {
+	if rootPage(t, p.GetRootPage, root1) != 0 {
 		t.Error("root1 should be 0 after delete")
 	}
-	if p.GetRootPage(root2) != page2 {
+	if rootPage(t, p.GetRootPage, root2) != page2 {
 		t.Error("root2 should not be affected")
 	}
 }
+
+func TestRootDirectory(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	p, err := bpager.Open(path)
+	if err != nil {
+		t.Fatalf("bpager.Open failed: %v", err)
+	}
+
+	// Enough roots for a directory of three levels
+	n := bpager.RootID(300000)
+	tx := p.Begin(true)
+	for i := bpager.RootID(0); i < n; i++ {
+		rootID, err := tx.CreateRoot()
+		if err != nil {
+			t.Fatalf("CreateRoot failed: %v", err)
+		}
+		if rootID != i {
+			t.Fatalf("expected root %d, got %d", i, rootID)
+		}
+		if err := tx.SetRootPage(rootID, 1000+rootID); err != nil {
+			t.Fatalf("SetRootPage failed: %v", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	p.Close()
+
+	p, err = bpager.Open(path)
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	defer p.Close()
+	if p.RootCount() != uint64(n) {
+		t.Fatalf("expected %d roots, got %d", n, p.RootCount())
+	}
+	for i := bpager.RootID(0); i < n; i++ {
+		if page := rootPage(t, p.GetRootPage, i); page != 1000+i {
+			t.Fatalf("root %d: expected page %d, got %d", i, 1000+i, page)
+		}
+	}
+	if page := rootPage(t, p.GetRootPage, n); page != 0 {
+		t.Errorf("unused root: expected page 0, got %d", page)
+	}
+
+	// Deleted IDs are reused, the most recently deleted first
+	p.DeleteRoot(5)
+	p.DeleteRoot(270000)
+	for _, expected := range []bpager.RootID{270000, 5, n} {
+		if rootID, _ := p.CreateRoot(); rootID != expected {
+			t.Errorf("expected root %d, got %d", expected, rootID)
+		}
+	}
+	if rootPage(t, p.GetRootPage, 5) != 0 {
+		t.Error("a reused root should start empty")
+	}
+}
+
+func TestWALReplay(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	p1, err := bpager.Open(path)
+	if err != nil {
+		t.Fatalf("bpager.Open failed: %v", err)
+	}
+	id, _ := p1.AllocatePage()
+	p1.Close()
+
+	// Simulate a crash after a flash committed to the log but before the
+	// pages were copied into the file
+	w, err := bwal.Open(path+bpager.WALSuffix, bpager.PageSize)
+	if err != nil {
+		t.Fatalf("bwal.Open failed: %v", err)
+	}
+	data := make([]byte, bpager.PageSize)
+	copy(data, []byte("hello"))
+	if err := w.Append([]bwal.Frame{{PageID: id, Data: data}}); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	w.Close()
+
+	p2, err := bpager.Open(path)
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	defer p2.Close()
+
+	// The frame was not written by a pager, so it has no checksum
+	p2.SetVerify(bpager.VerifyNone)
+	if string(getPage(t, p2.GetPage, id)[0:5]) != "hello" {
+		t.Errorf("logged page should be replayed, got '%s'", string(getPage(t, p2.GetPage, id)[0:5]))
+	}
+}
+
+func TestUnflashedChangesNotInFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	p1, err := bpager.Open(path)
+	if err != nil {
+		t.Fatalf("bpager.Open failed: %v", err)
+	}
+	defer p1.Close()
+
+	id, _ := p1.AllocatePage()
+	writePage(t, p1, id, "first")
+	p1.Flash()
+
+	writePage(t, p1, id, "later")
+	if string(getPage(t, p1.GetPage, id)[0:5]) != "later" {
+		t.Errorf("committed writes should be visible through GetPage, got '%s'", string(getPage(t, p1.GetPage, id)[0:5]))
+	}
+
+	// A second pager sees only what was flashed
+	p2, err := bpager.Open(path)
+	if err != nil {
+		t.Fatalf("second Open failed: %v", err)
+	}
+	if string(getPage(t, p2.GetPage, id)[0:5]) != "first" {
+		t.Errorf("file should hold the flashed page, got '%s'", string(getPage(t, p2.GetPage, id)[0:5]))
+	}
+	p2.Close()
+}
+
+func TestConcurrentAllocate(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	p, err := bpager.Open(path)
+	if err != nil {
+		t.Fatalf("bpager.Open failed: %v", err)
+	}
+	defer p.Close()
+
+	// Allocations from several goroutines must hand out distinct pages
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	seen := make(map[bpager.PageID]bool)
+	workers, perWorker := 8, 100
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				id, err := p.AllocatePage()
+				if err != nil {
+					t.Errorf("AllocatePage failed: %v", err)
+					return
+				}
+				mu.Lock()
+				if seen[id] {
+					t.Errorf("page %d allocated twice", id)
+				}
+				seen[id] = true
+				mu.Unlock()
+				p.PageCount()
+				p.RootCount()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if p.PageCount() != uint64(workers*perWorker+1) {
+		t.Errorf("expected page count %d, got %d", workers*perWorker+1, p.PageCount())
+	}
+}
+
+// writePage commits text to the start of a page.
+func writePage(t *testing.T, p *bpager.Pager, id bpager.PageID, text string) {
+	t.Helper()
+	tx := p.Begin(true)
+	copy(getPage(t, tx.GetPageForWrite, id), text)
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// getPage returns a page read with get, failing the test on an error.
+func getPage(t *testing.T, get func(bpager.PageID) ([]byte, error), id bpager.PageID) []byte {
+	t.Helper()
+	data, err := get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// rootPage returns the root page of rootID read with get, failing the test
+// on an error.
+func rootPage(t *testing.T, get func(bpager.RootID) (bpager.PageID, error), rootID bpager.RootID) bpager.PageID {
+	t.Helper()
+	pageID, err := get(rootID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pageID
+}
+
+func TestRelocateAndTruncate(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	p, err := bpager.Open(path)
+	if err != nil {
+		t.Fatalf("bpager.Open failed: %v", err)
+	}
+	defer p.Close()
+
+	// 1000 pages, each holding its own ID, of which the last 100 stay in use
+	tx := p.Begin(true)
+	for i := 0; i < 1000; i++ {
+		id, _ := tx.AllocatePage()
+		binary.BigEndian.PutUint64(getPage(t, tx.GetPageForWrite, id)[100:108], id)
+	}
+	shared := bpager.PageID(1000)
+	if err := tx.AddRef(shared); err != nil {
+		t.Fatalf("AddRef failed: %v", err)
+	}
+	for id := bpager.PageID(1); id <= 900; id++ {
+		tx.FreePage(id)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if err := p.Flash(); err != nil {
+		t.Fatalf("Flash failed: %v", err)
+	}
+
+	// The reference table takes two pages past the others
+	tx = p.Begin(true)
+	limit, err := tx.RelocationLimit()
+	if err != nil {
+		t.Fatalf("RelocationLimit failed: %v", err)
+	}
+	if limit != 103 {
+		t.Errorf("expected limit 103, got %d", limit)
+	}
+	if err := tx.RelocateTables(limit); err != nil {
+		t.Fatalf("RelocateTables failed: %v", err)
+	}
+	for id := bpager.PageID(901); id <= 1000; id++ {
+		newID, err := tx.RelocatePage(id, limit)
+		if err != nil || newID >= limit {
+			t.Errorf("page %d moved to %d, past the limit: %v", id, newID, err)
+			continue
+		}
+		if got := binary.BigEndian.Uint64(getPage(t, tx.GetPage, newID)[100:108]); got != id {
+			t.Errorf("page %d: moved contents %d", id, got)
+		}
+		if id == shared {
+			shared = newID
+		}
+	}
+	if n, err := tx.RefCount(shared); err != nil || n != 2 {
+		t.Errorf("moved page %d: expected 2 references, got %d (%v)", shared, n, err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	before, _ := os.Stat(path)
+	if err := p.Truncate(); err != nil {
+		t.Fatalf("Truncate failed: %v", err)
+	}
+	// Moving the count of the shared page took a new reference table page,
+	// so one page found no free page before the limit
+	if n := p.PageCount(); n != limit+1 {
+		t.Errorf("expected %d pages, got %d", limit+1, n)
+	}
+	after, _ := os.Stat(path)
+	if after.Size() >= before.Size() {
+		t.Errorf("file should shrink: %d bytes before, %d after", before.Size(), after.Size())
+	}
+
+	// The file grows again as needed
+	tx = p.Begin(true)
+	for i := 0; i < 1000; i++ {
+		tx.AllocatePage()
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if err := p.Flash(); err != nil {
+		t.Fatalf("Flash failed: %v", err)
+	}
+	if got := binary.BigEndian.Uint64(getPage(t, p.GetPage, shared)[100:108]); got != 1000 {
+		t.Errorf("page %d: expected contents 1000, got %d", shared, got)
+	}
+}
+
+func TestRelocationAcrossTransactions(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	p, err := bpager.Open(path)
+	if err != nil {
+		t.Fatalf("bpager.Open failed: %v", err)
+	}
+	defer p.Close()
+
+	// 1000 pages, each holding its own ID, of which the last 100 stay in use
+	tx := p.Begin(true)
+	for i := 0; i < 1000; i++ {
+		id, _ := tx.AllocatePage()
+		binary.BigEndian.PutUint64(getPage(t, tx.GetPageForWrite, id)[100:108], id)
+	}
+	for id := bpager.PageID(1); id <= 900; id++ {
+		tx.FreePage(id)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	// The free list is sorted 100 pages per transaction
+	tx = p.Begin(true)
+	r, err := tx.BeginRelocation()
+	if err != nil {
+		t.Fatalf("BeginRelocation failed: %v", err)
+	}
+	limit := r.Limit()
+	if limit != 101 {
+		t.Errorf("expected limit 101, got %d", limit)
+	}
+	for more := true; more; {
+		if more, err = tx.SortFreePages(100); err != nil {
+			t.Fatalf("SortFreePages failed: %v", err)
+		}
+		if n := tx.DirtyCount(); n > 102 {
+			t.Errorf("sorting 100 free pages modified %d pages", n)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatalf("Commit failed: %v", err)
+		}
+		tx = p.Begin(true)
+		if err := tx.ResumeRelocation(r); err != nil {
+			t.Fatalf("ResumeRelocation failed: %v", err)
+		}
+	}
+
+	// Ten transactions move ten pages each; the pages freed by one are at
+	// the front of the free list when the next begins
+	for id := bpager.PageID(901); id <= 1000; id++ {
+		newID, err := tx.RelocatePage(id, limit)
+		if err != nil || newID >= limit {
+			t.Errorf("page %d moved to %d, past the limit: %v", id, newID, err)
+		} else if got := binary.BigEndian.Uint64(getPage(t, tx.GetPage, newID)[100:108]); got != id {
+			t.Errorf("page %d: moved contents %d", id, got)
+		}
+		if id%10 == 0 {
+			if err := tx.Commit(); err != nil {
+				t.Fatalf("Commit failed: %v", err)
+			}
+			tx = p.Begin(true)
+			if err := tx.ResumeRelocation(r); err != nil {
+				t.Fatalf("ResumeRelocation failed: %v", err)
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	if err := p.Truncate(); err != nil {
+		t.Fatalf("Truncate failed: %v", err)
+	}
+	if n := p.PageCount(); n != limit {
+		t.Errorf("expected %d pages, got %d", limit, n)
+	}
+}
+
+// corrupt flips a byte of the file at path.
+func corrupt(t *testing.T, path string, offset int64) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	defer f.Close()
+	b := make([]byte, 1)
+	if _, err := f.ReadAt(b, offset); err != nil {
+		t.Fatalf("ReadAt failed: %v", err)
+	}
+	b[0] ^= 0x40
+	if _, err := f.WriteAt(b, offset); err != nil {
+		t.Fatalf("WriteAt failed: %v", err)
+	}
+}
+
+func TestChecksums(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	p, err := bpager.Open(path)
+	if err != nil {
+		t.Fatalf("bpager.Open failed: %v", err)
+	}
+	tx := p.Begin(true)
+	var ids []bpager.PageID
+	for i := 0; i < 3; i++ {
+		id, _ := tx.AllocatePage()
+		copy(getPage(t, tx.GetPageForWrite, id)[100:], "page contents")
+		ids = append(ids, id)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	p.Close()
+
+	corrupt(t, path, int64(ids[0])*bpager.PageSize+100)
+	p, err = bpager.Open(path)
+	if err != nil {
+		t.Fatalf("bpager.Open failed: %v", err)
+	}
+	defer p.Close()
+
+	if _, err := p.GetPage(ids[0]); err != (bpager.ErrCorruptPage{PageID: ids[0]}) {
+		t.Errorf("expected ErrCorruptPage for page %d, got %v", ids[0], err)
+	}
+	if _, err := p.GetPage(ids[1]); err != nil {
+		t.Errorf("page %d: %v", ids[1], err)
+	}
+	snap := p.Begin(false)
+	if _, err := snap.GetPage(ids[0]); err != (bpager.ErrCorruptPage{PageID: ids[0]}) {
+		t.Errorf("snapshot: expected ErrCorruptPage for page %d, got %v", ids[0], err)
+	}
+	snap.Rollback()
+	tx = p.Begin(true)
+	if _, err := tx.GetPageForWrite(ids[0]); err != (bpager.ErrCorruptPage{PageID: ids[0]}) {
+		t.Errorf("GetPageForWrite: expected ErrCorruptPage for page %d, got %v", ids[0], err)
+	}
+	tx.Rollback()
+	p.SetVerify(bpager.VerifyNone)
+	if _, err := p.GetPage(ids[0]); err != nil {
+		t.Errorf("VerifyNone should not verify: %v", err)
+	}
+
+	// VerifyOnce trusts a page it has verified, VerifyAlways does not
+	p.SetVerify(bpager.VerifyOnce)
+	corrupt(t, path, int64(ids[1])*bpager.PageSize+100)
+	if _, err := p.GetPage(ids[1]); err != nil {
+		t.Errorf("VerifyOnce should not verify page %d again: %v", ids[1], err)
+	}
+	p.SetVerify(bpager.VerifyAlways)
+	if _, err := p.GetPage(ids[1]); err != (bpager.ErrCorruptPage{PageID: ids[1]}) {
+		t.Errorf("expected ErrCorruptPage for page %d, got %v", ids[1], err)
+	}
+
+	// A rewritten page gets a new checksum
+	p.SetVerify(bpager.VerifyNone)
+	tx = p.Begin(true)
+	copy(getPage(t, tx.GetPageForWrite, ids[0])[100:], "new contents")
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if err := p.Flash(); err != nil {
+		t.Fatalf("Flash failed: %v", err)
+	}
+	p.SetVerify(bpager.VerifyAlways)
+	if _, err := p.GetPage(ids[0]); err != nil {
+		t.Errorf("rewritten page %d: %v", ids[0], err)
+	}
+}
+
+func TestChecksumRewrite(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	// A version 2 file of more pages than Flash logs at a time
+	p, err := bpager.Open(path)
+	if err != nil {
+		t.Fatalf("bpager.Open failed: %v", err)
+	}
+	tx := p.Begin(true)
+	for i := 0; i < 1000; i++ {
+		id, _ := tx.AllocatePage()
+		copy(getPage(t, tx.GetPageForWrite, id)[100:], "page contents")
+	}
+	if err := tx.SetVersion(2); err != nil {
+		t.Fatalf("SetVersion failed: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	p.Close()
+
+	p, err = bpager.Open(path)
+	if err != nil {
+		t.Fatalf("bpager.Open failed: %v", err)
+	}
+	tx = p.Begin(true)
+	if err := tx.SetVersion(bpager.Version); err != nil {
+		t.Fatalf("SetVersion failed: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	// Pages are not verified until Flash has given them their checksums
+	p.SetVerify(bpager.VerifyAlways)
+	if _, err := p.GetPage(500); err != nil {
+		t.Errorf("page 500 before Flash: %v", err)
+	}
+	if err := p.Flash(); err != nil {
+		t.Fatalf("Flash failed: %v", err)
+	}
+	p.Close()
+
+	p, err = bpager.Open(path)
+	if err != nil {
+		t.Fatalf("bpager.Open failed: %v", err)
+	}
+	defer p.Close()
+	p.SetVerify(bpager.VerifyAlways)
+	for id := bpager.PageID(1); id < p.PageCount(); id++ {
+		if _, err := p.GetPage(id); err != nil {
+			t.Fatalf("page %d: %v", id, err)
+		}
+	}
+	if string(getPage(t, p.GetPage, 500)[100:113]) != "page contents" {
+		t.Errorf("page 500 lost its contents: %q", getPage(t, p.GetPage, 500)[100:113])
+	}
+}
+
+func TestCorruptMetaPage(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	p, err := bpager.Open(path)
+	if err != nil {
+		t.Fatalf("bpager.Open failed: %v", err)
+	}
+	p.CreateRoot()
+	p.Close()
+
+	corrupt(t, path, 50)
+	if _, err := bpager.Open(path); err != (bpager.ErrCorruptPage{PageID: bpager.MetaPageID}) {
+		t.Errorf("expected ErrCorruptPage for the meta page, got %v", err)
+	}
+}
@@ -0,0 +1,195 @@
+package bpager
+
+import (
+	"encoding/binary"
+)
+
+const (
+	// radixHeader is the size of the header of a radix table page, which
+	// holds its checksum. The entries follow it.
+	radixHeader = PageHeaderSize
+
+	// radixFanout is the number of entries in a radix table page.
+	radixFanout = (PageSize - radixHeader) / 8 // 510 entries
+)
+
+// A radix table maps uint64 keys below a limit to uint64 values, such as
+// root IDs to root pages. It is a tree of pages of radixFanout entries:
+// the pages of the lowest level hold values, the pages above hold pages of
+// the level below. Each level resolves a digit of a key in base radixFanout,
+// the lowest digit at the lowest level.
+//
+// A table has as many levels as keys below its limit need and gains a level
+// on top when the limit outgrows it, so lookups read one page per level: two
+// levels cover 260100 keys, three over 130 million. Pages are allocated when
+// the first key they cover is set; keys without a page read as 0.
+
+// radixLevels returns the number of levels of a radix table for keys below limit.
+func radixLevels(limit uint64) int {
+	levels := 1
+	for capacity := uint64(radixFanout); capacity < limit; capacity *= radixFanout {
+		levels++
+	}
+	return levels
+}
+
+// radixOffset returns the offset of the entry for key in its table page at level.
+func radixOffset(key uint64, level int) int {
+	for range level {
+		key /= radixFanout
+	}
+	return radixHeader + int(key%radixFanout)*8
+}
+
+// radixLookup returns the value of key in the table at top, which covers
+// the keys below limit. Returns 0 if the key is not set. Pages are read with page.
+func radixLookup(top PageID, limit, key uint64, page func(PageID) ([]byte, error)) (uint64, error) {
+	if key >= limit {
+		return 0, nil
+	}
+
+	value := top
+	for level := radixLevels(limit) - 1; level >= 0 && value != 0; level-- {
+		data, err := page(value)
+		if err != nil || data == nil {
+			return 0, err
+		}
+		off := radixOffset(key, level)
+		value = binary.BigEndian.Uint64(data[off : off+8])
+	}
+	return value, nil
+}
+
+// radixSet sets the value of a key below limit in the table at *top,
+// allocating the pages on its path as needed.
+func (tx *Tx) radixSet(top *PageID, limit, key, value uint64) error {
+	if *top == 0 {
+		pageID, err := tx.AllocatePage()
+		if err != nil {
+			return err
+		}
+		*top = pageID
+	}
+
+	pageID := *top
+	for level := radixLevels(limit) - 1; level > 0; level-- {
+		data, err := tx.GetPage(pageID)
+		if err != nil {
+			return err
+		}
+		off := radixOffset(key, level)
+		child := binary.BigEndian.Uint64(data[off : off+8])
+		if child == 0 {
+			if child, err = tx.AllocatePage(); err != nil {
+				return err
+			}
+			if data, err = tx.GetPageForWrite(pageID); err != nil {
+				return err
+			}
+			binary.BigEndian.PutUint64(data[off:off+8], child)
+		}
+		pageID = child
+	}
+
+	data, err := tx.GetPageForWrite(pageID)
+	if err != nil {
+		return err
+	}
+	off := radixOffset(key, 0)
+	binary.BigEndian.PutUint64(data[off:off+8], value)
+	return nil
+}
+
+// radixGrow adds levels on top of the table at *top, which covers the keys
+// below limit, until it covers the keys below newLimit. The old top page
+// becomes the first entry of each new top page.
+func (tx *Tx) radixGrow(top *PageID, limit, newLimit uint64) error {
+	if *top == 0 {
+		return nil
+	}
+	for levels := radixLevels(limit); levels < radixLevels(newLimit); levels++ {
+		pageID, err := tx.AllocatePage()
+		if err != nil {
+			return err
+		}
+		data, err := tx.GetPageForWrite(pageID)
+		if err != nil {
+			return err
+		}
+		binary.BigEndian.PutUint64(data[radixHeader:radixHeader+8], *top)
+		*top = pageID
+	}
+	return nil
+}
+
+// radixFree frees the pages of the table at top, which covers the keys below limit.
+func (tx *Tx) radixFree(top PageID, limit uint64) error {
+	if top == 0 {
+		return nil
+	}
+	return tx.radixFreePage(top, radixLevels(limit)-1)
+}
+
+// radixFreePage frees a table page at level and the pages below it.
+func (tx *Tx) radixFreePage(pageID PageID, level int) error {
+	if level > 0 {
+		data, err := tx.GetPage(pageID)
+		if err != nil {
+			return err
+		}
+		for off := radixHeader; off < PageSize; off += 8 {
+			if child := binary.BigEndian.Uint64(data[off : off+8]); child != 0 {
+				if err := tx.radixFreePage(child, level-1); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return tx.FreePage(pageID)
+}
+
+// radixRelocate relocates the pages of the table at *top, which covers the
+// keys below limit, with RelocatePage. Each page is relocated, and the entry
+// above it updated, before the pages below it, so the table stays readable.
+func (tx *Tx) radixRelocate(top *PageID, limit uint64, pageLimit PageID) error {
+	if *top == 0 {
+		return nil
+	}
+	pageID, err := tx.RelocatePage(*top, pageLimit)
+	if err != nil {
+		return err
+	}
+	*top = pageID
+	return tx.radixRelocateBelow(pageID, radixLevels(limit)-1, pageLimit)
+}
+
+// radixRelocateBelow relocates the pages below a table page at level.
+func (tx *Tx) radixRelocateBelow(pageID PageID, level int, pageLimit PageID) error {
+	if level == 0 {
+		return nil
+	}
+	for off := radixHeader; off < PageSize; off += 8 {
+		data, err := tx.GetPage(pageID)
+		if err != nil {
+			return err
+		}
+		child := binary.BigEndian.Uint64(data[off : off+8])
+		if child == 0 {
+			continue
+		}
+		newChild, err := tx.RelocatePage(child, pageLimit)
+		if err != nil {
+			return err
+		}
+		if newChild != child {
+			if data, err = tx.GetPageForWrite(pageID); err != nil {
+				return err
+			}
+			binary.BigEndian.PutUint64(data[off:off+8], newChild)
+		}
+		if err := tx.radixRelocateBelow(newChild, level-1, pageLimit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package bpager
+
+// The reclaim queue holds pages that are no longer reachable from any root
+// but whose descendants still have to be found and freed, such as the root
+// page of a deleted tree. It is a stack starting at MetaPage.Reclaim, so
+// that the work survives a restart.
+
+// PushReclaim adds a page to the reclaim queue.
+func (tx *Tx) PushReclaim(id PageID) error {
+	if err := tx.checkWritable(); err != nil {
+		return err
+	}
+	return tx.pushStack(&tx.meta.Reclaim, id)
+}
+
+// PopReclaim removes the most recently added page from the reclaim queue
+// and returns it. Returns false if the queue is empty.
+func (tx *Tx) PopReclaim() (PageID, bool, error) {
+	if err := tx.checkWritable(); err != nil {
+		return 0, false, err
+	}
+	return tx.popStack(&tx.meta.Reclaim)
+}
+
+// ReclaimPending returns true if the reclaim queue is not empty.
+func (tx *Tx) ReclaimPending() bool {
+	return tx.meta.Reclaim != 0
+}
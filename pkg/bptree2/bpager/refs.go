@@ -0,0 +1,73 @@
+package bpager
+
+import (
+	"fmt"
+)
+
+// Pages can be shared by the trees of forked roots (see ForkRoot), so each
+// page has a reference count: the number of pages and roots that refer to
+// it. The counts live in the reference table, a radix table (see radix.go)
+// starting at MetaPage.Refs that covers the page IDs below MetaPage.RefLimit.
+// It holds the references beyond the first, so pages with one reference
+// have no entry, and a file without forks has no table.
+
+// RefCount returns the number of references to a page in use.
+func (tx *Tx) RefCount(id PageID) (uint64, error) {
+	extra, err := radixLookup(tx.meta.Refs, tx.meta.RefLimit, id, tx.GetPage)
+	return 1 + extra, err
+}
+
+// Shared returns true if a page has more than one reference. A shared page
+// must not be modified; its users copy it instead.
+func (tx *Tx) Shared(id PageID) (bool, error) {
+	if tx.meta.Refs == 0 {
+		return false, nil
+	}
+	count, err := tx.RefCount(id)
+	return count > 1, err
+}
+
+// AddRef adds a reference to a page in use. FreePage drops one again.
+// Version 2 files have no reference table.
+func (tx *Tx) AddRef(id PageID) error {
+	if err := tx.checkWritable(); err != nil {
+		return err
+	}
+	if id == MetaPageID || id >= tx.meta.PageCount {
+		return fmt.Errorf("invalid page %d for a reference", id)
+	}
+	if tx.meta.RootTable != nil {
+		return fmt.Errorf("pages cannot be shared in version %d files", tx.meta.Version)
+	}
+
+	if id >= tx.meta.RefLimit {
+		limit := tx.meta.PageCount
+		if err := tx.radixGrow(&tx.meta.Refs, tx.meta.RefLimit, limit); err != nil {
+			return fmt.Errorf("failed to allocate reference table page: %w", err)
+		}
+		tx.meta.RefLimit = limit
+	}
+	count, err := tx.RefCount(id)
+	if err != nil {
+		return err
+	}
+	return tx.setRefs(id, count)
+}
+
+// dropRef removes a reference from a shared page. Returns false, leaving
+// the count as it is, if the page has a single reference.
+func (tx *Tx) dropRef(id PageID) (bool, error) {
+	extra, err := radixLookup(tx.meta.Refs, tx.meta.RefLimit, id, tx.GetPage)
+	if extra == 0 || err != nil {
+		return false, err
+	}
+	return true, tx.setRefs(id, extra-1)
+}
+
+// setRefs sets the number of references beyond the first of a page below RefLimit.
+func (tx *Tx) setRefs(id PageID, extra uint64) error {
+	if err := tx.radixSet(&tx.meta.Refs, tx.meta.RefLimit, id, extra); err != nil {
+		return fmt.Errorf("failed to allocate reference table page: %w", err)
+	}
+	return nil
+}
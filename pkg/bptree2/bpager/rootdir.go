@@ -0,0 +1,141 @@
+package bpager
+
+import (
+	"fmt"
+)
+
+// The root directory maps root IDs to root pages. It is a radix table (see
+// radix.go) starting at MetaPage.RootDir that covers the root IDs below
+// MetaPage.NextRoot. Directory pages are allocated when the first root ID
+// they cover is used.
+
+// lookupRoot returns the root page of rootID in meta, or 0 if the rootID is
+// not in use. Directory pages are read with page.
+func lookupRoot(meta *MetaPage, rootID RootID, page func(PageID) ([]byte, error)) (PageID, error) {
+	if meta.RootTable != nil {
+		if rootID >= RootID(len(meta.RootTable)) {
+			return 0, nil
+		}
+		return meta.RootTable[rootID], nil
+	}
+	if rootID == CatalogRoot {
+		return meta.Catalog, nil
+	}
+	return radixLookup(meta.RootDir, meta.NextRoot, rootID, page)
+}
+
+// setRoot sets the root page of a rootID below RootLimit or of CatalogRoot,
+// allocating the directory pages on its path as needed.
+func (tx *Tx) setRoot(rootID RootID, pageID PageID) error {
+	if tx.meta.RootTable != nil {
+		tx.meta.RootTable[rootID] = pageID
+		return nil
+	}
+	if rootID == CatalogRoot {
+		tx.meta.Catalog = pageID
+		return nil
+	}
+
+	if err := tx.radixSet(&tx.meta.RootDir, tx.meta.NextRoot, rootID, pageID); err != nil {
+		return fmt.Errorf("failed to allocate root directory page: %w", err)
+	}
+	return nil
+}
+
+// newRootID returns an unused root ID: the most recently deleted one if
+// any, else NextRoot.
+func (tx *Tx) newRootID() (RootID, error) {
+	if tx.meta.RootTable != nil {
+		for i, page := range tx.meta.RootTable {
+			if page == 0 {
+				return RootID(i), nil
+			}
+		}
+		return 0, fmt.Errorf("maximum roots reached: %d", LegacyMaxRoots)
+	}
+
+	rootID, ok, err := tx.popStack(&tx.meta.FreeRoots)
+	if err != nil || ok {
+		return rootID, err
+	}
+
+	rootID = tx.meta.NextRoot
+	if err := tx.growRootDir(rootID + 1); err != nil {
+		return 0, err
+	}
+	return rootID, nil
+}
+
+// growRootDir raises NextRoot to limit, adding levels on top of the root
+// directory until it covers every root ID below limit.
+func (tx *Tx) growRootDir(limit RootID) error {
+	if err := tx.radixGrow(&tx.meta.RootDir, tx.meta.NextRoot, limit); err != nil {
+		return fmt.Errorf("failed to allocate root directory page: %w", err)
+	}
+	tx.meta.NextRoot = limit
+	return nil
+}
+
+// upgradeRootTable moves the roots of the meta page table into a root directory.
+// Unused IDs below the highest one in use are kept for reuse, lowest first.
+func (tx *Tx) upgradeRootTable() error {
+	table := tx.meta.RootTable
+	limit := len(table)
+	for limit > 0 && table[limit-1] == 0 {
+		limit--
+	}
+
+	tx.meta.RootTable = nil
+	tx.meta.RootDir, tx.meta.NextRoot, tx.meta.FreeRoots = 0, RootID(limit), 0
+	for i := limit - 1; i >= 0; i-- {
+		var err error
+		if table[i] == 0 {
+			err = tx.pushStack(&tx.meta.FreeRoots, RootID(i))
+		} else {
+			err = tx.setRoot(RootID(i), table[i])
+		}
+		if err != nil {
+			return fmt.Errorf("failed to upgrade root table: %w", err)
+		}
+	}
+	return nil
+}
+
+// downgradeRootDir moves the roots of the root directory back into a meta
+// page table and frees the directory.
+func (tx *Tx) downgradeRootDir() error {
+	if tx.meta.NextRoot > LegacyMaxRoots {
+		return fmt.Errorf("root IDs up to %d do not fit the root table (max: %d)", tx.meta.NextRoot-1, LegacyMaxRoots-1)
+	}
+	if tx.meta.Catalog != 0 {
+		return fmt.Errorf("the tree catalog does not fit the root table")
+	}
+	if tx.meta.Refs != 0 {
+		return fmt.Errorf("the reference table does not fit the root table")
+	}
+
+	table := make([]PageID, LegacyMaxRoots)
+	for rootID := range tx.meta.NextRoot {
+		pageID, err := lookupRoot(tx.meta, rootID, tx.GetPage)
+		if err != nil {
+			return err
+		}
+		table[rootID] = pageID
+	}
+	if err := tx.radixFree(tx.meta.RootDir, tx.meta.NextRoot); err != nil {
+		return err
+	}
+	for {
+		_, ok, err := tx.popStack(&tx.meta.FreeRoots)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+	}
+
+	tx.meta.RootTable = table
+	tx.meta.RootDir, tx.meta.NextRoot = 0, 0
+	return nil
+}
@@ -0,0 +1,88 @@
+package bpager
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	// StackHeaderSize is the header size of a stack page: the next page of
+	// the stack (8 bytes) and the number of entries (2 bytes), padded to 16
+	// bytes. The padding ends with the page checksum.
+	StackHeaderSize = 16
+
+	// StackCapacity is the number of values a stack page holds.
+	StackCapacity = (PageSize - StackHeaderSize) / 8 // 510
+)
+
+// A stack is a chain of pages holding uint64 values, such as the reclaim
+// queue or the IDs of deleted roots. Only the head page is written when
+// a value is pushed or popped; a head page is freed as soon as it is empty.
+
+// pushStack adds a value to the stack starting at *head.
+func (tx *Tx) pushStack(head *PageID, value uint64) error {
+	full := *head == 0
+	if !full {
+		data, err := tx.GetPage(*head)
+		if err != nil {
+			return err
+		}
+		full = binary.BigEndian.Uint16(data[8:10]) == StackCapacity
+	}
+	if full {
+		newHead, err := tx.AllocatePage()
+		if err != nil {
+			return fmt.Errorf("failed to allocate stack page: %w", err)
+		}
+		data, err := tx.GetPageForWrite(newHead)
+		if err != nil {
+			return err
+		}
+		binary.BigEndian.PutUint64(data[0:8], *head)
+		*head = newHead
+	}
+
+	data, err := tx.GetPageForWrite(*head)
+	if err != nil {
+		return err
+	}
+	count := binary.BigEndian.Uint16(data[8:10])
+	off := StackHeaderSize + int(count)*8
+	binary.BigEndian.PutUint64(data[off:off+8], value)
+	binary.BigEndian.PutUint16(data[8:10], count+1)
+	return nil
+}
+
+// popStack removes the most recently pushed value from the stack starting
+// at *head and returns it. Returns false if the stack is empty.
+func (tx *Tx) popStack(head *PageID) (uint64, bool, error) {
+	if *head == 0 {
+		return 0, false, nil
+	}
+	pageID := *head
+	data, err := tx.GetPage(pageID)
+	if err != nil {
+		return 0, false, err
+	}
+	if data == nil {
+		return 0, false, fmt.Errorf("failed to get stack page %d", pageID)
+	}
+
+	count := binary.BigEndian.Uint16(data[8:10]) - 1
+	off := StackHeaderSize + int(count)*8
+	value := binary.BigEndian.Uint64(data[off : off+8])
+
+	if count == 0 {
+		*head = binary.BigEndian.Uint64(data[0:8])
+		if err := tx.FreePage(pageID); err != nil {
+			return 0, false, err
+		}
+		return value, true, nil
+	}
+
+	if data, err = tx.GetPageForWrite(pageID); err != nil {
+		return 0, false, err
+	}
+	binary.BigEndian.PutUint16(data[8:10], count)
+	return value, true, nil
+}
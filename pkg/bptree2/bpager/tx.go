@@ -0,0 +1,513 @@
+package bpager
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+var (
+	// ErrTxDone is returned when a transaction is used after Commit or Rollback.
+	ErrTxDone = errors.New("transaction has already been committed or rolled back")
+
+	// ErrTxReadOnly is returned when a read-only transaction is asked to modify pages.
+	ErrTxReadOnly = errors.New("transaction is read-only")
+)
+
+// Tx is a pager-level transaction.
+//
+// A writable Tx keeps every page it modifies, and its own copy of the
+// metadata, in a private overlay. Nothing is visible to other users of the
+// pager until Commit; Rollback simply drops the overlay. Only one writable
+// Tx can be active at a time, Begin(true) blocks until the previous one ends.
+//
+// A read-only Tx is a snapshot: it keeps seeing the metadata and pages as
+// they were when it began, no matter how many transactions commit after it.
+// The pager keeps the old page images and defers freeing pages for as long
+// as the snapshot is open.
+type Tx struct {
+	p        *Pager
+	meta     *MetaPage         // private copy (writable) or shared committed metadata (read-only)
+	pages    map[PageID][]byte // pages modified by this transaction
+	freed    []PageID          // pages freed by this transaction
+	seq      uint64            // commit sequence number seen by a snapshot
+	writable bool
+	done     bool
+	rewrite  bool // the pages need a checksum, see SetVersion
+
+	relocation *Relocation // relocation in progress, see BeginRelocation
+}
+
+// pageVersion is a preserved page image for snapshots taken before it was overwritten.
+type pageVersion struct {
+	data         []byte
+	validThrough uint64 // last commit sequence number the image belongs to
+}
+
+// pendingFree is a group of pages freed by the commit with sequence number seq.
+type pendingFree struct {
+	seq   uint64
+	pages []PageID
+}
+
+// Begin starts a new transaction.
+func (p *Pager) Begin(writable bool) *Tx {
+	tx := &Tx{p: p, writable: writable}
+	if !writable {
+		p.mu.Lock()
+		tx.seq = p.seq
+		tx.meta = p.meta
+		p.snapshots[tx.seq]++
+		p.mu.Unlock()
+		return tx
+	}
+
+	p.wmu.Lock()
+
+	// Pages freed while snapshots were open may be reusable by now
+	p.mu.Lock()
+	p.reclaimPending(p.oldestSnapshot())
+	meta := *p.meta
+	p.mu.Unlock()
+
+	// The table of an older file must not be shared with the committed metadata
+	if meta.RootTable != nil {
+		meta.RootTable = slices.Clone(meta.RootTable)
+	}
+	tx.meta = &meta
+	tx.pages = make(map[PageID][]byte)
+	return tx
+}
+
+// Writable returns true if the transaction can modify pages.
+func (tx *Tx) Writable() bool {
+	return tx.writable
+}
+
+// DirtyCount returns the number of pages the transaction holds modified.
+func (tx *Tx) DirtyCount() int {
+	return len(tx.pages)
+}
+
+// GetPage returns a byte slice for the given page ID as seen by this transaction.
+// The slice must be treated as read-only; use GetPageForWrite to modify a page.
+// Returns nil for a page past the end of the file.
+func (tx *Tx) GetPage(id PageID) ([]byte, error) {
+	if !tx.writable {
+		return tx.p.snapshotPage(id, tx.seq)
+	}
+	if data, ok := tx.pages[id]; ok {
+		return data, nil
+	}
+	return tx.p.GetPage(id)
+}
+
+// GetPageForWrite returns a private writable copy of the given page.
+// The copy stays valid for the rest of the transaction.
+func (tx *Tx) GetPageForWrite(id PageID) ([]byte, error) {
+	if err := tx.checkWritable(); err != nil {
+		return nil, err
+	}
+	if data, ok := tx.pages[id]; ok {
+		return data, nil
+	}
+
+	src, err := tx.p.GetPage(id)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, PageSize)
+	copy(data, src)
+	tx.pages[id] = data
+	return data, nil
+}
+
+// AllocatePage allocates a new page and returns its ID.
+func (tx *Tx) AllocatePage() (PageID, error) {
+	if err := tx.checkWritable(); err != nil {
+		return 0, err
+	}
+
+	// Check free list first
+	if tx.meta.FreeList != 0 {
+		pageID := tx.meta.FreeList
+
+		// Get the next free page from the freed page's header
+		data, err := tx.GetPage(pageID)
+		if err != nil {
+			return 0, err
+		}
+		if data == nil {
+			return 0, fmt.Errorf("failed to get free page %d", pageID)
+		}
+		tx.meta.FreeList = binary.BigEndian.Uint64(data[0:8])
+		if tx.relocation != nil {
+			tx.relocation.allocated(pageID, tx.meta.FreeList)
+		}
+
+		// Clear the page
+		tx.pages[pageID] = make([]byte, PageSize)
+		return pageID, nil
+	}
+
+	// The file itself is grown on Flash; until then the page lives in memory
+	newPageID := PageID(tx.meta.PageCount)
+	tx.pages[newPageID] = make([]byte, PageSize)
+	tx.meta.PageCount++
+
+	return newPageID, nil
+}
+
+// FreePage releases a page.
+// The page only returns to the free list once the transaction has committed
+// and no snapshot can still reach it; until then its contents are left intact.
+// A shared page only loses a reference and stays in use.
+func (tx *Tx) FreePage(id PageID) error {
+	if err := tx.checkWritable(); err != nil {
+		return err
+	}
+	if id == MetaPageID || id >= tx.meta.PageCount {
+		return fmt.Errorf("failed to get page %d for freeing", id)
+	}
+	if dropped, err := tx.dropRef(id); dropped || err != nil {
+		return err
+	}
+
+	delete(tx.pages, id)
+	tx.freed = append(tx.freed, id)
+	return nil
+}
+
+// GetRootPage returns the root page ID for a given rootID.
+// Returns 0 if the rootID is invalid or the tree doesn't exist.
+func (tx *Tx) GetRootPage(rootID RootID) (PageID, error) {
+	page, err := lookupRoot(tx.meta, rootID, tx.GetPage)
+	// Reserved marker means empty tree
+	if page == ReservedMarker {
+		return 0, err
+	}
+	return page, err
+}
+
+// SetRootPage sets the root page ID for a given rootID.
+// A rootID that CreateRoot has not handed out yet raises RootLimit; the IDs
+// skipped by that are not handed out by CreateRoot.
+// Version 2 files have no CatalogRoot.
+func (tx *Tx) SetRootPage(rootID RootID, pageID PageID) error {
+	if err := tx.checkWritable(); err != nil {
+		return err
+	}
+	if rootID >= tx.RootLimit() {
+		if tx.meta.RootTable != nil {
+			return fmt.Errorf("invalid rootID: %d (max: %d)", rootID, LegacyMaxRoots-1)
+		}
+		if rootID == CatalogRoot {
+			return tx.setRoot(rootID, pageID)
+		}
+		if err := tx.growRootDir(rootID + 1); err != nil {
+			return err
+		}
+	}
+	return tx.setRoot(rootID, pageID)
+}
+
+// CreateRoot creates a new root and returns its ID.
+// The IDs of deleted roots are reused, the most recently deleted first.
+// Version 2 files hold at most LegacyMaxRoots roots.
+func (tx *Tx) CreateRoot() (RootID, error) {
+	if err := tx.checkWritable(); err != nil {
+		return 0, err
+	}
+
+	rootID, err := tx.newRootID()
+	if err != nil {
+		return 0, err
+	}
+	// Mark as reserved (not free, but empty tree)
+	if err := tx.setRoot(rootID, ReservedMarker); err != nil {
+		return 0, err
+	}
+	tx.meta.RootCount++
+	return rootID, nil
+}
+
+// ForkRoot creates a root that shares the tree of src and returns its ID.
+// The root page of src gains a reference, so that the two roots keep the
+// tree alive between them; pages must be copied before either root writes
+// to them (see Shared).
+func (tx *Tx) ForkRoot(src RootID) (RootID, error) {
+	if err := tx.checkWritable(); err != nil {
+		return 0, err
+	}
+	if src >= tx.RootLimit() {
+		return 0, fmt.Errorf("invalid rootID: %d", src)
+	}
+	pageID, err := lookupRoot(tx.meta, src, tx.GetPage)
+	if err != nil {
+		return 0, err
+	}
+	if pageID == 0 {
+		return 0, fmt.Errorf("root %d does not exist", src)
+	}
+
+	rootID, err := tx.CreateRoot()
+	if err != nil || pageID == ReservedMarker {
+		return rootID, err
+	}
+	if err := tx.AddRef(pageID); err != nil {
+		return 0, err
+	}
+	return rootID, tx.setRoot(rootID, pageID)
+}
+
+// DeleteRoot deletes a root tree.
+// Note: This only removes the root reference, does not free pages.
+// bptree2.Tx.DeleteRoot queues them for reclaiming first.
+func (tx *Tx) DeleteRoot(rootID RootID) error {
+	if err := tx.checkWritable(); err != nil {
+		return err
+	}
+	if rootID >= tx.RootLimit() {
+		return fmt.Errorf("invalid rootID: %d", rootID)
+	}
+	if pageID, err := lookupRoot(tx.meta, rootID, tx.GetPage); pageID == 0 || err != nil {
+		return err
+	}
+
+	if err := tx.setRoot(rootID, 0); err != nil {
+		return err
+	}
+	if tx.meta.RootCount > 0 {
+		tx.meta.RootCount--
+	}
+	if tx.meta.RootTable == nil {
+		return tx.pushStack(&tx.meta.FreeRoots, rootID)
+	}
+	return nil
+}
+
+// RootCount returns the number of active roots.
+func (tx *Tx) RootCount() uint64 {
+	return tx.meta.RootCount
+}
+
+// RootLimit returns the number of root IDs handed out so far.
+// Every root in use has an ID below it.
+func (tx *Tx) RootLimit() RootID {
+	if tx.meta.RootTable != nil {
+		return RootID(len(tx.meta.RootTable))
+	}
+	return tx.meta.NextRoot
+}
+
+// SetVersion sets the file format version, once the file has been upgraded.
+// Going from version 2 to Version moves the roots from the meta page table
+// into a root directory, and makes the next Flash rewrite every page to give
+// it a checksum, a batch of pages at a time. Going back moves the roots into
+// the table again; it fails if a root ID does not fit the table.
+func (tx *Tx) SetVersion(version uint32) error {
+	if err := tx.checkWritable(); err != nil {
+		return err
+	}
+	if version < MinVersion || version > Version {
+		return fmt.Errorf("unsupported version: %d (expected %d to %d)", version, MinVersion, Version)
+	}
+	if version == Version && tx.meta.RootTable != nil {
+		if err := tx.upgradeRootTable(); err != nil {
+			return err
+		}
+	} else if version < Version && tx.meta.RootTable == nil {
+		if err := tx.downgradeRootDir(); err != nil {
+			return err
+		}
+	}
+
+	if (version == Version) != (tx.meta.Version == Version) {
+		tx.rewrite = version == Version
+	}
+	tx.meta.Version = version
+	return nil
+}
+
+// Commit publishes the transaction's pages and metadata to the pager.
+// Committed pages reach the file on the next Flash.
+// For a read-only transaction Commit releases the snapshot, like Rollback.
+func (tx *Tx) Commit() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+	if !tx.writable {
+		tx.p.release(tx.seq)
+		return nil
+	}
+	defer tx.p.wmu.Unlock()
+
+	p := tx.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.seq++
+
+	// Pages are copied aside before being replaced while snapshots can see them
+	newest, live := p.newestSnapshot()
+	for id, data := range tx.pages {
+		if live {
+			p.preserve(id, newest)
+		}
+		p.dirty[id] = data
+	}
+
+	if len(tx.freed) > 0 {
+		p.pending = append(p.pending, pendingFree{seq: p.seq, pages: tx.freed})
+	}
+	p.reclaim(tx.meta, p.oldestSnapshot())
+
+	p.meta = tx.meta
+	p.writeMeta()
+	if tx.rewrite || tx.meta.Version < Version {
+		p.rewrite = tx.rewrite // a downgrade cancels a pending rewrite
+	}
+
+	tx.pages = nil
+	return nil
+}
+
+// Rollback discards all changes made by the transaction.
+// For a read-only transaction it releases the snapshot.
+func (tx *Tx) Rollback() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+	if tx.writable {
+		tx.pages = nil
+		tx.p.wmu.Unlock()
+	} else {
+		tx.p.release(tx.seq)
+	}
+	return nil
+}
+
+// checkWritable returns an error if the transaction cannot modify pages.
+func (tx *Tx) checkWritable() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	if !tx.writable {
+		return ErrTxReadOnly
+	}
+	return nil
+}
+
+// snapshotPage returns a page as it was after the commit with sequence number seq.
+func (p *Pager) snapshotPage(id PageID, seq uint64) ([]byte, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	for _, v := range p.versions[id] {
+		if v.validThrough >= seq {
+			return v.data, nil
+		}
+	}
+	return p.page(id)
+}
+
+// preserve saves the committed image of a page before it is overwritten,
+// unless the snapshots up to newest are already served by a saved image.
+// Must be called with p.mu held, after p.seq has been advanced.
+func (p *Pager) preserve(id PageID, newest uint64) {
+	versions := p.versions[id]
+	if n := len(versions); n > 0 && versions[n-1].validThrough >= newest {
+		return
+	}
+
+	// Copied without verification, so a corrupt page cannot fail the commit
+	current := p.unverifiedPage(id)
+	if current == nil {
+		return // Page did not exist yet
+	}
+	data := make([]byte, PageSize)
+	copy(data, current)
+	p.versions[id] = append(versions, pageVersion{data: data, validThrough: p.seq - 1})
+}
+
+// reclaim moves pages freed by commits up to sequence number limit onto the
+// free list of meta. Must be called with p.mu held.
+func (p *Pager) reclaim(meta *MetaPage, limit uint64) {
+	n := 0
+	for ; n < len(p.pending) && p.pending[n].seq <= limit; n++ {
+		for _, id := range p.pending[n].pages {
+			// Clear page and store next free page pointer
+			data := make([]byte, PageSize)
+			binary.BigEndian.PutUint64(data[0:8], meta.FreeList)
+			p.dirty[id] = data
+
+			// Update free list head
+			meta.FreeList = id
+		}
+	}
+	p.pending = p.pending[n:]
+}
+
+// release closes the snapshot taken at seq and drops the page images
+// that no remaining snapshot can see.
+func (p *Pager) release(seq uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.snapshots[seq]--
+	if p.snapshots[seq] > 0 {
+		return
+	}
+	delete(p.snapshots, seq)
+
+	if len(p.snapshots) == 0 {
+		if len(p.versions) > 0 {
+			p.versions = make(map[PageID][]pageVersion)
+		}
+		return
+	}
+
+	// Images are only dropped when the oldest snapshot goes away
+	oldest := p.oldestSnapshot()
+	if seq > oldest {
+		return
+	}
+	for id, versions := range p.versions {
+		n := 0
+		for n < len(versions) && versions[n].validThrough < oldest {
+			n++
+		}
+		if n == len(versions) {
+			delete(p.versions, id)
+		} else if n > 0 {
+			p.versions[id] = versions[n:]
+		}
+	}
+}
+
+// oldestSnapshot returns the sequence number of the oldest open snapshot,
+// or the current sequence number if there is none. Must be called with p.mu held.
+func (p *Pager) oldestSnapshot() uint64 {
+	oldest := p.seq
+	for seq := range p.snapshots {
+		if seq < oldest {
+			oldest = seq
+		}
+	}
+	return oldest
+}
+
+// newestSnapshot returns the sequence number of the newest open snapshot.
+// Must be called with p.mu held.
+func (p *Pager) newestSnapshot() (uint64, bool) {
+	var newest uint64
+	for seq := range p.snapshots {
+		if seq > newest {
+			newest = seq
+		}
+	}
+	return newest, len(p.snapshots) > 0
+}
@@ -0,0 +1,344 @@
+package bpager_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"bptree2/bpager"
+)
+
+func TestTxCommit(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	p, err := bpager.Open(path)
+	if err != nil {
+		t.Fatalf("bpager.Open failed: %v", err)
+	}
+	defer p.Close()
+
+	tx := p.Begin(true)
+	root, err := tx.CreateRoot()
+	if err != nil {
+		t.Fatalf("CreateRoot failed: %v", err)
+	}
+	id, err := tx.AllocatePage()
+	if err != nil {
+		t.Fatalf("AllocatePage failed: %v", err)
+	}
+	copy(getPage(t, tx.GetPageForWrite, id), "hello")
+	tx.SetRootPage(root, id)
+
+	// Nothing is visible outside the transaction before Commit
+	if p.RootCount() != 0 || p.PageCount() != 1 {
+		t.Errorf("uncommitted metadata should be invisible, got %d roots and %d pages", p.RootCount(), p.PageCount())
+	}
+	if rootPage(t, tx.GetRootPage, root) != id {
+		t.Errorf("transaction should see its own root page")
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if rootPage(t, p.GetRootPage, root) != id {
+		t.Errorf("expected root page %d after commit, got %d", id, rootPage(t, p.GetRootPage, root))
+	}
+	if string(getPage(t, p.GetPage, id)[0:5]) != "hello" {
+		t.Errorf("expected 'hello' after commit, got '%s'", string(getPage(t, p.GetPage, id)[0:5]))
+	}
+
+	if err := tx.Commit(); err != bpager.ErrTxDone {
+		t.Errorf("second Commit should return ErrTxDone, got %v", err)
+	}
+}
+
+func TestTxRollback(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	p, err := bpager.Open(path)
+	if err != nil {
+		t.Fatalf("bpager.Open failed: %v", err)
+	}
+	defer p.Close()
+
+	id, _ := p.AllocatePage()
+	writePage(t, p, id, "first")
+
+	tx := p.Begin(true)
+	copy(getPage(t, tx.GetPageForWrite, id), "later")
+	tx.AllocatePage()
+	tx.FreePage(id)
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("Rollback failed: %v", err)
+	}
+
+	if string(getPage(t, p.GetPage, id)[0:5]) != "first" {
+		t.Errorf("rolled back write should be discarded, got '%s'", string(getPage(t, p.GetPage, id)[0:5]))
+	}
+	if p.PageCount() != 2 {
+		t.Errorf("rolled back allocation should be discarded, got page count %d", p.PageCount())
+	}
+
+	// The writer is released again and the rolled back FreePage left no trace
+	next, err := p.AllocatePage()
+	if err != nil {
+		t.Fatalf("AllocatePage failed: %v", err)
+	}
+	if next != 2 {
+		t.Errorf("expected page 2, got %d", next)
+	}
+}
+
+func TestReadOnlyTx(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	p, err := bpager.Open(path)
+	if err != nil {
+		t.Fatalf("bpager.Open failed: %v", err)
+	}
+	defer p.Close()
+
+	tx := p.Begin(false)
+	defer tx.Rollback()
+
+	if _, err := tx.AllocatePage(); err == nil {
+		t.Error("AllocatePage in a read-only transaction should fail")
+	}
+	if _, err := tx.CreateRoot(); err == nil {
+		t.Error("CreateRoot in a read-only transaction should fail")
+	}
+
+	// A read-only transaction does not block writers
+	if _, err := p.AllocatePage(); err != nil {
+		t.Fatalf("AllocatePage failed: %v", err)
+	}
+}
+
+func TestSnapshotSeesOldPages(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	p, err := bpager.Open(path)
+	if err != nil {
+		t.Fatalf("bpager.Open failed: %v", err)
+	}
+	defer p.Close()
+
+	id, _ := p.AllocatePage()
+	writePage(t, p, id, "first")
+	p.Flash()
+
+	snap := p.Begin(false)
+
+	// Overwrite the page twice, flashing in between
+	writePage(t, p, id, "secnd")
+	p.Flash()
+	writePage(t, p, id, "third")
+
+	if string(getPage(t, snap.GetPage, id)[0:5]) != "first" {
+		t.Errorf("snapshot should see the old page, got '%s'", string(getPage(t, snap.GetPage, id)[0:5]))
+	}
+	if string(getPage(t, p.GetPage, id)[0:5]) != "third" {
+		t.Errorf("pager should see the latest page, got '%s'", string(getPage(t, p.GetPage, id)[0:5]))
+	}
+
+	// A second snapshot sees the state at its own start
+	snap2 := p.Begin(false)
+	writePage(t, p, id, "forth")
+	if string(getPage(t, snap2.GetPage, id)[0:5]) != "third" {
+		t.Errorf("second snapshot should see 'third', got '%s'", string(getPage(t, snap2.GetPage, id)[0:5]))
+	}
+	if string(getPage(t, snap.GetPage, id)[0:5]) != "first" {
+		t.Errorf("first snapshot should still see 'first', got '%s'", string(getPage(t, snap.GetPage, id)[0:5]))
+	}
+
+	snap.Rollback()
+	if string(getPage(t, snap2.GetPage, id)[0:5]) != "third" {
+		t.Errorf("second snapshot should survive the release of the first, got '%s'", string(getPage(t, snap2.GetPage, id)[0:5]))
+	}
+	snap2.Rollback()
+}
+
+func TestSnapshotDefersFree(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	p, err := bpager.Open(path)
+	if err != nil {
+		t.Fatalf("bpager.Open failed: %v", err)
+	}
+	defer p.Close()
+
+	root, _ := p.CreateRoot()
+	id, _ := p.AllocatePage()
+	writePage(t, p, id, "hello")
+	p.SetRootPage(root, id)
+
+	snap := p.Begin(false)
+
+	p.SetRootPage(root, 0)
+	if err := p.FreePage(id); err != nil {
+		t.Fatalf("FreePage failed: %v", err)
+	}
+
+	// The snapshot still reaches the page, so it must not be handed out again
+	other, _ := p.AllocatePage()
+	if other == id {
+		t.Fatalf("page %d reused while a snapshot can still reach it", id)
+	}
+	p.Flash()
+	if rootPage(t, snap.GetRootPage, root) != id || string(getPage(t, snap.GetPage, id)[0:5]) != "hello" {
+		t.Errorf("snapshot should still see the freed page")
+	}
+
+	snap.Rollback()
+
+	reused, _ := p.AllocatePage()
+	if reused != id {
+		t.Errorf("expected page %d to be reused after release, got %d", id, reused)
+	}
+}
+
+func TestReclaimQueue(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	p, err := bpager.Open(path)
+	if err != nil {
+		t.Fatalf("bpager.Open failed: %v", err)
+	}
+
+	// Enough entries to chain several queue pages
+	n := 3*bpager.StackCapacity + 7
+	tx := p.Begin(true)
+	for i := 0; i < n; i++ {
+		if err := tx.PushReclaim(bpager.PageID(1000 + i)); err != nil {
+			t.Fatalf("PushReclaim failed: %v", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	p.Close()
+
+	// The queue survives a restart
+	p, err = bpager.Open(path)
+	if err != nil {
+		t.Fatalf("bpager.Open failed: %v", err)
+	}
+	defer p.Close()
+	if !p.ReclaimPending() {
+		t.Fatal("reclaim queue should survive a restart")
+	}
+
+	tx = p.Begin(true)
+	for i := n - 1; i >= 0; i-- {
+		id, ok, err := tx.PopReclaim()
+		if err != nil || !ok {
+			t.Fatalf("PopReclaim failed: %v, %v", ok, err)
+		}
+		if id != bpager.PageID(1000+i) {
+			t.Fatalf("expected page %d, got %d", 1000+i, id)
+		}
+	}
+	if _, ok, _ := tx.PopReclaim(); ok {
+		t.Error("queue should be empty")
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if p.ReclaimPending() {
+		t.Error("ReclaimPending should be false for an empty queue")
+	}
+}
+
+func TestPageReferences(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	p, err := bpager.Open(path)
+	if err != nil {
+		t.Fatalf("bpager.Open failed: %v", err)
+	}
+
+	tx := p.Begin(true)
+	first, _ := tx.AllocatePage()
+	if err := tx.AddRef(first); err != nil {
+		t.Fatalf("AddRef failed: %v", err)
+	}
+
+	// Pages allocated later grow the reference table by a level
+	var last bpager.PageID
+	for i := 0; i < 1000; i++ {
+		last, _ = tx.AllocatePage()
+	}
+	for i := 0; i < 2; i++ {
+		if err := tx.AddRef(last); err != nil {
+			t.Fatalf("AddRef failed: %v", err)
+		}
+	}
+	blob, err := tx.WriteOverflow(make([]byte, 3*bpager.PageSize))
+	if err != nil {
+		t.Fatalf("WriteOverflow failed: %v", err)
+	}
+	if err := tx.AddRef(blob); err != nil {
+		t.Fatalf("AddRef failed: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	p.Close()
+
+	// The counts survive a restart
+	p, err = bpager.Open(path)
+	if err != nil {
+		t.Fatalf("bpager.Open failed: %v", err)
+	}
+	defer p.Close()
+
+	tx = p.Begin(true)
+	if n, err := tx.RefCount(first); err != nil || n != 2 {
+		t.Errorf("page %d: expected 2 references, got %d (%v)", first, n, err)
+	}
+	if n, err := tx.RefCount(last); err != nil || n != 3 {
+		t.Errorf("page %d: expected 3 references, got %d (%v)", last, n, err)
+	}
+	if shared, err := tx.Shared(first + 1); err != nil || shared {
+		t.Errorf("page %d should not be shared (%v)", first+1, err)
+	}
+
+	// Freeing a shared page drops a reference
+	if err := tx.FreePage(first); err != nil {
+		t.Fatalf("FreePage failed: %v", err)
+	}
+	if err := tx.FreeOverflow(blob); err != nil {
+		t.Fatalf("FreeOverflow failed: %v", err)
+	}
+	for _, id := range []bpager.PageID{first, blob} {
+		if shared, err := tx.Shared(id); err != nil || shared {
+			t.Errorf("page %d should have a single reference left (%v)", id, err)
+		}
+	}
+	if data, err := tx.ReadOverflow(blob); err != nil || len(data) != 3*bpager.PageSize {
+		t.Errorf("shared chain should stay intact: %d bytes, %v", len(data), err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	// The last reference frees the page
+	tx = p.Begin(true)
+	if err := tx.FreePage(first); err != nil {
+		t.Fatalf("FreePage failed: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	tx = p.Begin(true)
+	defer tx.Rollback()
+	if id, _ := tx.AllocatePage(); id != first {
+		t.Errorf("expected freed page %d to be reused, got %d", first, id)
+	}
+}
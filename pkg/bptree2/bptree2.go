@@ -16,8 +16,8 @@
 //	tree.Insert(rootID, 1, 100, 500)  // key1=1, key2=100, value=500
 //	tree.Insert(rootID, 1, 200, 600)  // key1=1, key2=200, value=600
 //
-//	val, ok := tree.Find(rootID, 1, 100)
-//	if ok {
+//	val, ok, err := tree.Find(rootID, 1, 100)
+//	if err == nil && ok {
 //	    fmt.Println(val) // 500
 //	}
 //
@@ -26,6 +26,8 @@ package bptree2
 
 import (
 	"fmt"
+	"sync"
+	"sync/atomic"
 
 	"bptree2/bnode"
 	"bptree2/bpager"
@@ -34,11 +36,32 @@ import (
 // RootID is the identifier for a root tree.
 type RootID = bpager.RootID
 
+// maxDepth bounds every descent from a root to a leaf. Even nodes of two
+// entries would need more pages than a file can hold for a tree this deep,
+// so a descent that reaches it is in a corrupt tree, such as one whose child
+// pointers form a loop, and ends with errTooDeep.
+const maxDepth = 64
+
+// errTooDeep is returned by a descent that reaches maxDepth.
+var errTooDeep = fmt.Errorf("tree is deeper than %d levels", maxDepth)
+
+// maxDirtyPages bounds the committed pages that wait on the heap for the
+// next Flash, 64 MB of them. A commit that leaves more flashes them itself.
+const maxDirtyPages = 16384
+
 // BPTree is a B+Tree that stores composite keys (Key1, Key2) and values.
 // Supports multiple root trees.
+//
+// All operations run in transactions (see Begin). The methods on BPTree
+// each run a single operation in its own transaction.
 type BPTree struct {
-	pager *bpager.Pager
-	// mu    sync.RWMutex // Protects writes, allows concurrent reads
+	pager  *bpager.Pager
+	wmu    sync.Mutex   // Serializes writable transactions, Flash and Close
+	mu     sync.RWMutex // Held by snapshot reads, taken exclusively by Flash and Close
+	closed bool
+
+	reclaiming atomic.Bool // set while the background reclaimer runs
+	reclaimErr error       // error that stopped the background reclaimer, guarded by wmu
 }
 
 // Open opens or creates a B+Tree file.
@@ -48,291 +71,293 @@ func Open(path string) (*BPTree, error) {
 		return nil, fmt.Errorf("failed to open pager: %w", err)
 	}
 
-	return &BPTree{
+	t := &BPTree{
 		pager: p,
-	}, nil
+	}
+
+	// Files from older versions are upgraded in place
+	if err := t.upgrade(); err != nil {
+		p.Close()
+		return nil, err
+	}
+
+	// Finish freeing the pages of trees deleted before the last Close
+	if p.ReclaimPending() {
+		t.startReclaim()
+	}
+
+	return t, nil
 }
 
 // Flash syncs all changes to disk.
+// Only committed transactions are written; Flash waits for an active
+// writable transaction and for reads in progress to finish. Committed
+// changes are durable once Flash returns, not before.
+// An error that stopped the background reclaimer since the last Flash or
+// Reclaim is returned once the changes are synced.
 func (t *BPTree) Flash() error {
-	//	t.mu.Lock()
-	//	defer t.mu.Unlock()
+	t.wmu.Lock()
+	defer t.wmu.Unlock()
+	if err := t.flash(); err != nil {
+		return err
+	}
+	return t.takeReclaimErr()
+}
+
+// flash syncs the committed changes to disk once the reads in progress
+// have finished. The caller holds wmu.
+func (t *BPTree) flash() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	return t.pager.Flash()
 }
 
 // Count returns the number of key-value pairs in a tree.
-// This is an O(n) operation.
-func (t *BPTree) Count(rootID RootID) int {
-	//	t.mu.RLock()
-	//	defer t.mu.RUnlock()
-
-	count := 0
-	t.scanInternal(rootID, 0, 0, ^uint64(0), ^uint64(0), func(key1, key2, value uint64) bool {
-		count++
-		return true
+// This is an O(1) operation.
+func (t *BPTree) Count(rootID RootID) (count int, err error) {
+	err = t.view(func(tx *Tx) (err error) {
+		count, err = tx.Count(rootID)
+		return err
 	})
-	return count
+	return count, err
 }
 
 // Close closes the B+Tree and underlying file.
 func (t *BPTree) Close() error {
+	t.wmu.Lock()
+	defer t.wmu.Unlock()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closed = true
 	return t.pager.Close()
 }
 
 // CreateRoot creates a new root tree and returns its ID.
 func (t *BPTree) CreateRoot() (RootID, error) {
-	//	t.mu.Lock()
-	//	defer t.mu.Unlock()
-	return t.pager.CreateRoot()
-}
-
-// DeleteRoot deletes a root tree.
-// Note: This only removes the root reference.
-func (t *BPTree) DeleteRoot(rootID RootID) error {
-	//	t.mu.Lock()
-	//	defer t.mu.Unlock()
-	return t.pager.DeleteRoot(rootID)
+	var rootID RootID
+	err := t.update(func(tx *Tx) (err error) {
+		rootID, err = tx.pages.CreateRoot()
+		return err
+	})
+	return rootID, err
 }
 
 // RootCount returns the number of active root trees.
 func (t *BPTree) RootCount() uint64 {
-	//	t.mu.RLock()
-	//	defer t.mu.RUnlock()
+	t.mu.RLock()
+	defer t.mu.RUnlock()
 	return t.pager.RootCount()
 }
 
 // Find retrieves a value by composite key (key1, key2) from a specific root tree.
 // Returns (value, true) if found, (0, false) otherwise.
-func (t *BPTree) Find(rootID RootID, key1, key2 uint64) (uint64, bool) {
-	//	t.mu.RLock()
-	//	defer t.mu.RUnlock()
-
-	rootPageID := t.pager.GetRootPage(rootID)
-	if rootPageID == 0 {
-		return 0, false // Empty tree
-	}
-
-	return t.search(rootPageID, key1, key2)
+func (t *BPTree) Find(rootID RootID, key1, key2 uint64) (value uint64, found bool, err error) {
+	err = t.view(func(tx *Tx) (err error) {
+		value, found, err = tx.Find(rootID, key1, key2)
+		return err
+	})
+	return value, found, err
 }
 
 // FindRange iterates over all key-value pairs where (start1,start2) <= (key1,key2) <= (end1,end2).
 // The callback function is called for each pair. Return false to stop iteration.
 func (t *BPTree) FindRange(rootID RootID, start1, start2, end1, end2 uint64, fn func(key1, key2, value uint64) bool) error {
-	//	t.mu.RLock()
-	//	defer t.mu.RUnlock()
+	return t.view(func(tx *Tx) error {
+		return tx.FindRange(rootID, start1, start2, end1, end2, fn)
+	})
+}
 
-	return t.scanInternal(rootID, start1, start2, end1, end2, fn)
+// FindRangeReverse is like FindRange, but calls fn in descending key order,
+// starting at (end1,end2) and walking down to (start1,start2).
+func (t *BPTree) FindRangeReverse(rootID RootID, start1, start2, end1, end2 uint64, fn func(key1, key2, value uint64) bool) error {
+	return t.view(func(tx *Tx) error {
+		return tx.FindRangeReverse(rootID, start1, start2, end1, end2, fn)
+	})
 }
 
 // Insert inserts or updates a key-value pair with composite key in a specific root tree.
 func (t *BPTree) Insert(rootID RootID, key1, key2, value uint64) error {
-	//	t.mu.Lock()
-	//	defer t.mu.Unlock()
-
-	rootPageID := t.pager.GetRootPage(rootID)
-
-	// Empty tree - create first leaf
-	if rootPageID == 0 {
-		newPageID, err := t.pager.AllocatePage()
-		if err != nil {
-			return fmt.Errorf("failed to allocate root: %w", err)
-		}
-		data := t.pager.GetPage(newPageID)
-		leaf := bnode.NewLeafNode(data, true)
-		leaf.Put(key1, key2, value)
-		if err := t.pager.SetRootPage(rootID, newPageID); err != nil {
-			return err
-		}
-		return nil
-	}
-
-	// Insert into existing tree
-	splitKey, newChildID, err := t.insert(rootPageID, key1, key2, value)
-	if err != nil {
-		return err
-	}
-
-	// Root was split - create new root
-	if newChildID != 0 {
-		newRootID, err := t.pager.AllocatePage()
-		if err != nil {
-			return fmt.Errorf("failed to allocate new root: %w", err)
-		}
-		data := t.pager.GetPage(newRootID)
-		newRoot := bnode.NewInternalNode(data, true)
-		newRoot.InitRoot(rootPageID, newChildID, splitKey)
-		if err := t.pager.SetRootPage(rootID, newRootID); err != nil {
-			return err
-		}
-	}
-
-	return nil
+	return t.update(func(tx *Tx) error {
+		return tx.Insert(rootID, key1, key2, value)
+	})
 }
 
 // Delete removes a composite key from a specific root tree.
 // Returns true if the key was found and removed.
-func (t *BPTree) Delete(rootID RootID, key1, key2 uint64) bool {
-	//	t.mu.Lock()
-	//	defer t.mu.Unlock()
+func (t *BPTree) Delete(rootID RootID, key1, key2 uint64) (deleted bool, err error) {
+	err = t.update(func(tx *Tx) (err error) {
+		deleted, err = tx.Delete(rootID, key1, key2)
+		return err
+	})
+	return deleted, err
+}
 
-	rootPageID := t.pager.GetRootPage(rootID)
-	if rootPageID == 0 {
-		return false
+// update runs fn in a writable transaction, committing it if fn succeeds.
+// A corrupt page read by fn rolls the transaction back with ErrCorruptPage.
+func (t *BPTree) update(fn func(tx *Tx) error) error {
+	tx, err := t.Begin(true)
+	if err != nil {
+		return err
 	}
-
-	deleted, _ := t.deleteRecursive(rootPageID, key1, key2)
-
-	// Check if root needs to shrink
-	if deleted {
-		rootData := t.pager.GetPage(rootPageID)
-		rootType := bnode.GetNodeType(rootData)
-
-		if rootType == bnode.NodeTypeInternal {
-			internal := bnode.NewInternalNode(rootData, false)
-			if internal.KeyCount() == 0 {
-				// Root has no keys, promote only child to root
-				newRootPageID := internal.GetChild(0)
-				t.pager.SetRootPage(rootID, newRootPageID)
-				t.pager.FreePage(rootPageID)
-			}
-		} else {
-			// Root is leaf
-			leaf := bnode.NewLeafNode(rootData, false)
-			if leaf.KeyCount() == 0 {
-				// Tree is now empty
-				t.pager.SetRootPage(rootID, 0)
-				t.pager.FreePage(rootPageID)
-			}
-		}
+	if err := tx.run(fn); err != nil {
+		tx.Rollback()
+		return err
 	}
-
-	return deleted
+	return tx.Commit()
 }
 
-// search recursively searches for a composite key starting from the given page.
-func (t *BPTree) search(pageID bpager.PageID, key1, key2 uint64) (uint64, bool) {
-	data := t.pager.GetPage(pageID)
-	if data == nil {
-		return 0, false
+// view runs fn in a read-only transaction.
+// A corrupt page read by fn ends it with ErrCorruptPage.
+func (t *BPTree) view(fn func(tx *Tx) error) error {
+	tx, err := t.Begin(false)
+	if err != nil {
+		return err
 	}
+	defer tx.Rollback()
+	return tx.run(fn)
+}
 
-	nodeType := bnode.GetNodeType(data)
+// run calls fn with the transaction, returning a corrupt page as an error.
+func (tx *Tx) run(fn func(tx *Tx) error) (err error) {
+	defer recoverCorrupt(&err)
+	return fn(tx)
+}
 
-	if nodeType == bnode.NodeTypeLeaf {
-		leaf := bnode.NewLeafNode(data, false)
-		return leaf.Get(key1, key2)
+// search searches for a composite key starting from the given page.
+func (tx *Tx) search(pageID bpager.PageID, key1, key2 uint64) (uint64, bool, error) {
+	leafID, err := tx.findLeaf(pageID, key1, key2)
+	if err != nil {
+		return 0, false, err
 	}
-
-	// Internal node - find child to search (use key1 for navigation)
-	internal := bnode.NewInternalNode(data, false)
-	childID := internal.GetChildForKey(key1)
-	return t.search(childID, key1, key2)
+	value, found := bnode.NewLeafNode(tx.page(leafID), false).Get(key1, key2)
+	return value, found, nil
 }
 
+// putFunc decides the entry to store under a key, given the current one.
+// found is false and value and flags are 0 if the key does not exist.
+// Returning ok == false leaves the tree as it is.
+type putFunc func(found bool, value uint64, flags bnode.EntryFlags) (newValue uint64, newFlags bnode.EntryFlags, ok bool)
+
 // insert recursively inserts a key-value pair with composite key.
+// fn decides the value once the leaf is found.
 // Returns (splitKey, newPageID, error). If newPageID is non-zero, a split occurred.
-func (t *BPTree) insert(pageID bpager.PageID, key1, key2, value uint64) (uint64, bpager.PageID, error) {
-	data := t.pager.GetPage(pageID)
+func (tx *Tx) insert(pageID bpager.PageID, key1, key2 uint64, fn putFunc) (Key, bpager.PageID, error) {
+	data := tx.page(pageID)
 	if data == nil {
-		return 0, 0, fmt.Errorf("failed to get page %d", pageID)
+		return Key{}, 0, fmt.Errorf("failed to get page %d", pageID)
 	}
 
 	nodeType := bnode.GetNodeType(data)
 
 	if nodeType == bnode.NodeTypeLeaf {
-		return t.insertLeaf(pageID, key1, key2, value)
+		return tx.insertLeaf(pageID, key1, key2, fn)
 	}
 
-	return t.insertInternal(pageID, key1, key2, value)
+	return tx.insertInternal(pageID, key1, key2, fn)
 }
 
 // insertLeaf inserts into a leaf node.
-// Note: pageID is used instead of data slice because AllocatePage may trigger
-// mmap remap, invalidating any previously obtained slices.
-func (t *BPTree) insertLeaf(pageID bpager.PageID, key1, key2, value uint64) (uint64, bpager.PageID, error) {
-	data := t.pager.GetPage(pageID)
-	leaf := bnode.NewLeafNode(data, false)
+// Note: pageID is used instead of data slice because the leaf is modified
+// through the transaction's writable copy of the page.
+func (tx *Tx) insertLeaf(pageID bpager.PageID, key1, key2 uint64, fn putFunc) (Key, bpager.PageID, error) {
+	leaf := bnode.NewLeafNode(tx.page(pageID), false)
+	idx, found := leaf.Search(key1, key2)
+	var value uint64
+	var flags bnode.EntryFlags
+	if found {
+		value, flags = leaf.GetValueAt(idx), leaf.GetFlagsAt(idx)
+	}
+	value, flags, ok := fn(found, value, flags)
+	if !ok {
+		return Key{}, 0, nil
+	}
+
+	data := tx.pageForWrite(pageID)
+	leaf = bnode.NewLeafNode(data, false)
 
-	// If node has room, just insert
-	if !leaf.IsFull() {
-		leaf.Put(key1, key2, value)
-		return 0, 0, nil
+	// An update replaces the old value, and with it any overflow chain or bucket
+	if found {
+		if err := tx.freeValueAt(leaf, idx); err != nil {
+			return Key{}, 0, err
+		}
+		leaf.PutWithFlags(key1, key2, value, flags)
+		return Key{}, 0, nil
 	}
 
-	// Check if it's an update (existing key)
-	if _, found := leaf.Get(key1, key2); found {
-		leaf.Put(key1, key2, value)
-		return 0, 0, nil
+	// If node has room, just insert
+	if !leaf.IsFull() {
+		leaf.PutWithFlags(key1, key2, value, flags)
+		return Key{}, 0, nil
 	}
 
-	// Need to split - AllocatePage may remap mmap, invalidating data slice
-	newPageID, err := t.pager.AllocatePage()
+	// Need to split
+	newPageID, err := tx.pages.AllocatePage()
 	if err != nil {
-		return 0, 0, fmt.Errorf("failed to allocate page: %w", err)
+		return Key{}, 0, fmt.Errorf("failed to allocate page: %w", err)
 	}
 
-	// Re-fetch data after potential mmap remap
-	data = t.pager.GetPage(pageID)
-	leaf = bnode.NewLeafNode(data, false)
-
-	newData := t.pager.GetPage(newPageID)
+	newData := tx.pageForWrite(newPageID)
 	splitKey, newLeaf := leaf.Split(newData)
 
 	// Insert the new key into appropriate node
-	// Use key1 for comparison with splitKey (which is the first key1 of new node)
-	if key1 < splitKey {
-		leaf.Put(key1, key2, value)
+	// splitKey is the first key of the new node
+	if (Key{Key1: key1, Key2: key2}).Compare(splitKey) < 0 {
+		leaf.PutWithFlags(key1, key2, value, flags)
 	} else {
-		newLeaf.Put(key1, key2, value)
+		newLeaf.PutWithFlags(key1, key2, value, flags)
 	}
 
-	// Update leaf links
-	newLeaf.SetNextLeaf(leaf.NextLeaf())
-	leaf.SetNextLeaf(newPageID)
-
 	return splitKey, newPageID, nil
 }
 
 // insertInternal handles insertion through an internal node.
-// Note: pageID is used instead of data slice because AllocatePage may trigger
-// mmap remap, invalidating any previously obtained slices.
-func (t *BPTree) insertInternal(pageID bpager.PageID, key1, key2, value uint64) (uint64, bpager.PageID, error) {
-	data := t.pager.GetPage(pageID)
+// Note: pageID is used instead of data slice because the node is only copied
+// into a writable page when a child split has to be absorbed.
+func (tx *Tx) insertInternal(pageID bpager.PageID, key1, key2 uint64, fn putFunc) (Key, bpager.PageID, error) {
+	data := tx.page(pageID)
 	internal := bnode.NewInternalNode(data, false)
-	childID := internal.GetChildForKey(key1)
+	childIdx := internal.Search(key1, key2)
+	childID, err := tx.unshareChild(pageID, childIdx)
+	if err != nil {
+		return Key{}, 0, err
+	}
 
-	// Recursively insert into child - this may trigger mmap remap
-	splitKey, newChildID, err := t.insert(childID, key1, key2, value)
+	// Recursively insert into child
+	splitKey, newChildID, err := tx.insert(childID, key1, key2, fn)
 	if err != nil {
-		return 0, 0, err
+		return Key{}, 0, err
 	}
 
-	// No split in child
-	if newChildID == 0 {
-		return 0, 0, nil
+	// An update that leaves the summary of the child as it is needs no copy of this node
+	childStats := tx.nodeStats(childID)
+	if newChildID == 0 && childStats == internal.GetStats(childIdx) {
+		return Key{}, 0, nil
 	}
 
-	// Re-fetch data after potential mmap remap during child insert
-	data = t.pager.GetPage(pageID)
+	// The child changed or was split, this node has to be modified
+	data = tx.pageForWrite(pageID)
 	internal = bnode.NewInternalNode(data, false)
+	internal.SetStats(childIdx, childStats)
+
+	// No split in child
+	if newChildID == 0 {
+		return Key{}, 0, nil
+	}
 
 	// Child was split, need to insert new key into this node
+	newChildStats := tx.nodeStats(newChildID)
 	if !internal.IsFull() {
-		internal.Insert(splitKey, newChildID)
-		return 0, 0, nil
+		internal.Insert(splitKey, newChildID, newChildStats)
+		return Key{}, 0, nil
 	}
 
-	// This node is full, need to split - AllocatePage may remap mmap
-	newPageID, err := t.pager.AllocatePage()
+	// This node is full, need to split
+	newPageID, err := tx.pages.AllocatePage()
 	if err != nil {
-		return 0, 0, fmt.Errorf("failed to allocate page: %w", err)
+		return Key{}, 0, fmt.Errorf("failed to allocate page: %w", err)
 	}
 
-	// Re-fetch data after potential mmap remap
-	data = t.pager.GetPage(pageID)
-	internal = bnode.NewInternalNode(data, false)
-
-	newData := t.pager.GetPage(newPageID)
+	newData := tx.pageForWrite(newPageID)
 	midKey, _ := internal.Split(newData)
 
 	// Insert the new key into appropriate node
@@ -340,10 +365,10 @@ func (t *BPTree) insertInternal(pageID bpager.PageID, key1, key2, value uint64)
 	internal = bnode.NewInternalNode(data, false)
 	newInternal := bnode.NewInternalNode(newData, false)
 
-	if splitKey < midKey {
-		internal.Insert(splitKey, newChildID)
+	if splitKey.Compare(midKey) < 0 {
+		internal.Insert(splitKey, newChildID, newChildStats)
 	} else {
-		newInternal.Insert(splitKey, newChildID)
+		newInternal.Insert(splitKey, newChildID, newChildStats)
 	}
 
 	return midKey, newPageID, nil
@@ -351,67 +376,100 @@ func (t *BPTree) insertInternal(pageID bpager.PageID, key1, key2, value uint64)
 
 // deleteRecursive recursively deletes a composite key, handling underflow.
 // Returns (deleted, underflow) where underflow indicates this node needs rebalancing.
-func (t *BPTree) deleteRecursive(pageID bpager.PageID, key1, key2 uint64) (bool, bool) {
-	data := t.pager.GetPage(pageID)
+func (tx *Tx) deleteRecursive(pageID bpager.PageID, key1, key2 uint64) (bool, bool, error) {
+	data := tx.page(pageID)
 	if data == nil {
-		return false, false
+		return false, false, nil
 	}
 
 	bnodeType := bnode.GetNodeType(data)
 
 	if bnodeType == bnode.NodeTypeLeaf {
 		leaf := bnode.NewLeafNode(data, false)
+		idx, found := leaf.Search(key1, key2)
+		if !found {
+			return false, false, nil
+		}
+		if err := tx.freeValueAt(leaf, idx); err != nil {
+			return false, false, err
+		}
+		leaf = bnode.NewLeafNode(tx.pageForWrite(pageID), false)
 		deleted := leaf.Delete(key1, key2)
-		return deleted, deleted && leaf.IsUnderflow()
+		return deleted, deleted && leaf.IsUnderflow(), nil
 	}
 
-	// Internal node - find child and recurse (use key1 for navigation)
+	// Internal node - find child and recurse
 	internal := bnode.NewInternalNode(data, false)
-	childIdx := internal.Search(key1)
-	childID := internal.GetChild(childIdx)
+	childIdx := internal.Search(key1, key2)
+	childID, err := tx.unshareChild(pageID, childIdx)
+	if err != nil {
+		return false, false, err
+	}
 
-	deleted, childUnderflow := t.deleteRecursive(childID, key1, key2)
-	if !deleted {
-		return false, false
+	deleted, childUnderflow, err := tx.deleteRecursive(childID, key1, key2)
+	if !deleted || err != nil {
+		return false, false, err
 	}
 
+	data = tx.pageForWrite(pageID)
+	internal = bnode.NewInternalNode(data, false)
+	tx.syncChild(internal, childIdx)
 	if !childUnderflow {
-		return true, false
+		return true, false, nil
 	}
 
 	// Handle child underflow
-	t.handleUnderflow(internal, childIdx, data)
+	if err := tx.handleUnderflow(internal, childIdx, pageID); err != nil {
+		return false, false, err
+	}
 
-	return true, internal.IsUnderflow()
+	return true, internal.IsUnderflow(), nil
 }
 
 // handleUnderflow handles an underflowing child by borrowing or merging.
-func (t *BPTree) handleUnderflow(parent *bnode.InternalNode, childIdx int, parentData []byte) {
-	childID := parent.GetChild(childIdx)
-	childData := t.pager.GetPage(childID)
+// parent must wrap the writable page of parentID; siblings are only copied
+// for writing once it is clear that they take part in the rebalancing.
+func (tx *Tx) handleUnderflow(parent *bnode.InternalNode, childIdx int, parentID bpager.PageID) error {
+	childID, err := tx.unshareChild(parentID, childIdx)
+	if err != nil {
+		return err
+	}
+	childData := tx.pageForWrite(childID)
 	childType := bnode.GetNodeType(childData)
 
 	// Try to borrow from left sibling
 	if childIdx > 0 {
 		leftSibID := parent.GetChild(childIdx - 1)
-		leftSibData := t.pager.GetPage(leftSibID)
+		leftSibData := tx.page(leftSibID)
 
 		if childType == bnode.NodeTypeLeaf {
 			leftSib := bnode.NewLeafNode(leftSibData, false)
 			if leftSib.CanLendTo() {
+				if leftSibID, err = tx.unshareChild(parentID, childIdx-1); err != nil {
+					return err
+				}
+				leftSib = bnode.NewLeafNode(tx.pageForWrite(leftSibID), false)
 				child := bnode.NewLeafNode(childData, false)
 				newSeparator := child.BorrowFromLeft(leftSib)
 				parent.SetKeyAt(childIdx-1, newSeparator)
-				return
+				tx.syncChild(parent, childIdx-1)
+				tx.syncChild(parent, childIdx)
+				return nil
 			}
 		} else {
 			leftSib := bnode.NewInternalNode(leftSibData, false)
 			if leftSib.CanLendTo() {
+				if leftSibID, err = tx.unshareChild(parentID, childIdx-1); err != nil {
+					return err
+				}
+				leftSib = bnode.NewInternalNode(tx.pageForWrite(leftSibID), false)
 				child := bnode.NewInternalNode(childData, false)
 				parentKey := parent.GetKeyAt(childIdx - 1)
 				newSeparator := child.BorrowFromLeft(leftSib, parentKey)
 				parent.SetKeyAt(childIdx-1, newSeparator)
-				return
+				tx.syncChild(parent, childIdx-1)
+				tx.syncChild(parent, childIdx)
+				return nil
 			}
 		}
 	}
@@ -419,32 +477,47 @@ func (t *BPTree) handleUnderflow(parent *bnode.InternalNode, childIdx int, paren
 	// Try to borrow from right sibling
 	if childIdx < parent.KeyCount() {
 		rightSibID := parent.GetChild(childIdx + 1)
-		rightSibData := t.pager.GetPage(rightSibID)
+		rightSibData := tx.page(rightSibID)
 
 		if childType == bnode.NodeTypeLeaf {
 			rightSib := bnode.NewLeafNode(rightSibData, false)
 			if rightSib.CanLendTo() {
+				if rightSibID, err = tx.unshareChild(parentID, childIdx+1); err != nil {
+					return err
+				}
+				rightSib = bnode.NewLeafNode(tx.pageForWrite(rightSibID), false)
 				child := bnode.NewLeafNode(childData, false)
 				newSeparator := child.BorrowFromRight(rightSib)
 				parent.SetKeyAt(childIdx, newSeparator)
-				return
+				tx.syncChild(parent, childIdx)
+				tx.syncChild(parent, childIdx+1)
+				return nil
 			}
 		} else {
 			rightSib := bnode.NewInternalNode(rightSibData, false)
 			if rightSib.CanLendTo() {
+				if rightSibID, err = tx.unshareChild(parentID, childIdx+1); err != nil {
+					return err
+				}
+				rightSib = bnode.NewInternalNode(tx.pageForWrite(rightSibID), false)
 				child := bnode.NewInternalNode(childData, false)
 				parentKey := parent.GetKeyAt(childIdx)
 				newSeparator := child.BorrowFromRight(rightSib, parentKey)
 				parent.SetKeyAt(childIdx, newSeparator)
-				return
+				tx.syncChild(parent, childIdx)
+				tx.syncChild(parent, childIdx+1)
+				return nil
 			}
 		}
 	}
 
 	// Must merge - prefer merging with left sibling
 	if childIdx > 0 {
-		leftSibID := parent.GetChild(childIdx - 1)
-		leftSibData := t.pager.GetPage(leftSibID)
+		leftSibID, err := tx.unshareChild(parentID, childIdx-1)
+		if err != nil {
+			return err
+		}
+		leftSibData := tx.pageForWrite(leftSibID)
 
 		if childType == bnode.NodeTypeLeaf {
 			leftSib := bnode.NewLeafNode(leftSibData, false)
@@ -459,88 +532,233 @@ func (t *BPTree) handleUnderflow(parent *bnode.InternalNode, childIdx int, paren
 
 		// Remove the separator and child pointer from parent
 		parent.DeleteKeyAt(childIdx - 1)
-		t.pager.FreePage(childID)
-	} else {
-		// Merge with right sibling
-		rightSibID := parent.GetChild(childIdx + 1)
-		rightSibData := t.pager.GetPage(rightSibID)
-
-		if childType == bnode.NodeTypeLeaf {
-			child := bnode.NewLeafNode(childData, false)
-			rightSib := bnode.NewLeafNode(rightSibData, false)
-			child.MergeWith(rightSib)
-		} else {
-			child := bnode.NewInternalNode(childData, false)
-			rightSib := bnode.NewInternalNode(rightSibData, false)
-			parentKey := parent.GetKeyAt(childIdx)
-			child.MergeWith(rightSib, parentKey)
-		}
+		tx.syncChild(parent, childIdx-1)
+		return tx.pages.FreePage(childID)
+	}
 
-		// Remove the separator and right child pointer from parent
-		parent.DeleteKeyAt(childIdx)
-		t.pager.FreePage(rightSibID)
+	// Merge with right sibling
+	rightSibID, err := tx.unshareChild(parentID, childIdx+1)
+	if err != nil {
+		return err
 	}
-}
+	rightSibData := tx.page(rightSibID)
 
-// scanInternal is the internal scan implementation without locking.
-// Caller must hold at least a read lock.
-func (t *BPTree) scanInternal(rootID RootID, start1, start2, end1, end2 uint64, fn func(key1, key2, value uint64) bool) error {
-	rootPageID := t.pager.GetRootPage(rootID)
-	if rootPageID == 0 {
-		return nil // Empty tree
+	if childType == bnode.NodeTypeLeaf {
+		child := bnode.NewLeafNode(childData, false)
+		rightSib := bnode.NewLeafNode(rightSibData, false)
+		child.MergeWith(rightSib)
+	} else {
+		child := bnode.NewInternalNode(childData, false)
+		rightSib := bnode.NewInternalNode(rightSibData, false)
+		parentKey := parent.GetKeyAt(childIdx)
+		child.MergeWith(rightSib, parentKey)
 	}
 
+	// Remove the separator and right child pointer from parent
+	parent.DeleteKeyAt(childIdx)
+	tx.syncChild(parent, childIdx)
+	return tx.pages.FreePage(rightSibID)
+}
+
+// scanInternal is the internal scan implementation. It takes the read lock
+// for each leaf it reads and calls fn without it (see Tx.locked).
+func (tx *Tx) scanInternal(rootID RootID, start1, start2, end1, end2 uint64, fn func(key1, key2, value uint64) bool) error {
 	// Find the leaf containing start key
-	leafID := t.findLeaf(rootPageID, start1)
-	if leafID == 0 {
-		return nil
-	}
+	w := leafWalk{tx: tx}
+	var leafID bpager.PageID
+	err := tx.locked(func() (err error) {
+		rootPageID := tx.rootPage(rootID)
+		if rootPageID == 0 {
+			return nil // Empty tree
+		}
+		if err := tx.checkKeyType(rootPageID, false); err != nil {
+			return err
+		}
+		leafID, err = w.seek(rootPageID, func(data []byte) int {
+			return bnode.NewInternalNode(data, false).Search(start1, start2)
+		})
+		return err
+	})
 
 	// Iterate through leaves
-	for leafID != 0 {
-		data := t.pager.GetPage(leafID)
-		if data == nil {
-			return fmt.Errorf("failed to get page %d", leafID)
-		}
+	for leafID != 0 && err == nil {
+		var pairs []bnode.KVPair
+		var last bool
+		err = tx.locked(func() error {
+			data := tx.page(leafID)
+			if data == nil {
+				return fmt.Errorf("failed to get page %d", leafID)
+			}
 
-		leaf := bnode.NewLeafNode(data, false)
-		pairs := leaf.Range(start1, start2, end1, end2)
+			leaf := bnode.NewLeafNode(data, false)
+			pairs = leaf.Range(start1, start2, end1, end2)
+
+			// Check if we've passed the end
+			if leaf.KeyCount() > 0 {
+				lastKey1 := leaf.GetKey1At(leaf.KeyCount() - 1)
+				lastKey2 := leaf.GetKey2At(leaf.KeyCount() - 1)
+				last = lastKey1 > end1 || (lastKey1 == end1 && lastKey2 >= end2)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
 
 		for _, pair := range pairs {
 			if !fn(pair.Key1, pair.Key2, pair.Value) {
 				return nil // User requested stop
 			}
 		}
+		if last {
+			break
+		}
 
-		// Check if we've passed the end
-		if leaf.KeyCount() > 0 {
-			lastKey1 := leaf.GetKey1At(leaf.KeyCount() - 1)
-			lastKey2 := leaf.GetKey2At(leaf.KeyCount() - 1)
-			if lastKey1 > end1 || (lastKey1 == end1 && lastKey2 >= end2) {
-				break
+		err = tx.locked(func() (err error) {
+			leafID, err = w.next(true)
+			return err
+		})
+	}
+
+	return err
+}
+
+// scanReverse walks the leaves of a range backwards, locking like scanInternal.
+func (tx *Tx) scanReverse(rootID RootID, start1, start2, end1, end2 uint64, fn func(key1, key2, value uint64) bool) error {
+	// The leaf found for the end key is the last one that can hold keys <= (end1, end2)
+	w := leafWalk{tx: tx}
+	var leafID bpager.PageID
+	err := tx.locked(func() (err error) {
+		rootPageID := tx.rootPage(rootID)
+		if rootPageID == 0 {
+			return nil // Empty tree
+		}
+		if err := tx.checkKeyType(rootPageID, false); err != nil {
+			return err
+		}
+		leafID, err = w.seek(rootPageID, func(data []byte) int {
+			return bnode.NewInternalNode(data, false).Search(end1, end2)
+		})
+		return err
+	})
+
+	// Iterate through leaves
+	for leafID != 0 && err == nil {
+		var pairs []bnode.KVPair
+		var first bool
+		err = tx.locked(func() error {
+			data := tx.page(leafID)
+			if data == nil {
+				return fmt.Errorf("failed to get page %d", leafID)
 			}
+
+			leaf := bnode.NewLeafNode(data, false)
+			pairs = leaf.Range(start1, start2, end1, end2)
+
+			// Check if we've passed the start
+			if leaf.KeyCount() > 0 {
+				firstKey1 := leaf.GetKey1At(0)
+				firstKey2 := leaf.GetKey2At(0)
+				first = firstKey1 < start1 || (firstKey1 == start1 && firstKey2 <= start2)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
 		}
 
-		leafID = leaf.NextLeaf()
+		for i := len(pairs) - 1; i >= 0; i-- {
+			if !fn(pairs[i].Key1, pairs[i].Key2, pairs[i].Value) {
+				return nil // User requested stop
+			}
+		}
+		if first {
+			break
+		}
+
+		err = tx.locked(func() (err error) {
+			leafID, err = w.next(false)
+			return err
+		})
 	}
 
-	return nil
+	return err
 }
 
-// findLeaf finds the leaf page that would contain the given key.
-func (t *BPTree) findLeaf(pageID bpager.PageID, key1 uint64) bpager.PageID {
-	data := t.pager.GetPage(pageID)
-	if data == nil {
-		return 0
+// leafWalk steps through the leaves of a tree of either key type by
+// climbing and descending its internal nodes. Leaves are not linked to their
+// neighbours, since a leaf shared by forked roots has different ones in each.
+type leafWalk struct {
+	tx   *Tx
+	path []cursorElem // internal nodes above the current leaf and the child taken
+}
+
+// seek descends from pageID to a leaf, taking the child that search picks
+// in each internal node, and returns the leaf.
+func (w *leafWalk) seek(pageID bpager.PageID, search func(data []byte) int) (bpager.PageID, error) {
+	for {
+		if len(w.path) >= maxDepth {
+			return 0, errTooDeep
+		}
+		data := w.tx.page(pageID)
+		if data == nil {
+			return 0, fmt.Errorf("failed to get page %d", pageID)
+		}
+		if nodeType := bnode.GetNodeType(data); nodeType == bnode.NodeTypeLeaf || nodeType == bnode.NodeTypeVarLeaf {
+			return pageID, nil
+		}
+
+		idx := search(data)
+		w.path = append(w.path, cursorElem{pageID: pageID, index: idx})
+		pageID = childAt(data, idx)
 	}
+}
 
-	nodeType := bnode.GetNodeType(data)
+// next returns the leaf after the current one, or before it if !forward.
+// Returns 0 past either end of the tree.
+func (w *leafWalk) next(forward bool) (bpager.PageID, error) {
+	for len(w.path) > 0 {
+		top := &w.path[len(w.path)-1]
+		data := w.tx.page(top.pageID)
+		if data == nil {
+			return 0, fmt.Errorf("failed to get page %d", top.pageID)
+		}
 
-	if nodeType == bnode.NodeTypeLeaf {
-		return pageID
+		if forward {
+			top.index++
+		} else {
+			top.index--
+		}
+		if top.index >= 0 && top.index <= int(bnode.GetKeyCount(data)) {
+			return w.seek(childAt(data, top.index), func(data []byte) int {
+				if forward {
+					return 0
+				}
+				return int(bnode.GetKeyCount(data))
+			})
+		}
+		w.path = w.path[:len(w.path)-1]
 	}
+	return 0, nil
+}
 
-	internal := bnode.NewInternalNode(data, false)
-	childID := internal.GetChildForKey(key1)
-	return t.findLeaf(childID, key1)
+// findLeaf finds the leaf page that would contain the given key.
+// The tree must hold uint64 keys, see checkKeyType.
+func (tx *Tx) findLeaf(pageID bpager.PageID, key1, key2 uint64) (bpager.PageID, error) {
+	for range maxDepth {
+		data := tx.page(pageID)
+		if data == nil {
+			return 0, fmt.Errorf("failed to get page %d", pageID)
+		}
+
+		switch nodeType := bnode.GetNodeType(data); nodeType {
+		case bnode.NodeTypeLeaf:
+			return pageID, nil
+		case bnode.NodeTypeInternal:
+			pageID = bnode.NewInternalNode(data, false).GetChildForKey(key1, key2)
+		default:
+			return 0, fmt.Errorf("invalid node type %d in page %d", nodeType, pageID)
+		}
+	}
+	return 0, errTooDeep
 }
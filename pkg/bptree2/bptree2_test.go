@@ -2,6 +2,8 @@ package bptree2_test
 
 import (
 	"bptree2"
+	"bptree2/bpager"
+	"bytes"
 	"math/rand"
 	"path/filepath"
 	"sync"
@@ -36,7 +38,10 @@ func TestBasicOperations(t *testing.T) {
 	}
 
 	// Find with exact composite key match (AND condition)
-	val, found := tree.Find(rootID, 10, 100)
+	val, found, err := tree.Find(rootID, 10, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
 	if !found {
 		t.Error("key (10, 100) should be found")
 	}
@@ -45,13 +50,19 @@ func TestBasicOperations(t *testing.T) {
 	}
 
 	// Find non-existent key pair - key1 exists but key2 doesn't
-	_, found = tree.Find(rootID, 10, 200)
+	_, found, err = tree.Find(rootID, 10, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
 	if found {
 		t.Error("key (10, 200) should not be found - AND condition")
 	}
 
 	// Find completely non-existent
-	_, found = tree.Find(rootID, 20, 200)
+	_, found, err = tree.Find(rootID, 20, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
 	if found {
 		t.Error("key (20, 200) should not be found")
 	}
@@ -60,7 +71,10 @@ func TestBasicOperations(t *testing.T) {
 	if err := tree.Insert(rootID, 10, 100, 2000); err != nil {
 		t.Fatalf("Insert failed: %v", err)
 	}
-	val, _ = tree.Find(rootID, 10, 100)
+	val, _, err = tree.Find(rootID, 10, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
 	if val != 2000 {
 		t.Errorf("expected 2000 after update, got %d", val)
 	}
@@ -88,7 +102,10 @@ func TestLargeInsert(t *testing.T) {
 
 	// Verify all keys
 	for i := 0; i < n; i++ {
-		val, found := tree.Find(rootID, uint64(i), uint64(i*2))
+		val, found, err := tree.Find(rootID, uint64(i), uint64(i*2))
+		if err != nil {
+			t.Fatal(err)
+		}
 		if !found {
 			t.Fatalf("key (%d, %d) should be found", i, i*2)
 		}
@@ -98,7 +115,10 @@ func TestLargeInsert(t *testing.T) {
 	}
 
 	// Verify count
-	count := tree.Count(rootID)
+	count, err := tree.Count(rootID)
+	if err != nil {
+		t.Fatal(err)
+	}
 	if count != n {
 		t.Errorf("expected count %d, got %d", n, count)
 	}
@@ -130,7 +150,10 @@ func TestMillionInsert(t *testing.T) {
 
 	// Verify some keys
 	for i := 0; i < n; i += 10000 {
-		val, found := tree.Find(rootID, uint64(i), uint64(i*2))
+		val, found, err := tree.Find(rootID, uint64(i), uint64(i*2))
+		if err != nil {
+			t.Fatal(err)
+		}
 		if !found {
 			t.Fatalf("key (%d, %d) should be found", i, i*2)
 		}
@@ -170,7 +193,10 @@ func TestRandomInsert(t *testing.T) {
 
 	// Verify
 	for i := 0; i < n; i++ {
-		val, found := tree.Find(rootID, keys1[i], keys2[i])
+		val, found, err := tree.Find(rootID, keys1[i], keys2[i])
+		if err != nil {
+			t.Fatal(err)
+		}
 		if !found {
 			t.Fatalf("key (%d, %d) should be found", keys1[i], keys2[i])
 		}
@@ -222,6 +248,94 @@ func TestRangeScan(t *testing.T) {
 	}
 }
 
+func TestFindRangeReverse(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer tree.Close()
+
+	rootID, _ := tree.CreateRoot()
+	for i := 1; i <= 10000; i++ {
+		if err := tree.Insert(rootID, uint64(i), uint64(i), uint64(i*10)); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+
+	// Range spanning many leaves, walked from the high end
+	var results []uint64
+	err = tree.FindRangeReverse(rootID, 300, 300, 5000, 5000, func(key1, key2, value uint64) bool {
+		results = append(results, key1)
+		return true
+	})
+	if err != nil {
+		t.Fatalf("FindRangeReverse failed: %v", err)
+	}
+	if len(results) != 4701 {
+		t.Errorf("expected 4701 results, got %d", len(results))
+	}
+	for i, r := range results {
+		if expected := uint64(5000 - i); r != expected {
+			t.Fatalf("result %d: expected %d, got %d", i, expected, r)
+		}
+	}
+
+	// Latest N entries
+	var latest []uint64
+	tree.FindRangeReverse(rootID, 0, 0, ^uint64(0), ^uint64(0), func(key1, key2, value uint64) bool {
+		latest = append(latest, key1)
+		return len(latest) < 3
+	})
+	if len(latest) != 3 || latest[0] != 10000 || latest[2] != 9998 {
+		t.Errorf("expected [10000 9999 9998], got %v", latest)
+	}
+}
+
+func TestLeafChainAfterDeletes(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer tree.Close()
+
+	rootID, _ := tree.CreateRoot()
+	rng := rand.New(rand.NewSource(7))
+	keys := rng.Perm(20000)
+	for _, k := range keys {
+		tree.Insert(rootID, uint64(k), 0, uint64(k))
+	}
+
+	// Random deletes exercise borrowing and merging in both directions
+	for _, k := range keys[:15000] {
+		tree.Delete(rootID, uint64(k), 0)
+	}
+
+	var forward, backward []uint64
+	tree.FindRange(rootID, 0, 0, ^uint64(0), ^uint64(0), func(key1, key2, value uint64) bool {
+		forward = append(forward, key1)
+		return true
+	})
+	tree.FindRangeReverse(rootID, 0, 0, ^uint64(0), ^uint64(0), func(key1, key2, value uint64) bool {
+		backward = append(backward, key1)
+		return true
+	})
+
+	if len(forward) != 5000 || len(backward) != 5000 {
+		t.Fatalf("expected 5000 entries both ways, got %d and %d", len(forward), len(backward))
+	}
+	for i := range forward {
+		if forward[i] != backward[len(backward)-1-i] {
+			t.Fatalf("leaf chains disagree at %d: %d vs %d", i, forward[i], backward[len(backward)-1-i])
+		}
+	}
+}
+
 func TestFindRangeEarlyStop(t *testing.T) {
 	tmpDir := t.TempDir()
 	path := filepath.Join(tmpDir, "test.db")
@@ -275,7 +389,10 @@ func TestPersistence(t *testing.T) {
 
 	// Use same rootID (0)
 	for i := 0; i < 1000; i++ {
-		val, found := tree2.Find(rootID, uint64(i), uint64(i*2))
+		val, found, err := tree2.Find(rootID, uint64(i), uint64(i*2))
+		if err != nil {
+			t.Fatal(err)
+		}
 		if !found {
 			t.Fatalf("key (%d, %d) should be found after reopen", i, i*2)
 		}
@@ -285,6 +402,79 @@ func TestPersistence(t *testing.T) {
 	}
 }
 
+func TestCrashBetweenFlashes(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree1, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer tree1.Close()
+
+	rootID, _ := tree1.CreateRoot()
+	for i := 0; i < 1000; i++ {
+		tree1.Insert(rootID, uint64(i), uint64(i), uint64(i))
+	}
+	tree1.Flash()
+
+	// Splits and merges that are never flashed
+	for i := 1000; i < 5000; i++ {
+		tree1.Insert(rootID, uint64(i), uint64(i), uint64(i))
+	}
+	for i := 0; i < 500; i++ {
+		tree1.Delete(rootID, uint64(i), uint64(i))
+	}
+
+	// Opening the file now is what a restart after a crash would see
+	tree2, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	defer tree2.Close()
+
+	if count, err := tree2.Count(rootID); err != nil || count != 1000 {
+		t.Errorf("expected the flashed 1000 entries, got %d", count)
+	}
+	for i := 0; i < 1000; i++ {
+		if val, found, err := tree2.Find(rootID, uint64(i), uint64(i)); err != nil || !found || val != uint64(i) {
+			t.Fatalf("key (%d, %d) should be found after reopen", i, i)
+		}
+	}
+}
+
+func TestCommitFlashesDirtyPages(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree1, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer tree1.Close()
+
+	// A commit of more pages than are kept waiting flashes them
+	rootID, _ := tree1.CreateRoot()
+	tree1.Insert(rootID, 1, 1, 1)
+	big := bytes.Repeat([]byte("x"), 16384*bpager.OverflowCapacity)
+	if err := tree1.InsertBlob(rootID, 2, 2, big); err != nil {
+		t.Fatalf("InsertBlob failed: %v", err)
+	}
+
+	tree2, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	defer tree2.Close()
+
+	if val, found, err := tree2.Find(rootID, 1, 1); err != nil || !found || val != 1 {
+		t.Errorf("earlier commit: got %d, %v, %v", val, found, err)
+	}
+	if data, found, err := tree2.FindBlob(rootID, 2, 2); err != nil || !found || !bytes.Equal(data, big) {
+		t.Errorf("blob: got %d bytes, %v, %v", len(data), found, err)
+	}
+}
+
 func TestDelete(t *testing.T) {
 	tmpDir := t.TempDir()
 	path := filepath.Join(tmpDir, "test.db")
@@ -303,25 +493,34 @@ func TestDelete(t *testing.T) {
 	}
 
 	// Delete some keys
-	if !tree.Delete(rootID, 5, 10) {
+	if deleted, err := tree.Delete(rootID, 5, 10); err != nil || !deleted {
 		t.Error("Delete(5, 10) should return true")
 	}
-	if tree.Delete(rootID, 5, 10) {
+	if deleted, err := tree.Delete(rootID, 5, 10); err != nil || deleted {
 		t.Error("Delete(5, 10) second time should return false")
 	}
 
 	// Verify
-	_, found := tree.Find(rootID, 5, 10)
+	_, found, err := tree.Find(rootID, 5, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
 	if found {
 		t.Error("key (5, 10) should not be found after delete")
 	}
 
 	// Other keys should still exist
-	val, found := tree.Find(rootID, 4, 8)
+	val, found, err := tree.Find(rootID, 4, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
 	if !found || val != 40 {
 		t.Error("key (4, 8) should still exist")
 	}
-	val, found = tree.Find(rootID, 6, 12)
+	val, found, err = tree.Find(rootID, 6, 12)
+	if err != nil {
+		t.Fatal(err)
+	}
 	if !found || val != 60 {
 		t.Error("key (6, 12) should still exist")
 	}
@@ -347,19 +546,22 @@ func TestLargeDelete(t *testing.T) {
 
 	// Delete all keys
 	for i := 0; i < n; i++ {
-		if !tree.Delete(rootID, uint64(i), uint64(i*2)) {
+		if deleted, err := tree.Delete(rootID, uint64(i), uint64(i*2)); err != nil || !deleted {
 			t.Fatalf("Delete(%d, %d) should return true", i, i*2)
 		}
 	}
 
 	// Verify tree is empty
-	if tree.Count(rootID) != 0 {
-		t.Errorf("expected empty tree, got count %d", tree.Count(rootID))
+	if count, err := tree.Count(rootID); err != nil || count != 0 {
+		t.Errorf("expected empty tree, got count %d (%v)", count, err)
 	}
 
 	// Verify no keys found
 	for i := 0; i < n; i++ {
-		_, found := tree.Find(rootID, uint64(i), uint64(i*2))
+		_, found, err := tree.Find(rootID, uint64(i), uint64(i*2))
+		if err != nil {
+			t.Fatal(err)
+		}
 		if found {
 			t.Fatalf("key (%d, %d) should not be found after delete", i, i*2)
 		}
@@ -396,7 +598,10 @@ func TestDeleteWithPageReuse(t *testing.T) {
 
 	// Verify remaining keys
 	for i := n / 2; i < n+n/2; i++ {
-		val, found := tree.Find(rootID, uint64(i), uint64(i*2))
+		val, found, err := tree.Find(rootID, uint64(i), uint64(i*2))
+		if err != nil {
+			t.Fatal(err)
+		}
 		if !found {
 			t.Fatalf("key (%d, %d) should be found", i, i*2)
 		}
@@ -426,14 +631,14 @@ func TestDeleteReverseOrder(t *testing.T) {
 
 	// Delete in reverse order
 	for i := n - 1; i >= 0; i-- {
-		if !tree.Delete(rootID, uint64(i), uint64(i*2)) {
+		if deleted, err := tree.Delete(rootID, uint64(i), uint64(i*2)); err != nil || !deleted {
 			t.Fatalf("Delete(%d, %d) should return true", i, i*2)
 		}
 	}
 
 	// Verify tree is empty
-	if tree.Count(rootID) != 0 {
-		t.Errorf("expected empty tree, got count %d", tree.Count(rootID))
+	if count, err := tree.Count(rootID); err != nil || count != 0 {
+		t.Errorf("expected empty tree, got count %d (%v)", count, err)
 	}
 }
 
@@ -467,7 +672,11 @@ func TestConcurrentReads(t *testing.T) {
 			for i := 0; i < readsPerReader; i++ {
 				key1 := uint64(rand.Intn(n))
 				key2 := key1 * 2
-				val, found := tree.Find(rootID, key1, key2)
+				val, found, err := tree.Find(rootID, key1, key2)
+				if err != nil {
+					t.Errorf("Find failed: %v", err)
+					return
+				}
 				if !found {
 					t.Errorf("key (%d, %d) should be found", key1, key2)
 					return
@@ -496,13 +705,16 @@ func TestEmptyTree(t *testing.T) {
 	rootID, _ := tree.CreateRoot()
 
 	// Find from empty tree
-	_, found := tree.Find(rootID, 1, 2)
+	_, found, err := tree.Find(rootID, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
 	if found {
 		t.Error("empty tree should not find anything")
 	}
 
 	// Delete from empty tree
-	if tree.Delete(rootID, 1, 2) {
+	if deleted, err := tree.Delete(rootID, 1, 2); err != nil || deleted {
 		t.Error("delete from empty tree should return false")
 	}
 
@@ -517,8 +729,8 @@ func TestEmptyTree(t *testing.T) {
 	}
 
 	// Count empty tree
-	if tree.Count(rootID) != 0 {
-		t.Error("empty tree count should be 0")
+	if count, err := tree.Count(rootID); err != nil || count != 0 {
+		t.Errorf("empty tree count should be 0, got %d (%v)", count, err)
 	}
 }
 
@@ -546,9 +758,18 @@ func TestMultipleRoots(t *testing.T) {
 
 	// Verify each root has independent data
 	for i := 0; i < 100; i++ {
-		val1, _ := tree.Find(root1, uint64(i), uint64(i*2))
-		val2, _ := tree.Find(root2, uint64(i), uint64(i*2))
-		val3, _ := tree.Find(root3, uint64(i), uint64(i*2))
+		val1, _, err := tree.Find(root1, uint64(i), uint64(i*2))
+		if err != nil {
+			t.Fatal(err)
+		}
+		val2, _, err := tree.Find(root2, uint64(i), uint64(i*2))
+		if err != nil {
+			t.Fatal(err)
+		}
+		val3, _, err := tree.Find(root3, uint64(i), uint64(i*2))
+		if err != nil {
+			t.Fatal(err)
+		}
 
 		if val1 != uint64(i*10) {
 			t.Errorf("root1 key (%d, %d): expected %d, got %d", i, i*2, i*10, val1)
@@ -562,20 +783,24 @@ func TestMultipleRoots(t *testing.T) {
 	}
 
 	// Verify counts are independent
-	if tree.Count(root1) != 100 || tree.Count(root2) != 100 || tree.Count(root3) != 100 {
-		t.Error("each root should have 100 entries")
+	for _, rootID := range []bptree2.RootID{root1, root2, root3} {
+		if count, err := tree.Count(rootID); err != nil || count != 100 {
+			t.Errorf("root %d should have 100 entries, got %d (%v)", rootID, count, err)
+		}
 	}
 
 	// Delete from one root doesn't affect others
 	for i := 0; i < 50; i++ {
-		tree.Delete(root1, uint64(i), uint64(i*2))
+		if _, err := tree.Delete(root1, uint64(i), uint64(i*2)); err != nil {
+			t.Fatal(err)
+		}
 	}
 
-	if tree.Count(root1) != 50 {
-		t.Errorf("root1 should have 50 entries after delete, got %d", tree.Count(root1))
+	if count, err := tree.Count(root1); err != nil || count != 50 {
+		t.Errorf("root1 should have 50 entries after delete, got %d (%v)", count, err)
 	}
-	if tree.Count(root2) != 100 {
-		t.Errorf("root2 should still have 100 entries, got %d", tree.Count(root2))
+	if count, err := tree.Count(root2); err != nil || count != 100 {
+		t.Errorf("root2 should still have 100 entries, got %d (%v)", count, err)
 	}
 }
 
@@ -600,8 +825,14 @@ func TestMultipleRootsPersistence(t *testing.T) {
 	defer tree2.Close()
 
 	for i := 0; i < 100; i++ {
-		val1, found1 := tree2.Find(root1, uint64(i), uint64(i*2))
-		val2, found2 := tree2.Find(root2, uint64(i), uint64(i*2))
+		val1, found1, err := tree2.Find(root1, uint64(i), uint64(i*2))
+		if err != nil {
+			t.Fatal(err)
+		}
+		val2, found2, err := tree2.Find(root2, uint64(i), uint64(i*2))
+		if err != nil {
+			t.Fatal(err)
+		}
 
 		if !found1 || val1 != uint64(i*10) {
 			t.Errorf("root1 key (%d, %d): expected %d, got %d (found=%v)", i, i*2, i*10, val1, found1)
@@ -634,29 +865,146 @@ func TestAndCondition(t *testing.T) {
 	tree.Insert(rootID, 200, 1, 2001)
 
 	// Test AND condition - must match both key1 AND key2
-	val, found := tree.Find(rootID, 100, 1)
+	val, found, err := tree.Find(rootID, 100, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
 	if !found || val != 1001 {
 		t.Errorf("expected 1001, got %d (found=%v)", val, found)
 	}
 
-	val, found = tree.Find(rootID, 100, 2)
+	val, found, err = tree.Find(rootID, 100, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
 	if !found || val != 1002 {
 		t.Errorf("expected 1002, got %d (found=%v)", val, found)
 	}
 
 	// Key1 matches but key2 doesn't
-	_, found = tree.Find(rootID, 100, 99)
+	_, found, err = tree.Find(rootID, 100, 99)
+	if err != nil {
+		t.Fatal(err)
+	}
 	if found {
 		t.Error("should not find (100, 99) - AND condition not satisfied")
 	}
 
 	// Key2 matches but key1 doesn't
-	_, found = tree.Find(rootID, 999, 1)
+	_, found, err = tree.Find(rootID, 999, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
 	if found {
 		t.Error("should not find (999, 1) - AND condition not satisfied")
 	}
 }
 
+func TestSingleKey1SpansLeaves(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer tree.Close()
+
+	rootID, _ := tree.CreateRoot()
+
+	// One key1 with enough key2 values to fill thousands of leaves,
+	// between neighbours on either side
+	const key1 = 7
+	n := 300000
+	tree.Insert(rootID, key1-1, ^uint64(0), 1)
+	tree.Insert(rootID, key1+1, 0, 2)
+	rng := rand.New(rand.NewSource(1))
+	for _, i := range rng.Perm(n) {
+		if err := tree.Insert(rootID, key1, uint64(i*2), uint64(i)); err != nil {
+			t.Fatalf("Insert failed at %d: %v", i, err)
+		}
+	}
+
+	if count, err := tree.Count(rootID); err != nil || count != n+2 {
+		t.Fatalf("expected %d entries, got %d", n+2, count)
+	}
+	for i := 0; i < n; i++ {
+		if val, found, err := tree.Find(rootID, key1, uint64(i*2)); err != nil || !found || val != uint64(i) {
+			t.Fatalf("key (%d, %d): expected %d, got %d (found=%v)", key1, i*2, i, val, found)
+		}
+		if _, found, err := tree.Find(rootID, key1, uint64(i*2+1)); err != nil || found {
+			t.Fatalf("key (%d, %d) should not be found", key1, i*2+1)
+		}
+	}
+
+	// Ranges within the key1 start and end in the middle of its leaves
+	expected := uint64(100000)
+	tree.FindRange(rootID, key1, 200000, key1, 399999, func(k1, k2, value uint64) bool {
+		if k1 != key1 || value != expected {
+			t.Fatalf("expected (%d, %d)=%d, got (%d, %d)=%d", key1, expected*2, expected, k1, k2, value)
+		}
+		expected++
+		return true
+	})
+	if expected != 200000 {
+		t.Errorf("forward range ended at %d, expected 200000", expected)
+	}
+	tree.FindRangeReverse(rootID, key1, 200000, key1, 399999, func(k1, k2, value uint64) bool {
+		expected--
+		if value != expected {
+			t.Fatalf("expected %d in reverse, got (%d, %d)=%d", expected, k1, k2, value)
+		}
+		return true
+	})
+	if expected != 100000 {
+		t.Errorf("reverse range ended at %d, expected 100000", expected)
+	}
+
+	cursor, err := tree.Cursor(rootID)
+	if err != nil {
+		t.Fatalf("Cursor failed: %v", err)
+	}
+	if !cursor.Seek(key1, 123457) {
+		t.Fatal("Seek failed")
+	}
+	if k1, k2 := cursor.Key(); k1 != key1 || k2 != 123458 {
+		t.Errorf("Seek: expected (%d, 123458), got (%d, %d)", key1, k1, k2)
+	}
+	cursor.Close()
+
+	// Deletes rebalance leaves whose separators share the key1
+	for i := 0; i < n; i += 2 {
+		if deleted, err := tree.Delete(rootID, key1, uint64(i*2)); err != nil || !deleted {
+			t.Fatalf("Delete of (%d, %d) failed", key1, i*2)
+		}
+	}
+	batch := &bptree2.Batch{}
+	for i := 1; i < n; i += 4 {
+		batch.Delete(key1, uint64(i*2))
+	}
+	if _, err := tree.ApplyBatch(rootID, batch); err != nil {
+		t.Fatalf("ApplyBatch failed: %v", err)
+	}
+	for i := 0; i < n; i++ {
+		_, found, err := tree.Find(rootID, key1, uint64(i*2))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found != (i%4 == 3) {
+			t.Fatalf("key (%d, %d): found=%v after deletes", key1, i*2, found)
+		}
+	}
+	if count, err := tree.Count(rootID); err != nil || count != n/4+2 {
+		t.Errorf("expected %d entries after deletes, got %d", n/4+2, count)
+	}
+	if _, found, err := tree.Find(rootID, key1-1, ^uint64(0)); err != nil || !found {
+		t.Error("left neighbour lost")
+	}
+	if _, found, err := tree.Find(rootID, key1+1, 0); err != nil || !found {
+		t.Error("right neighbour lost")
+	}
+}
+
 func BenchmarkInsert(b *testing.B) {
 	tmpDir := b.TempDir()
 	path := filepath.Join(tmpDir, "bench.db")
@@ -0,0 +1,279 @@
+package bptree2
+
+import (
+	"errors"
+	"fmt"
+
+	"bptree2/bnode"
+)
+
+var (
+	// ErrNotBucket is returned when a key holds a value rather than a bucket.
+	ErrNotBucket = errors.New("key does not hold a bucket")
+
+	// ErrBucketDeleted is returned by the methods of a Bucket whose entry no
+	// longer holds it.
+	ErrBucketDeleted = errors.New("bucket has been deleted")
+)
+
+// Bucket is a view of a tree nested in an entry of another tree.
+//
+// A bucket is a root tree of its own whose root ID is the value of its
+// entry in the parent, marked with bnode.FlagBucket. Like any root it has
+// uint64 keys and can hold buckets in turn. Deleting or overwriting the
+// entry, or deleting or clearing the parent, deletes the bucket and
+// everything nested in it; their pages are reclaimed as for DeleteRoot.
+// Buckets nested deeper only leave RootCount once the reclaimer reaches them.
+//
+// A Bucket from BPTree.Bucket runs each operation in its own transaction;
+// one from Tx.Bucket runs them in that transaction. Each operation first
+// checks that the entry of the bucket, and those of the buckets it is nested
+// in, still hold its root ID, and returns ErrBucketDeleted once one has been
+// deleted or overwritten. A new bucket stored under the key that gets the
+// same root ID back passes the check; the Bucket then refers to it.
+type Bucket struct {
+	tree   *BPTree
+	tx     *Tx
+	parent *Bucket // bucket holding the entry, nil for a root tree
+
+	// parentID, key1 and key2 locate the entry; rootID is its value.
+	parentID   RootID
+	key1, key2 uint64
+	rootID     RootID
+}
+
+// Bucket returns the bucket stored under (key1, key2) in a root tree.
+// Returns ErrKeyNotFound if the key does not exist and ErrNotBucket if it
+// holds a value.
+func (t *BPTree) Bucket(rootID RootID, key1, key2 uint64) (*Bucket, error) {
+	var childID RootID
+	err := t.view(func(tx *Tx) (err error) {
+		childID, err = tx.bucketRoot(rootID, key1, key2)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Bucket{tree: t, parentID: rootID, key1: key1, key2: key2, rootID: childID}, nil
+}
+
+// CreateBucket returns the bucket stored under (key1, key2) in a root tree,
+// creating it if the key does not exist. See Tx.CreateBucket.
+func (t *BPTree) CreateBucket(rootID RootID, key1, key2 uint64) (*Bucket, error) {
+	var childID RootID
+	err := t.update(func(tx *Tx) error {
+		b, err := tx.CreateBucket(rootID, key1, key2)
+		if err == nil {
+			childID = b.rootID
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Bucket{tree: t, parentID: rootID, key1: key1, key2: key2, rootID: childID}, nil
+}
+
+// Bucket returns the bucket stored under (key1, key2) in a root tree,
+// operating within the transaction.
+// Returns ErrKeyNotFound if the key does not exist and ErrNotBucket if it
+// holds a value.
+func (tx *Tx) Bucket(rootID RootID, key1, key2 uint64) (_ *Bucket, err error) {
+	if err := tx.acquire(); err != nil {
+		return nil, err
+	}
+	defer tx.release()
+	defer recoverCorrupt(&err)
+
+	childID, err := tx.bucketRoot(rootID, key1, key2)
+	if err != nil {
+		return nil, err
+	}
+	return &Bucket{tx: tx, parentID: rootID, key1: key1, key2: key2, rootID: childID}, nil
+}
+
+// CreateBucket returns the bucket stored under (key1, key2) in a root tree,
+// operating within the transaction. If the key does not exist, a new root
+// is created and stored under it. Returns ErrNotBucket if the key holds a value.
+// If CreateBucket fails the transaction should be rolled back.
+func (tx *Tx) CreateBucket(rootID RootID, key1, key2 uint64) (_ *Bucket, err error) {
+	defer recoverCorrupt(&err)
+	if err := tx.checkWritable(); err != nil {
+		return nil, err
+	}
+
+	childID, err := tx.bucketRoot(rootID, key1, key2)
+	if err == nil {
+		return &Bucket{tx: tx, parentID: rootID, key1: key1, key2: key2, rootID: childID}, nil
+	}
+	if !errors.Is(err, ErrKeyNotFound) {
+		return nil, err
+	}
+
+	childID, err = tx.pages.CreateRoot()
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.put(rootID, key1, key2, childID, bnode.FlagBucket); err != nil {
+		return nil, err
+	}
+	return &Bucket{tx: tx, parentID: rootID, key1: key1, key2: key2, rootID: childID}, nil
+}
+
+// bucketRoot returns the root ID of the bucket stored under (key1, key2).
+func (tx *Tx) bucketRoot(rootID RootID, key1, key2 uint64) (RootID, error) {
+	rootPageID := tx.rootPage(rootID)
+	if rootPageID != 0 {
+		if err := tx.checkKeyType(rootPageID, false); err != nil {
+			return 0, err
+		}
+		leafID, err := tx.findLeaf(rootPageID, key1, key2)
+		if err != nil {
+			return 0, err
+		}
+		leaf := bnode.NewLeafNode(tx.page(leafID), false)
+		if idx, found := leaf.Search(key1, key2); found {
+			if leaf.GetFlagsAt(idx)&bnode.FlagBucket == 0 {
+				return 0, fmt.Errorf("%w: (%d,%d)", ErrNotBucket, key1, key2)
+			}
+			return leaf.GetValueAt(idx), nil
+		}
+	}
+	return 0, fmt.Errorf("%w: (%d,%d)", ErrKeyNotFound, key1, key2)
+}
+
+// check returns ErrBucketDeleted unless the entry of the bucket, and those
+// of the buckets it is nested in, still hold it.
+func (b *Bucket) check(tx *Tx) error {
+	if b.parent != nil {
+		if err := b.parent.check(tx); err != nil {
+			return err
+		}
+	}
+	childID, err := tx.bucketRoot(b.parentID, b.key1, b.key2)
+	switch {
+	case err == nil && childID == b.rootID:
+		return nil
+	case err == nil, errors.Is(err, ErrKeyNotFound), errors.Is(err, ErrNotBucket), errors.Is(err, ErrKeyType):
+		return fmt.Errorf("%w: (%d,%d)", ErrBucketDeleted, b.key1, b.key2)
+	}
+	return err
+}
+
+// view runs fn in the transaction of the bucket, or in a read-only one,
+// once the bucket has been checked.
+func (b *Bucket) view(fn func(tx *Tx) error) error {
+	if b.tx != nil {
+		return b.checked(b.tx, fn)
+	}
+	return b.tree.view(func(tx *Tx) error {
+		return b.checked(tx, fn)
+	})
+}
+
+// update runs fn in the transaction of the bucket, or in a writable one,
+// once the bucket has been checked.
+func (b *Bucket) update(fn func(tx *Tx) error) error {
+	if b.tx != nil {
+		return b.checked(b.tx, fn)
+	}
+	return b.tree.update(func(tx *Tx) error {
+		return b.checked(tx, fn)
+	})
+}
+
+// checked runs fn in tx if the bucket passes check.
+func (b *Bucket) checked(tx *Tx, fn func(tx *Tx) error) error {
+	if err := tx.locked(func() error { return b.check(tx) }); err != nil {
+		return err
+	}
+	return fn(tx)
+}
+
+// RootID returns the root ID of the bucket's tree, which can be passed to
+// any method that takes one. Those methods do not check the bucket.
+func (b *Bucket) RootID() RootID {
+	return b.rootID
+}
+
+// Find retrieves a value by composite key (key1, key2).
+// Returns (value, true) if found, (0, false) otherwise.
+func (b *Bucket) Find(key1, key2 uint64) (value uint64, found bool, err error) {
+	err = b.view(func(tx *Tx) (err error) {
+		value, found, err = tx.Find(b.rootID, key1, key2)
+		return err
+	})
+	return value, found, err
+}
+
+// Insert inserts or updates a key-value pair.
+func (b *Bucket) Insert(key1, key2, value uint64) error {
+	return b.update(func(tx *Tx) error {
+		return tx.Insert(b.rootID, key1, key2, value)
+	})
+}
+
+// Delete removes a key. Returns true if the key was found and removed.
+func (b *Bucket) Delete(key1, key2 uint64) (deleted bool, err error) {
+	err = b.update(func(tx *Tx) (err error) {
+		deleted, err = tx.Delete(b.rootID, key1, key2)
+		return err
+	})
+	return deleted, err
+}
+
+// FindRange iterates over all key-value pairs where (start1,start2) <= (key1,key2) <= (end1,end2).
+// Return false from fn to stop iteration.
+func (b *Bucket) FindRange(start1, start2, end1, end2 uint64, fn func(key1, key2, value uint64) bool) error {
+	return b.view(func(tx *Tx) error {
+		return tx.FindRange(b.rootID, start1, start2, end1, end2, fn)
+	})
+}
+
+// FindRangeReverse is like FindRange, but iterates from the end of the range.
+func (b *Bucket) FindRangeReverse(start1, start2, end1, end2 uint64, fn func(key1, key2, value uint64) bool) error {
+	return b.view(func(tx *Tx) error {
+		return tx.FindRangeReverse(b.rootID, start1, start2, end1, end2, fn)
+	})
+}
+
+// Count returns the number of keys in the bucket, nested buckets counting as one.
+func (b *Bucket) Count() (count int, err error) {
+	err = b.view(func(tx *Tx) (err error) {
+		count, err = tx.Count(b.rootID)
+		return err
+	})
+	return count, err
+}
+
+// Bucket returns the bucket stored under (key1, key2) in this bucket.
+func (b *Bucket) Bucket(key1, key2 uint64) (*Bucket, error) {
+	child := &Bucket{tree: b.tree, tx: b.tx, parent: b, parentID: b.rootID, key1: key1, key2: key2}
+	err := b.view(func(tx *Tx) error {
+		return tx.locked(func() (err error) {
+			child.rootID, err = tx.bucketRoot(b.rootID, key1, key2)
+			return err
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return child, nil
+}
+
+// CreateBucket returns the bucket stored under (key1, key2) in this bucket,
+// creating it if the key does not exist.
+func (b *Bucket) CreateBucket(key1, key2 uint64) (*Bucket, error) {
+	child := &Bucket{tree: b.tree, tx: b.tx, parent: b, parentID: b.rootID, key1: key1, key2: key2}
+	err := b.update(func(tx *Tx) error {
+		created, err := tx.CreateBucket(b.rootID, key1, key2)
+		if err == nil {
+			child.rootID = created.rootID
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return child, nil
+}
@@ -0,0 +1,250 @@
+package bptree2_test
+
+import (
+	"bptree2"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBucket(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	// tenant → table → index
+	rootID, _ := tree.CreateRoot()
+	tenant, err := tree.CreateBucket(rootID, 1, 0)
+	if err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	table, err := tenant.CreateBucket(7, 0)
+	if err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	index, err := table.CreateBucket(7, 1)
+	if err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	for i := uint64(0); i < 5000; i++ {
+		table.Insert(i, 2, i*10)
+		index.Insert(i*10, i, i)
+	}
+	tree.Insert(rootID, 2, 0, 42)
+
+	// Existing buckets are returned as they are
+	again, err := tree.CreateBucket(rootID, 1, 0)
+	if err != nil || again.RootID() != tenant.RootID() {
+		t.Errorf("CreateBucket of an existing bucket: got %v, %v", again, err)
+	}
+	if _, err := tree.Bucket(rootID, 3, 0); !errors.Is(err, bptree2.ErrKeyNotFound) {
+		t.Errorf("missing bucket: expected ErrKeyNotFound, got %v", err)
+	}
+	if _, err := tree.Bucket(rootID, 2, 0); !errors.Is(err, bptree2.ErrNotBucket) {
+		t.Errorf("plain value: expected ErrNotBucket, got %v", err)
+	}
+	if _, err := tree.CreateBucket(rootID, 2, 0); !errors.Is(err, bptree2.ErrNotBucket) {
+		t.Errorf("CreateBucket over a plain value: expected ErrNotBucket, got %v", err)
+	}
+	tree.Close()
+
+	tree, err = bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer tree.Close()
+
+	tenant, err = tree.Bucket(rootID, 1, 0)
+	if err != nil {
+		t.Fatalf("Bucket failed: %v", err)
+	}
+	table, _ = tenant.Bucket(7, 0)
+	index, _ = table.Bucket(7, 1)
+	if count, err := table.Count(); err != nil || count != 5001 {
+		t.Errorf("table: expected 5001 keys, got %d", count)
+	}
+	if val, found, err := table.Find(123, 2); err != nil || !found || val != 1230 {
+		t.Errorf("table: got %d, %v", val, found)
+	}
+	var sum uint64
+	index.FindRange(0, 0, 100, ^uint64(0), func(key1, key2, value uint64) bool {
+		sum += value
+		return true
+	})
+	if sum != 55 {
+		t.Errorf("index: expected the values 0..10 to sum to 55, got %d", sum)
+	}
+
+	// Buckets from a transaction see its changes
+	tx, _ := tree.Begin(true)
+	b, err := tx.Bucket(rootID, 1, 0)
+	if err != nil {
+		t.Fatalf("Bucket failed: %v", err)
+	}
+	child, err := b.CreateBucket(8, 0)
+	if err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	child.Insert(1, 1, 11)
+	if val, _, err := child.Find(1, 1); err != nil || val != 11 {
+		t.Errorf("expected 11 in the transaction, got %d", val)
+	}
+	tx.Rollback()
+	if _, err := tenant.Bucket(8, 0); !errors.Is(err, bptree2.ErrKeyNotFound) {
+		t.Errorf("rolled back bucket: expected ErrKeyNotFound, got %v", err)
+	}
+}
+
+func TestBucketDeleted(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer tree.Close()
+
+	rootID, _ := tree.CreateRoot()
+	tenant, err := tree.CreateBucket(rootID, 1, 0)
+	if err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	table, err := tenant.CreateBucket(7, 0)
+	if err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	table.Insert(1, 1, 11)
+
+	// Overwriting the entry of a bucket ends it and the buckets nested in it
+	tree.Insert(rootID, 1, 0, 42)
+	for name, b := range map[string]*bptree2.Bucket{"tenant": tenant, "table": table} {
+		if _, _, err := b.Find(1, 1); !errors.Is(err, bptree2.ErrBucketDeleted) {
+			t.Errorf("%s: Find: expected ErrBucketDeleted, got %v", name, err)
+		}
+		if err := b.Insert(1, 1, 12); !errors.Is(err, bptree2.ErrBucketDeleted) {
+			t.Errorf("%s: Insert: expected ErrBucketDeleted, got %v", name, err)
+		}
+		if _, err := b.Count(); !errors.Is(err, bptree2.ErrBucketDeleted) {
+			t.Errorf("%s: Count: expected ErrBucketDeleted, got %v", name, err)
+		}
+	}
+	if _, err := tenant.CreateBucket(7, 0); !errors.Is(err, bptree2.ErrBucketDeleted) {
+		t.Errorf("CreateBucket: expected ErrBucketDeleted, got %v", err)
+	}
+
+	// Roots that reuse the IDs of the buckets do not receive their writes
+	if err := tree.Reclaim(); err != nil {
+		t.Fatalf("Reclaim failed: %v", err)
+	}
+	tree.Delete(rootID, 1, 0)
+	recreated, err := tree.CreateBucket(rootID, 1, 0)
+	if err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	plainID, _ := tree.CreateRoot()
+	for name, b := range map[string]*bptree2.Bucket{"tenant": tenant, "table": table} {
+		if err := b.Insert(5, 5, 5); !errors.Is(err, bptree2.ErrBucketDeleted) {
+			t.Errorf("%s: Insert after reuse: expected ErrBucketDeleted, got %v", name, err)
+		}
+	}
+	for _, id := range []bptree2.RootID{recreated.RootID(), plainID} {
+		if count, err := tree.Count(id); err != nil || count != 0 {
+			t.Errorf("root %d should be empty, got %d keys (%v)", id, count, err)
+		}
+	}
+
+	// Within a transaction the bucket ends with the deletion of its entry
+	tx, _ := tree.Begin(true)
+	defer tx.Rollback()
+	b, err := tx.Bucket(rootID, 1, 0)
+	if err != nil {
+		t.Fatalf("Bucket failed: %v", err)
+	}
+	if err := b.Insert(1, 1, 1); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	tx.Delete(rootID, 1, 0)
+	if _, _, err := b.Find(1, 1); !errors.Is(err, bptree2.ErrBucketDeleted) {
+		t.Errorf("deleted in the transaction: expected ErrBucketDeleted, got %v", err)
+	}
+}
+
+func TestBucketReclaim(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer tree.Close()
+
+	// Buckets three levels deep under keys of a large parent
+	fill := func() bptree2.RootID {
+		rootID, _ := tree.CreateRoot()
+		for i := uint64(0); i < 20000; i++ {
+			tree.Insert(rootID, i, 0, i)
+		}
+		for i := uint64(0); i < 20; i++ {
+			outer, err := tree.CreateBucket(rootID, i*1000, 1)
+			if err != nil {
+				t.Fatalf("CreateBucket failed: %v", err)
+			}
+			inner, _ := outer.CreateBucket(0, 0)
+			for j := uint64(0); j < 2000; j++ {
+				outer.Insert(1, j, j)
+				inner.Insert(j, 0, j)
+			}
+			if err := inner.Insert(5, 5, 5); err != nil {
+				t.Fatalf("Insert failed: %v", err)
+			}
+		}
+		return rootID
+	}
+
+	rootID := fill()
+	tree.Flash()
+	info, _ := os.Stat(path)
+	size := info.Size()
+	if count := tree.RootCount(); count != 41 {
+		t.Fatalf("expected 41 roots, got %d", count)
+	}
+
+	// Removing bucket entries deletes the buckets below them
+	tree.Delete(rootID, 0, 1)
+	tree.Insert(rootID, 1000, 1, 0)
+	if _, err := tree.DeleteRange(rootID, 2000, 0, 3000, 9); err != nil {
+		t.Fatalf("DeleteRange failed: %v", err)
+	}
+	if err := tree.Reclaim(); err != nil {
+		t.Fatalf("Reclaim failed: %v", err)
+	}
+	if count := tree.RootCount(); count != 33 {
+		t.Errorf("expected 33 roots, got %d", count)
+	}
+
+	// Deleting the parent deletes the rest, and their pages are reused
+	for round := 0; round < 3; round++ {
+		if err := tree.DeleteRoot(rootID); err != nil {
+			t.Fatalf("DeleteRoot failed: %v", err)
+		}
+		if err := tree.Reclaim(); err != nil {
+			t.Fatalf("Reclaim failed: %v", err)
+		}
+		if count := tree.RootCount(); count != 0 {
+			t.Fatalf("expected 0 roots after reclaiming, got %d", count)
+		}
+		rootID = fill()
+	}
+	tree.Flash()
+	info, _ = os.Stat(path)
+	if info.Size() > size {
+		t.Errorf("file grew from %d to %d bytes", size, info.Size())
+	}
+}
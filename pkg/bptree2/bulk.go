@@ -0,0 +1,273 @@
+package bptree2
+
+import (
+	"errors"
+	"fmt"
+	"iter"
+
+	"bptree2/bnode"
+	"bptree2/bpager"
+)
+
+// DefaultFillFactor is the fill factor used by BulkLoad when none is given.
+const DefaultFillFactor = 1.0
+
+var (
+	// ErrUnsorted is returned by BulkLoad when the input is not in strictly ascending key order.
+	ErrUnsorted = errors.New("bulk load input is not in ascending key order")
+
+	// ErrRootNotEmpty is returned by BulkLoad for a non-empty root unless Replace is set.
+	ErrRootNotEmpty = errors.New("root is not empty")
+)
+
+// BulkLoadOptions configures BulkLoad.
+type BulkLoadOptions struct {
+	// FillFactor is the fraction of each leaf and internal node to fill,
+	// between 0.5 and 1. Zero means DefaultFillFactor. Leave room for later
+	// inserts with a lower value; a full node splits on its next insert.
+	FillFactor float64
+
+	// Replace allows loading into a non-empty root. Its current entries are
+	// discarded and their pages freed.
+	Replace bool
+}
+
+// bulkChild is a finished node of the level below, its smallest key and
+// the summary of its subtree.
+type bulkChild struct {
+	pageID bpager.PageID
+	key    Key
+	stats  bnode.Stats
+}
+
+// BulkLoad builds the tree of a root from entries given in strictly
+// ascending key order, in a single transaction.
+//
+// Leaves are filled one after the other and the internal levels are built on
+// top of them, so loading costs no descents and no splits. The new pages stay
+// in memory until the next Flash. See BulkLoadOptions for the fill factor and
+// loading into a non-empty root; opts may be nil.
+func (t *BPTree) BulkLoad(rootID RootID, entries iter.Seq2[Key, uint64], opts *BulkLoadOptions) error {
+	return t.update(func(tx *Tx) error {
+		return tx.BulkLoad(rootID, entries, opts)
+	})
+}
+
+// BulkLoad builds the tree of a root from entries given in strictly
+// ascending key order. See BPTree.BulkLoad.
+// If BulkLoad fails the transaction should be rolled back.
+func (tx *Tx) BulkLoad(rootID RootID, entries iter.Seq2[Key, uint64], opts *BulkLoadOptions) (err error) {
+	defer recoverCorrupt(&err)
+	if err := tx.checkWritable(); err != nil {
+		return err
+	}
+	if opts == nil {
+		opts = &BulkLoadOptions{}
+	}
+	fill := opts.FillFactor
+	if fill == 0 {
+		fill = DefaultFillFactor
+	}
+	if fill < 0.5 || fill > 1 {
+		return fmt.Errorf("invalid fill factor %v (must be between 0.5 and 1)", fill)
+	}
+
+	// Only an empty root is loaded unless asked to replace it.
+	// An augmented root stays augmented.
+	augmented := false
+	if oldRoot := tx.rootPage(rootID); oldRoot != 0 {
+		if err := tx.checkKeyType(oldRoot, false); err != nil {
+			return err
+		}
+		if !opts.Replace && tx.nodeCount(oldRoot) > 0 {
+			return ErrRootNotEmpty
+		}
+		augmented = bnode.IsAugmented(tx.page(oldRoot))
+		if err := tx.freeTree(oldRoot); err != nil {
+			return err
+		}
+	}
+
+	leaves, err := tx.bulkLeaves(entries, max(int(fill*bnode.MaxLeafKeys), bnode.MinLeafKeys), augmented)
+	if err != nil {
+		return err
+	}
+	if len(leaves) == 0 {
+		if augmented {
+			return tx.createAugmentedLeaf(rootID)
+		}
+		// Keep the root reserved, but empty
+		return tx.pages.SetRootPage(rootID, bpager.ReservedMarker)
+	}
+
+	// Build internal levels until a single root remains
+	maxKeys := bnode.MaxInternalKeys
+	if augmented {
+		maxKeys = bnode.MaxAugInternalKeys
+	}
+	perInternal := max(int(fill*float64(maxKeys+1)), maxKeys/2+1)
+	level := leaves
+	for len(level) > 1 {
+		level, err = tx.bulkInternalLevel(level, perInternal, augmented)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.pages.SetRootPage(rootID, level[0].pageID)
+}
+
+// bulkLeaves writes the entries into leaves of perLeaf entries each.
+func (tx *Tx) bulkLeaves(entries iter.Seq2[Key, uint64], perLeaf int, augmented bool) ([]bulkChild, error) {
+	leaves, err := tx.bulkFillLeaves(entries, perLeaf, augmented)
+	if err != nil {
+		return nil, err
+	}
+	for i := range leaves {
+		leaves[i].stats = tx.nodeStats(leaves[i].pageID)
+	}
+	return leaves, nil
+}
+
+// bulkFillLeaves fills the leaves for bulkLeaves.
+func (tx *Tx) bulkFillLeaves(entries iter.Seq2[Key, uint64], perLeaf int, augmented bool) ([]bulkChild, error) {
+	var leaves []bulkChild
+	var leaf *bnode.LeafNode
+	var leafID bpager.PageID
+	var last Key
+	var err error
+
+	for key, value := range entries {
+		if leaf != nil && key.Compare(last) <= 0 {
+			err = fmt.Errorf("%w: (%d,%d) after (%d,%d)", ErrUnsorted, key.Key1, key.Key2, last.Key1, last.Key2)
+			break
+		}
+
+		// Start the next leaf
+		if leaf == nil || leaf.KeyCount() >= perLeaf {
+			newID, allocErr := tx.pages.AllocatePage()
+			if allocErr != nil {
+				err = fmt.Errorf("failed to allocate page: %w", allocErr)
+				break
+			}
+			newData := tx.pageForWrite(newID)
+			newLeaf := bnode.NewLeafNode(newData, true)
+			bnode.SetAugmented(newData, augmented)
+			leaf, leafID = newLeaf, newID
+			leaves = append(leaves, bulkChild{pageID: newID, key: key})
+		}
+
+		leaf.Put(key.Key1, key.Key2, value)
+		last = key
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The last leaf may be short; share entries with its left neighbour
+	n := len(leaves)
+	if n < 2 || leaf.KeyCount() >= bnode.MinLeafKeys {
+		return leaves, nil
+	}
+
+	prevLeaf := bnode.NewLeafNode(tx.pageForWrite(leaves[n-2].pageID), false)
+	total := prevLeaf.KeyCount() + leaf.KeyCount()
+	if total <= bnode.MaxLeafKeys {
+		prevLeaf.MergeWith(leaf)
+		if err := tx.pages.FreePage(leafID); err != nil {
+			return nil, err
+		}
+		return leaves[:n-1], nil
+	}
+	for leaf.KeyCount() < total/2 {
+		leaf.BorrowFromLeft(prevLeaf)
+	}
+	leaves[n-1].key = leaf.GetKeyAt(0)
+	return leaves, nil
+}
+
+// bulkInternalLevel builds one level of internal nodes over the nodes below.
+func (tx *Tx) bulkInternalLevel(children []bulkChild, perNode int, augmented bool) ([]bulkChild, error) {
+	var level []bulkChild
+
+	maxKeys := bnode.MaxInternalKeys
+	if augmented {
+		maxKeys = bnode.MaxAugInternalKeys
+	}
+	for _, size := range bulkGroups(len(children), perNode, maxKeys/2+1, maxKeys+1) {
+		group := children[:size]
+		children = children[size:]
+
+		pageID, err := tx.pages.AllocatePage()
+		if err != nil {
+			return nil, fmt.Errorf("failed to allocate page: %w", err)
+		}
+		data := tx.pageForWrite(pageID)
+		node := bnode.NewInternalNode(data, true)
+		bnode.SetAugmented(data, augmented)
+
+		// Separators are the smallest key of every child but the first
+		var stats bnode.Stats
+		for i, child := range group {
+			node.SetChild(i, child.pageID)
+			node.SetStats(i, child.stats)
+			if i > 0 {
+				node.SetKeyAt(i-1, child.key)
+			}
+			stats = stats.Merge(child.stats)
+		}
+		bnode.SetKeyCount(data, uint16(size-1))
+
+		level = append(level, bulkChild{pageID: pageID, key: group[0].key, stats: stats})
+	}
+
+	return level, nil
+}
+
+// bulkGroups splits n items into groups of per items, evening out the last
+// two groups so that every group holds between minSize and maxSize items.
+func bulkGroups(n, per, minSize, maxSize int) []int {
+	var sizes []int
+	for n > 0 {
+		size := min(per, n)
+		sizes = append(sizes, size)
+		n -= size
+	}
+
+	if k := len(sizes); k >= 2 && sizes[k-1] < minSize {
+		total := sizes[k-2] + sizes[k-1]
+		if total <= maxSize {
+			sizes = append(sizes[:k-2], total)
+		} else {
+			sizes[k-2], sizes[k-1] = total-total/2, total/2
+		}
+	}
+	return sizes
+}
+
+// freeTree frees every page of the subtree rooted at pageID, overflow chains
+// included. Buckets in the subtree are queued for reclaiming. A subtree
+// shared with a fork only loses a reference.
+func (tx *Tx) freeTree(pageID bpager.PageID) error {
+	if tx.shared(pageID) {
+		return tx.pages.FreePage(pageID)
+	}
+
+	data := tx.page(pageID)
+	if data == nil {
+		return fmt.Errorf("failed to get page %d", pageID)
+	}
+
+	if bnode.GetNodeType(data) == bnode.NodeTypeInternal {
+		internal := bnode.NewInternalNode(data, false)
+		for i := 0; i <= internal.KeyCount(); i++ {
+			if err := tx.freeTree(internal.GetChild(i)); err != nil {
+				return err
+			}
+		}
+	} else if err := tx.freeValues(bnode.NewLeafNode(data, false)); err != nil {
+		return err
+	}
+
+	return tx.pages.FreePage(pageID)
+}
@@ -0,0 +1,179 @@
+package bptree2_test
+
+import (
+	"bptree2"
+	"bptree2/bnode"
+	"bptree2/bpager"
+	"errors"
+	"iter"
+	"path/filepath"
+	"testing"
+)
+
+// sequence yields keys (i*step, i) with value i for i in [0, n).
+func sequence(n, step uint64) iter.Seq2[bptree2.Key, uint64] {
+	return func(yield func(bptree2.Key, uint64) bool) {
+		for i := uint64(0); i < n; i++ {
+			if !yield(bptree2.Key{Key1: i * step, Key2: i}, i) {
+				return
+			}
+		}
+	}
+}
+
+func TestBulkLoad(t *testing.T) {
+	for _, n := range []uint64{1, bnode.MinLeafKeys, bnode.MaxLeafKeys, bnode.MaxLeafKeys + 1, 255, 20000, 100000} {
+		tmpDir := t.TempDir()
+		path := filepath.Join(tmpDir, "test.db")
+
+		tree, err := bptree2.Open(path)
+		if err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+
+		rootID, _ := tree.CreateRoot()
+		if err := tree.BulkLoad(rootID, sequence(n, 2), nil); err != nil {
+			t.Fatalf("n=%d: BulkLoad failed: %v", n, err)
+		}
+
+		if count, err := tree.Count(rootID); err != nil || count != int(n) {
+			t.Errorf("n=%d: expected %d entries, got %d", n, n, count)
+		}
+		for i := uint64(0); i < n; i += 7 {
+			if val, found, err := tree.Find(rootID, i*2, i); err != nil || !found || val != i {
+				t.Fatalf("n=%d: key %d: expected %d, got %d (found=%v)", n, i, i, val, found)
+			}
+		}
+
+		// A backward scan sees every entry
+		expected := n
+		seq, errf := tree.BackwardErr(rootID, bptree2.Key{}, bptree2.MaxKey)
+		for k := range seq {
+			expected--
+			if k.Key2 != expected {
+				t.Fatalf("n=%d: backward: expected key2 %d, got %d", n, expected, k.Key2)
+			}
+		}
+		if err := errf(); err != nil {
+			t.Fatalf("n=%d: backward scan failed: %v", n, err)
+		}
+
+		// The loaded tree takes regular inserts and deletes
+		for i := uint64(0); i < n; i++ {
+			tree.Insert(rootID, i*2+1, 0, i)
+		}
+		for i := uint64(0); i < n; i++ {
+			if deleted, err := tree.Delete(rootID, i*2, i); err != nil || !deleted {
+				t.Fatalf("n=%d: Delete of loaded key %d failed", n, i)
+			}
+		}
+		if count, err := tree.Count(rootID); err != nil || count != int(n) {
+			t.Errorf("n=%d: expected %d entries after updates, got %d", n, n, count)
+		}
+		tree.Close()
+	}
+}
+
+func TestBulkLoadFillFactor(t *testing.T) {
+	pageCount := func(fill float64) uint64 {
+		tmpDir := t.TempDir()
+		path := filepath.Join(tmpDir, "test.db")
+
+		tree, err := bptree2.Open(path)
+		if err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+		rootID, _ := tree.CreateRoot()
+		if err := tree.BulkLoad(rootID, sequence(100000, 1), &bptree2.BulkLoadOptions{FillFactor: fill}); err != nil {
+			t.Fatalf("BulkLoad failed: %v", err)
+		}
+		if count, err := tree.Count(rootID); err != nil || count != 100000 {
+			t.Errorf("fill %v: expected 100000 entries, got %d", fill, count)
+		}
+		tree.Close()
+
+		p, err := bpager.Open(path)
+		if err != nil {
+			t.Fatalf("bpager.Open failed: %v", err)
+		}
+		defer p.Close()
+		return p.PageCount()
+	}
+
+	full, half := pageCount(1), pageCount(0.5)
+
+	// 100000 entries in full leaves, plus internal nodes and the meta page
+	if full > 100000/bnode.MaxLeafKeys+10 {
+		t.Errorf("packed load used %d pages", full)
+	}
+	if half < full*19/10 {
+		t.Errorf("half-full load should use about twice the pages: %d vs %d", half, full)
+	}
+}
+
+func TestBulkLoadRejects(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer tree.Close()
+
+	rootID, _ := tree.CreateRoot()
+
+	// Unsorted input is rejected and leaves nothing behind
+	unsorted := func(yield func(bptree2.Key, uint64) bool) {
+		for _, k := range []uint64{1, 2, 3, 5, 4} {
+			if !yield(bptree2.Key{Key1: k}, k) {
+				return
+			}
+		}
+	}
+	if err := tree.BulkLoad(rootID, unsorted, nil); !errors.Is(err, bptree2.ErrUnsorted) {
+		t.Errorf("expected ErrUnsorted, got %v", err)
+	}
+	if count, err := tree.Count(rootID); err != nil || count != 0 {
+		t.Errorf("failed load should leave the root empty, got %d entries", count)
+	}
+
+	// Duplicates are not ascending either
+	duplicate := func(yield func(bptree2.Key, uint64) bool) {
+		yield(bptree2.Key{Key1: 1, Key2: 1}, 1)
+		yield(bptree2.Key{Key1: 1, Key2: 1}, 2)
+	}
+	if err := tree.BulkLoad(rootID, duplicate, nil); !errors.Is(err, bptree2.ErrUnsorted) {
+		t.Errorf("expected ErrUnsorted for a duplicate key, got %v", err)
+	}
+
+	if err := tree.BulkLoad(rootID, sequence(10, 1), &bptree2.BulkLoadOptions{FillFactor: 0.3}); err == nil {
+		t.Error("fill factor below 0.5 should be rejected")
+	}
+
+	// A non-empty root is only replaced on request
+	tree.Insert(rootID, 1000, 0, 1)
+	if err := tree.BulkLoad(rootID, sequence(10, 1), nil); err != bptree2.ErrRootNotEmpty {
+		t.Errorf("expected ErrRootNotEmpty, got %v", err)
+	}
+	if err := tree.BulkLoad(rootID, sequence(5000, 1), &bptree2.BulkLoadOptions{Replace: true}); err != nil {
+		t.Fatalf("BulkLoad with Replace failed: %v", err)
+	}
+	if _, found, err := tree.Find(rootID, 1000, 0); err != nil || found {
+		t.Error("replaced entries should be gone")
+	}
+	if count, err := tree.Count(rootID); err != nil || count != 5000 {
+		t.Errorf("expected 5000 entries after replace, got %d", count)
+	}
+
+	// Replacing with nothing empties the root but keeps it
+	if err := tree.BulkLoad(rootID, sequence(0, 1), &bptree2.BulkLoadOptions{Replace: true}); err != nil {
+		t.Fatalf("empty BulkLoad failed: %v", err)
+	}
+	if count, err := tree.Count(rootID); err != nil || count != 0 {
+		t.Errorf("expected an empty root, got %d entries", count)
+	}
+	if tree.RootCount() != 1 {
+		t.Errorf("expected the root to remain, got %d roots", tree.RootCount())
+	}
+}
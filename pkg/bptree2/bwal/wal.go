@@ -0,0 +1,293 @@
+// Package bwal provides a write-ahead log of full page images.
+//
+// A log is a sequence of frames, each holding one page image. The last frame
+// of a group written by Append carries a commit flag; Recover only replays
+// groups that were completely written and end in a commit frame, so a crash
+// while appending never exposes a partial group.
+package bwal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+const (
+	// Magic number to identify WAL files
+	Magic uint32 = 0x4257414C // "BWAL"
+
+	// Version of the WAL file format
+	Version uint32 = 1
+
+	// HeaderSize is the size of the file header in bytes.
+	HeaderSize = 16
+
+	// FrameHeaderSize is the size of each frame header in bytes.
+	FrameHeaderSize = 16
+
+	// flagCommit marks the last frame of a committed group.
+	flagCommit uint32 = 1
+
+	// writeBatch is the number of frames written to the file at once.
+	writeBatch = 64
+)
+
+// File header layout:
+// Byte 0-3: Magic
+// Byte 4-7: Version
+// Byte 8-11: PageSize
+// Byte 12-15: Salt (changes on every Reset, invalidating old frames)
+//
+// Frame header layout:
+// Byte 0-7: PageID
+// Byte 8-11: Flags
+// Byte 12-15: CRC32C of salt, PageID, Flags and page data
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Frame is a single page image to be logged.
+type Frame struct {
+	PageID uint64
+	Data   []byte
+}
+
+// WAL is an append-only log file of page images.
+type WAL struct {
+	file     *os.File
+	pageSize int
+	salt     uint32
+	size     int64
+	buf      []byte // frames being written, see write
+}
+
+// Open opens or creates a WAL file for pages of the given size.
+func Open(path string, pageSize int) (*WAL, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open wal: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to stat wal: %w", err)
+	}
+
+	w := &WAL{
+		file:     file,
+		pageSize: pageSize,
+		size:     info.Size(),
+	}
+
+	if w.size < HeaderSize {
+		// New (or truncated before the header was written) log
+		if err := w.writeHeader(1); err != nil {
+			file.Close()
+			return nil, err
+		}
+		return w, nil
+	}
+
+	var hdr [HeaderSize]byte
+	if _, err := file.ReadAt(hdr[:], 0); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to read wal header: %w", err)
+	}
+	if binary.BigEndian.Uint32(hdr[0:4]) != Magic {
+		file.Close()
+		return nil, fmt.Errorf("invalid wal format: bad magic number")
+	}
+	if v := binary.BigEndian.Uint32(hdr[4:8]); v != Version {
+		file.Close()
+		return nil, fmt.Errorf("unsupported wal version: %d (expected %d)", v, Version)
+	}
+	if ps := int(binary.BigEndian.Uint32(hdr[8:12])); ps != pageSize {
+		file.Close()
+		return nil, fmt.Errorf("wal page size mismatch: %d (expected %d)", ps, pageSize)
+	}
+	w.salt = binary.BigEndian.Uint32(hdr[12:16])
+
+	return w, nil
+}
+
+// writeHeader truncates the log and writes a fresh header with the given salt.
+func (w *WAL) writeHeader(salt uint32) error {
+	if err := w.file.Truncate(0); err != nil {
+		return fmt.Errorf("failed to truncate wal: %w", err)
+	}
+
+	var hdr [HeaderSize]byte
+	binary.BigEndian.PutUint32(hdr[0:4], Magic)
+	binary.BigEndian.PutUint32(hdr[4:8], Version)
+	binary.BigEndian.PutUint32(hdr[8:12], uint32(w.pageSize))
+	binary.BigEndian.PutUint32(hdr[12:16], salt)
+	if _, err := w.file.WriteAt(hdr[:], 0); err != nil {
+		return fmt.Errorf("failed to write wal header: %w", err)
+	}
+	if err := w.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync wal: %w", err)
+	}
+
+	w.salt = salt
+	w.size = HeaderSize
+	return nil
+}
+
+// frameSize returns the on-disk size of a single frame.
+func (w *WAL) frameSize() int64 {
+	return int64(FrameHeaderSize + w.pageSize)
+}
+
+// checksum computes the frame checksum over the salt, frame header fields and data.
+func (w *WAL) checksum(hdr []byte, data []byte) uint32 {
+	var salt [4]byte
+	binary.BigEndian.PutUint32(salt[:], w.salt)
+	crc := crc32.Update(0, castagnoli, salt[:])
+	crc = crc32.Update(crc, castagnoli, hdr[0:12])
+	return crc32.Update(crc, castagnoli, data)
+}
+
+// Append writes frames as one committed group and syncs the log.
+// The group is durable once Append returns without error.
+func (w *WAL) Append(frames []Frame) error {
+	if len(frames) == 0 {
+		return nil
+	}
+	if err := w.write(frames, true); err != nil {
+		return err
+	}
+	if err := w.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync wal: %w", err)
+	}
+	return nil
+}
+
+// Write adds frames to the group that the next Append commits, so that a
+// group too large for memory can be logged in parts. Recover ignores them
+// until that Append returns.
+func (w *WAL) Write(frames []Frame) error {
+	if len(frames) == 0 {
+		return nil
+	}
+	return w.write(frames, false)
+}
+
+// write appends frames to the log, flagging the last one if commit is set.
+// The frames are written through a buffer of up to writeBatch frames.
+func (w *WAL) write(frames []Frame, commit bool) error {
+	for _, f := range frames {
+		if len(f.Data) != w.pageSize {
+			return fmt.Errorf("frame for page %d has size %d (expected %d)", f.PageID, len(f.Data), w.pageSize)
+		}
+	}
+
+	if w.buf == nil {
+		w.buf = make([]byte, writeBatch*w.frameSize())
+	}
+	off := w.size
+	for start := 0; start < len(frames); start += writeBatch {
+		batch := frames[start:min(start+writeBatch, len(frames))]
+		n := int64(len(batch)) * w.frameSize()
+		for i, f := range batch {
+			frame := w.buf[int64(i)*w.frameSize():]
+			hdr := frame[:FrameHeaderSize]
+			binary.BigEndian.PutUint64(hdr[0:8], f.PageID)
+			flags := uint32(0)
+			if commit && start+i == len(frames)-1 {
+				flags = flagCommit
+			}
+			binary.BigEndian.PutUint32(hdr[8:12], flags)
+			binary.BigEndian.PutUint32(hdr[12:16], w.checksum(hdr, f.Data))
+			copy(frame[FrameHeaderSize:w.frameSize()], f.Data)
+		}
+		if _, err := w.file.WriteAt(w.buf[:n], off); err != nil {
+			return fmt.Errorf("failed to append to wal: %w", err)
+		}
+		off += n
+	}
+	w.size = off
+	return nil
+}
+
+// Recover calls fn for every frame of every committed group, in log order.
+// Frames after the last valid commit frame (a torn or partial group) are ignored.
+func (w *WAL) Recover(fn func(pageID uint64, data []byte) error) error {
+	// First pass: find the end of the last committed group
+	end := int64(HeaderSize)
+	hdr := make([]byte, FrameHeaderSize)
+	data := make([]byte, w.pageSize)
+
+	for off := int64(HeaderSize); off+w.frameSize() <= w.size; off += w.frameSize() {
+		ok, commit, err := w.readFrame(off, hdr, data)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		if commit {
+			end = off + w.frameSize()
+		}
+	}
+
+	// Second pass: replay committed frames
+	for off := int64(HeaderSize); off < end; off += w.frameSize() {
+		if _, _, err := w.readFrame(off, hdr, data); err != nil {
+			return err
+		}
+		if err := fn(binary.BigEndian.Uint64(hdr[0:8]), data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readFrame reads the frame at off into hdr and data and validates its checksum.
+func (w *WAL) readFrame(off int64, hdr, data []byte) (ok, commit bool, err error) {
+	if _, err := w.file.ReadAt(hdr, off); err != nil {
+		if err == io.EOF {
+			return false, false, nil
+		}
+		return false, false, fmt.Errorf("failed to read wal frame: %w", err)
+	}
+	if _, err := w.file.ReadAt(data, off+FrameHeaderSize); err != nil {
+		if err == io.EOF {
+			return false, false, nil
+		}
+		return false, false, fmt.Errorf("failed to read wal frame: %w", err)
+	}
+
+	if binary.BigEndian.Uint32(hdr[12:16]) != w.checksum(hdr, data) {
+		return false, false, nil
+	}
+	return true, binary.BigEndian.Uint32(hdr[8:12])&flagCommit != 0, nil
+}
+
+// Reset discards all frames. Call it once the logged pages have been
+// checkpointed into the database file.
+func (w *WAL) Reset() error {
+	if w.size == HeaderSize {
+		return nil
+	}
+	return w.writeHeader(w.salt + 1)
+}
+
+// Size returns the current size of the log in bytes.
+func (w *WAL) Size() int64 {
+	return w.size
+}
+
+// Close closes the log file.
+func (w *WAL) Close() error {
+	if w.file == nil {
+		return nil
+	}
+	if err := w.file.Close(); err != nil {
+		return fmt.Errorf("failed to close wal: %w", err)
+	}
+	w.file = nil
+	return nil
+}
@@ -0,0 +1,244 @@
+package bwal_test
+
+import (
+	"bptree2/bwal"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const pageSize = 128
+
+func page(b byte) []byte {
+	data := make([]byte, pageSize)
+	for i := range data {
+		data[i] = b
+	}
+	return data
+}
+
+type frame struct {
+	pageID uint64
+	first  byte
+}
+
+func recoverAll(t *testing.T, w *bwal.WAL) []frame {
+	t.Helper()
+	var frames []frame
+	err := w.Recover(func(pageID uint64, data []byte) error {
+		frames = append(frames, frame{pageID, data[0]})
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Recover failed: %v", err)
+	}
+	return frames
+}
+
+func TestAppendRecover(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db-wal")
+
+	w, err := bwal.Open(path, pageSize)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	if err := w.Append([]bwal.Frame{{PageID: 1, Data: page('a')}, {PageID: 2, Data: page('b')}}); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	if err := w.Append([]bwal.Frame{{PageID: 1, Data: page('c')}}); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	w.Close()
+
+	// Reopen and replay
+	w, err = bwal.Open(path, pageSize)
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	defer w.Close()
+
+	frames := recoverAll(t, w)
+	expected := []frame{{1, 'a'}, {2, 'b'}, {1, 'c'}}
+	if len(frames) != len(expected) {
+		t.Fatalf("expected %d frames, got %d", len(expected), len(frames))
+	}
+	for i := range expected {
+		if frames[i] != expected[i] {
+			t.Errorf("frame %d: expected %v, got %v", i, expected[i], frames[i])
+		}
+	}
+}
+
+func TestTornGroupIgnored(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db-wal")
+
+	w, _ := bwal.Open(path, pageSize)
+	w.Append([]bwal.Frame{{PageID: 1, Data: page('a')}})
+	w.Append([]bwal.Frame{{PageID: 2, Data: page('b')}, {PageID: 3, Data: page('c')}})
+	size := w.Size()
+	w.Close()
+
+	// Cut the commit frame of the second group short
+	if err := os.Truncate(path, size-10); err != nil {
+		t.Fatalf("Truncate failed: %v", err)
+	}
+
+	w, err := bwal.Open(path, pageSize)
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	defer w.Close()
+
+	frames := recoverAll(t, w)
+	if len(frames) != 1 || frames[0] != (frame{1, 'a'}) {
+		t.Errorf("expected only the first group, got %v", frames)
+	}
+}
+
+func TestLargeGroup(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db-wal")
+
+	// Groups larger than the write buffer, the second one torn
+	w, _ := bwal.Open(path, pageSize)
+	var group []bwal.Frame
+	for i := 0; i < 150; i++ {
+		group = append(group, bwal.Frame{PageID: uint64(i), Data: page(byte(i))})
+	}
+	if err := w.Append(group); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	if err := w.Append(group); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	size := w.Size()
+	w.Close()
+	if err := os.Truncate(path, size-10); err != nil {
+		t.Fatalf("Truncate failed: %v", err)
+	}
+
+	w, err := bwal.Open(path, pageSize)
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	defer w.Close()
+
+	frames := recoverAll(t, w)
+	if len(frames) != len(group) {
+		t.Fatalf("expected the first group of %d frames, got %d", len(group), len(frames))
+	}
+	for i, f := range frames {
+		if f != (frame{uint64(i), byte(i)}) {
+			t.Errorf("frame %d: got %v", i, f)
+		}
+	}
+}
+
+func TestWriteUncommitted(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db-wal")
+
+	w, _ := bwal.Open(path, pageSize)
+	w.Append([]bwal.Frame{{PageID: 1, Data: page('a')}})
+	if err := w.Write([]bwal.Frame{{PageID: 2, Data: page('b')}}); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	// Written frames are not replayed before the group is committed
+	if frames := recoverAll(t, w); len(frames) != 1 || frames[0] != (frame{1, 'a'}) {
+		t.Errorf("expected only the first group, got %v", frames)
+	}
+
+	if err := w.Append([]bwal.Frame{{PageID: 3, Data: page('c')}}); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	w.Close()
+
+	w, err := bwal.Open(path, pageSize)
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	defer w.Close()
+
+	frames := recoverAll(t, w)
+	expected := []frame{{1, 'a'}, {2, 'b'}, {3, 'c'}}
+	if len(frames) != len(expected) {
+		t.Fatalf("expected %d frames, got %d", len(expected), len(frames))
+	}
+	for i := range expected {
+		if frames[i] != expected[i] {
+			t.Errorf("frame %d: expected %v, got %v", i, expected[i], frames[i])
+		}
+	}
+}
+
+func TestCorruptFrameIgnored(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db-wal")
+
+	w, _ := bwal.Open(path, pageSize)
+	w.Append([]bwal.Frame{{PageID: 1, Data: page('a')}})
+	w.Append([]bwal.Frame{{PageID: 2, Data: page('b')}})
+	w.Close()
+
+	// Flip a byte in the data of the second frame
+	f, _ := os.OpenFile(path, os.O_RDWR, 0644)
+	off := int64(bwal.HeaderSize + 2*bwal.FrameHeaderSize + pageSize + 5)
+	f.WriteAt([]byte{'x'}, off)
+	f.Close()
+
+	w, _ = bwal.Open(path, pageSize)
+	defer w.Close()
+
+	frames := recoverAll(t, w)
+	if len(frames) != 1 || frames[0] != (frame{1, 'a'}) {
+		t.Errorf("expected only the first group, got %v", frames)
+	}
+}
+
+func TestReset(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db-wal")
+
+	w, _ := bwal.Open(path, pageSize)
+	w.Append([]bwal.Frame{{PageID: 1, Data: page('a')}})
+
+	if err := w.Reset(); err != nil {
+		t.Fatalf("Reset failed: %v", err)
+	}
+	if w.Size() != bwal.HeaderSize {
+		t.Errorf("expected size %d after reset, got %d", bwal.HeaderSize, w.Size())
+	}
+	if frames := recoverAll(t, w); len(frames) != 0 {
+		t.Errorf("expected no frames after reset, got %v", frames)
+	}
+
+	// The log is still usable after a reset
+	w.Append([]bwal.Frame{{PageID: 4, Data: page('d')}})
+	w.Close()
+
+	w, _ = bwal.Open(path, pageSize)
+	defer w.Close()
+	frames := recoverAll(t, w)
+	if len(frames) != 1 || frames[0] != (frame{4, 'd'}) {
+		t.Errorf("expected frame for page 4, got %v", frames)
+	}
+}
+
+func TestPageSizeMismatch(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db-wal")
+
+	w, _ := bwal.Open(path, pageSize)
+	if err := w.Append([]bwal.Frame{{PageID: 1, Data: make([]byte, pageSize/2)}}); err == nil {
+		t.Error("Append with wrong frame size should fail")
+	}
+	w.Close()
+
+	if _, err := bwal.Open(path, pageSize*2); err == nil {
+		t.Error("Open with a different page size should fail")
+	}
+}
@@ -0,0 +1,490 @@
+package bptree2
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"bptree2/bnode"
+	"bptree2/bpager"
+)
+
+// MaxEntrySize is the largest key length plus value length of a BytesTree entry.
+const MaxEntrySize = bnode.MaxVarEntrySize
+
+var (
+	// ErrKeyType is returned when a root is used with a different key type than it holds.
+	ErrKeyType = errors.New("root holds keys of a different type")
+
+	// ErrEntryTooLarge is returned when a key and value exceed MaxEntrySize.
+	ErrEntryTooLarge = errors.New("entry is too large")
+)
+
+// BytesTree is a view of one root tree with []byte keys and values.
+//
+// Keys are ordered lexicographically, as by bytes.Compare; the empty key is
+// the smallest. Entries live in slotted pages with variable-length cells
+// (see bnode.VarLeafNode), and internal nodes hold truncated separators.
+// A root holds either uint64 or []byte keys; writing to it or reading it
+// through a BytesTree with the wrong key type returns ErrKeyType.
+//
+// A BytesTree from BPTree.Bytes runs each operation in its own transaction;
+// one from Tx.Bytes runs them in that transaction.
+type BytesTree struct {
+	tree   *BPTree
+	tx     *Tx
+	rootID RootID
+}
+
+// Bytes returns a view of a root tree with []byte keys and values.
+func (t *BPTree) Bytes(rootID RootID) *BytesTree {
+	return &BytesTree{tree: t, rootID: rootID}
+}
+
+// Bytes returns a view of a root tree with []byte keys and values,
+// operating within the transaction.
+func (tx *Tx) Bytes(rootID RootID) *BytesTree {
+	return &BytesTree{tx: tx, rootID: rootID}
+}
+
+// Find retrieves the value for a key.
+// Returns (value, true) if found, (nil, false) otherwise.
+func (b *BytesTree) Find(key []byte) (value []byte, found bool, err error) {
+	err = b.view(func(tx *Tx) error {
+		rootPageID, err := tx.bytesRoot(b.rootID)
+		if err != nil || rootPageID == 0 {
+			return err
+		}
+		value, found, err = tx.bytesSearch(rootPageID, key)
+		return err
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return value, found, nil
+}
+
+// Insert inserts or updates a key-value pair.
+func (b *BytesTree) Insert(key, value []byte) error {
+	if len(key)+len(value) > MaxEntrySize {
+		return fmt.Errorf("%w: %d bytes (max: %d)", ErrEntryTooLarge, len(key)+len(value), MaxEntrySize)
+	}
+	return b.update(func(tx *Tx) error {
+		return tx.bytesInsertRoot(b.rootID, key, value)
+	})
+}
+
+// Delete removes a key.
+// Returns true if the key was found and removed.
+func (b *BytesTree) Delete(key []byte) (bool, error) {
+	var deleted bool
+	err := b.update(func(tx *Tx) (err error) {
+		deleted, err = tx.bytesDeleteRoot(b.rootID, key)
+		return err
+	})
+	return deleted, err
+}
+
+// FindRange iterates over all key-value pairs where start <= key <= end.
+// A nil end means no upper bound. The slices passed to fn are only valid
+// during the call. Return false from fn to stop iteration.
+func (b *BytesTree) FindRange(start, end []byte, fn func(key, value []byte) bool) error {
+	if b.tx != nil {
+		return b.tx.bytesScan(b.rootID, start, end, fn)
+	}
+	return b.tree.view(func(tx *Tx) error {
+		return tx.bytesScan(b.rootID, start, end, fn)
+	})
+}
+
+// Count returns the number of key-value pairs in the tree.
+func (b *BytesTree) Count() (int, error) {
+	count := 0
+	err := b.FindRange(nil, nil, func(key, value []byte) bool {
+		count++
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// view runs fn in a read-only transaction, or in the view's transaction.
+func (b *BytesTree) view(fn func(tx *Tx) error) error {
+	if b.tx == nil {
+		return b.tree.view(fn)
+	}
+	return b.tx.locked(func() error {
+		return fn(b.tx)
+	})
+}
+
+// update runs fn in a writable transaction, or in the view's transaction.
+// A corrupt page read by fn is returned as an error.
+func (b *BytesTree) update(fn func(tx *Tx) error) error {
+	if b.tx == nil {
+		return b.tree.update(fn)
+	}
+	if err := b.tx.checkWritable(); err != nil {
+		return err
+	}
+	return b.tx.run(fn)
+}
+
+// bytesRoot returns the root page of a []byte-keyed tree, or 0 if it is empty.
+func (tx *Tx) bytesRoot(rootID RootID) (bpager.PageID, error) {
+	rootPageID := tx.rootPage(rootID)
+	if rootPageID == 0 {
+		return 0, nil
+	}
+	return rootPageID, tx.checkKeyType(rootPageID, true)
+}
+
+// checkKeyType returns ErrKeyType unless the node at pageID holds
+// []byte keys (varKeys) or uint64 keys (!varKeys).
+func (tx *Tx) checkKeyType(pageID bpager.PageID, varKeys bool) error {
+	data := tx.page(pageID)
+	if data == nil {
+		return fmt.Errorf("failed to get page %d", pageID)
+	}
+	switch bnode.GetNodeType(data) {
+	case bnode.NodeTypeVarLeaf, bnode.NodeTypeVarInternal:
+		if varKeys {
+			return nil
+		}
+	default:
+		if !varKeys {
+			return nil
+		}
+	}
+	return ErrKeyType
+}
+
+// bytesSearch searches for a key starting from the given page.
+// Returns a copy of the value.
+func (tx *Tx) bytesSearch(pageID bpager.PageID, key []byte) ([]byte, bool, error) {
+	for range maxDepth {
+		data := tx.page(pageID)
+		if data == nil {
+			return nil, false, fmt.Errorf("failed to get page %d", pageID)
+		}
+
+		if bnode.GetNodeType(data) == bnode.NodeTypeVarLeaf {
+			value, found := bnode.NewVarLeafNode(data, false).Get(key)
+			return bytes.Clone(value), found, nil
+		}
+
+		internal := bnode.NewVarInternalNode(data, false)
+		pageID = internal.GetChild(internal.Search(key))
+	}
+	return nil, false, errTooDeep
+}
+
+// bytesInsertRoot inserts a key-value pair into a []byte-keyed tree,
+// growing a new root when the old one splits.
+func (tx *Tx) bytesInsertRoot(rootID RootID, key, value []byte) error {
+	rootPageID, err := tx.bytesRoot(rootID)
+	if err != nil {
+		return err
+	}
+
+	// Empty tree - create first leaf
+	if rootPageID == 0 {
+		newPageID, err := tx.pages.AllocatePage()
+		if err != nil {
+			return fmt.Errorf("failed to allocate root: %w", err)
+		}
+		leaf := bnode.NewVarLeafNode(tx.pageForWrite(newPageID), true)
+		leaf.Put(key, value)
+		return tx.pages.SetRootPage(rootID, newPageID)
+	}
+
+	if rootPageID, err = tx.unshareRoot(rootID, rootPageID); err != nil {
+		return err
+	}
+	splitKey, newChildID, err := tx.bytesInsert(rootPageID, key, value)
+	if err != nil {
+		return err
+	}
+
+	// Root was split - create new root
+	if newChildID != 0 {
+		newRootID, err := tx.pages.AllocatePage()
+		if err != nil {
+			return fmt.Errorf("failed to allocate new root: %w", err)
+		}
+		newRoot := bnode.NewVarInternalNode(tx.pageForWrite(newRootID), true)
+		newRoot.InitRoot(rootPageID, newChildID, splitKey)
+		return tx.pages.SetRootPage(rootID, newRootID)
+	}
+	return nil
+}
+
+// bytesInsert recursively inserts a key-value pair below the given page.
+// Returns the separator and page ID of a new right sibling if the page split.
+func (tx *Tx) bytesInsert(pageID bpager.PageID, key, value []byte) ([]byte, bpager.PageID, error) {
+	data := tx.page(pageID)
+	if data == nil {
+		return nil, 0, fmt.Errorf("failed to get page %d", pageID)
+	}
+
+	if bnode.GetNodeType(data) == bnode.NodeTypeVarLeaf {
+		leaf := bnode.NewVarLeafNode(tx.pageForWrite(pageID), false)
+		if leaf.Put(key, value) {
+			return nil, 0, nil
+		}
+
+		// No room - split
+		newPageID, err := tx.pages.AllocatePage()
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to allocate page: %w", err)
+		}
+		splitKey, _ := leaf.Split(tx.pageForWrite(newPageID), key, value)
+		return splitKey, newPageID, nil
+	}
+
+	internal := bnode.NewVarInternalNode(data, false)
+	childID, err := tx.unshareChild(pageID, internal.Search(key))
+	if err != nil {
+		return nil, 0, err
+	}
+	splitKey, newChildID, err := tx.bytesInsert(childID, key, value)
+	if err != nil || newChildID == 0 {
+		return nil, 0, err
+	}
+
+	// Child was split, insert its separator here
+	internal = bnode.NewVarInternalNode(tx.pageForWrite(pageID), false)
+	if internal.Insert(splitKey, newChildID) {
+		return nil, 0, nil
+	}
+
+	newPageID, err := tx.pages.AllocatePage()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to allocate page: %w", err)
+	}
+	midKey, _ := internal.Split(tx.pageForWrite(newPageID), splitKey, newChildID)
+	return midKey, newPageID, nil
+}
+
+// bytesDeleteRoot removes a key from a []byte-keyed tree, shrinking the root as needed.
+func (tx *Tx) bytesDeleteRoot(rootID RootID, key []byte) (bool, error) {
+	rootPageID, err := tx.bytesRoot(rootID)
+	if err != nil || rootPageID == 0 {
+		return false, err
+	}
+	if rootPageID, err = tx.unshareRoot(rootID, rootPageID); err != nil {
+		return false, err
+	}
+
+	deleted, _, err := tx.bytesDelete(rootPageID, key)
+	if !deleted || err != nil {
+		return false, err
+	}
+
+	// Check if root needs to shrink
+	rootData := tx.page(rootPageID)
+	if bnode.GetNodeType(rootData) == bnode.NodeTypeVarInternal {
+		internal := bnode.NewVarInternalNode(rootData, false)
+		if internal.KeyCount() == 0 {
+			// Root has no keys, promote only child to root
+			if err := tx.pages.SetRootPage(rootID, internal.GetChild(0)); err != nil {
+				return true, err
+			}
+			return true, tx.pages.FreePage(rootPageID)
+		}
+	} else if bnode.GetKeyCount(rootData) == 0 {
+		// Tree is now empty, but the root stays reserved
+		if err := tx.pages.SetRootPage(rootID, bpager.ReservedMarker); err != nil {
+			return true, err
+		}
+		return true, tx.pages.FreePage(rootPageID)
+	}
+	return true, nil
+}
+
+// bytesDelete recursively deletes a key, handling underflow.
+// Returns (deleted, underflow) where underflow indicates this node needs rebalancing.
+func (tx *Tx) bytesDelete(pageID bpager.PageID, key []byte) (bool, bool, error) {
+	data := tx.page(pageID)
+	if data == nil {
+		return false, false, nil
+	}
+
+	if bnode.GetNodeType(data) == bnode.NodeTypeVarLeaf {
+		if _, found := bnode.NewVarLeafNode(data, false).Search(key); !found {
+			return false, false, nil
+		}
+		leaf := bnode.NewVarLeafNode(tx.pageForWrite(pageID), false)
+		leaf.Delete(key)
+		return true, leaf.IsUnderflow(), nil
+	}
+
+	internal := bnode.NewVarInternalNode(data, false)
+	childIdx := internal.Search(key)
+	childID, err := tx.unshareChild(pageID, childIdx)
+	if err != nil {
+		return false, false, err
+	}
+	deleted, childUnderflow, err := tx.bytesDelete(childID, key)
+	if !deleted || !childUnderflow || err != nil {
+		return deleted, false, err
+	}
+
+	internal = bnode.NewVarInternalNode(tx.pageForWrite(pageID), false)
+	if err := tx.bytesUnderflow(internal, childIdx, pageID); err != nil {
+		return false, false, err
+	}
+	return true, internal.IsUnderflow(), nil
+}
+
+// bytesUnderflow handles an underflowing child by borrowing or merging.
+// parent must wrap the writable page of parentID.
+//
+// Nodes are balanced by bytes, so a merge is only possible when both nodes
+// fit in one page, and a borrow only when the new separator fits in the
+// parent. Otherwise the child is left underfull, which is harmless.
+func (tx *Tx) bytesUnderflow(parent *bnode.VarInternalNode, childIdx int, parentID bpager.PageID) error {
+	// Rebalance with the left sibling if there is one, otherwise the right one
+	leftIdx := childIdx - 1
+	if childIdx == 0 {
+		if parent.KeyCount() == 0 {
+			return nil
+		}
+		leftIdx = 0
+	}
+	leftID, err := tx.unshareChild(parentID, leftIdx)
+	if err != nil {
+		return err
+	}
+	rightID, err := tx.unshareChild(parentID, leftIdx+1)
+	if err != nil {
+		return err
+	}
+	leftData, rightData := tx.page(leftID), tx.page(rightID)
+	parentKey := parent.GetKeyAt(leftIdx)
+
+	if bnode.GetNodeType(leftData) == bnode.NodeTypeVarLeaf {
+		left := bnode.NewVarLeafNode(leftData, false)
+		right := bnode.NewVarLeafNode(rightData, false)
+
+		if left.CanMergeWith(right) {
+			left = bnode.NewVarLeafNode(tx.pageForWrite(leftID), false)
+			left.MergeWith(right)
+			parent.DeleteKeyAt(leftIdx)
+			return tx.pages.FreePage(rightID)
+		}
+
+		// Borrow entries from the sibling until the child is no longer underfull
+		left = bnode.NewVarLeafNode(tx.pageForWrite(leftID), false)
+		right = bnode.NewVarLeafNode(tx.pageForWrite(rightID), false)
+		moved := 0
+		if leftIdx == childIdx {
+			for ; left.IsUnderflow() && right.CanLendTo(); moved++ {
+				left.BorrowFromRight(right)
+			}
+		} else {
+			for ; right.IsUnderflow() && left.CanLendTo(); moved++ {
+				right.BorrowFromLeft(left)
+			}
+		}
+		if moved == 0 {
+			return nil
+		}
+
+		// The old separator stays valid if the new one does not fit; move the entries back
+		if !parent.SetKeyAt(leftIdx, bnode.Separator(left.GetKeyAt(left.KeyCount()-1), right.GetKeyAt(0))) {
+			for ; moved > 0; moved-- {
+				if leftIdx == childIdx {
+					right.BorrowFromLeft(left)
+				} else {
+					left.BorrowFromRight(right)
+				}
+			}
+		}
+		return nil
+	}
+
+	left := bnode.NewVarInternalNode(leftData, false)
+	right := bnode.NewVarInternalNode(rightData, false)
+
+	if left.CanMergeWith(right, parentKey) {
+		left = bnode.NewVarInternalNode(tx.pageForWrite(leftID), false)
+		left.MergeWith(right, parentKey)
+		parent.DeleteKeyAt(leftIdx)
+		return tx.pages.FreePage(rightID)
+	}
+
+	// Rotate one key through the parent, if the key moving up fits there
+	parentKey = bytes.Clone(parentKey)
+	if leftIdx == childIdx {
+		if !right.CanLendTo() || !parent.CanSetKeyAt(leftIdx, right.GetKeyAt(0)) {
+			return nil
+		}
+		left = bnode.NewVarInternalNode(tx.pageForWrite(leftID), false)
+		right = bnode.NewVarInternalNode(tx.pageForWrite(rightID), false)
+		parent.SetKeyAt(leftIdx, left.BorrowFromRight(right, parentKey))
+	} else {
+		if !left.CanLendTo() || !parent.CanSetKeyAt(leftIdx, left.GetKeyAt(left.KeyCount()-1)) {
+			return nil
+		}
+		left = bnode.NewVarInternalNode(tx.pageForWrite(leftID), false)
+		right = bnode.NewVarInternalNode(tx.pageForWrite(rightID), false)
+		parent.SetKeyAt(leftIdx, right.BorrowFromLeft(left, parentKey))
+	}
+	return nil
+}
+
+// bytesScan calls fn for every pair with start <= key <= end (end nil: no upper bound).
+// Like scanInternal it locks one leaf at a time; fn is called on a copy of the leaf.
+func (tx *Tx) bytesScan(rootID RootID, start, end []byte, fn func(key, value []byte) bool) error {
+	// Find the leaf containing start key
+	w := leafWalk{tx: tx}
+	var pageID bpager.PageID
+	err := tx.locked(func() (err error) {
+		pageID, err = tx.bytesRoot(rootID)
+		if err != nil || pageID == 0 {
+			return err
+		}
+		pageID, err = w.seek(pageID, func(data []byte) int {
+			return bnode.NewVarInternalNode(data, false).Search(start)
+		})
+		return err
+	})
+
+	// Iterate through leaves
+	buf := make([]byte, bpager.PageSize)
+	for pageID != 0 && err == nil {
+		err = tx.locked(func() error {
+			data := tx.page(pageID)
+			if data == nil {
+				return fmt.Errorf("failed to get page %d", pageID)
+			}
+			copy(buf, data)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		leaf := bnode.NewVarLeafNode(buf, false)
+		idx, _ := leaf.Search(start)
+		for ; idx < leaf.KeyCount(); idx++ {
+			key := leaf.GetKeyAt(idx)
+			if end != nil && bytes.Compare(key, end) > 0 {
+				return nil
+			}
+			if !fn(key, leaf.GetValueAt(idx)) {
+				return nil // User requested stop
+			}
+		}
+
+		err = tx.locked(func() (err error) {
+			pageID, err = w.next(true)
+			return err
+		})
+	}
+
+	return err
+}
@@ -0,0 +1,191 @@
+package bptree2_test
+
+import (
+	"bptree2"
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestBytesTree(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	rootID, _ := tree.CreateRoot()
+	bt := tree.Bytes(rootID)
+
+	// Keys of very different lengths, so that pages split by bytes
+	rng := rand.New(rand.NewSource(1))
+	model := make(map[string]string)
+	for i := 0; i < 20000; i++ {
+		key := fmt.Sprintf("user/%d/%s", rng.Intn(5000), bytes.Repeat([]byte("k"), rng.Intn(200)))
+		if rng.Intn(4) == 0 {
+			deleted, err := bt.Delete([]byte(key))
+			if err != nil {
+				t.Fatalf("Delete failed: %v", err)
+			}
+			if _, exists := model[key]; deleted != exists {
+				t.Fatalf("Delete(%q) = %v, expected %v", key, deleted, exists)
+			}
+			delete(model, key)
+			continue
+		}
+		value := bytes.Repeat([]byte{byte(i)}, rng.Intn(300))
+		if err := bt.Insert([]byte(key), value); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+		model[key] = string(value)
+	}
+
+	check := func() {
+		t.Helper()
+		if count, err := bt.Count(); err != nil || count != len(model) {
+			t.Fatalf("expected %d entries, got %d", len(model), count)
+		}
+		for key, value := range model {
+			if val, found, err := bt.Find([]byte(key)); err != nil || !found || string(val) != value {
+				t.Fatalf("key %q: wrong value (found=%v)", key, found)
+			}
+		}
+
+		// Range scans visit keys in lexicographic order
+		keys := make([]string, 0, len(model))
+		for key := range model {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		var scanned []string
+		bt.FindRange(nil, nil, func(key, value []byte) bool {
+			scanned = append(scanned, string(key))
+			return true
+		})
+		if !slices.Equal(scanned, keys) {
+			t.Fatalf("full scan returned %d keys out of order", len(scanned))
+		}
+	}
+	check()
+
+	// Reopen and check again
+	tree.Close()
+	tree, err = bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer tree.Close()
+	bt = tree.Bytes(rootID)
+	check()
+
+	// Delete everything
+	for key := range model {
+		if deleted, _ := bt.Delete([]byte(key)); !deleted {
+			t.Fatalf("Delete(%q) failed", key)
+		}
+	}
+	if count, err := bt.Count(); err != nil || count != 0 {
+		t.Errorf("expected empty tree, got %d entries", count)
+	}
+}
+
+func TestBytesTreeFindRange(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer tree.Close()
+
+	rootID, _ := tree.CreateRoot()
+	bt := tree.Bytes(rootID)
+	for _, key := range []string{"a", "ab", "abc", "b", "ba", "c"} {
+		bt.Insert([]byte(key), []byte(key))
+	}
+
+	var got []string
+	bt.FindRange([]byte("ab"), []byte("b"), func(key, value []byte) bool {
+		got = append(got, string(key))
+		return true
+	})
+	if expected := []string{"ab", "abc", "b"}; !slices.Equal(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestBytesTreeErrors(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer tree.Close()
+
+	rootID, _ := tree.CreateRoot()
+	bt := tree.Bytes(rootID)
+
+	if err := bt.Insert(make([]byte, 600), make([]byte, 600)); !errors.Is(err, bptree2.ErrEntryTooLarge) {
+		t.Errorf("expected ErrEntryTooLarge, got %v", err)
+	}
+
+	// A root holds one key type
+	bt.Insert([]byte("key"), []byte("value"))
+	if err := tree.Insert(rootID, 1, 1, 1); !errors.Is(err, bptree2.ErrKeyType) {
+		t.Errorf("expected ErrKeyType, got %v", err)
+	}
+	otherID, _ := tree.CreateRoot()
+	tree.Insert(otherID, 1, 1, 1)
+	if err := tree.Bytes(otherID).Insert([]byte("key"), nil); !errors.Is(err, bptree2.ErrKeyType) {
+		t.Errorf("expected ErrKeyType, got %v", err)
+	}
+
+	// Reads of the wrong key type fail as well
+	if _, _, err := tree.Find(rootID, 1, 1); !errors.Is(err, bptree2.ErrKeyType) {
+		t.Errorf("Find: expected ErrKeyType, got %v", err)
+	}
+	all := func(key1, key2, value uint64) bool { return true }
+	if err := tree.FindRange(rootID, 0, 0, ^uint64(0), ^uint64(0), all); !errors.Is(err, bptree2.ErrKeyType) {
+		t.Errorf("FindRange: expected ErrKeyType, got %v", err)
+	}
+	if err := tree.FindRangeReverse(rootID, 0, 0, ^uint64(0), ^uint64(0), all); !errors.Is(err, bptree2.ErrKeyType) {
+		t.Errorf("FindRangeReverse: expected ErrKeyType, got %v", err)
+	}
+	cursor, err := tree.Cursor(rootID)
+	if err != nil {
+		t.Fatalf("Cursor failed: %v", err)
+	}
+	defer cursor.Close()
+	moves := map[string]func() bool{
+		"First": cursor.First,
+		"Last":  cursor.Last,
+		"Seek":  func() bool { return cursor.Seek(1, 1) },
+	}
+	for name, move := range moves {
+		if move() || !errors.Is(cursor.Err(), bptree2.ErrKeyType) {
+			t.Errorf("Cursor.%s: expected ErrKeyType, got %v", name, cursor.Err())
+		}
+	}
+
+	// Writes through a read-only transaction fail
+	tx, err := tree.Begin(false)
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	defer tx.Rollback()
+	if val, found, err := tx.Bytes(rootID).Find([]byte("key")); err != nil || !found || string(val) != "value" {
+		t.Errorf("expected value in transaction, got %q (found=%v)", val, found)
+	}
+	if err := tx.Bytes(rootID).Insert([]byte("other"), nil); !errors.Is(err, bptree2.ErrTxReadOnly) {
+		t.Errorf("expected ErrTxReadOnly, got %v", err)
+	}
+}
@@ -0,0 +1,177 @@
+package bptree2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"bptree2/bpager"
+)
+
+var (
+	// ErrTreeExists is returned by CreateTree when a tree has the name already.
+	ErrTreeExists = errors.New("tree already exists")
+
+	// ErrTreeNotFound is returned when no tree has the name.
+	ErrTreeNotFound = errors.New("tree not found")
+)
+
+// The catalog is a []byte-keyed tree at bpager.CatalogRoot. It maps the name
+// of each named tree to its root ID, stored as 8 big-endian bytes.
+
+// CreateTree creates a root tree registered under name and returns its ID.
+// See Tx.CreateTree.
+func (t *BPTree) CreateTree(name string) (RootID, error) {
+	var rootID RootID
+	err := t.update(func(tx *Tx) (err error) {
+		rootID, err = tx.CreateTree(name)
+		return err
+	})
+	return rootID, err
+}
+
+// OpenTree returns the ID of the root tree registered under name.
+// Returns ErrTreeNotFound if there is none.
+func (t *BPTree) OpenTree(name string) (RootID, error) {
+	var rootID RootID
+	err := t.view(func(tx *Tx) (err error) {
+		rootID, err = tx.OpenTree(name)
+		return err
+	})
+	return rootID, err
+}
+
+// DropTree deletes the root tree registered under name and frees its pages.
+// See Tx.DropTree.
+func (t *BPTree) DropTree(name string) error {
+	return t.update(func(tx *Tx) error {
+		return tx.DropTree(name)
+	})
+}
+
+// ListTrees returns the names of all named trees in byte order.
+func (t *BPTree) ListTrees() ([]string, error) {
+	var names []string
+	err := t.view(func(tx *Tx) (err error) {
+		names, err = tx.ListTrees()
+		return err
+	})
+	return names, err
+}
+
+// CreateTree creates a root tree registered under name and returns its ID.
+// The tree is a regular root: it can be used with every method taking a
+// RootID, and counts towards RootCount. Returns ErrTreeExists if the name
+// is taken.
+// If CreateTree fails the transaction should be rolled back.
+func (tx *Tx) CreateTree(name string) (_ RootID, err error) {
+	defer recoverCorrupt(&err)
+	if err := tx.checkWritable(); err != nil {
+		return 0, err
+	}
+	if err := checkTreeName(name); err != nil {
+		return 0, err
+	}
+
+	if _, err := tx.lookupTree(name); err == nil {
+		return 0, fmt.Errorf("%w: %q", ErrTreeExists, name)
+	} else if !errors.Is(err, ErrTreeNotFound) {
+		return 0, err
+	}
+
+	rootID, err := tx.pages.CreateRoot()
+	if err != nil {
+		return 0, err
+	}
+	var value [8]byte
+	binary.BigEndian.PutUint64(value[:], rootID)
+	if err := tx.bytesInsertRoot(bpager.CatalogRoot, []byte(name), value[:]); err != nil {
+		return 0, fmt.Errorf("failed to register tree %q: %w", name, err)
+	}
+	return rootID, nil
+}
+
+// OpenTree returns the ID of the root tree registered under name.
+// Returns ErrTreeNotFound if there is none.
+func (tx *Tx) OpenTree(name string) (_ RootID, err error) {
+	if err := tx.acquire(); err != nil {
+		return 0, err
+	}
+	defer tx.release()
+	defer recoverCorrupt(&err)
+	return tx.lookupTree(name)
+}
+
+// DropTree deletes the root tree registered under name with DeleteRoot,
+// which removes the name. Returns ErrTreeNotFound if there is none.
+// If DropTree fails the transaction should be rolled back.
+func (tx *Tx) DropTree(name string) (err error) {
+	defer recoverCorrupt(&err)
+	if err := tx.checkWritable(); err != nil {
+		return err
+	}
+
+	rootID, err := tx.lookupTree(name)
+	if err != nil {
+		return err
+	}
+	return tx.DeleteRoot(rootID)
+}
+
+// ListTrees returns the names of all named trees in byte order.
+func (tx *Tx) ListTrees() ([]string, error) {
+	var names []string
+	err := tx.bytesScan(bpager.CatalogRoot, nil, nil, func(key, value []byte) bool {
+		names = append(names, string(key))
+		return true
+	})
+	return names, err
+}
+
+// lookupTree returns the root ID registered under name in the catalog.
+func (tx *Tx) lookupTree(name string) (RootID, error) {
+	rootPageID, err := tx.bytesRoot(bpager.CatalogRoot)
+	if err != nil {
+		return 0, err
+	}
+	if rootPageID != 0 {
+		value, found, err := tx.bytesSearch(rootPageID, []byte(name))
+		if err != nil {
+			return 0, err
+		}
+		if found {
+			return binary.BigEndian.Uint64(value), nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %q", ErrTreeNotFound, name)
+}
+
+// unregisterTree removes the name of a root tree from the catalog, if it
+// has one. The catalog is keyed by name, so it is scanned for the ID.
+func (tx *Tx) unregisterTree(rootID RootID) error {
+	var name []byte
+	err := tx.bytesScan(bpager.CatalogRoot, nil, nil, func(key, value []byte) bool {
+		if binary.BigEndian.Uint64(value) == rootID {
+			name = bytes.Clone(key)
+			return false
+		}
+		return true
+	})
+	if err != nil || name == nil {
+		return err
+	}
+	_, err = tx.bytesDeleteRoot(bpager.CatalogRoot, name)
+	return err
+}
+
+// checkTreeName returns an error unless name can be stored in the catalog.
+func checkTreeName(name string) error {
+	if name == "" {
+		return errors.New("tree name is empty")
+	}
+	if len(name)+8 > MaxEntrySize {
+		return fmt.Errorf("%w: tree name of %d bytes (max: %d)", ErrEntryTooLarge, len(name), MaxEntrySize-8)
+	}
+	return nil
+}
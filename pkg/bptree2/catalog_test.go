@@ -0,0 +1,134 @@
+package bptree2_test
+
+import (
+	"bptree2"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestNamedTrees(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	// Named trees share the ID space with plain roots
+	plainID, _ := tree.CreateRoot()
+	orders, err := tree.CreateTree("orders")
+	if err != nil {
+		t.Fatalf("CreateTree failed: %v", err)
+	}
+	if orders == plainID {
+		t.Fatalf("named tree got the ID of a plain root")
+	}
+	if _, err := tree.CreateTree("orders"); !errors.Is(err, bptree2.ErrTreeExists) {
+		t.Errorf("expected ErrTreeExists, got %v", err)
+	}
+	if _, err := tree.CreateTree(""); err == nil {
+		t.Error("CreateTree should reject an empty name")
+	}
+	tree.Insert(orders, 1, 1, 100)
+
+	// Enough names to split the catalog
+	for i := 0; i < 1000; i++ {
+		rootID, err := tree.CreateTree(fmt.Sprintf("customer-%04d", i))
+		if err != nil {
+			t.Fatalf("CreateTree failed: %v", err)
+		}
+		tree.Insert(rootID, uint64(i), 0, uint64(i))
+	}
+	if count := tree.RootCount(); count != 1002 {
+		t.Errorf("expected 1002 roots, got %d", count)
+	}
+	tree.Close()
+
+	tree, err = bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer tree.Close()
+
+	rootID, err := tree.OpenTree("orders")
+	if err != nil || rootID != orders {
+		t.Fatalf("OpenTree: expected %d, got %d, %v", orders, rootID, err)
+	}
+	if val, _, err := tree.Find(rootID, 1, 1); err != nil || val != 100 {
+		t.Errorf("expected 100, got %d", val)
+	}
+	rootID, _ = tree.OpenTree("customer-0500")
+	if val, found, err := tree.Find(rootID, 500, 0); err != nil || !found || val != 500 {
+		t.Errorf("customer-0500: got %d, %v", val, found)
+	}
+	if _, err := tree.OpenTree("missing"); !errors.Is(err, bptree2.ErrTreeNotFound) {
+		t.Errorf("expected ErrTreeNotFound, got %v", err)
+	}
+
+	names, err := tree.ListTrees()
+	if err != nil {
+		t.Fatalf("ListTrees failed: %v", err)
+	}
+	if len(names) != 1001 || names[0] != "customer-0000" || names[1000] != "orders" || !slices.IsSorted(names) {
+		t.Errorf("unexpected names: %d names, first %q", len(names), names[0])
+	}
+
+	// Dropping frees the name and the tree
+	if err := tree.DropTree("orders"); err != nil {
+		t.Fatalf("DropTree failed: %v", err)
+	}
+	if err := tree.DropTree("orders"); !errors.Is(err, bptree2.ErrTreeNotFound) {
+		t.Errorf("second DropTree: expected ErrTreeNotFound, got %v", err)
+	}
+	if _, err := tree.OpenTree("orders"); !errors.Is(err, bptree2.ErrTreeNotFound) {
+		t.Errorf("dropped tree: expected ErrTreeNotFound, got %v", err)
+	}
+	if count := tree.RootCount(); count != 1001 {
+		t.Errorf("expected 1001 roots after DropTree, got %d", count)
+	}
+	recreated, err := tree.CreateTree("orders")
+	if err != nil {
+		t.Fatalf("CreateTree failed: %v", err)
+	}
+	if count, err := tree.Count(recreated); err != nil || count != 0 {
+		t.Errorf("recreated tree should be empty, got %d keys", count)
+	}
+
+	// Deleting a named tree by ID removes the name too, so that the name
+	// does not lead to the root that gets the ID next
+	if err := tree.DeleteRoot(recreated); err != nil {
+		t.Fatalf("DeleteRoot failed: %v", err)
+	}
+	if _, err := tree.OpenTree("orders"); !errors.Is(err, bptree2.ErrTreeNotFound) {
+		t.Errorf("deleted tree: expected ErrTreeNotFound, got %v", err)
+	}
+	reused, _ := tree.CreateRoot()
+	if reused != recreated {
+		t.Fatalf("expected CreateRoot to reuse ID %d, got %d", recreated, reused)
+	}
+	tree.Insert(reused, 1, 1, 1)
+	if rootID, err := tree.OpenTree("orders"); !errors.Is(err, bptree2.ErrTreeNotFound) {
+		t.Errorf("reused ID: expected ErrTreeNotFound, got root %d, %v", rootID, err)
+	}
+	if names, _ := tree.ListTrees(); slices.Contains(names, "orders") {
+		t.Error("ListTrees should not list a deleted tree")
+	}
+
+	// A snapshot keeps seeing the catalog as of its start
+	snap, err := tree.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	defer snap.Release()
+	tree.DropTree("customer-0000")
+	if _, err := snap.OpenTree("customer-0000"); err != nil {
+		t.Errorf("snapshot should still see the dropped tree: %v", err)
+	}
+	if names, _ := tree.ListTrees(); len(names) != 999 {
+		t.Errorf("expected 999 names, got %d", len(names))
+	}
+}
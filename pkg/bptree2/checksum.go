@@ -0,0 +1,48 @@
+package bptree2
+
+import (
+	"bptree2/bpager"
+)
+
+// ErrCorruptPage is returned when a page does not match its checksum.
+// Use errors.As to find the ID of the page. Every method that reads the tree
+// returns it; a Cursor reports it through Err.
+type ErrCorruptPage = bpager.ErrCorruptPage
+
+// VerifyLevel sets which reads of a page verify its checksum.
+type VerifyLevel = bpager.VerifyLevel
+
+const (
+	// VerifyNone never verifies checksums.
+	VerifyNone = bpager.VerifyNone
+
+	// VerifyOnce verifies a page the first time it is read from the file
+	// after Open. This is the default.
+	VerifyOnce = bpager.VerifyOnce
+
+	// VerifyAlways verifies a page every time it is read from the file.
+	VerifyAlways = bpager.VerifyAlways
+)
+
+// SetVerify sets which reads verify the checksum of a page. Pages that have
+// not been flashed yet are never verified.
+func (t *BPTree) SetVerify(level VerifyLevel) {
+	t.pager.SetVerify(level)
+}
+
+// pageError carries an error of the pager through a panic, see Tx.page.
+type pageError struct {
+	err error
+}
+
+// recoverCorrupt turns a panic with a pageError, with which Tx.page reports
+// a page it could not read, into an error in *err. Other panics go on.
+func recoverCorrupt(err *error) {
+	if r := recover(); r != nil {
+		pe, ok := r.(pageError)
+		if !ok {
+			panic(r)
+		}
+		*err = pe.err
+	}
+}
@@ -0,0 +1,249 @@
+package bptree2_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"bptree2"
+	"bptree2/bnode"
+	"bptree2/bpager"
+)
+
+// corruptPage flips a byte in the entries of a page of the file at path.
+func corruptPage(t *testing.T, path string, id bpager.PageID) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	defer f.Close()
+	b := make([]byte, 1)
+	offset := int64(id)*bpager.PageSize + 100
+	if _, err := f.ReadAt(b, offset); err != nil {
+		t.Fatalf("ReadAt failed: %v", err)
+	}
+	b[0] ^= 0x40
+	if _, err := f.WriteAt(b, offset); err != nil {
+		t.Fatalf("WriteAt failed: %v", err)
+	}
+}
+
+// firstLeaf returns the first leaf page of a root tree in the file at path.
+func firstLeaf(t *testing.T, path string, rootID bptree2.RootID) bpager.PageID {
+	t.Helper()
+	p, err := bpager.Open(path)
+	if err != nil {
+		t.Fatalf("bpager.Open failed: %v", err)
+	}
+	defer p.Close()
+	tx := p.Begin(false)
+	defer tx.Rollback()
+	return treeLeaves(t, tx, rootPage(t, tx, rootID))[0]
+}
+
+func TestCorruptPage(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	rootID, _ := tree.CreateRoot()
+	for i := uint64(0); i < 10000; i++ {
+		tree.Insert(rootID, i, 0, i)
+	}
+	tree.Close()
+
+	leaf := firstLeaf(t, path, rootID)
+	corruptPage(t, path, leaf)
+
+	tree, err = bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer tree.Close()
+
+	var corrupt bptree2.ErrCorruptPage
+	err = tree.FindRange(rootID, 0, 0, ^uint64(0), ^uint64(0), func(key1, key2, value uint64) bool {
+		return true
+	})
+	if !errors.As(err, &corrupt) || corrupt.PageID != leaf {
+		t.Errorf("FindRange: expected ErrCorruptPage for page %d, got %v", leaf, err)
+	}
+	if err := tree.Insert(rootID, 0, 1, 1); !errors.As(err, &corrupt) {
+		t.Errorf("Insert: expected ErrCorruptPage, got %v", err)
+	}
+	if _, found, err := tree.Find(rootID, 0, 0); found || !errors.As(err, &corrupt) {
+		t.Errorf("Find: expected ErrCorruptPage, got %v (found=%v)", err, found)
+	}
+	if _, err := tree.CountRange(rootID, 0, 0, 10, 0); !errors.As(err, &corrupt) {
+		t.Errorf("CountRange: expected ErrCorruptPage, got %v", err)
+	}
+	if deleted, err := tree.Delete(rootID, 0, 0); deleted || !errors.As(err, &corrupt) {
+		t.Errorf("Delete: expected ErrCorruptPage, got %v (deleted=%v)", err, deleted)
+	}
+
+	// The rest of the tree can still be read and written
+	if v, found, err := tree.Find(rootID, 9999, 0); err != nil || !found || v != 9999 {
+		t.Errorf("expected 9999, got %d (found=%v)", v, found)
+	}
+	if err := tree.Insert(rootID, 20000, 0, 1); err != nil {
+		t.Errorf("Insert failed: %v", err)
+	}
+
+	// Without verification the corrupt page is read as it is
+	tree.SetVerify(bptree2.VerifyNone)
+	err = tree.FindRange(rootID, 0, 0, ^uint64(0), ^uint64(0), func(key1, key2, value uint64) bool {
+		return true
+	})
+	if err != nil {
+		t.Errorf("FindRange with VerifyNone failed: %v", err)
+	}
+}
+
+func TestTreeLoop(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	rootID, _ := tree.CreateRoot()
+	for i := uint64(0); i < 10000; i++ {
+		tree.Insert(rootID, i, 0, i)
+	}
+	tree.Close()
+
+	// Point the first child of the root back at the root
+	p, err := bpager.Open(path)
+	if err != nil {
+		t.Fatalf("bpager.Open failed: %v", err)
+	}
+	tx := p.Begin(true)
+	rootPageID := rootPage(t, tx, rootID)
+	bnode.NewInternalNode(getPage(t, tx.GetPageForWrite, rootPageID), false).SetChild(0, rootPageID)
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	p.Close()
+
+	tree, err = bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer tree.Close()
+
+	// Descents into the loop end with an error instead of running forever
+	if _, _, err := tree.Find(rootID, 0, 0); err == nil {
+		t.Error("Find should fail in a loop")
+	}
+	err = tree.FindRange(rootID, 0, 0, 10, 0, func(key1, key2, value uint64) bool {
+		return true
+	})
+	if err == nil {
+		t.Error("FindRange should fail in a loop")
+	}
+	cursor, err := tree.Cursor(rootID)
+	if err != nil {
+		t.Fatalf("Cursor failed: %v", err)
+	}
+	defer cursor.Close()
+	if cursor.First() || cursor.Err() == nil {
+		t.Error("First should fail in a loop")
+	}
+	if cursor.Seek(0, 0) || cursor.Err() == nil {
+		t.Error("Seek should fail in a loop")
+	}
+
+	// Keys outside the loop can still be read
+	if v, found, err := tree.Find(rootID, 9999, 0); err != nil || !found || v != 9999 {
+		t.Errorf("expected 9999, got %d (found=%v, %v)", v, found, err)
+	}
+}
+
+func TestCorruptPageTx(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	rootID, _ := tree.CreateRoot()
+	for i := uint64(0); i < 10000; i++ {
+		tree.Insert(rootID, i, 0, i)
+	}
+	tree.Close()
+
+	corruptPage(t, path, firstLeaf(t, path, rootID))
+
+	tree, err = bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer tree.Close()
+
+	// The methods of transactions, snapshots and cursors return the corrupt
+	// page as an error instead of panicking
+	var corrupt bptree2.ErrCorruptPage
+	snap, err := tree.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	defer snap.Release()
+	if v, found, err := snap.Find(rootID, 0, 0); found || !errors.As(err, &corrupt) {
+		t.Errorf("Find: expected ErrCorruptPage, got %v (value %d)", err, v)
+	}
+	if _, _, err := snap.Rank(rootID, 0, 0); !errors.As(err, &corrupt) {
+		t.Errorf("Rank: expected ErrCorruptPage, got %v", err)
+	}
+	if _, _, _, _, err := snap.Select(rootID, 0); !errors.As(err, &corrupt) {
+		t.Errorf("Select: expected ErrCorruptPage, got %v", err)
+	}
+	all, allErr := snap.AllErr(rootID)
+	for range all {
+	}
+	if err := allErr(); !errors.As(err, &corrupt) {
+		t.Errorf("AllErr: expected ErrCorruptPage, got %v", err)
+	}
+	seq, errf := snap.RangeErr(rootID, bptree2.Key{}, bptree2.Key{Key1: 10})
+	for range seq {
+	}
+	if err := errf(); !errors.As(err, &corrupt) {
+		t.Errorf("RangeErr: expected ErrCorruptPage, got %v", err)
+	}
+
+	cursor, err := tree.Cursor(rootID)
+	if err != nil {
+		t.Fatalf("Cursor failed: %v", err)
+	}
+	defer cursor.Close()
+	if cursor.First() {
+		t.Error("First should not position the cursor on a corrupt page")
+	}
+	if err := cursor.Err(); !errors.As(err, &corrupt) {
+		t.Errorf("Cursor: expected ErrCorruptPage, got %v", err)
+	}
+	if !cursor.Last() {
+		t.Errorf("Last failed: %v", cursor.Err())
+	}
+
+	tx, err := tree.Begin(true)
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	defer tx.Rollback()
+	if err := tx.Insert(rootID, 0, 1, 1); !errors.As(err, &corrupt) {
+		t.Errorf("Insert: expected ErrCorruptPage, got %v", err)
+	}
+	if _, err := tx.DeleteRange(rootID, 0, 0, 10, 0); !errors.As(err, &corrupt) {
+		t.Errorf("DeleteRange: expected ErrCorruptPage, got %v", err)
+	}
+	if _, found, err := tx.FindBlob(rootID, 0, 0); found || !errors.As(err, &corrupt) {
+		t.Errorf("FindBlob: expected ErrCorruptPage, got %v (found=%v)", err, found)
+	}
+}
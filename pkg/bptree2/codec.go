@@ -0,0 +1,102 @@
+package bptree2
+
+import (
+	"encoding/binary"
+	"math"
+	"time"
+)
+
+// KeyCodec maps keys of type K to composite keys.
+// The mapping must preserve order: a < b exactly when the composite key of
+// a is smaller than that of b, so that range scans visit keys in order.
+type KeyCodec[K any] interface {
+	EncodeKey(k K) (key1, key2 uint64)
+	DecodeKey(key1, key2 uint64) K
+}
+
+// ValueCodec maps values of type V to stored uint64 values.
+type ValueCodec[V any] interface {
+	EncodeValue(v V) uint64
+	DecodeValue(value uint64) V
+}
+
+// signBit flips signed integers into unsigned order.
+const signBit = 1 << 63
+
+// Uint64Codec stores uint64 keys in key1 and values as they are.
+type Uint64Codec struct{}
+
+func (Uint64Codec) EncodeKey(k uint64) (uint64, uint64) { return k, 0 }
+func (Uint64Codec) DecodeKey(key1, key2 uint64) uint64  { return key1 }
+func (Uint64Codec) EncodeValue(v uint64) uint64         { return v }
+func (Uint64Codec) DecodeValue(value uint64) uint64     { return value }
+
+// Int64Codec stores int64 keys and values. Keys are biased so that
+// negative numbers sort before positive ones.
+type Int64Codec struct{}
+
+func (Int64Codec) EncodeKey(k int64) (uint64, uint64) { return uint64(k) ^ signBit, 0 }
+func (Int64Codec) DecodeKey(key1, key2 uint64) int64  { return int64(key1 ^ signBit) }
+func (Int64Codec) EncodeValue(v int64) uint64         { return uint64(v) }
+func (Int64Codec) DecodeValue(value uint64) int64     { return int64(value) }
+
+// Float64Codec stores float64 keys and values.
+// Keys sort numerically, with -0 just before +0 and NaNs beyond the
+// infinities on either side, depending on their sign bit.
+type Float64Codec struct{}
+
+func (Float64Codec) EncodeKey(k float64) (uint64, uint64) {
+	bits := math.Float64bits(k)
+	if bits&signBit != 0 {
+		return ^bits, 0 // Negative: larger magnitudes sort first
+	}
+	return bits | signBit, 0
+}
+
+func (Float64Codec) DecodeKey(key1, key2 uint64) float64 {
+	if key1&signBit != 0 {
+		return math.Float64frombits(key1 &^ signBit)
+	}
+	return math.Float64frombits(^key1)
+}
+
+func (Float64Codec) EncodeValue(v float64) uint64     { return math.Float64bits(v) }
+func (Float64Codec) DecodeValue(value uint64) float64 { return math.Float64frombits(value) }
+
+// TimeCodec stores time.Time keys and values.
+//
+// Keys keep the full range and nanosecond precision of time.Time: the
+// seconds since the Unix epoch go into key1 and the nanoseconds into key2.
+// Values are stored as Unix nanoseconds, which covers the years 1678 to 2262.
+// Decoded times are in UTC; the location and monotonic clock reading are
+// not stored.
+type TimeCodec struct{}
+
+func (TimeCodec) EncodeKey(k time.Time) (uint64, uint64) {
+	return uint64(k.Unix()) ^ signBit, uint64(k.Nanosecond())
+}
+
+func (TimeCodec) DecodeKey(key1, key2 uint64) time.Time {
+	return time.Unix(int64(key1^signBit), int64(key2)).UTC()
+}
+
+func (TimeCodec) EncodeValue(v time.Time) uint64     { return uint64(v.UnixNano()) }
+func (TimeCodec) DecodeValue(value uint64) time.Time { return time.Unix(0, int64(value)).UTC() }
+
+// UUID is a 16-byte universally unique identifier.
+type UUID [16]byte
+
+// UUIDCodec stores UUID keys, ordered bytewise: the first eight bytes go
+// into key1 and the last eight into key2. A UUID does not fit in a value.
+type UUIDCodec struct{}
+
+func (UUIDCodec) EncodeKey(k UUID) (uint64, uint64) {
+	return binary.BigEndian.Uint64(k[0:8]), binary.BigEndian.Uint64(k[8:16])
+}
+
+func (UUIDCodec) DecodeKey(key1, key2 uint64) UUID {
+	var u UUID
+	binary.BigEndian.PutUint64(u[0:8], key1)
+	binary.BigEndian.PutUint64(u[8:16], key2)
+	return u
+}
@@ -0,0 +1,317 @@
+package bptree2
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"bptree2/bnode"
+	"bptree2/bpager"
+)
+
+// Compact moves the pages in use to the front of the file and shrinks the
+// file, cutting off the free pages that gather at its end. It waits for the
+// pages of deleted trees to be reclaimed first.
+//
+// Compact holds the write lock while it moves pages, which takes time in
+// proportion to the size of the file. The moves are committed in batches of
+// about relocateBatch pages, so only a batch is held in memory; if Compact
+// fails, the batches committed before stay moved. Pages that open
+// snapshots can still see are only freed once the snapshots are released,
+// so they keep the file from shrinking past them until the next Compact.
+func (t *BPTree) Compact() error {
+	if err := t.Reclaim(); err != nil {
+		return err
+	}
+	err := t.update(func(tx *Tx) error {
+		return tx.relocate()
+	})
+	if err != nil {
+		return fmt.Errorf("failed to compact: %w", err)
+	}
+
+	t.wmu.Lock()
+	defer t.wmu.Unlock()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return truncate(t.pager)
+}
+
+// truncate runs Truncate on the pager, returning a corrupt free page as an error.
+func truncate(p *bpager.Pager) (err error) {
+	defer recoverCorrupt(&err)
+	return p.Truncate()
+}
+
+// Compact writes a compacted copy of the file at src to dst, which must not
+// exist yet. src must not be open; it is opened to recover and upgrade it
+// before it is copied, but is not compacted itself. If Compact fails, dst
+// is removed again.
+func Compact(src, dst string) (err error) {
+	t, err := Open(src)
+	if err != nil {
+		return err
+	}
+	// Close flashes, so the file holds every change and the log is empty
+	if err := t.Close(); err != nil {
+		return err
+	}
+	if err := copyFile(src, dst); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(dst)
+			os.Remove(dst + bpager.WALSuffix)
+		}
+	}()
+
+	t, err = Open(dst)
+	if err != nil {
+		return err
+	}
+	if err := t.Compact(); err != nil {
+		t.Close()
+		return err
+	}
+	return t.Close()
+}
+
+// copyFile copies the file at src to a new file at dst, which is removed
+// again if the copy fails.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", src, err)
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", dst, err)
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return fmt.Errorf("failed to copy %s: %w", src, err)
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return fmt.Errorf("failed to sync %s: %w", dst, err)
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return fmt.Errorf("failed to close %s: %w", dst, err)
+	}
+	return nil
+}
+
+// relocateBatch is the number of pages a relocation modifies before it
+// commits them and goes on in a new transaction.
+const relocateBatch = 1024
+
+// relocation moves the pages of trees past the limit to the front of the
+// file. Every page is moved before the pages below it, and whoever refers
+// to it is pointed at the copy right away, so that the trees are whole
+// whenever a batch is committed. A shared page is only moved once all its
+// references have been seen; refs holds those seen so far.
+type relocation struct {
+	tx    *Tx
+	pages *bpager.Relocation
+	refs  map[bpager.PageID][]pageRef
+}
+
+// pageRef is a reference to a page: the root page of rootID if pageID is 0,
+// and otherwise the child at index of the internal node pageID, or the blob
+// at index of the leaf pageID.
+type pageRef struct {
+	rootID RootID
+	pageID bpager.PageID
+	index  int
+}
+
+// relocate moves every page in use past the number of pages in use to a free
+// page before it, rewriting the references to it, so that the free pages
+// gather at the end of the file.
+func (tx *Tx) relocate() error {
+	// The queue holds pages of trees that are not reachable from a root
+	for more := tx.pages.ReclaimPending(); more; {
+		var err error
+		if more, err = tx.reclaim(reclaimBatch); err != nil {
+			return err
+		}
+		if _, err := tx.checkpoint(); err != nil {
+			return err
+		}
+	}
+
+	pages, err := tx.pages.BeginRelocation()
+	if err != nil {
+		return err
+	}
+	r := &relocation{tx: tx, pages: pages, refs: make(map[bpager.PageID][]pageRef)}
+	for more := true; more; {
+		if more, err = tx.pages.SortFreePages(relocateBatch); err != nil {
+			return err
+		}
+		if err := r.checkpoint(); err != nil {
+			return err
+		}
+	}
+	if err := tx.pages.RelocateTables(pages.Limit()); err != nil {
+		return err
+	}
+
+	// Buckets are roots of their own, so this reaches every tree
+	for rootID := range tx.pages.RootLimit() {
+		if err := r.root(rootID); err != nil {
+			return err
+		}
+	}
+	if err := r.root(bpager.CatalogRoot); err != nil {
+		return err
+	}
+
+	// A shared page with references the walk did not see stays where it
+	// is, but the pages below it are moved
+	for len(r.refs) > 0 {
+		for pageID, refs := range r.refs {
+			delete(r.refs, pageID)
+			if !r.blob(refs[0]) {
+				if err := r.below(pageID); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// checkpoint commits the changes of the transaction once it has modified
+// relocateBatch pages, and goes on in a new pager transaction under the
+// same write lock. Like Commit, it flashes once committed pages pile up.
+// Returns true if it committed.
+func (tx *Tx) checkpoint() (bool, error) {
+	if tx.pages.DirtyCount() < relocateBatch {
+		return false, nil
+	}
+	if err := tx.pages.Commit(); err != nil {
+		return false, err
+	}
+	var err error
+	if tx.tree.pager.DirtyCount() > maxDirtyPages {
+		err = tx.tree.flash()
+	}
+	tx.pages = tx.tree.pager.Begin(true)
+	return true, err
+}
+
+// checkpoint runs checkpoint on the transaction, and continues the
+// relocation in the new one.
+func (r *relocation) checkpoint() error {
+	committed, err := r.tx.checkpoint()
+	if err != nil || !committed {
+		return err
+	}
+	return r.tx.pages.ResumeRelocation(r.pages)
+}
+
+// root relocates the tree of a root.
+func (r *relocation) root(rootID RootID) error {
+	pageID := r.tx.rootPage(rootID)
+	if pageID == 0 {
+		return nil
+	}
+	return r.visit(pageRef{rootID: rootID}, pageID)
+}
+
+// visit relocates the page ref refers to and the pages below it, or, if the
+// page is shared and other references to it are still to come, records ref.
+func (r *relocation) visit(ref pageRef, pageID bpager.PageID) error {
+	if err := r.checkpoint(); err != nil {
+		return err
+	}
+
+	refs := []pageRef{ref}
+	if r.tx.shared(pageID) {
+		count, err := r.tx.pages.RefCount(pageID)
+		if err != nil {
+			return err
+		}
+		refs = append(r.refs[pageID], ref)
+		if uint64(len(refs)) < count {
+			r.refs[pageID] = refs
+			return nil
+		}
+		delete(r.refs, pageID)
+	}
+
+	blob := r.blob(ref)
+	var newID bpager.PageID
+	var err error
+	if blob {
+		newID, err = r.tx.pages.RelocateOverflow(pageID, r.pages.Limit())
+	} else {
+		newID, err = r.tx.pages.RelocatePage(pageID, r.pages.Limit())
+	}
+	if err != nil {
+		return err
+	}
+	if newID != pageID {
+		for _, ref := range refs {
+			if err := r.set(ref, newID); err != nil {
+				return err
+			}
+		}
+	}
+	if blob {
+		return nil
+	}
+	return r.below(newID)
+}
+
+// below relocates the children of an internal node, or the blobs of a leaf.
+// Pages are read again after each visit, which may commit a batch.
+func (r *relocation) below(pageID bpager.PageID) error {
+	data := r.tx.page(pageID)
+	if bnode.GetNodeType(data) != bnode.NodeTypeLeaf {
+		for i, child := range nodeChildren(data) {
+			if err := r.visit(pageRef{pageID: pageID, index: i}, child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for i := range int(bnode.GetKeyCount(data)) {
+		leaf := bnode.NewLeafNode(r.tx.page(pageID), false)
+		if leaf.GetFlagsAt(i)&bnode.FlagBlob == 0 {
+			continue
+		}
+		if err := r.visit(pageRef{pageID: pageID, index: i}, leaf.GetValueAt(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// blob returns true if ref refers to the blob of a leaf entry.
+func (r *relocation) blob(ref pageRef) bool {
+	return ref.pageID != 0 && bnode.GetNodeType(r.tx.page(ref.pageID)) == bnode.NodeTypeLeaf
+}
+
+// set points ref at newID.
+func (r *relocation) set(ref pageRef, newID bpager.PageID) error {
+	if ref.pageID == 0 {
+		return r.tx.pages.SetRootPage(ref.rootID, newID)
+	}
+	data := r.tx.pageForWrite(ref.pageID)
+	if bnode.GetNodeType(data) != bnode.NodeTypeLeaf {
+		setChildAt(data, ref.index, newID)
+		return nil
+	}
+	leaf := bnode.NewLeafNode(data, false)
+	leaf.PutWithFlags(leaf.GetKey1At(ref.index), leaf.GetKey2At(ref.index), newID, leaf.GetFlagsAt(ref.index))
+	return nil
+}
@@ -0,0 +1,324 @@
+package bptree2_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"bptree2"
+	"bptree2/bpager"
+)
+
+// buildSparseFile fills a file with many trees and deletes most of them,
+// leaving a blob, a bucket, a named tree and a fork among the survivors.
+// Returns the IDs of the roots kept.
+func buildSparseFile(t *testing.T, tree *bptree2.BPTree) []bptree2.RootID {
+	t.Helper()
+	roots := make([]bptree2.RootID, 10)
+	for i := range roots {
+		roots[i], _ = tree.CreateRoot()
+	}
+	// Interleaved, so the pages of the roots kept spread over the file
+	for j := uint64(0); j < 10000; j++ {
+		for i, rootID := range roots {
+			tree.Insert(rootID, j, 0, j+uint64(i))
+		}
+	}
+
+	blob := bytes.Repeat([]byte("blob"), 3000)
+	if err := tree.InsertBlob(roots[3], 20000, 0, blob); err != nil {
+		t.Fatalf("InsertBlob failed: %v", err)
+	}
+	bucket, err := tree.CreateBucket(roots[7], 20000, 0)
+	if err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	for j := uint64(0); j < 2000; j++ {
+		bucket.Insert(j, 1, j)
+	}
+	named, err := tree.CreateTree("named")
+	if err != nil {
+		t.Fatalf("CreateTree failed: %v", err)
+	}
+	for j := uint64(0); j < 2000; j++ {
+		tree.Insert(named, j, 2, j)
+	}
+	fork, err := tree.ForkRoot(roots[3])
+	if err != nil {
+		t.Fatalf("ForkRoot failed: %v", err)
+	}
+	tree.Insert(fork, 5000, 0, 1)
+
+	for i, rootID := range roots {
+		if i != 3 && i != 7 {
+			if err := tree.DeleteRoot(rootID); err != nil {
+				t.Fatalf("DeleteRoot failed: %v", err)
+			}
+		}
+	}
+	if err := tree.Reclaim(); err != nil {
+		t.Fatalf("Reclaim failed: %v", err)
+	}
+	if err := tree.Flash(); err != nil {
+		t.Fatalf("Flash failed: %v", err)
+	}
+	return []bptree2.RootID{roots[3], roots[7], fork, named}
+}
+
+// checkSparseFile checks the trees left by buildSparseFile.
+func checkSparseFile(t *testing.T, tree *bptree2.BPTree, roots []bptree2.RootID) {
+	t.Helper()
+	src, kept, fork, named := roots[0], roots[1], roots[2], roots[3]
+
+	for _, rootID := range []bptree2.RootID{src, kept} {
+		if count, err := tree.Count(rootID); err != nil || count != 10001 {
+			t.Errorf("root %d: expected 10001 keys, got %d", rootID, count)
+		}
+	}
+	for j := uint64(0); j < 10000; j += 97 {
+		if v, ok, err := tree.Find(src, j, 0); err != nil || !ok || v != j+3 {
+			t.Errorf("root %d: key %d: expected %d, got %d (found=%v)", src, j, j+3, v, ok)
+		}
+		if v, ok, err := tree.Find(kept, j, 0); err != nil || !ok || v != j+7 {
+			t.Errorf("root %d: key %d: expected %d, got %d (found=%v)", kept, j, j+7, v, ok)
+		}
+		if v, ok, err := tree.Find(fork, j, 0); err != nil || !ok || v != j+3 {
+			t.Errorf("fork: key %d: expected %d, got %d (found=%v)", j, j+3, v, ok)
+		}
+	}
+
+	blob := bytes.Repeat([]byte("blob"), 3000)
+	for _, rootID := range []bptree2.RootID{src, fork} {
+		if data, found, err := tree.FindBlob(rootID, 20000, 0); err != nil || !found || !bytes.Equal(data, blob) {
+			t.Errorf("root %d: blob of %d bytes (found=%v)", rootID, len(data), found)
+		}
+	}
+	if _, ok, err := tree.Find(src, 5000, 0); err != nil || !ok {
+		t.Error("source: key 5000 missing")
+	}
+	if count, err := tree.Count(fork); err != nil || count != 10001 {
+		t.Errorf("fork: expected 10001 keys, got %d", count)
+	}
+
+	bucket, err := tree.Bucket(kept, 20000, 0)
+	if err != nil {
+		t.Fatalf("Bucket failed: %v", err)
+	}
+	if count, err := bucket.Count(); err != nil || count != 2000 {
+		t.Errorf("bucket: expected 2000 keys, got %d", count)
+	}
+	if id, err := tree.OpenTree("named"); err != nil || id != named {
+		t.Errorf("OpenTree: expected %d, got %d (%v)", named, id, err)
+	}
+	if count, err := tree.Count(named); err != nil || count != 2000 {
+		t.Errorf("named tree: expected 2000 keys, got %d", count)
+	}
+}
+
+func fileSize(t *testing.T, path string) int64 {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	return info.Size()
+}
+
+func TestCompact(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	roots := buildSparseFile(t, tree)
+	before := fileSize(t, path)
+
+	if err := tree.Compact(); err != nil {
+		t.Fatalf("Compact failed: %v", err)
+	}
+	after := fileSize(t, path)
+	if after > before/2 {
+		t.Errorf("file should shrink to less than half: %d bytes before, %d after", before, after)
+	}
+	checkSparseFile(t, tree, roots)
+
+	// The compacted file takes writes and survives a restart
+	for j := uint64(0); j < 10000; j++ {
+		tree.Insert(roots[1], 30000+j, 0, j)
+	}
+	tree.Close()
+	tree, err = bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer tree.Close()
+	if count, err := tree.Count(roots[1]); err != nil || count != 20001 {
+		t.Errorf("expected 20001 keys, got %d", count)
+	}
+	if _, err := tree.DeleteRange(roots[1], 30000, 0, 39999, 0); err != nil {
+		t.Fatalf("DeleteRange failed: %v", err)
+	}
+	checkSparseFile(t, tree, roots)
+}
+
+func TestCompactInBatches(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	// Two trees of blobs, interleaved, so that thousands of pages of the one
+	// kept have to move: several batches of the relocation
+	a, _ := tree.CreateRoot()
+	b, _ := tree.CreateRoot()
+	blob := bytes.Repeat([]byte("x"), 4*bpager.OverflowCapacity)
+	tx, err := tree.Begin(true)
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	for j := uint64(0); j < 2000; j++ {
+		for _, rootID := range []bptree2.RootID{a, b} {
+			if err := tx.InsertBlob(rootID, j, 0, blob); err != nil {
+				t.Fatalf("InsertBlob failed: %v", err)
+			}
+			if err := tx.Insert(rootID, j, 1, j); err != nil {
+				t.Fatalf("Insert failed: %v", err)
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	fork, err := tree.ForkRoot(a)
+	if err != nil {
+		t.Fatalf("ForkRoot failed: %v", err)
+	}
+	tree.Insert(fork, 5000, 0, 1)
+	if err := tree.DeleteRoot(b); err != nil {
+		t.Fatalf("DeleteRoot failed: %v", err)
+	}
+	if err := tree.Flash(); err != nil {
+		t.Fatalf("Flash failed: %v", err)
+	}
+	before := fileSize(t, path)
+
+	if err := tree.Compact(); err != nil {
+		t.Fatalf("Compact failed: %v", err)
+	}
+	if after := fileSize(t, path); after > before/2+before/10 {
+		t.Errorf("file should shrink to about half: %d bytes before, %d after", before, after)
+	}
+
+	check := func() {
+		t.Helper()
+		for rootID, count := range map[bptree2.RootID]int{a: 4000, fork: 4001} {
+			if n, err := tree.Count(rootID); err != nil || n != count {
+				t.Errorf("root %d: expected %d keys, got %d (%v)", rootID, count, n, err)
+			}
+			for j := uint64(0); j < 2000; j += 97 {
+				if v, ok, err := tree.Find(rootID, j, 1); err != nil || !ok || v != j {
+					t.Errorf("root %d: key %d: expected %d, got %d (found=%v)", rootID, j, j, v, ok)
+				}
+				if data, found, err := tree.FindBlob(rootID, j, 0); err != nil || !found || !bytes.Equal(data, blob) {
+					t.Errorf("root %d: blob %d of %d bytes (found=%v, %v)", rootID, j, len(data), found, err)
+				}
+			}
+		}
+	}
+	check()
+
+	// Every batch reached the file
+	tree.Close()
+	tree, err = bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer tree.Close()
+	check()
+}
+
+func TestCompactFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "src.db")
+	dst := filepath.Join(tmpDir, "dst.db")
+
+	tree, err := bptree2.Open(src)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	roots := buildSparseFile(t, tree)
+	tree.Close()
+
+	if err := bptree2.Compact(src, dst); err != nil {
+		t.Fatalf("Compact failed: %v", err)
+	}
+	if before, after := fileSize(t, src), fileSize(t, dst); after > before/2 {
+		t.Errorf("copy should be less than half the size: %d bytes, copy %d", before, after)
+	}
+	if err := bptree2.Compact(src, dst); err == nil {
+		t.Error("expected an error for an existing destination")
+	}
+
+	// A failed compaction leaves no copy behind, so it can be retried
+	failed := filepath.Join(tmpDir, "failed.db")
+	if err := os.MkdirAll(filepath.Join(failed+bpager.WALSuffix, "blocked"), 0755); err != nil {
+		t.Fatalf("MkdirAll failed: %v", err)
+	}
+	if err := bptree2.Compact(src, failed); err == nil {
+		t.Error("expected an error when the log of the copy cannot be opened")
+	}
+	if _, err := os.Stat(failed); !os.IsNotExist(err) {
+		t.Errorf("a failed compaction should remove its copy: %v", err)
+	}
+	os.RemoveAll(failed + bpager.WALSuffix)
+	if err := bptree2.Compact(src, failed); err != nil {
+		t.Errorf("Compact after a failed attempt failed: %v", err)
+	}
+
+	for _, path := range []string{src, dst} {
+		tree, err := bptree2.Open(path)
+		if err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+		checkSparseFile(t, tree, roots)
+		tree.Close()
+	}
+
+	// Every page of the copy is accounted for: once its trees are gone,
+	// compacting leaves a file of the initial size
+	tree, err = bptree2.Open(dst)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if err := tree.DropTree("named"); err != nil {
+		t.Fatalf("DropTree failed: %v", err)
+	}
+	for _, rootID := range roots[:3] {
+		if err := tree.DeleteRoot(rootID); err != nil {
+			t.Fatalf("DeleteRoot failed: %v", err)
+		}
+	}
+	if err := tree.Compact(); err != nil {
+		t.Fatalf("Compact failed: %v", err)
+	}
+	if size := fileSize(t, dst); size != bpager.InitialFileSize {
+		t.Errorf("expected %d bytes, got %d", bpager.InitialFileSize, size)
+	}
+	tree.Close()
+
+	p, err := bpager.Open(dst)
+	if err != nil {
+		t.Fatalf("bpager.Open failed: %v", err)
+	}
+	defer p.Close()
+	// The meta page, the catalog and the tables of the pager remain
+	if n := p.PageCount(); n > 8 {
+		t.Errorf("expected every tree page to be freed, %d pages in use", n)
+	}
+}
@@ -0,0 +1,275 @@
+package bptree2_test
+
+import (
+	"bptree2"
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// These tests are meant to be run with the race detector (make test-race).
+// They mix readers and writers on the same tree without any external locking.
+
+func TestConcurrentReadWrite(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer tree.Close()
+
+	rootID, _ := tree.CreateRoot()
+
+	// Enough inserts to grow the file (and remap the mmap) several times
+	n := 20000
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+
+	// Single writer
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(done)
+		for i := 0; i < n; i++ {
+			if err := tree.Insert(rootID, uint64(i), uint64(i*2), uint64(i*10)); err != nil {
+				t.Errorf("Insert failed at %d: %v", i, err)
+				return
+			}
+		}
+	}()
+
+	// Readers run until the writer is done
+	for r := 0; r < 8; r++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(seed))
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				key1 := uint64(rng.Intn(n))
+				if val, found, err := tree.Find(rootID, key1, key1*2); err != nil || found && val != key1*10 {
+					t.Errorf("key (%d, %d): expected %d, got %d", key1, key1*2, key1*10, val)
+					return
+				}
+
+				// Range results must always be sorted and consistent
+				var last uint64
+				first := true
+				err := tree.FindRange(rootID, key1, 0, key1+50, ^uint64(0), func(key1, key2, value uint64) bool {
+					if !first && key1 <= last {
+						t.Errorf("range out of order: %d after %d", key1, last)
+						return false
+					}
+					if value != key1*10 {
+						t.Errorf("key (%d, %d): expected %d, got %d", key1, key2, key1*10, value)
+						return false
+					}
+					last, first = key1, false
+					return true
+				})
+				if err != nil {
+					t.Errorf("FindRange failed: %v", err)
+					return
+				}
+			}
+		}(int64(r))
+	}
+
+	wg.Wait()
+
+	if count, err := tree.Count(rootID); err != nil || count != n {
+		t.Errorf("expected count %d, got %d", n, count)
+	}
+}
+
+func TestConcurrentMixedWorkload(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer tree.Close()
+
+	numRoots := 4
+	roots := make([]bptree2.RootID, numRoots)
+	for i := range roots {
+		roots[i], _ = tree.CreateRoot()
+	}
+
+	var wg sync.WaitGroup
+	perWriter := 3000
+
+	// One writer per root, each inserting and then deleting half its keys
+	for w := 0; w < numRoots; w++ {
+		wg.Add(1)
+		go func(rootID bptree2.RootID) {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				if err := tree.Insert(rootID, uint64(i), uint64(i), uint64(i)); err != nil {
+					t.Errorf("Insert failed: %v", err)
+					return
+				}
+			}
+			for i := 0; i < perWriter; i += 2 {
+				if deleted, err := tree.Delete(rootID, uint64(i), uint64(i)); err != nil || !deleted {
+					t.Errorf("Delete(%d, %d) should return true", i, i)
+					return
+				}
+			}
+		}(roots[w])
+	}
+
+	// Readers over all roots
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(seed))
+			for i := 0; i < 2000; i++ {
+				rootID := roots[rng.Intn(numRoots)]
+				key := uint64(rng.Intn(perWriter))
+				if val, found, err := tree.Find(rootID, key, key); err != nil || found && val != key {
+					t.Errorf("key (%d, %d): expected %d, got %d", key, key, key, val)
+					return
+				}
+				if i%100 == 0 {
+					tree.Count(rootID)
+					tree.RootCount()
+				}
+			}
+		}(int64(r))
+	}
+
+	// Periodic flash and root management alongside the workload
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			if err := tree.Flash(); err != nil {
+				t.Errorf("Flash failed: %v", err)
+				return
+			}
+			extra, err := tree.CreateRoot()
+			if err != nil {
+				t.Errorf("CreateRoot failed: %v", err)
+				return
+			}
+			if err := tree.DeleteRoot(extra); err != nil {
+				t.Errorf("DeleteRoot failed: %v", err)
+				return
+			}
+		}
+	}()
+
+	wg.Wait()
+
+	for _, rootID := range roots {
+		if count, err := tree.Count(rootID); err != nil || count != perWriter/2 {
+			t.Errorf("root %d: expected count %d, got %d", rootID, perWriter/2, count)
+		}
+		for i := 1; i < perWriter; i += 2 {
+			if val, found, err := tree.Find(rootID, uint64(i), uint64(i)); err != nil || !found || val != uint64(i) {
+				t.Fatalf("root %d key (%d, %d): expected %d, got %d (found=%v)", rootID, i, i, i, val, found)
+			}
+		}
+	}
+}
+
+func TestConcurrentNestedRead(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer tree.Close()
+
+	rootID, _ := tree.CreateRoot()
+	n := 5000
+	for i := 0; i < n; i++ {
+		tree.Insert(rootID, uint64(i), 0, uint64(i))
+	}
+	bytesRoot, _ := tree.CreateRoot()
+	bt := tree.Bytes(bytesRoot)
+	for i := 0; i < 500; i++ {
+		bt.Insert([]byte(fmt.Sprintf("key%05d", i)), []byte("value"))
+	}
+
+	// A writer that flashes all the time, so that Flash waits for the lock
+	// whenever the readers below take it
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := n; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if err := tree.Insert(rootID, uint64(i), 0, uint64(i)); err != nil {
+				t.Errorf("Insert failed: %v", err)
+				return
+			}
+			if err := tree.Flash(); err != nil {
+				t.Errorf("Flash failed: %v", err)
+				return
+			}
+		}
+	}()
+
+	// Reads nested in callbacks and loop bodies must not deadlock with it,
+	// and neither must a Flash in the loop body itself
+	find := func(key uint64) {
+		if val, found, err := tree.Find(rootID, key, 0); err != nil || !found || val != key {
+			t.Errorf("key %d: expected %d, got %d (found=%v)", key, key, val, found)
+		}
+	}
+	count := 0
+	err = tree.FindRange(rootID, 0, 0, uint64(n-1), 0, func(key1, key2, value uint64) bool {
+		find(key1)
+		count++
+		return true
+	})
+	if err != nil || count != n {
+		t.Errorf("FindRange: expected %d pairs, got %d (err=%v)", n, count, err)
+	}
+	count = 0
+	seq, errf := tree.BackwardErr(rootID, bptree2.Key{}, bptree2.Key{Key1: uint64(n - 1)})
+	for key := range seq {
+		find(key.Key1)
+		if count++; count%1000 == 0 {
+			if err := tree.Flash(); err != nil {
+				t.Errorf("Flash failed: %v", err)
+			}
+		}
+	}
+	if err := errf(); err != nil || count != n {
+		t.Errorf("BackwardErr: expected %d pairs, got %d (err=%v)", n, count, err)
+	}
+	count = 0
+	err = bt.FindRange(nil, nil, func(key, value []byte) bool {
+		find(uint64(count))
+		count++
+		return true
+	})
+	if err != nil || count != 500 {
+		t.Errorf("BytesTree.FindRange: expected 500 pairs, got %d (err=%v)", count, err)
+	}
+
+	close(done)
+	wg.Wait()
+}
@@ -0,0 +1,184 @@
+package bptree2
+
+import (
+	"fmt"
+
+	"bptree2/bnode"
+	"bptree2/bpager"
+)
+
+// CountRange returns the number of key-value pairs where
+// (start1,start2) <= (key1,key2) <= (end1,end2).
+// This is an O(log n) operation.
+func (t *BPTree) CountRange(rootID RootID, start1, start2, end1, end2 uint64) (count int, err error) {
+	err = t.view(func(tx *Tx) (err error) {
+		count, err = tx.CountRange(rootID, start1, start2, end1, end2)
+		return err
+	})
+	return count, err
+}
+
+// Rank returns the position of (key1, key2) in key order, which is the
+// number of smaller keys, and whether the key exists.
+func (t *BPTree) Rank(rootID RootID, key1, key2 uint64) (rank int, found bool, err error) {
+	err = t.view(func(tx *Tx) (err error) {
+		rank, found, err = tx.Rank(rootID, key1, key2)
+		return err
+	})
+	return rank, found, err
+}
+
+// Select returns the key-value pair at position i in key order, counting from 0.
+// Returns ok == false if i is out of range.
+func (t *BPTree) Select(rootID RootID, i int) (key1, key2, value uint64, ok bool, err error) {
+	err = t.view(func(tx *Tx) (err error) {
+		key1, key2, value, ok, err = tx.Select(rootID, i)
+		return err
+	})
+	return key1, key2, value, ok, err
+}
+
+// Count returns the number of key-value pairs in a tree.
+// The root keeps the entry counts of its subtrees, so this is an O(1) operation.
+func (tx *Tx) Count(rootID RootID) (_ int, err error) {
+	if err := tx.acquire(); err != nil {
+		return 0, err
+	}
+	defer tx.release()
+	defer recoverCorrupt(&err)
+
+	rootPageID := tx.rootPage(rootID)
+	if rootPageID == 0 {
+		return 0, nil
+	}
+	if err := tx.checkKeyType(rootPageID, false); err != nil {
+		return 0, err
+	}
+	return int(tx.nodeCount(rootPageID)), nil
+}
+
+// CountRange returns the number of key-value pairs where
+// (start1,start2) <= (key1,key2) <= (end1,end2). See BPTree.CountRange.
+func (tx *Tx) CountRange(rootID RootID, start1, start2, end1, end2 uint64) (_ int, err error) {
+	if err := tx.acquire(); err != nil {
+		return 0, err
+	}
+	defer tx.release()
+	defer recoverCorrupt(&err)
+
+	rootPageID := tx.rootPage(rootID)
+	if rootPageID == 0 {
+		return 0, nil
+	}
+	if err := tx.checkKeyType(rootPageID, false); err != nil {
+		return 0, err
+	}
+	if (Key{Key1: start1, Key2: start2}).Compare(Key{Key1: end1, Key2: end2}) > 0 {
+		return 0, nil
+	}
+
+	low, _ := tx.rank(rootPageID, start1, start2)
+	high, found := tx.rank(rootPageID, end1, end2)
+	if found {
+		high++
+	}
+	return int(high - low), nil
+}
+
+// Rank returns the position of (key1, key2) in key order and whether the key exists.
+func (tx *Tx) Rank(rootID RootID, key1, key2 uint64) (_ int, _ bool, err error) {
+	if err := tx.acquire(); err != nil {
+		return 0, false, err
+	}
+	defer tx.release()
+	defer recoverCorrupt(&err)
+
+	rootPageID := tx.rootPage(rootID)
+	if rootPageID == 0 {
+		return 0, false, nil
+	}
+	if err := tx.checkKeyType(rootPageID, false); err != nil {
+		return 0, false, err
+	}
+	rank, found := tx.rank(rootPageID, key1, key2)
+	return int(rank), found, nil
+}
+
+// Select returns the key-value pair at position i in key order, counting from 0.
+// Returns ok == false if i is out of range.
+func (tx *Tx) Select(rootID RootID, i int) (key1, key2, value uint64, ok bool, err error) {
+	if err := tx.acquire(); err != nil {
+		return 0, 0, 0, false, err
+	}
+	defer tx.release()
+	defer recoverCorrupt(&err)
+
+	pageID := tx.rootPage(rootID)
+	if pageID == 0 || i < 0 {
+		return 0, 0, 0, false, nil
+	}
+	if err := tx.checkKeyType(pageID, false); err != nil {
+		return 0, 0, 0, false, err
+	}
+
+	// Skip the subtrees before the one holding entry i on every level
+	remaining := uint64(i)
+	for {
+		data := tx.page(pageID)
+		if data == nil {
+			return 0, 0, 0, false, fmt.Errorf("failed to get page %d", pageID)
+		}
+
+		if bnode.GetNodeType(data) == bnode.NodeTypeLeaf {
+			leaf := bnode.NewLeafNode(data, false)
+			if remaining >= uint64(leaf.KeyCount()) {
+				return 0, 0, 0, false, nil
+			}
+			idx := int(remaining)
+			return leaf.GetKey1At(idx), leaf.GetKey2At(idx), leaf.GetValueAt(idx), true, nil
+		}
+
+		internal := bnode.NewInternalNode(data, false)
+		child := 0
+		for ; child < internal.KeyCount() && remaining >= internal.GetCount(child); child++ {
+			remaining -= internal.GetCount(child)
+		}
+		pageID = internal.GetChild(child)
+	}
+}
+
+// rank returns the number of entries smaller than (key1, key2) in the tree
+// rooted at pageID, and whether the key exists.
+func (tx *Tx) rank(pageID bpager.PageID, key1, key2 uint64) (uint64, bool) {
+	var below uint64
+	for {
+		data := tx.page(pageID)
+		if data == nil {
+			return below, false
+		}
+
+		if bnode.GetNodeType(data) == bnode.NodeTypeLeaf {
+			idx, found := bnode.NewLeafNode(data, false).Search(key1, key2)
+			return below + uint64(idx), found
+		}
+
+		internal := bnode.NewInternalNode(data, false)
+		idx := internal.Search(key1, key2)
+		for i := 0; i < idx; i++ {
+			below += internal.GetCount(i)
+		}
+		pageID = internal.GetChild(idx)
+	}
+}
+
+// nodeCount returns the number of entries in the subtree of a page.
+func (tx *Tx) nodeCount(pageID bpager.PageID) uint64 {
+	data := tx.page(pageID)
+	if data == nil {
+		return 0
+	}
+	if bnode.GetNodeType(data) == bnode.NodeTypeLeaf {
+		return uint64(bnode.GetKeyCount(data))
+	}
+	return bnode.NewInternalNode(data, false).TotalCount()
+}
@@ -0,0 +1,141 @@
+package bptree2_test
+
+import (
+	"bptree2"
+	"math/rand"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestOrderStatistics(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer tree.Close()
+
+	rootID, _ := tree.CreateRoot()
+
+	// Inserts, deletes and batches change the counts along different paths
+	rng := rand.New(rand.NewSource(1))
+	model := make(map[bptree2.Key]uint64)
+	for round := 0; round < 20; round++ {
+		for i := 0; i < 3000; i++ {
+			key := bptree2.Key{Key1: uint64(rng.Intn(50)), Key2: uint64(rng.Intn(2000))}
+			if rng.Intn(3) == 0 {
+				if deleted, err := tree.Delete(rootID, key.Key1, key.Key2); err != nil || deleted {
+					delete(model, key)
+				}
+				continue
+			}
+			tree.Insert(rootID, key.Key1, key.Key2, uint64(i))
+			model[key] = uint64(i)
+		}
+
+		batch := &bptree2.Batch{}
+		for i := 0; i < 1000; i++ {
+			key := bptree2.Key{Key1: uint64(rng.Intn(50)), Key2: uint64(rng.Intn(2000))}
+			if rng.Intn(2) == 0 {
+				batch.Delete(key.Key1, key.Key2)
+				delete(model, key)
+			} else {
+				batch.Put(key.Key1, key.Key2, 1)
+				model[key] = 1
+			}
+		}
+		if _, err := tree.ApplyBatch(rootID, batch); err != nil {
+			t.Fatalf("ApplyBatch failed: %v", err)
+		}
+
+		if count, err := tree.Count(rootID); err != nil || count != len(model) {
+			t.Fatalf("round %d: expected count %d, got %d", round, len(model), count)
+		}
+	}
+
+	keys := make([]bptree2.Key, 0, len(model))
+	for key := range model {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, bptree2.Key.Compare)
+
+	for i, key := range keys {
+		if rank, found, err := tree.Rank(rootID, key.Key1, key.Key2); err != nil || rank != i || !found {
+			t.Fatalf("Rank(%v) = %d, %v, expected %d, true", key, rank, found, i)
+		}
+		key1, key2, value, ok, err := tree.Select(rootID, i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok || key1 != key.Key1 || key2 != key.Key2 || value != model[key] {
+			t.Fatalf("Select(%d) = (%d,%d)=%d, expected %v=%d", i, key1, key2, value, key, model[key])
+		}
+	}
+	if _, _, _, ok, err := tree.Select(rootID, len(keys)); err != nil || ok {
+		t.Error("Select past the end should fail")
+	}
+	if _, _, _, ok, err := tree.Select(rootID, -1); err != nil || ok {
+		t.Error("Select(-1) should fail")
+	}
+
+	// A missing key ranks where it would be inserted
+	for i := 0; i < 1000; i++ {
+		key := bptree2.Key{Key1: uint64(rng.Intn(50)), Key2: uint64(rng.Intn(2000))}
+		expected, exists := slices.BinarySearchFunc(keys, key, bptree2.Key.Compare)
+		if rank, found, err := tree.Rank(rootID, key.Key1, key.Key2); err != nil || rank != expected || found != exists {
+			t.Fatalf("Rank(%v) = %d, %v, expected %d, %v", key, rank, found, expected, exists)
+		}
+	}
+
+	for i := 0; i < 1000; i++ {
+		start := bptree2.Key{Key1: uint64(rng.Intn(50)), Key2: uint64(rng.Intn(2000))}
+		end := bptree2.Key{Key1: uint64(rng.Intn(50)), Key2: uint64(rng.Intn(2000))}
+		expected := 0
+		tree.FindRange(rootID, start.Key1, start.Key2, end.Key1, end.Key2, func(key1, key2, value uint64) bool {
+			expected++
+			return true
+		})
+		if count, err := tree.CountRange(rootID, start.Key1, start.Key2, end.Key1, end.Key2); err != nil || count != expected {
+			t.Fatalf("CountRange(%v, %v) = %d, expected %d", start, end, count, expected)
+		}
+	}
+}
+
+func TestCountAfterBulkLoad(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	rootID, _ := tree.CreateRoot()
+	n := 100000
+	if err := tree.BulkLoad(rootID, sequence(uint64(n), 1), &bptree2.BulkLoadOptions{FillFactor: 0.7}); err != nil {
+		t.Fatalf("BulkLoad failed: %v", err)
+	}
+	if count, err := tree.Count(rootID); err != nil || count != n {
+		t.Errorf("expected %d entries, got %d", n, count)
+	}
+	if count, err := tree.CountRange(rootID, 1000, 0, 1999, ^uint64(0)); err != nil || count != 1000 {
+		t.Errorf("expected 1000 entries in range, got %d", count)
+	}
+	tree.Close()
+
+	// The counts are stored in the file
+	tree, err = bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer tree.Close()
+	if key1, _, _, ok, err := tree.Select(rootID, 54321); err != nil || !ok || key1 != 54321 {
+		t.Errorf("Select(54321) = %d, %v", key1, ok)
+	}
+	if count, err := tree.Count(rootID); err != nil || count != n {
+		t.Errorf("expected %d entries after reopen, got %d", n, count)
+	}
+}
@@ -0,0 +1,288 @@
+package bptree2
+
+import (
+	"fmt"
+
+	"bptree2/bnode"
+	"bptree2/bpager"
+)
+
+// Cursor iterates over the key-value pairs of one root tree in either direction.
+//
+// A Cursor remembers the path from the root to its current entry by page ID
+// and reads the pages again on every move, so it stays valid when the file is
+// remapped and can be kept across function calls. A Cursor created with
+// BPTree.Cursor reads from its own snapshot and must be closed; one created
+// with Tx.Cursor reads through the transaction and ends with it.
+//
+// Modifying the tree through the transaction of a cursor invalidates its
+// position; call Seek, First or Last again afterwards.
+type Cursor struct {
+	tx     *Tx
+	owned  bool // tx was started for the cursor and ends with Close
+	rootID RootID
+	stack  []cursorElem // path from the root to the current leaf
+
+	valid bool
+	key1  uint64
+	key2  uint64
+	value uint64
+	err   error
+}
+
+// cursorElem is a page on the cursor path and the index of the child or entry in it.
+type cursorElem struct {
+	pageID bpager.PageID
+	index  int
+}
+
+// Cursor returns a cursor over a root tree, reading from a new snapshot.
+// The cursor is not positioned until Seek, First or Last is called.
+func (t *BPTree) Cursor(rootID RootID) (*Cursor, error) {
+	tx, err := t.Begin(false)
+	if err != nil {
+		return nil, err
+	}
+	c := tx.Cursor(rootID)
+	c.owned = true
+	return c, nil
+}
+
+// Cursor returns a cursor over a root tree as seen by the transaction.
+func (tx *Tx) Cursor(rootID RootID) *Cursor {
+	return &Cursor{tx: tx, rootID: rootID}
+}
+
+// First moves the cursor to the smallest key.
+// Returns false if the tree is empty.
+func (c *Cursor) First() bool {
+	return c.move(func() error {
+		rootPageID := c.tx.rootPage(c.rootID)
+		if rootPageID == 0 {
+			return nil // Empty tree
+		}
+		if err := c.tx.checkKeyType(rootPageID, false); err != nil {
+			return err
+		}
+		if err := c.descend(rootPageID, true); err != nil {
+			return err
+		}
+		return c.settle(true)
+	})
+}
+
+// Last moves the cursor to the largest key.
+// Returns false if the tree is empty.
+func (c *Cursor) Last() bool {
+	return c.move(func() error {
+		rootPageID := c.tx.rootPage(c.rootID)
+		if rootPageID == 0 {
+			return nil // Empty tree
+		}
+		if err := c.tx.checkKeyType(rootPageID, false); err != nil {
+			return err
+		}
+		if err := c.descend(rootPageID, false); err != nil {
+			return err
+		}
+		return c.settle(false)
+	})
+}
+
+// Seek moves the cursor to the first key that is >= (key1, key2).
+// Returns false if there is no such key.
+func (c *Cursor) Seek(key1, key2 uint64) bool {
+	return c.move(func() error {
+		pageID := c.tx.rootPage(c.rootID)
+		if pageID == 0 {
+			return nil // Empty tree
+		}
+		if err := c.tx.checkKeyType(pageID, false); err != nil {
+			return err
+		}
+
+		// Descend along the search path
+		for {
+			if len(c.stack) >= maxDepth {
+				return errTooDeep
+			}
+			data := c.tx.page(pageID)
+			if data == nil {
+				return fmt.Errorf("failed to get page %d", pageID)
+			}
+
+			if bnode.GetNodeType(data) == bnode.NodeTypeLeaf {
+				idx, _ := bnode.NewLeafNode(data, false).Search(key1, key2)
+				c.stack = append(c.stack, cursorElem{pageID: pageID, index: idx})
+				return c.settle(true)
+			}
+
+			internal := bnode.NewInternalNode(data, false)
+			idx := internal.Search(key1, key2)
+			c.stack = append(c.stack, cursorElem{pageID: pageID, index: idx})
+			pageID = internal.GetChild(idx)
+		}
+	})
+}
+
+// Next moves the cursor to the next key.
+// Returns false at the end of the tree or if the cursor is not positioned.
+func (c *Cursor) Next() bool {
+	if !c.valid {
+		return false
+	}
+	return c.step(true)
+}
+
+// Prev moves the cursor to the previous key.
+// Returns false at the start of the tree or if the cursor is not positioned.
+func (c *Cursor) Prev() bool {
+	if !c.valid {
+		return false
+	}
+	return c.step(false)
+}
+
+// Valid returns true if the cursor is positioned on a key.
+func (c *Cursor) Valid() bool {
+	return c.valid
+}
+
+// Key returns the composite key at the cursor position.
+func (c *Cursor) Key() (key1, key2 uint64) {
+	return c.key1, c.key2
+}
+
+// Value returns the value at the cursor position.
+func (c *Cursor) Value() uint64 {
+	return c.value
+}
+
+// Err returns the error that made the cursor invalid, if any.
+func (c *Cursor) Err() error {
+	return c.err
+}
+
+// Close releases the cursor. A cursor created with BPTree.Cursor releases its snapshot.
+func (c *Cursor) Close() error {
+	c.valid = false
+	c.stack = nil
+	if c.owned {
+		c.owned = false
+		return c.tx.Rollback()
+	}
+	return nil
+}
+
+// move repositions the cursor from scratch using fn.
+func (c *Cursor) move(fn func() error) bool {
+	c.stack = c.stack[:0]
+	c.valid = false
+	c.err = nil
+
+	if err := c.tx.locked(fn); err != nil {
+		c.err = err
+		c.valid = false
+		c.stack = c.stack[:0]
+	}
+	return c.valid
+}
+
+// step moves the cursor one entry forward or backward from its current position.
+func (c *Cursor) step(forward bool) bool {
+	err := c.tx.locked(func() error {
+		top := &c.stack[len(c.stack)-1]
+		if forward {
+			top.index++
+		} else {
+			top.index--
+		}
+		return c.settle(forward)
+	})
+	if err != nil {
+		c.err = err
+		c.valid = false
+		c.stack = c.stack[:0]
+	}
+	return c.valid
+}
+
+// descend pushes the path from pageID down to its leftmost (or rightmost) leaf.
+func (c *Cursor) descend(pageID bpager.PageID, leftmost bool) error {
+	for {
+		if len(c.stack) >= maxDepth {
+			return errTooDeep
+		}
+		data := c.tx.page(pageID)
+		if data == nil {
+			return fmt.Errorf("failed to get page %d", pageID)
+		}
+
+		if bnode.GetNodeType(data) == bnode.NodeTypeLeaf {
+			idx := 0
+			if !leftmost {
+				idx = int(bnode.GetKeyCount(data)) - 1
+			}
+			c.stack = append(c.stack, cursorElem{pageID: pageID, index: idx})
+			return nil
+		}
+
+		internal := bnode.NewInternalNode(data, false)
+		idx := 0
+		if !leftmost {
+			idx = internal.KeyCount()
+		}
+		c.stack = append(c.stack, cursorElem{pageID: pageID, index: idx})
+		pageID = internal.GetChild(idx)
+	}
+}
+
+// settle makes sure the leaf index on top of the stack points at an entry.
+// If it is out of range, the cursor moves on to the neighbouring leaf in the
+// given direction by climbing the path and descending the next subtree.
+func (c *Cursor) settle(forward bool) error {
+	for len(c.stack) > 0 {
+		top := c.stack[len(c.stack)-1]
+		data := c.tx.page(top.pageID)
+		if data == nil {
+			return fmt.Errorf("failed to get page %d", top.pageID)
+		}
+
+		leaf := bnode.NewLeafNode(data, false)
+		if top.index >= 0 && top.index < leaf.KeyCount() {
+			c.key1 = leaf.GetKey1At(top.index)
+			c.key2 = leaf.GetKey2At(top.index)
+			c.value = leaf.GetValueAt(top.index)
+			c.valid = true
+			return nil
+		}
+
+		// Leaf exhausted: climb to the first ancestor with a sibling subtree
+		c.stack = c.stack[:len(c.stack)-1]
+		for len(c.stack) > 0 {
+			parent := &c.stack[len(c.stack)-1]
+			parentData := c.tx.page(parent.pageID)
+			if parentData == nil {
+				return fmt.Errorf("failed to get page %d", parent.pageID)
+			}
+			internal := bnode.NewInternalNode(parentData, false)
+
+			if forward {
+				parent.index++
+			} else {
+				parent.index--
+			}
+			if parent.index >= 0 && parent.index <= internal.KeyCount() {
+				if err := c.descend(internal.GetChild(parent.index), forward); err != nil {
+					return err
+				}
+				break
+			}
+			c.stack = c.stack[:len(c.stack)-1]
+		}
+	}
+
+	// Ran off either end of the tree
+	c.valid = false
+	return nil
+}
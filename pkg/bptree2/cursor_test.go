@@ -0,0 +1,229 @@
+package bptree2_test
+
+import (
+	"bptree2"
+	"path/filepath"
+	"testing"
+)
+
+func TestCursorForwardBackward(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer tree.Close()
+
+	rootID, _ := tree.CreateRoot()
+	n := uint64(10000)
+	for i := uint64(0); i < n; i++ {
+		tree.Insert(rootID, i*2, i, i*10)
+	}
+
+	c, err := tree.Cursor(rootID)
+	if err != nil {
+		t.Fatalf("Cursor failed: %v", err)
+	}
+	defer c.Close()
+
+	// Forward over the whole tree
+	count := uint64(0)
+	for ok := c.First(); ok; ok = c.Next() {
+		key1, key2 := c.Key()
+		if key1 != count*2 || key2 != count || c.Value() != count*10 {
+			t.Fatalf("forward: expected key (%d,%d), got (%d,%d)", count*2, count, key1, key2)
+		}
+		count++
+	}
+	if count != n {
+		t.Errorf("forward: expected %d entries, got %d", n, count)
+	}
+
+	// Backward over the whole tree
+	count = 0
+	for ok := c.Last(); ok; ok = c.Prev() {
+		key1, _ := c.Key()
+		if expected := (n - 1 - count) * 2; key1 != expected {
+			t.Fatalf("backward: expected key1 %d, got %d", expected, key1)
+		}
+		count++
+	}
+	if count != n {
+		t.Errorf("backward: expected %d entries, got %d", n, count)
+	}
+	if c.Valid() || c.Prev() {
+		t.Error("cursor should stay invalid after running off the start")
+	}
+}
+
+func TestCursorSeek(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer tree.Close()
+
+	rootID, _ := tree.CreateRoot()
+	for i := uint64(0); i < 5000; i++ {
+		tree.Insert(rootID, i*10, 5, i)
+	}
+
+	c, _ := tree.Cursor(rootID)
+	defer c.Close()
+
+	// Exact match
+	if !c.Seek(1230, 5) {
+		t.Fatal("Seek(1230,5) should find a key")
+	}
+	if key1, key2 := c.Key(); key1 != 1230 || key2 != 5 {
+		t.Errorf("expected (1230,5), got (%d,%d)", key1, key2)
+	}
+
+	// Between keys, including the key2 component
+	c.Seek(1230, 6)
+	if key1, _ := c.Key(); key1 != 1240 {
+		t.Errorf("Seek(1230,6): expected key1 1240, got %d", key1)
+	}
+	c.Seek(1235, 0)
+	if key1, _ := c.Key(); key1 != 1240 {
+		t.Errorf("Seek(1235,0): expected key1 1240, got %d", key1)
+	}
+
+	// Step back and forth around the position
+	c.Prev()
+	if key1, _ := c.Key(); key1 != 1230 {
+		t.Errorf("Prev: expected key1 1230, got %d", key1)
+	}
+	c.Next()
+	c.Next()
+	if key1, _ := c.Key(); key1 != 1250 {
+		t.Errorf("Next: expected key1 1250, got %d", key1)
+	}
+
+	// Before the first and after the last key
+	if !c.Seek(0, 0) {
+		t.Error("Seek(0,0) should find the first key")
+	}
+	if c.Seek(49990, 6) {
+		t.Error("Seek past the last key should return false")
+	}
+	if c.Valid() {
+		t.Error("cursor should be invalid after seeking past the end")
+	}
+}
+
+func TestCursorEmptyTree(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer tree.Close()
+
+	rootID, _ := tree.CreateRoot()
+	c, _ := tree.Cursor(rootID)
+	defer c.Close()
+
+	if c.First() || c.Last() || c.Seek(1, 1) || c.Next() || c.Prev() {
+		t.Error("cursor on an empty tree should never be valid")
+	}
+	if c.Err() != nil {
+		t.Errorf("unexpected error: %v", c.Err())
+	}
+}
+
+func TestCursorAcrossRemap(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer tree.Close()
+
+	rootID, _ := tree.CreateRoot()
+	for i := uint64(0); i < 1000; i++ {
+		tree.Insert(rootID, i, 0, i)
+	}
+	tree.Flash()
+
+	c, _ := tree.Cursor(rootID)
+	defer c.Close()
+
+	count := uint64(0)
+	for ok := c.First(); ok; ok = c.Next() {
+		if key1, _ := c.Key(); key1 != count {
+			t.Fatalf("expected key1 %d, got %d", count, key1)
+		}
+		count++
+
+		// Grow the file well past its mapping while the cursor is paused
+		if count == 500 {
+			other, _ := tree.CreateRoot()
+			for i := uint64(0); i < 100000; i++ {
+				tree.Insert(other, i, 0, i)
+			}
+			tree.Delete(rootID, 600, 0)
+			tree.Flash()
+		}
+	}
+	if count != 1000 {
+		t.Errorf("expected the snapshot's 1000 entries, got %d", count)
+	}
+}
+
+func TestTxCursorMerge(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer tree.Close()
+
+	evens, _ := tree.CreateRoot()
+	odds, _ := tree.CreateRoot()
+	for i := uint64(0); i < 2000; i++ {
+		if i%2 == 0 {
+			tree.Insert(evens, i, 0, i)
+		} else {
+			tree.Insert(odds, i, 0, i)
+		}
+	}
+
+	tx, _ := tree.Begin(false)
+	defer tx.Rollback()
+
+	// Merge both roots into one ordered stream
+	a, b := tx.Cursor(evens), tx.Cursor(odds)
+	okA, okB := a.First(), b.First()
+	expected := uint64(0)
+	for okA || okB {
+		ka, _ := a.Key()
+		kb, _ := b.Key()
+		var got uint64
+		if okA && (!okB || ka < kb) {
+			got = ka
+			okA = a.Next()
+		} else {
+			got = kb
+			okB = b.Next()
+		}
+		if got != expected {
+			t.Fatalf("expected %d, got %d", expected, got)
+		}
+		expected++
+	}
+	if expected != 2000 {
+		t.Errorf("expected 2000 merged entries, got %d", expected)
+	}
+}
@@ -0,0 +1,240 @@
+package bptree2
+
+import (
+	"fmt"
+
+	"bptree2/bnode"
+	"bptree2/bpager"
+)
+
+// DeleteRange removes all keys where (start1,start2) <= (key1,key2) <= (end1,end2)
+// and returns the number of removed keys. See Tx.DeleteRange.
+func (t *BPTree) DeleteRange(rootID RootID, start1, start2, end1, end2 uint64) (int, error) {
+	var deleted int
+	err := t.update(func(tx *Tx) (err error) {
+		deleted, err = tx.DeleteRange(rootID, start1, start2, end1, end2)
+		return err
+	})
+	return deleted, err
+}
+
+// DeleteRange removes all keys where (start1,start2) <= (key1,key2) <= (end1,end2)
+// and returns the number of removed keys.
+//
+// Only the leaves holding the two ends of the range are trimmed. Leaves and
+// subtrees in between are dropped as a whole and their pages returned to the
+// free list; the tree is then rebalanced along the two boundary paths only.
+// If DeleteRange fails the transaction should be rolled back.
+func (tx *Tx) DeleteRange(rootID RootID, start1, start2, end1, end2 uint64) (_ int, err error) {
+	defer recoverCorrupt(&err)
+	if err := tx.checkWritable(); err != nil {
+		return 0, err
+	}
+
+	rootPageID := tx.rootPage(rootID)
+	if rootPageID == 0 {
+		return 0, nil
+	}
+	if err := tx.checkKeyType(rootPageID, false); err != nil {
+		return 0, err
+	}
+
+	start := Key{Key1: start1, Key2: start2}
+	end := Key{Key1: end1, Key2: end2}
+	if start.Compare(end) > 0 {
+		return 0, nil
+	}
+
+	rootPageID, err = tx.unshareRoot(rootID, rootPageID)
+	if err != nil {
+		return 0, err
+	}
+	deleted, err := tx.deleteRange(rootPageID, &start, &end)
+	if err != nil {
+		return 0, err
+	}
+	if deleted > 0 {
+		if err := tx.shrinkRoot(rootID); err != nil {
+			return 0, err
+		}
+	}
+	return int(deleted), nil
+}
+
+// deleteRange removes the entries between low and high from the subtree at
+// pageID and returns their number. A nil bound is open.
+//
+// Children entirely within the bounds are freed; the children holding a bound
+// are descended into and rebalanced afterwards. On return every child of the
+// node is at least half full, unless the node is left with a single child.
+func (tx *Tx) deleteRange(pageID bpager.PageID, low, high *Key) (uint64, error) {
+	data := tx.page(pageID)
+	if data == nil {
+		return 0, fmt.Errorf("failed to get page %d", pageID)
+	}
+
+	if bnode.GetNodeType(data) == bnode.NodeTypeLeaf {
+		leaf := bnode.NewLeafNode(data, false)
+		from, to := 0, leaf.KeyCount()
+		if low != nil {
+			from, _ = leaf.Search(low.Key1, low.Key2)
+		}
+		if high != nil {
+			idx, found := leaf.Search(high.Key1, high.Key2)
+			if found {
+				idx++
+			}
+			to = idx
+		}
+		if from >= to {
+			return 0, nil
+		}
+
+		for i := from; i < to; i++ {
+			if err := tx.freeValueAt(leaf, i); err != nil {
+				return 0, err
+			}
+		}
+		bnode.NewLeafNode(tx.pageForWrite(pageID), false).DeleteRange(from, to)
+		return uint64(to - from), nil
+	}
+
+	internal := bnode.NewInternalNode(data, false)
+	first, last := 0, internal.KeyCount()
+	if low != nil {
+		first = internal.Search(low.Key1, low.Key2)
+	}
+	if high != nil {
+		last = internal.Search(high.Key1, high.Key2)
+	}
+
+	var deleted uint64
+	for i := first; i <= last; i++ {
+		childLow, childHigh := low, high
+		if i > first {
+			childLow = nil
+		}
+		if i < last {
+			childHigh = nil
+		}
+
+		if childLow == nil && childHigh == nil {
+			deleted += internal.GetCount(i)
+			if err := tx.freeTree(internal.GetChild(i)); err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		childID, err := tx.unshareChild(pageID, i)
+		if err != nil {
+			return 0, err
+		}
+		n, err := tx.deleteRange(childID, childLow, childHigh)
+		if err != nil {
+			return 0, err
+		}
+		deleted += n
+	}
+	if deleted == 0 {
+		return 0, nil
+	}
+
+	// Drop the freed children, which lie between the boundary children
+	data = tx.pageForWrite(pageID)
+	internal = bnode.NewInternalNode(data, false)
+	from, to := first, last+1
+	if low != nil {
+		from++
+	}
+	if high != nil {
+		to--
+	}
+	internal.RemoveChildren(from, to)
+
+	// Rebalance the boundary children, right one first, as rebalancing it
+	// may merge it into the left one
+	lowIdx, highIdx := -1, -1
+	if low != nil {
+		lowIdx = first
+	}
+	if high != nil && (low == nil || last > first) {
+		highIdx = from
+	}
+	for _, i := range []int{highIdx, lowIdx} {
+		if i >= 0 {
+			tx.syncChild(internal, i)
+		}
+	}
+	for _, i := range []int{highIdx, lowIdx} {
+		if i >= 0 && i <= internal.KeyCount() {
+			if err := tx.rebalance(internal, i, pageID); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	return deleted, nil
+}
+
+// rebalance borrows from and merges with the siblings of child childIdx
+// until it is at least half full or the only child of parent, which must
+// wrap the writable page of parentID.
+func (tx *Tx) rebalance(parent *bnode.InternalNode, childIdx int, parentID bpager.PageID) error {
+	childID := parent.GetChild(childIdx)
+	if !tx.isUnderflow(childID) {
+		return nil
+	}
+
+	// An internal child with a single child may hold an underflowing
+	// grandchild, which only gets siblings from here on
+	childData := tx.page(childID)
+	deep := bnode.GetNodeType(childData) == bnode.NodeTypeInternal && bnode.GetKeyCount(childData) == 0
+
+	for {
+		for parent.KeyCount() > 0 && tx.isUnderflow(parent.GetChild(childIdx)) {
+			keys := parent.KeyCount()
+			if err := tx.handleUnderflow(parent, childIdx, parentID); err != nil {
+				return err
+			}
+			if parent.KeyCount() < keys && childIdx > 0 {
+				childIdx-- // Merged into the left sibling
+			}
+		}
+		if !deep {
+			return nil
+		}
+
+		// Merges below may leave the child underflowing once more
+		deep = false
+		childID, err := tx.unshareChild(parentID, childIdx)
+		if err != nil {
+			return err
+		}
+		if err := tx.rebalanceChildren(childID); err != nil {
+			return err
+		}
+	}
+}
+
+// rebalanceChildren rebalances every underflowing child of a private internal node.
+func (tx *Tx) rebalanceChildren(pageID bpager.PageID) error {
+	internal := bnode.NewInternalNode(tx.pageForWrite(pageID), false)
+	for i := 0; i <= internal.KeyCount(); i++ {
+		if tx.isUnderflow(internal.GetChild(i)) {
+			if err := tx.rebalance(internal, i, pageID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// isUnderflow returns true if the node at pageID is less than half full.
+func (tx *Tx) isUnderflow(pageID bpager.PageID) bool {
+	data := tx.page(pageID)
+	if bnode.GetNodeType(data) == bnode.NodeTypeLeaf {
+		return bnode.NewLeafNode(data, false).IsUnderflow()
+	}
+	return bnode.NewInternalNode(data, false).IsUnderflow()
+}
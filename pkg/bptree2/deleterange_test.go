@@ -0,0 +1,164 @@
+package bptree2_test
+
+import (
+	"bptree2"
+	"cmp"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// checkContents compares a root against a model, scanning both ways so that
+// both leaf links are checked.
+func checkContents(t *testing.T, tree *bptree2.BPTree, rootID bptree2.RootID, model map[bptree2.Key]uint64) {
+	t.Helper()
+
+	keys := make([]bptree2.Key, 0, len(model))
+	for key := range model {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, bptree2.Key.Compare)
+
+	var forward, backward []bptree2.Key
+	all, allErr := tree.AllErr(rootID)
+	for key, value := range all {
+		if value != model[key] {
+			t.Fatalf("key %v: expected %d, got %d", key, model[key], value)
+		}
+		forward = append(forward, key)
+	}
+	reverse, reverseErr := tree.BackwardErr(rootID, bptree2.Key{}, bptree2.MaxKey)
+	for key := range reverse {
+		backward = append(backward, key)
+	}
+	if err := cmp.Or(allErr(), reverseErr()); err != nil {
+		t.Fatalf("scan failed: %v", err)
+	}
+	slices.Reverse(backward)
+
+	if !slices.Equal(forward, keys) || !slices.Equal(backward, keys) {
+		t.Fatalf("expected %d keys, scanned %d forward and %d backward", len(keys), len(forward), len(backward))
+	}
+	if count, err := tree.Count(rootID); err != nil || count != len(keys) {
+		t.Fatalf("expected count %d, got %d", len(keys), count)
+	}
+}
+
+func TestDeleteRange(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer tree.Close()
+
+	rootID, _ := tree.CreateAugmentedRoot()
+	rng := rand.New(rand.NewSource(1))
+	model := make(map[bptree2.Key]uint64)
+
+	// Ranges from within a leaf to most of the tree, with refills in between
+	for round := 0; round < 60; round++ {
+		for i := 0; i < 3000; i++ {
+			key := bptree2.Key{Key1: uint64(rng.Intn(100)), Key2: uint64(rng.Intn(1000))}
+			tree.Insert(rootID, key.Key1, key.Key2, uint64(i))
+			model[key] = uint64(i)
+		}
+
+		start := bptree2.Key{Key1: uint64(rng.Intn(100)), Key2: uint64(rng.Intn(1000))}
+		end := start
+		switch round % 3 {
+		case 0:
+			end.Key2 += uint64(rng.Intn(50))
+		case 1:
+			end.Key1 += uint64(rng.Intn(10))
+		default:
+			end.Key1 += uint64(rng.Intn(100))
+		}
+
+		expected := 0
+		for key := range model {
+			if key.Compare(start) >= 0 && key.Compare(end) <= 0 {
+				delete(model, key)
+				expected++
+			}
+		}
+		deleted, err := tree.DeleteRange(rootID, start.Key1, start.Key2, end.Key1, end.Key2)
+		if err != nil {
+			t.Fatalf("DeleteRange failed: %v", err)
+		}
+		if deleted != expected {
+			t.Fatalf("round %d: DeleteRange(%v, %v) removed %d keys, expected %d", round, start, end, deleted, expected)
+		}
+		checkContents(t, tree, rootID, model)
+
+		// The aggregates along the boundary paths are kept up to date
+		agg, _ := tree.Aggregate(rootID, 0, 0, ^uint64(0), ^uint64(0))
+		if want := aggregateModel(model, bptree2.Key{}, bptree2.MaxKey); agg != want {
+			t.Fatalf("round %d: expected aggregate %+v, got %+v", round, want, agg)
+		}
+	}
+
+	// Emptying the tree keeps the augmented root
+	deleted, err := tree.DeleteRange(rootID, 0, 0, ^uint64(0), ^uint64(0))
+	if err != nil || deleted != len(model) {
+		t.Fatalf("DeleteRange of everything: removed %d of %d keys, %v", deleted, len(model), err)
+	}
+	clear(model)
+	checkContents(t, tree, rootID, model)
+	if _, err := tree.Aggregate(rootID, 0, 0, 1, 1); err != nil {
+		t.Errorf("emptied root should stay augmented: %v", err)
+	}
+
+	if deleted, _ := tree.DeleteRange(rootID, 5, 0, 1, 0); deleted != 0 {
+		t.Errorf("an inverted range should remove nothing, removed %d", deleted)
+	}
+}
+
+func TestDeleteRangeFreesPages(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer tree.Close()
+
+	rootID, _ := tree.CreateRoot()
+	n := uint64(200000)
+	for i := uint64(0); i < n; i++ {
+		tree.Insert(rootID, i/1000, i%1000, i)
+	}
+	if err := tree.InsertBlob(rootID, 50, 0, make([]byte, 100000)); err != nil {
+		t.Fatalf("InsertBlob failed: %v", err)
+	}
+	tree.Flash()
+	info, _ := os.Stat(path)
+	size := info.Size()
+
+	// Removing and restoring the middle of the tree reuses the freed pages
+	for round := 0; round < 3; round++ {
+		deleted, err := tree.DeleteRange(rootID, 10, 500, 190, 499)
+		if err != nil {
+			t.Fatalf("DeleteRange failed: %v", err)
+		}
+		if deleted != 180000 {
+			t.Fatalf("expected 180000 deletions, got %d", deleted)
+		}
+		if count, err := tree.Count(rootID); err != nil || count != int(n)-180000 {
+			t.Fatalf("expected %d keys, got %d", n-180000, count)
+		}
+		for i := uint64(10500); i < 190500; i++ {
+			tree.Insert(rootID, i/1000, i%1000, i)
+		}
+	}
+	tree.Flash()
+	info, _ = os.Stat(path)
+	if info.Size() > size {
+		t.Errorf("file grew from %d to %d bytes", size, info.Size())
+	}
+}
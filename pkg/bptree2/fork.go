@@ -0,0 +1,138 @@
+package bptree2
+
+import (
+	"fmt"
+
+	"bptree2/bnode"
+	"bptree2/bpager"
+)
+
+// ForkRoot creates a root tree holding the same entries as src and returns
+// its ID. See Tx.ForkRoot.
+func (t *BPTree) ForkRoot(src RootID) (RootID, error) {
+	var rootID RootID
+	err := t.update(func(tx *Tx) (err error) {
+		rootID, err = tx.ForkRoot(src)
+		return err
+	})
+	return rootID, err
+}
+
+// ForkRoot creates a root tree holding the same entries as src and returns
+// its ID. The two trees share all of their pages, so forking takes constant
+// time and space; a shared page is copied the first time either tree writes
+// to it, and only then. Buckets are forked in turn when the leaf holding
+// them is copied. DeleteRoot on either tree frees only the pages the other
+// does not use.
+// If ForkRoot fails the transaction should be rolled back.
+func (tx *Tx) ForkRoot(src RootID) (_ RootID, err error) {
+	defer recoverCorrupt(&err)
+	if err := tx.checkWritable(); err != nil {
+		return 0, err
+	}
+	return tx.pages.ForkRoot(src)
+}
+
+// unshareRoot makes the root page of rootID private to its tree, copying it
+// if it is shared, and returns its ID.
+func (tx *Tx) unshareRoot(rootID RootID, pageID bpager.PageID) (bpager.PageID, error) {
+	if !tx.shared(pageID) {
+		return pageID, nil
+	}
+	newID, err := tx.copyPage(pageID)
+	if err != nil {
+		return 0, err
+	}
+	return newID, tx.pages.SetRootPage(rootID, newID)
+}
+
+// unshareChild makes child i of the internal node at parentID private to its
+// tree, copying it if it is shared, and returns its ID. The parent must be
+// private already; it is only written if the child is copied.
+func (tx *Tx) unshareChild(parentID bpager.PageID, i int) (bpager.PageID, error) {
+	childID := childAt(tx.page(parentID), i)
+	if !tx.shared(childID) {
+		return childID, nil
+	}
+	newID, err := tx.copyPage(childID)
+	if err != nil {
+		return 0, err
+	}
+	setChildAt(tx.pageForWrite(parentID), i, newID)
+	return newID, nil
+}
+
+// copyPage copies a shared page to a new page and returns its ID; the
+// shared page loses the reference the copy takes over. The copy adds a
+// reference to everything the page refers to: the children of an internal
+// node, the overflow chains of a leaf. Buckets are forked instead, as every
+// bucket belongs to one entry.
+func (tx *Tx) copyPage(pageID bpager.PageID) (bpager.PageID, error) {
+	newID, err := tx.pages.AllocatePage()
+	if err != nil {
+		return 0, fmt.Errorf("failed to allocate page: %w", err)
+	}
+	data := tx.page(pageID)
+	if data == nil {
+		return 0, fmt.Errorf("failed to get page %d", pageID)
+	}
+	newData := tx.pageForWrite(newID)
+	copy(newData, data)
+
+	for _, child := range nodeChildren(newData) {
+		if err := tx.pages.AddRef(child); err != nil {
+			return 0, err
+		}
+	}
+	if bnode.GetNodeType(newData) == bnode.NodeTypeLeaf {
+		leaf := bnode.NewLeafNode(newData, false)
+		for i := range leaf.KeyCount() {
+			flags := leaf.GetFlagsAt(i)
+			switch {
+			case flags&bnode.FlagBucket != 0:
+				childID, err := tx.pages.ForkRoot(leaf.GetValueAt(i))
+				if err != nil {
+					return 0, fmt.Errorf("failed to fork bucket (%d,%d): %w", leaf.GetKey1At(i), leaf.GetKey2At(i), err)
+				}
+				leaf.PutWithFlags(leaf.GetKey1At(i), leaf.GetKey2At(i), childID, flags)
+			case flags&bnode.FlagBlob != 0:
+				if err := tx.pages.AddRef(leaf.GetValueAt(i)); err != nil {
+					return 0, err
+				}
+			}
+		}
+	}
+
+	return newID, tx.pages.FreePage(pageID)
+}
+
+// nodeChildren returns the children of an internal node of either key
+// type, or nil for a leaf.
+func nodeChildren(data []byte) []bpager.PageID {
+	switch bnode.GetNodeType(data) {
+	case bnode.NodeTypeInternal, bnode.NodeTypeVarInternal:
+		children := make([]bpager.PageID, bnode.GetKeyCount(data)+1)
+		for i := range children {
+			children[i] = childAt(data, i)
+		}
+		return children
+	}
+	return nil
+}
+
+// childAt returns child i of an internal node of either key type.
+func childAt(data []byte, i int) bpager.PageID {
+	if bnode.GetNodeType(data) == bnode.NodeTypeVarInternal {
+		return bnode.NewVarInternalNode(data, false).GetChild(i)
+	}
+	return bnode.NewInternalNode(data, false).GetChild(i)
+}
+
+// setChildAt sets child i of an internal node of either key type.
+func setChildAt(data []byte, i int, pageID bpager.PageID) {
+	if bnode.GetNodeType(data) == bnode.NodeTypeVarInternal {
+		bnode.NewVarInternalNode(data, false).SetChild(i, pageID)
+		return
+	}
+	bnode.NewInternalNode(data, false).SetChild(i, pageID)
+}
@@ -0,0 +1,242 @@
+package bptree2_test
+
+import (
+	"bptree2"
+	"bptree2/bpager"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"testing"
+)
+
+// collect returns all entries of a root tree, read with a range scan.
+func collect(t *testing.T, tree *bptree2.BPTree, rootID bptree2.RootID) map[bptree2.Key]uint64 {
+	t.Helper()
+	entries := make(map[bptree2.Key]uint64)
+	err := tree.FindRange(rootID, 0, 0, ^uint64(0), ^uint64(0), func(key1, key2, value uint64) bool {
+		entries[bptree2.Key{Key1: key1, Key2: key2}] = value
+		return true
+	})
+	if err != nil {
+		t.Fatalf("FindRange failed: %v", err)
+	}
+	return entries
+}
+
+func TestForkRoot(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	srcID, _ := tree.CreateRoot()
+	want := make(map[bptree2.Key]uint64)
+	for i := uint64(0); i < 30000; i++ {
+		tree.Insert(srcID, i/100, i%100, i)
+		want[bptree2.Key{Key1: i / 100, Key2: i % 100}] = i
+	}
+	if err := tree.InsertBlob(srcID, 500, 0, []byte("source blob")); err != nil {
+		t.Fatalf("InsertBlob failed: %v", err)
+	}
+	bucket, err := tree.CreateBucket(srcID, 600, 0)
+	if err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	for i := uint64(0); i < 1000; i++ {
+		bucket.Insert(i, 0, i)
+	}
+
+	forkID, err := tree.ForkRoot(srcID)
+	if err != nil {
+		t.Fatalf("ForkRoot failed: %v", err)
+	}
+	if count := tree.RootCount(); count != 3 {
+		t.Errorf("expected 3 roots, got %d", count)
+	}
+	if src, fork := collect(t, tree, srcID), collect(t, tree, forkID); !maps.Equal(src, fork) {
+		t.Fatalf("fork differs from its source: %d and %d entries", len(src), len(fork))
+	}
+
+	// Each tree takes its own changes
+	forkWant := maps.Clone(want)
+	for i := uint64(0); i < 3000; i++ {
+		tree.Insert(forkID, 1000+i, 0, i)
+		forkWant[bptree2.Key{Key1: 1000 + i, Key2: 0}] = i
+		tree.Delete(forkID, i/100, i%100)
+		delete(forkWant, bptree2.Key{Key1: i / 100, Key2: i % 100})
+	}
+	if _, err := tree.DeleteRange(forkID, 100, 0, 199, ^uint64(0)); err != nil {
+		t.Fatalf("DeleteRange failed: %v", err)
+	}
+	for i := uint64(10000); i < 20000; i++ {
+		delete(forkWant, bptree2.Key{Key1: i / 100, Key2: i % 100})
+	}
+	var batch bptree2.Batch
+	for i := uint64(0); i < 500; i++ {
+		batch.Put(250, i, 7)
+		forkWant[bptree2.Key{Key1: 250, Key2: i}] = 7
+	}
+	if _, err := tree.ApplyBatch(forkID, &batch); err != nil {
+		t.Fatalf("ApplyBatch failed: %v", err)
+	}
+	if err := tree.InsertBlob(forkID, 500, 0, []byte("fork blob")); err != nil {
+		t.Fatalf("InsertBlob failed: %v", err)
+	}
+	tree.Insert(srcID, 5, 5, 999)
+	want[bptree2.Key{Key1: 5, Key2: 5}] = 999
+
+	forkBucket, err := tree.Bucket(forkID, 600, 0)
+	if err != nil {
+		t.Fatalf("Bucket failed: %v", err)
+	}
+	if forkBucket.RootID() == bucket.RootID() {
+		t.Error("a copied leaf should fork its buckets")
+	}
+	forkBucket.Insert(5000, 0, 1)
+	bucket.Delete(0, 0)
+
+	check := func() {
+		t.Helper()
+		srcWant, fork := maps.Clone(want), maps.Clone(forkWant)
+		src, err := tree.Bucket(srcID, 600, 0)
+		if err != nil {
+			t.Fatalf("Bucket failed: %v", err)
+		}
+		srcWant[bptree2.Key{Key1: 500, Key2: 0}] = collect(t, tree, srcID)[bptree2.Key{Key1: 500, Key2: 0}]
+		srcWant[bptree2.Key{Key1: 600, Key2: 0}] = src.RootID()
+		if got := collect(t, tree, srcID); !maps.Equal(got, srcWant) {
+			t.Errorf("source: expected %d entries, got %d", len(srcWant), len(got))
+		}
+		forkGot := collect(t, tree, forkID)
+		fork[bptree2.Key{Key1: 500, Key2: 0}] = forkGot[bptree2.Key{Key1: 500, Key2: 0}]
+		if b, err := tree.Bucket(forkID, 600, 0); err == nil {
+			fork[bptree2.Key{Key1: 600, Key2: 0}] = b.RootID()
+		}
+		if !maps.Equal(forkGot, fork) {
+			t.Errorf("fork: expected %d entries, got %d", len(fork), len(forkGot))
+		}
+
+		if data, _, err := tree.FindBlob(srcID, 500, 0); err != nil || string(data) != "source blob" {
+			t.Errorf("source blob: got %q", data)
+		}
+		if data, _, err := tree.FindBlob(forkID, 500, 0); err != nil || string(data) != "fork blob" {
+			t.Errorf("fork blob: got %q", data)
+		}
+		if count, err := src.Count(); err != nil || count != 999 {
+			t.Errorf("source bucket: expected 999 keys, got %d", count)
+		}
+		if b, err := tree.Bucket(forkID, 600, 0); err != nil {
+			t.Errorf("fork bucket: %v", err)
+		} else if count, err := b.Count(); err != nil || count != 1001 {
+			t.Errorf("fork bucket: expected 1001 keys, got %d (%v)", count, err)
+		}
+	}
+	check()
+
+	// Shared pages and their counts survive a restart
+	tree.Close()
+	tree, err = bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	check()
+
+	// Deleting the source leaves the fork intact
+	if err := tree.DeleteRoot(srcID); err != nil {
+		t.Fatalf("DeleteRoot failed: %v", err)
+	}
+	if err := tree.Reclaim(); err != nil {
+		t.Fatalf("Reclaim failed: %v", err)
+	}
+	// forkWant leaves out the blob and the bucket
+	if got := collect(t, tree, forkID); len(got) != len(forkWant)+2 {
+		t.Errorf("fork after deleting the source: expected %d entries, got %d", len(forkWant)+2, len(got))
+	}
+	if data, _, err := tree.FindBlob(forkID, 500, 0); err != nil || string(data) != "fork blob" {
+		t.Errorf("fork blob after deleting the source: got %q", data)
+	}
+	if b, err := tree.Bucket(forkID, 600, 0); err != nil {
+		t.Errorf("fork bucket after deleting the source: %v", err)
+	} else if count, err := b.Count(); err != nil || count != 1001 {
+		t.Errorf("fork bucket after deleting the source: expected 1001 keys, got %d (%v)", count, err)
+	}
+
+	// Deleting the fork as well frees every page of both trees
+	if err := tree.DeleteRoot(forkID); err != nil {
+		t.Fatalf("DeleteRoot failed: %v", err)
+	}
+	if err := tree.Reclaim(); err != nil {
+		t.Fatalf("Reclaim failed: %v", err)
+	}
+	if count := tree.RootCount(); count != 0 {
+		t.Errorf("expected 0 roots, got %d", count)
+	}
+	tree.Close()
+
+	p, err := bpager.Open(path)
+	if err != nil {
+		t.Fatalf("bpager.Open failed: %v", err)
+	}
+	defer p.Close()
+	pageCount := p.PageCount()
+	tx := p.Begin(true)
+	defer tx.Rollback()
+	free := uint64(0)
+	for {
+		id, err := tx.AllocatePage()
+		if err != nil {
+			t.Fatalf("AllocatePage failed: %v", err)
+		}
+		if id >= pageCount {
+			break
+		}
+		free++
+	}
+	// Only the meta page, the root directory and the reference table remain
+	if used := pageCount - free; used > 8 {
+		t.Errorf("expected every tree page to be freed, %d of %d pages still in use", used, pageCount)
+	}
+}
+
+func TestForkBytesTree(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer tree.Close()
+
+	srcID, _ := tree.CreateRoot()
+	src := tree.Bytes(srcID)
+	for i := 0; i < 5000; i++ {
+		src.Insert([]byte(fmt.Sprintf("key-%05d", i)), []byte(fmt.Sprintf("value-%d", i)))
+	}
+
+	forkID, err := tree.ForkRoot(srcID)
+	if err != nil {
+		t.Fatalf("ForkRoot failed: %v", err)
+	}
+	fork := tree.Bytes(forkID)
+	for i := 0; i < 5000; i += 2 {
+		fork.Delete([]byte(fmt.Sprintf("key-%05d", i)))
+	}
+	fork.Insert([]byte("key-99999"), []byte("fork only"))
+
+	if count, err := src.Count(); err != nil || count != 5000 {
+		t.Errorf("source: expected 5000 keys, got %d", count)
+	}
+	if count, err := fork.Count(); err != nil || count != 2501 {
+		t.Errorf("fork: expected 2501 keys, got %d", count)
+	}
+	if _, found, err := src.Find([]byte("key-99999")); err != nil || found {
+		t.Error("a key inserted into the fork should not be in the source")
+	}
+	if value, found, err := fork.Find([]byte("key-00001")); err != nil || !found || string(value) != "value-1" {
+		t.Errorf("fork: expected value-1, got %q (found=%v)", value, found)
+	}
+}
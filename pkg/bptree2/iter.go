@@ -0,0 +1,125 @@
+package bptree2
+
+import (
+	"iter"
+
+	"bptree2/bnode"
+)
+
+// Key is a composite key (Key1, Key2).
+type Key = bnode.Key
+
+// MaxKey is the largest possible composite key.
+var MaxKey = Key{Key1: ^uint64(0), Key2: ^uint64(0)}
+
+// AllErr returns an iterator over all key-value pairs of a root tree in key
+// order, and a function that reports the error that ended the iterator's last
+// loop early, or nil if it ran to completion or was stopped by the loop. The
+// error belongs to the iterator alone.
+//
+//	seq, errf := tree.AllErr(rootID)
+//	for k, v := range seq {
+//		...
+//	}
+//	if err := errf(); err != nil {
+//		...
+//	}
+//
+// Each loop reads from its own snapshot, taken when the loop starts.
+// Breaking out of the loop stops the leaf walk. The tree is only locked
+// while a leaf is read, not while the loop body runs, so the body may use
+// the tree, Flash included.
+func (t *BPTree) AllErr(rootID RootID) (iter.Seq2[Key, uint64], func() error) {
+	return t.seqErr(func(tx *Tx) (iter.Seq2[Key, uint64], func() error) {
+		return tx.AllErr(rootID)
+	})
+}
+
+// RangeErr is like AllErr, but iterates over the key-value pairs with
+// from <= key <= to, in ascending key order.
+func (t *BPTree) RangeErr(rootID RootID, from, to Key) (iter.Seq2[Key, uint64], func() error) {
+	return t.seqErr(func(tx *Tx) (iter.Seq2[Key, uint64], func() error) {
+		return tx.RangeErr(rootID, from, to)
+	})
+}
+
+// BackwardErr is like AllErr, but iterates over the key-value pairs with
+// from <= key <= to, in descending key order.
+func (t *BPTree) BackwardErr(rootID RootID, from, to Key) (iter.Seq2[Key, uint64], func() error) {
+	return t.seqErr(func(tx *Tx) (iter.Seq2[Key, uint64], func() error) {
+		return tx.BackwardErr(rootID, from, to)
+	})
+}
+
+// seqErr returns an iterator that runs the iterator returned by txSeq in a
+// read-only transaction of its own, and a function that reports its error.
+func (t *BPTree) seqErr(txSeq func(tx *Tx) (iter.Seq2[Key, uint64], func() error)) (iter.Seq2[Key, uint64], func() error) {
+	var err error
+	seq := func(yield func(Key, uint64) bool) {
+		err = t.view(func(tx *Tx) error {
+			seq, errf := txSeq(tx)
+			seq(yield)
+			return errf()
+		})
+	}
+	return seq, func() error { return err }
+}
+
+// AllErr returns an iterator over all key-value pairs of a root tree in key
+// order, as seen by the transaction, and a function that reports the error
+// that ended the iterator's last loop early, or nil.
+func (tx *Tx) AllErr(rootID RootID) (iter.Seq2[Key, uint64], func() error) {
+	return tx.RangeErr(rootID, Key{}, MaxKey)
+}
+
+// RangeErr is like AllErr, but iterates over the key-value pairs with
+// from <= key <= to, in ascending key order.
+func (tx *Tx) RangeErr(rootID RootID, from, to Key) (iter.Seq2[Key, uint64], func() error) {
+	return seqErr(func(fn func(key1, key2, value uint64) bool) error {
+		return tx.FindRange(rootID, from.Key1, from.Key2, to.Key1, to.Key2, fn)
+	})
+}
+
+// BackwardErr is like AllErr, but iterates over the key-value pairs with
+// from <= key <= to, in descending key order.
+func (tx *Tx) BackwardErr(rootID RootID, from, to Key) (iter.Seq2[Key, uint64], func() error) {
+	return seqErr(func(fn func(key1, key2, value uint64) bool) error {
+		return tx.FindRangeReverse(rootID, from.Key1, from.Key2, to.Key1, to.Key2, fn)
+	})
+}
+
+// seqErr returns an iterator over the pairs that scan passes to its callback,
+// and a function that returns the error of the iterator's last loop.
+func seqErr(scan func(fn func(key1, key2, value uint64) bool) error) (iter.Seq2[Key, uint64], func() error) {
+	var err error
+	seq := func(yield func(Key, uint64) bool) {
+		err = scan(yieldPairs(yield))
+	}
+	return seq, func() error { return err }
+}
+
+// AllErr returns an iterator over all key-value pairs of a root tree in key
+// order, and a function that reports the error that ended the iterator's
+// last loop early, or nil.
+func (s *Snapshot) AllErr(rootID RootID) (iter.Seq2[Key, uint64], func() error) {
+	return s.tx.AllErr(rootID)
+}
+
+// RangeErr is like AllErr, but iterates over the key-value pairs with
+// from <= key <= to, in ascending key order.
+func (s *Snapshot) RangeErr(rootID RootID, from, to Key) (iter.Seq2[Key, uint64], func() error) {
+	return s.tx.RangeErr(rootID, from, to)
+}
+
+// BackwardErr is like AllErr, but iterates over the key-value pairs with
+// from <= key <= to, in descending key order.
+func (s *Snapshot) BackwardErr(rootID RootID, from, to Key) (iter.Seq2[Key, uint64], func() error) {
+	return s.tx.BackwardErr(rootID, from, to)
+}
+
+// yieldPairs adapts an iterator's yield function to a scan callback.
+func yieldPairs(yield func(Key, uint64) bool) func(key1, key2, value uint64) bool {
+	return func(key1, key2, value uint64) bool {
+		return yield(Key{Key1: key1, Key2: key2}, value)
+	}
+}
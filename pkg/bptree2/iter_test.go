@@ -0,0 +1,191 @@
+package bptree2_test
+
+import (
+	"bptree2"
+	"maps"
+	"path/filepath"
+	"testing"
+)
+
+func TestIterators(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer tree.Close()
+
+	rootID, _ := tree.CreateRoot()
+	for i := uint64(0); i < 3000; i++ {
+		tree.Insert(rootID, i, i%3, i*10)
+	}
+
+	// AllErr composes with the maps helpers
+	seq, allErr := tree.AllErr(rootID)
+	all := maps.Collect(seq)
+	if len(all) != 3000 {
+		t.Fatalf("expected 3000 entries, got %d", len(all))
+	}
+	if all[bptree2.Key{Key1: 1234, Key2: 1234 % 3}] != 12340 {
+		t.Errorf("unexpected value for key 1234: %d", all[bptree2.Key{Key1: 1234, Key2: 1234 % 3}])
+	}
+
+	// RangeErr in ascending order
+	expected := uint64(100)
+	seq, rangeErr := tree.RangeErr(rootID, bptree2.Key{Key1: 100}, bptree2.Key{Key1: 2000, Key2: 2})
+	for k, v := range seq {
+		if k.Key1 != expected || v != expected*10 {
+			t.Fatalf("Range: expected key1 %d, got %d (value %d)", expected, k.Key1, v)
+		}
+		expected++
+	}
+	if expected != 2001 {
+		t.Errorf("Range: expected to end after 2000, ended at %d", expected-1)
+	}
+
+	// BackwardErr in descending order
+	expected = 2000
+	seq, backwardErr := tree.BackwardErr(rootID, bptree2.Key{Key1: 100}, bptree2.Key{Key1: 2000, Key2: 2})
+	for k := range seq {
+		if k.Key1 != expected {
+			t.Fatalf("Backward: expected key1 %d, got %d", expected, k.Key1)
+		}
+		expected--
+	}
+	if expected != 99 {
+		t.Errorf("Backward: expected to end after 100, ended at %d", expected+1)
+	}
+
+	for _, errf := range []func() error{allErr, rangeErr, backwardErr} {
+		if err := errf(); err != nil {
+			t.Errorf("unexpected scan error: %v", err)
+		}
+	}
+}
+
+func TestIteratorBreak(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer tree.Close()
+
+	rootID, _ := tree.CreateRoot()
+	for i := uint64(0); i < 5000; i++ {
+		tree.Insert(rootID, i, 0, i)
+	}
+
+	count := 0
+	seq, allErr := tree.AllErr(rootID)
+	for range seq {
+		count++
+		if count == 10 {
+			break
+		}
+	}
+	if count != 10 {
+		t.Errorf("expected to stop after 10 entries, got %d", count)
+	}
+	seq, backwardErr := tree.BackwardErr(rootID, bptree2.Key{}, bptree2.MaxKey)
+	for range seq {
+		break
+	}
+	if err := allErr(); err != nil {
+		t.Errorf("break should not be an error, got %v", err)
+	}
+	if err := backwardErr(); err != nil {
+		t.Errorf("break should not be an error, got %v", err)
+	}
+
+	// The snapshots were released, so Flash does not wait
+	if err := tree.Flash(); err != nil {
+		t.Fatalf("Flash failed: %v", err)
+	}
+}
+
+func TestIteratorErrors(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	rootID, _ := tree.CreateRoot()
+	tree.Insert(rootID, 1, 1, 1)
+
+	tx, _ := tree.Begin(false)
+	tx.Rollback()
+	seq, errf := tx.AllErr(rootID)
+	for range seq {
+		t.Error("iterator of a finished transaction should yield nothing")
+	}
+	if err := errf(); err != bptree2.ErrTxDone {
+		t.Errorf("expected ErrTxDone, got %v", err)
+	}
+
+	tree.Close()
+	seq, errf = tree.AllErr(rootID)
+	for range seq {
+		t.Error("iterator of a closed tree should yield nothing")
+	}
+	if err := errf(); err != bptree2.ErrClosed {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+}
+
+func TestIteratorErrFunc(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	rootID, _ := tree.CreateRoot()
+	for i := uint64(0); i < 3000; i++ {
+		tree.Insert(rootID, i, 0, i)
+	}
+
+	tx, _ := tree.Begin(false)
+	tx.Rollback()
+
+	// Each iterator keeps its own error, even when one runs inside the other
+	outer, outerErr := tree.AllErr(rootID)
+	inner, innerErr := tx.BackwardErr(rootID, bptree2.Key{}, bptree2.MaxKey)
+	count := 0
+	for range outer {
+		if count == 0 {
+			for range inner {
+				t.Error("iterator of a finished transaction should yield nothing")
+			}
+		}
+		count++
+	}
+	if count != 3000 {
+		t.Errorf("expected 3000 entries, got %d", count)
+	}
+	if err := outerErr(); err != nil {
+		t.Errorf("outer: unexpected error %v", err)
+	}
+	if err := innerErr(); err != bptree2.ErrTxDone {
+		t.Errorf("inner: expected ErrTxDone, got %v", err)
+	}
+
+	seq, errf := tree.RangeErr(rootID, bptree2.Key{Key1: 10}, bptree2.Key{Key1: 20})
+	tree.Close()
+	for range seq {
+		t.Error("iterator of a closed tree should yield nothing")
+	}
+	if err := errf(); err != bptree2.ErrClosed {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+	if err := outerErr(); err != nil {
+		t.Errorf("outer: error changed by another iterator: %v", err)
+	}
+}
@@ -0,0 +1,208 @@
+package bptree2
+
+import (
+	"errors"
+	"fmt"
+
+	"bptree2/bnode"
+	"bptree2/bpager"
+)
+
+// reclaimBatch is the number of pages the background reclaimer frees in one
+// transaction. Writers wait for at most one batch.
+const reclaimBatch = 1024
+
+// DeleteRoot deletes a root tree and frees all of its pages. See Tx.DeleteRoot.
+func (t *BPTree) DeleteRoot(rootID RootID) error {
+	return t.update(func(tx *Tx) error {
+		return tx.DeleteRoot(rootID)
+	})
+}
+
+// ClearRoot removes all keys from a root tree, but keeps its ID reserved.
+// See Tx.ClearRoot.
+func (t *BPTree) ClearRoot(rootID RootID) error {
+	return t.update(func(tx *Tx) error {
+		return tx.ClearRoot(rootID)
+	})
+}
+
+// Reclaim frees the pages of deleted and cleared trees that the background
+// reclaimer has not freed yet, and returns once all of them are free.
+// Like the reclaimer, it releases the write lock between batches.
+// An error that stopped the background reclaimer since the last Flash or
+// Reclaim is returned once the pages are free.
+func (t *BPTree) Reclaim() error {
+	for {
+		more := false
+		err := t.update(func(tx *Tx) (err error) {
+			more, err = tx.reclaim(reclaimBatch)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if !more {
+			break
+		}
+	}
+
+	t.wmu.Lock()
+	defer t.wmu.Unlock()
+	return t.takeReclaimErr()
+}
+
+// DeleteRoot deletes a root tree, and with it the buckets nested in it.
+// A named tree loses its name, so that OpenTree does not return the ID once
+// CreateRoot hands it out again.
+//
+// The pages of the tree are freed in the background once the transaction
+// has committed, in batches that leave room for other writers; Reclaim waits
+// for them. Pages that are still queued when the tree is closed are freed
+// after the next Open.
+func (tx *Tx) DeleteRoot(rootID RootID) (err error) {
+	defer recoverCorrupt(&err)
+	if err := tx.checkWritable(); err != nil {
+		return err
+	}
+	if err := tx.detachRoot(rootID); err != nil {
+		return err
+	}
+	if err := tx.unregisterTree(rootID); err != nil {
+		return err
+	}
+	return tx.pages.DeleteRoot(rootID)
+}
+
+// ClearRoot removes all keys from a root tree, but keeps its ID reserved.
+// An augmented root stays augmented. The pages are freed as for DeleteRoot.
+func (tx *Tx) ClearRoot(rootID RootID) (err error) {
+	defer recoverCorrupt(&err)
+	if err := tx.checkWritable(); err != nil {
+		return err
+	}
+
+	rootPageID := tx.rootPage(rootID)
+	if rootPageID == 0 {
+		return nil
+	}
+	augmented := bnode.IsAugmented(tx.page(rootPageID))
+
+	if err := tx.detachRoot(rootID); err != nil {
+		return err
+	}
+	if err := tx.pages.SetRootPage(rootID, bpager.ReservedMarker); err != nil {
+		return err
+	}
+	if augmented {
+		return tx.createAugmentedLeaf(rootID)
+	}
+	return nil
+}
+
+// detachRoot queues the pages of a root tree for reclaiming.
+func (tx *Tx) detachRoot(rootID RootID) error {
+	rootPageID := tx.rootPage(rootID)
+	if rootPageID == 0 {
+		return nil
+	}
+	if err := tx.pages.PushReclaim(rootPageID); err != nil {
+		return err
+	}
+	tx.reclaimQueued = true
+	return nil
+}
+
+// reclaim frees up to limit pages from the reclaim queue, queueing the
+// children of the internal nodes it frees. A page shared with a fork only
+// loses a reference, and its children are left alone. Returns true if pages remain.
+func (tx *Tx) reclaim(limit int) (bool, error) {
+	for i := 0; i < limit; i++ {
+		pageID, ok, err := tx.pages.PopReclaim()
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			return false, nil
+		}
+		if tx.shared(pageID) {
+			if err := tx.pages.FreePage(pageID); err != nil {
+				return false, err
+			}
+			continue
+		}
+
+		data := tx.page(pageID)
+		if data == nil {
+			return false, fmt.Errorf("failed to get page %d", pageID)
+		}
+
+		children := nodeChildren(data)
+		if bnode.GetNodeType(data) == bnode.NodeTypeLeaf {
+			if err := tx.freeValues(bnode.NewLeafNode(data, false)); err != nil {
+				return false, err
+			}
+		}
+
+		for _, child := range children {
+			if err := tx.pages.PushReclaim(child); err != nil {
+				return false, err
+			}
+		}
+		if err := tx.pages.FreePage(pageID); err != nil {
+			return false, err
+		}
+	}
+	return tx.pages.ReclaimPending(), nil
+}
+
+// startReclaim starts the background reclaimer unless it is running.
+func (t *BPTree) startReclaim() {
+	if t.reclaiming.CompareAndSwap(false, true) {
+		go t.reclaimLoop()
+	}
+}
+
+// reclaimLoop frees queued pages in batches until the queue is empty or the
+// tree is closed.
+func (t *BPTree) reclaimLoop() {
+	for {
+		more := false
+		err := t.update(func(tx *Tx) (err error) {
+			more, err = tx.reclaim(reclaimBatch)
+			return err
+		})
+		if err == nil && more {
+			continue
+		}
+
+		// The error is kept for the next Flash or Reclaim; the pages stay
+		// queued until a commit or Open starts the reclaimer again
+		if err != nil {
+			t.reclaiming.Store(false)
+			if !errors.Is(err, ErrClosed) {
+				t.wmu.Lock()
+				t.reclaimErr = err
+				t.wmu.Unlock()
+			}
+			return
+		}
+
+		// Pick up pages queued after the last batch looked at the queue
+		t.reclaiming.Store(false)
+		if !t.pager.ReclaimPending() || !t.reclaiming.CompareAndSwap(false, true) {
+			return
+		}
+	}
+}
+
+// takeReclaimErr returns and clears the error that stopped the background
+// reclaimer. Must be called with t.wmu held.
+func (t *BPTree) takeReclaimErr() error {
+	err := t.reclaimErr
+	t.reclaimErr = nil
+	if err != nil {
+		return fmt.Errorf("background reclaim failed: %w", err)
+	}
+	return nil
+}
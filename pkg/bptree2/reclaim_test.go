@@ -0,0 +1,209 @@
+package bptree2_test
+
+import (
+	"bptree2"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDeleteRootFreesPages(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer tree.Close()
+
+	fill := func(rootID bptree2.RootID) {
+		for i := uint64(0); i < 100000; i++ {
+			tree.Insert(rootID, i/1000, i%1000, i)
+		}
+		if err := tree.InsertBlob(rootID, 50, 0, make([]byte, 100000)); err != nil {
+			t.Fatalf("InsertBlob failed: %v", err)
+		}
+	}
+
+	rootID, _ := tree.CreateRoot()
+	fill(rootID)
+	tree.Flash()
+	info, _ := os.Stat(path)
+	size := info.Size()
+
+	// Each new tree reuses the pages of the deleted one
+	for round := 0; round < 3; round++ {
+		if err := tree.DeleteRoot(rootID); err != nil {
+			t.Fatalf("DeleteRoot failed: %v", err)
+		}
+		if count := tree.RootCount(); count != 0 {
+			t.Fatalf("expected 0 roots, got %d", count)
+		}
+		if err := tree.Reclaim(); err != nil {
+			t.Fatalf("Reclaim failed: %v", err)
+		}
+		rootID, _ = tree.CreateRoot()
+		fill(rootID)
+	}
+	tree.Flash()
+	info, _ = os.Stat(path)
+	if info.Size() > size {
+		t.Errorf("file grew from %d to %d bytes", size, info.Size())
+	}
+	if count, err := tree.Count(rootID); err != nil || count != 100000 {
+		t.Errorf("expected 100000 keys, got %d", count)
+	}
+}
+
+func TestClearRoot(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer tree.Close()
+
+	plainID, _ := tree.CreateRoot()
+	augID, _ := tree.CreateAugmentedRoot()
+	otherID, _ := tree.CreateRoot()
+	for i := uint64(0); i < 20000; i++ {
+		tree.Insert(plainID, i, i, i)
+		tree.Insert(augID, i, i, i)
+		tree.Insert(otherID, i, i, i)
+	}
+
+	for _, rootID := range []bptree2.RootID{plainID, augID} {
+		if err := tree.ClearRoot(rootID); err != nil {
+			t.Fatalf("ClearRoot failed: %v", err)
+		}
+		if count, err := tree.Count(rootID); err != nil || count != 0 {
+			t.Errorf("root %d: expected 0 keys after ClearRoot, got %d", rootID, count)
+		}
+	}
+	if count := tree.RootCount(); count != 3 {
+		t.Errorf("ClearRoot should keep the root IDs, got %d roots", count)
+	}
+
+	// The new root ID is not handed out again and the cleared trees are usable
+	newID, _ := tree.CreateRoot()
+	if newID == plainID || newID == augID {
+		t.Errorf("cleared root ID %d reused", newID)
+	}
+	tree.Insert(plainID, 1, 1, 10)
+	tree.Insert(augID, 1, 1, 10)
+	if val, found, err := tree.Find(plainID, 1, 1); err != nil || !found || val != 10 {
+		t.Errorf("expected 10 after refill, got %d, %v", val, found)
+	}
+	if agg, err := tree.Aggregate(augID, 0, 0, ^uint64(0), ^uint64(0)); err != nil || agg.Count != 1 || agg.Sum != 10 {
+		t.Errorf("cleared augmented root: got %+v, %v", agg, err)
+	}
+
+	if err := tree.Reclaim(); err != nil {
+		t.Fatalf("Reclaim failed: %v", err)
+	}
+	if count, err := tree.Count(otherID); err != nil || count != 20000 {
+		t.Errorf("other root: expected 20000 keys, got %d", count)
+	}
+}
+
+func TestReclaimAfterReopen(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	// Deleting in a transaction and closing right after leaves pages queued
+	rootID, _ := tree.CreateRoot()
+	keepID, _ := tree.CreateRoot()
+	for i := uint64(0); i < 200000; i++ {
+		tree.Insert(rootID, i, 0, i)
+	}
+	tree.Insert(keepID, 1, 1, 1)
+	tree.Flash()
+	info, _ := os.Stat(path)
+	size := info.Size()
+
+	tx, _ := tree.Begin(true)
+	if err := tx.DeleteRoot(rootID); err != nil {
+		t.Fatalf("DeleteRoot failed: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	tree.Close()
+
+	tree, err = bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer tree.Close()
+	if err := tree.Reclaim(); err != nil {
+		t.Fatalf("Reclaim failed: %v", err)
+	}
+
+	rootID, _ = tree.CreateRoot()
+	for i := uint64(0); i < 200000; i++ {
+		tree.Insert(rootID, i, 0, i)
+	}
+	tree.Flash()
+	info, _ = os.Stat(path)
+	if info.Size() > size {
+		t.Errorf("file grew from %d to %d bytes", size, info.Size())
+	}
+	if val, found, err := tree.Find(keepID, 1, 1); err != nil || !found || val != 1 {
+		t.Errorf("other root lost its key")
+	}
+}
+
+func TestReclaimError(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	rootID, _ := tree.CreateRoot()
+	for i := uint64(0); i < 10000; i++ {
+		tree.Insert(rootID, i, 0, i)
+	}
+	tree.Close()
+
+	corruptPage(t, path, firstLeaf(t, path, rootID))
+
+	tree, err = bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer tree.Close()
+
+	// The background reclaimer stops at the corrupt leaf; the next Flash
+	// reports it, once
+	if err := tree.DeleteRoot(rootID); err != nil {
+		t.Fatalf("DeleteRoot failed: %v", err)
+	}
+	var corrupt bptree2.ErrCorruptPage
+	deadline := time.Now().Add(5 * time.Second)
+	for err = tree.Flash(); err == nil && time.Now().Before(deadline); err = tree.Flash() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !errors.As(err, &corrupt) {
+		t.Fatalf("Flash: expected ErrCorruptPage, got %v", err)
+	}
+	if err := tree.Flash(); err != nil {
+		t.Errorf("the error should be reported once, got %v", err)
+	}
+
+	// The pages stay queued, and Reclaim runs into the same page
+	if err := tree.Reclaim(); !errors.As(err, &corrupt) {
+		t.Errorf("Reclaim: expected ErrCorruptPage, got %v", err)
+	}
+}
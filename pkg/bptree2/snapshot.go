@@ -0,0 +1,100 @@
+package bptree2
+
+// Snapshot is a consistent, read-only view of a BPTree.
+//
+// A Snapshot keeps seeing the root table and pages as they were when it was
+// taken, while writers continue to commit. The pager keeps a copy on the
+// heap of every page a commit overwrites while the snapshot may still read
+// it, and pages freed since it was taken are not reused until it is
+// released. A snapshot that is held on to costs memory with every commit,
+// and makes the file grow.
+//
+// A Snapshot is a read-only Tx: it can be read from several goroutines at
+// once, but must not be released while they still use it.
+type Snapshot struct {
+	tx *Tx
+}
+
+// Snapshot takes a snapshot of the tree. It must be released with Release.
+func (t *BPTree) Snapshot() (*Snapshot, error) {
+	tx, err := t.Begin(false)
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshot{tx: tx}, nil
+}
+
+// Find retrieves a value by composite key (key1, key2) from a specific root tree.
+// Returns (value, true) if found, (0, false) otherwise.
+func (s *Snapshot) Find(rootID RootID, key1, key2 uint64) (uint64, bool, error) {
+	return s.tx.Find(rootID, key1, key2)
+}
+
+// FindRange iterates over all key-value pairs where (start1,start2) <= (key1,key2) <= (end1,end2).
+// The callback function is called for each pair. Return false to stop iteration.
+func (s *Snapshot) FindRange(rootID RootID, start1, start2, end1, end2 uint64, fn func(key1, key2, value uint64) bool) error {
+	return s.tx.FindRange(rootID, start1, start2, end1, end2, fn)
+}
+
+// FindRangeReverse is like FindRange, but calls fn in descending key order.
+func (s *Snapshot) FindRangeReverse(rootID RootID, start1, start2, end1, end2 uint64, fn func(key1, key2, value uint64) bool) error {
+	return s.tx.FindRangeReverse(rootID, start1, start2, end1, end2, fn)
+}
+
+// Count returns the number of key-value pairs in a tree.
+// This is an O(1) operation.
+func (s *Snapshot) Count(rootID RootID) (int, error) {
+	return s.tx.Count(rootID)
+}
+
+// CountRange returns the number of key-value pairs where
+// (start1,start2) <= (key1,key2) <= (end1,end2).
+func (s *Snapshot) CountRange(rootID RootID, start1, start2, end1, end2 uint64) (int, error) {
+	return s.tx.CountRange(rootID, start1, start2, end1, end2)
+}
+
+// Rank returns the position of (key1, key2) in key order and whether the key exists.
+func (s *Snapshot) Rank(rootID RootID, key1, key2 uint64) (int, bool, error) {
+	return s.tx.Rank(rootID, key1, key2)
+}
+
+// Select returns the key-value pair at position i in key order, counting from 0.
+func (s *Snapshot) Select(rootID RootID, i int) (key1, key2, value uint64, ok bool, err error) {
+	return s.tx.Select(rootID, i)
+}
+
+// Aggregate returns the count, sum, minimum and maximum of the values where
+// (start1,start2) <= (key1,key2) <= (end1,end2).
+func (s *Snapshot) Aggregate(rootID RootID, start1, start2, end1, end2 uint64) (Aggregate, error) {
+	return s.tx.Aggregate(rootID, start1, start2, end1, end2)
+}
+
+// Bytes returns a read-only view of a []byte-keyed root tree as of the snapshot.
+func (s *Snapshot) Bytes(rootID RootID) *BytesTree {
+	return s.tx.Bytes(rootID)
+}
+
+// Bucket returns a read-only view of a bucket as of the snapshot.
+func (s *Snapshot) Bucket(rootID RootID, key1, key2 uint64) (*Bucket, error) {
+	return s.tx.Bucket(rootID, key1, key2)
+}
+
+// OpenTree returns the ID of the root tree registered under name.
+func (s *Snapshot) OpenTree(name string) (RootID, error) {
+	return s.tx.OpenTree(name)
+}
+
+// ListTrees returns the names of all named trees in byte order.
+func (s *Snapshot) ListTrees() ([]string, error) {
+	return s.tx.ListTrees()
+}
+
+// RootCount returns the number of active root trees.
+func (s *Snapshot) RootCount() uint64 {
+	return s.tx.pages.RootCount()
+}
+
+// Release releases the snapshot and lets the pages only it could reach be reused.
+func (s *Snapshot) Release() error {
+	return s.tx.Rollback()
+}
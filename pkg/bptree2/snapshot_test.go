@@ -0,0 +1,256 @@
+package bptree2_test
+
+import (
+	"bptree2"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSnapshotConsistency(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer tree.Close()
+
+	rootID, _ := tree.CreateRoot()
+	for i := uint64(0); i < 5000; i++ {
+		tree.Insert(rootID, i, 0, i)
+	}
+	tree.Flash()
+
+	snap, err := tree.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+
+	// Rewrite the tree underneath the snapshot: updates, splits and merges
+	for i := uint64(0); i < 5000; i += 2 {
+		tree.Delete(rootID, i, 0)
+	}
+	for i := uint64(1); i < 5000; i += 2 {
+		tree.Insert(rootID, i, 0, i*100)
+	}
+	for i := uint64(5000); i < 10000; i++ {
+		tree.Insert(rootID, i, 0, i)
+	}
+	other, _ := tree.CreateRoot()
+	tree.Insert(other, 1, 1, 1)
+	tree.Flash()
+
+	if count, err := snap.Count(rootID); err != nil || count != 5000 {
+		t.Errorf("snapshot: expected 5000 entries, got %d", count)
+	}
+	if snap.RootCount() != 1 {
+		t.Errorf("snapshot: expected 1 root, got %d", snap.RootCount())
+	}
+	expected := uint64(0)
+	snap.FindRange(rootID, 0, 0, ^uint64(0), ^uint64(0), func(key1, key2, value uint64) bool {
+		if key1 != expected || value != expected {
+			t.Fatalf("snapshot: expected key %d, got key %d value %d", expected, key1, value)
+		}
+		expected++
+		return true
+	})
+	if val, found, err := snap.Find(rootID, 7, 0); err != nil || !found || val != 7 {
+		t.Errorf("snapshot: expected old value 7, got %d (found=%v)", val, found)
+	}
+
+	// The tree itself sees the new state
+	if count, err := tree.Count(rootID); err != nil || count != 7500 {
+		t.Errorf("tree: expected 7500 entries, got %d", count)
+	}
+	if val, _, err := tree.Find(rootID, 7, 0); err != nil || val != 700 {
+		t.Errorf("tree: expected new value 700, got %d", val)
+	}
+
+	if err := snap.Release(); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	if err := snap.Release(); err != bptree2.ErrTxDone {
+		t.Errorf("second Release should return ErrTxDone, got %v", err)
+	}
+}
+
+func TestSnapshotDoesNotBlockWriters(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer tree.Close()
+
+	rootID, _ := tree.CreateRoot()
+	for i := uint64(0); i < 1000; i++ {
+		tree.Insert(rootID, i, 0, i)
+	}
+
+	snap, _ := tree.Snapshot()
+	defer snap.Release()
+
+	// Commit from another goroutine while the scan is in progress
+	count := 0
+	snap.FindRange(rootID, 0, 0, ^uint64(0), ^uint64(0), func(key1, key2, value uint64) bool {
+		if count == 10 {
+			done := make(chan error)
+			go func() {
+				for i := uint64(0); i < 1000; i++ {
+					if err := tree.Insert(rootID, i+1000, 0, i); err != nil {
+						done <- err
+						return
+					}
+				}
+				tree.Delete(rootID, 500, 0)
+				done <- nil
+			}()
+			select {
+			case err := <-done:
+				if err != nil {
+					t.Errorf("Insert failed: %v", err)
+				}
+			case <-time.After(10 * time.Second):
+				t.Fatal("writer blocked by a snapshot scan")
+			}
+		}
+		count++
+		return true
+	})
+
+	if count != 1000 {
+		t.Errorf("scan should see the snapshot only, got %d entries", count)
+	}
+	if count, err := tree.Count(rootID); err != nil || count != 1999 {
+		t.Errorf("expected 1999 entries after the writer, got %d (%v)", count, err)
+	}
+}
+
+func TestSnapshotSharedReaders(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer tree.Close()
+
+	rootID, _ := tree.CreateRoot()
+	for i := uint64(0); i < 1000; i++ {
+		tree.Insert(rootID, i, 0, i)
+	}
+	snap, err := tree.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+
+	// Readers share the snapshot while a writer overwrites its pages
+	var wg sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := uint64(0); i < 1000; i++ {
+				if val, found, err := snap.Find(rootID, i, 0); err != nil || !found || val != i {
+					t.Errorf("Find(%d): got %d, %v, %v", i, val, found, err)
+					return
+				}
+			}
+			if count, err := snap.Count(rootID); err != nil || count != 1000 {
+				t.Errorf("Count: got %d, %v", count, err)
+			}
+		}()
+	}
+	for i := uint64(0); i < 1000; i++ {
+		if err := tree.Insert(rootID, i, 0, i+1); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+	wg.Wait()
+
+	if err := snap.Release(); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+}
+
+func TestSnapshotPageReuse(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer tree.Close()
+
+	rootID, _ := tree.CreateRoot()
+	for i := uint64(0); i < 20000; i++ {
+		tree.Insert(rootID, i, 0, i)
+	}
+
+	snap, _ := tree.Snapshot()
+
+	// Empty the tree and fill it with other keys; freed pages must not be
+	// reused while the snapshot can still reach them
+	for i := uint64(0); i < 20000; i++ {
+		tree.Delete(rootID, i, 0)
+	}
+	for i := uint64(0); i < 20000; i++ {
+		tree.Insert(rootID, i, 1, i+1)
+	}
+
+	if count, err := snap.Count(rootID); err != nil || count != 20000 {
+		t.Errorf("snapshot: expected 20000 entries, got %d", count)
+	}
+	snap.FindRange(rootID, 0, 0, ^uint64(0), ^uint64(0), func(key1, key2, value uint64) bool {
+		if key2 != 0 || value != key1 {
+			t.Fatalf("snapshot sees a reused page: key (%d,%d) value %d", key1, key2, value)
+		}
+		return true
+	})
+	snap.Release()
+
+	// Once released, the freed pages are reused instead of growing the file
+	for i := uint64(0); i < 20000; i++ {
+		tree.Delete(rootID, i, 1)
+	}
+	for i := uint64(0); i < 20000; i++ {
+		tree.Insert(rootID, i, 2, i)
+	}
+	if count, err := tree.Count(rootID); err != nil || count != 20000 {
+		t.Errorf("expected 20000 entries, got %d", count)
+	}
+}
+
+func TestSnapshotAfterClose(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	rootID, _ := tree.CreateRoot()
+	tree.Insert(rootID, 1, 1, 1)
+
+	snap, _ := tree.Snapshot()
+	tree.Close()
+
+	if _, found, err := snap.Find(rootID, 1, 1); found || err != bptree2.ErrClosed {
+		t.Errorf("Find on a snapshot of a closed tree: expected ErrClosed, got %v", err)
+	}
+	if err := snap.FindRange(rootID, 0, 0, 1, 1, nil); err != bptree2.ErrClosed {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+	snap.Release()
+
+	if _, err := tree.Snapshot(); err != bptree2.ErrClosed {
+		t.Errorf("Snapshot on a closed tree should return ErrClosed, got %v", err)
+	}
+}
@@ -0,0 +1,389 @@
+package bptree2
+
+import (
+	"errors"
+	"fmt"
+
+	"bptree2/bnode"
+	"bptree2/bpager"
+)
+
+var (
+	// ErrTxDone is returned when a transaction is used after Commit or Rollback.
+	ErrTxDone = bpager.ErrTxDone
+
+	// ErrTxReadOnly is returned when a read-only transaction is asked to modify the tree.
+	ErrTxReadOnly = bpager.ErrTxReadOnly
+
+	// ErrClosed is returned when a transaction is started on a closed tree.
+	ErrClosed = errors.New("tree is closed")
+)
+
+// Tx is a transaction on a BPTree.
+//
+// A writable Tx can modify any number of root trees. Its changes are only
+// visible to the transaction itself until Commit, which publishes them all
+// at once; Rollback discards them. Only one writable Tx is active at a time.
+//
+// A read-only Tx is a snapshot: it sees the tree as it was when it began,
+// while writers keep committing. Readers never block writers, and pages a
+// read-only Tx can reach are not reused until it ends.
+//
+// A writable Tx must not be used from several goroutines at once. A
+// read-only Tx can be read from several goroutines at once, but must not be
+// rolled back while they still use it.
+type Tx struct {
+	tree     *BPTree
+	pages    *bpager.Tx
+	writable bool
+	done     bool
+
+	reclaimQueued bool // pages were queued for the background reclaimer
+}
+
+// Begin starts a new transaction. Every transaction must end with
+// Commit or Rollback.
+func (t *BPTree) Begin(writable bool) (*Tx, error) {
+	if writable {
+		t.wmu.Lock()
+		if t.closed {
+			t.wmu.Unlock()
+			return nil, ErrClosed
+		}
+	} else {
+		t.mu.RLock()
+		defer t.mu.RUnlock()
+		if t.closed {
+			return nil, ErrClosed
+		}
+	}
+
+	return &Tx{
+		tree:     t,
+		pages:    t.pager.Begin(writable),
+		writable: writable,
+	}, nil
+}
+
+// Writable returns true if the transaction can modify the tree.
+func (tx *Tx) Writable() bool {
+	return tx.writable
+}
+
+// Commit publishes the changes made by the transaction.
+// Commit does not make them durable: they wait in memory and reach the file
+// on the next Flash, so a crash before it loses them. A commit that leaves
+// too many changed pages waiting flashes them itself; if that fails, Commit
+// returns the error, but the changes are committed and the next Flash
+// retries.
+// Committing a read-only transaction ends it, like Rollback.
+func (tx *Tx) Commit() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+
+	if tx.writable {
+		defer tx.tree.wmu.Unlock()
+	}
+	if err := tx.pages.Commit(); err != nil {
+		return err
+	}
+	if tx.reclaimQueued {
+		tx.tree.startReclaim()
+	}
+	if tx.writable && tx.tree.pager.DirtyCount() > maxDirtyPages {
+		if err := tx.tree.flash(); err != nil {
+			return fmt.Errorf("failed to flash committed changes: %w", err)
+		}
+	}
+	return nil
+}
+
+// Rollback discards all changes made by the transaction.
+func (tx *Tx) Rollback() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+
+	if tx.writable {
+		defer tx.tree.wmu.Unlock()
+	}
+	return tx.pages.Rollback()
+}
+
+// acquire prepares the transaction for reading pages.
+// Read-only transactions hold the tree's read lock while they read,
+// so that Flash and Close do not rewrite or unmap the pages under them.
+// The lock must not be held while user code runs, see locked.
+func (tx *Tx) acquire() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	if tx.writable {
+		return nil
+	}
+
+	tx.tree.mu.RLock()
+	if tx.tree.closed {
+		tx.tree.mu.RUnlock()
+		return ErrClosed
+	}
+	return nil
+}
+
+// release undoes acquire.
+func (tx *Tx) release() {
+	if !tx.writable {
+		tx.tree.mu.RUnlock()
+	}
+}
+
+// locked runs fn between acquire and release, returning a corrupt page
+// read by fn as an error.
+// Scans read one leaf at a time this way and call back without the lock,
+// so that the callback can use the tree while Flash waits for it. The pages
+// of a read-only transaction are not reused until it ends, which keeps the
+// page IDs of the walk valid in between.
+func (tx *Tx) locked(fn func() error) (err error) {
+	if err := tx.acquire(); err != nil {
+		return err
+	}
+	defer tx.release()
+	defer recoverCorrupt(&err)
+	return fn()
+}
+
+// page returns a page as the transaction sees it. The tree code reads pages
+// at every level of its recursion, so an error of the pager, such as
+// ErrCorruptPage, is not passed up by hand: page panics with it, and
+// recoverCorrupt returns it from the public method.
+func (tx *Tx) page(id bpager.PageID) []byte {
+	data, err := tx.pages.GetPage(id)
+	if err != nil {
+		panic(pageError{err})
+	}
+	return data
+}
+
+// pageForWrite is like page, but returns a private writable copy of the page.
+func (tx *Tx) pageForWrite(id bpager.PageID) []byte {
+	data, err := tx.pages.GetPageForWrite(id)
+	if err != nil {
+		panic(pageError{err})
+	}
+	return data
+}
+
+// rootPage is like page, but returns the root page of rootID, or 0 if the
+// tree is empty.
+func (tx *Tx) rootPage(rootID RootID) bpager.PageID {
+	pageID, err := tx.pages.GetRootPage(rootID)
+	if err != nil {
+		panic(pageError{err})
+	}
+	return pageID
+}
+
+// shared is like page, but returns true if a page is shared with a fork.
+func (tx *Tx) shared(id bpager.PageID) bool {
+	shared, err := tx.pages.Shared(id)
+	if err != nil {
+		panic(pageError{err})
+	}
+	return shared
+}
+
+// checkWritable returns an error if the transaction cannot modify the tree.
+func (tx *Tx) checkWritable() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	if !tx.writable {
+		return ErrTxReadOnly
+	}
+	return nil
+}
+
+// Find retrieves a value by composite key (key1, key2) from a specific root tree.
+// Returns (value, true) if found, (0, false) otherwise.
+func (tx *Tx) Find(rootID RootID, key1, key2 uint64) (value uint64, found bool, err error) {
+	err = tx.locked(func() error {
+		rootPageID := tx.rootPage(rootID)
+		if rootPageID == 0 {
+			return nil // Empty tree
+		}
+		if err := tx.checkKeyType(rootPageID, false); err != nil {
+			return err
+		}
+		value, found, err = tx.search(rootPageID, key1, key2)
+		return err
+	})
+	return value, found, err
+}
+
+// FindRange iterates over all key-value pairs where (start1,start2) <= (key1,key2) <= (end1,end2).
+// The callback function is called for each pair. Return false to stop iteration.
+// The callback may read the tree, and call Flash unless the transaction is writable.
+func (tx *Tx) FindRange(rootID RootID, start1, start2, end1, end2 uint64, fn func(key1, key2, value uint64) bool) error {
+	return tx.scanInternal(rootID, start1, start2, end1, end2, fn)
+}
+
+// FindRangeReverse is like FindRange, but calls fn in descending key order,
+// starting at (end1,end2) and walking down to (start1,start2).
+func (tx *Tx) FindRangeReverse(rootID RootID, start1, start2, end1, end2 uint64, fn func(key1, key2, value uint64) bool) error {
+	return tx.scanReverse(rootID, start1, start2, end1, end2, fn)
+}
+
+// Insert inserts or updates a key-value pair with composite key in a specific root tree.
+// If Insert fails the transaction should be rolled back.
+func (tx *Tx) Insert(rootID RootID, key1, key2, value uint64) error {
+	return tx.put(rootID, key1, key2, value, 0)
+}
+
+// put inserts or updates an entry with the given flags.
+func (tx *Tx) put(rootID RootID, key1, key2, value uint64, flags bnode.EntryFlags) error {
+	return tx.upsert(rootID, key1, key2, func(bool, uint64, bnode.EntryFlags) (uint64, bnode.EntryFlags, bool) {
+		return value, flags, true
+	})
+}
+
+// upsert stores the entry fn decides on, in a single descent.
+// A corrupt page on the way is returned as an error.
+func (tx *Tx) upsert(rootID RootID, key1, key2 uint64, fn putFunc) (err error) {
+	defer recoverCorrupt(&err)
+	if err := tx.checkWritable(); err != nil {
+		return err
+	}
+
+	rootPageID := tx.rootPage(rootID)
+
+	// Empty tree - create first leaf
+	if rootPageID == 0 {
+		value, flags, ok := fn(false, 0, 0)
+		if !ok {
+			return nil
+		}
+		newPageID, err := tx.pages.AllocatePage()
+		if err != nil {
+			return fmt.Errorf("failed to allocate root: %w", err)
+		}
+		data := tx.pageForWrite(newPageID)
+		leaf := bnode.NewLeafNode(data, true)
+		leaf.PutWithFlags(key1, key2, value, flags)
+		if err := tx.pages.SetRootPage(rootID, newPageID); err != nil {
+			return err
+		}
+		return nil
+	}
+
+	if err := tx.checkKeyType(rootPageID, false); err != nil {
+		return err
+	}
+	rootPageID, err = tx.unshareRoot(rootID, rootPageID)
+	if err != nil {
+		return err
+	}
+
+	// Insert into existing tree
+	splitKey, newChildID, err := tx.insert(rootPageID, key1, key2, fn)
+	if err != nil {
+		return err
+	}
+
+	// Root was split - create new root
+	if newChildID != 0 {
+		newRootID, err := tx.pages.AllocatePage()
+		if err != nil {
+			return fmt.Errorf("failed to allocate new root: %w", err)
+		}
+		data := tx.pageForWrite(newRootID)
+		newRoot := bnode.NewInternalNode(data, true)
+		bnode.SetAugmented(data, bnode.IsAugmented(tx.page(rootPageID)))
+		newRoot.InitRoot(rootPageID, newChildID, splitKey, tx.nodeStats(rootPageID), tx.nodeStats(newChildID))
+		if err := tx.pages.SetRootPage(rootID, newRootID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete removes a composite key from a specific root tree.
+// Returns true if the key was found and removed.
+func (tx *Tx) Delete(rootID RootID, key1, key2 uint64) (_ bool, err error) {
+	defer recoverCorrupt(&err)
+	if err := tx.checkWritable(); err != nil {
+		return false, err
+	}
+
+	rootPageID := tx.rootPage(rootID)
+	if rootPageID == 0 {
+		return false, nil
+	}
+	if err := tx.checkKeyType(rootPageID, false); err != nil {
+		return false, err
+	}
+	rootPageID, err = tx.unshareRoot(rootID, rootPageID)
+	if err != nil {
+		return false, err
+	}
+
+	deleted, _, err := tx.deleteRecursive(rootPageID, key1, key2)
+	if err != nil {
+		return false, err
+	}
+
+	// Check if root needs to shrink
+	if deleted {
+		if err := tx.shrinkRoot(rootID); err != nil {
+			return true, err
+		}
+	}
+
+	return deleted, nil
+}
+
+// shrinkRoot removes internal roots with a single child and frees an empty
+// leaf root, after deletions.
+func (tx *Tx) shrinkRoot(rootID RootID) error {
+	for {
+		rootPageID := tx.rootPage(rootID)
+		if rootPageID == 0 {
+			return nil
+		}
+		rootData := tx.page(rootPageID)
+
+		if bnode.GetNodeType(rootData) == bnode.NodeTypeInternal {
+			internal := bnode.NewInternalNode(rootData, false)
+			if internal.KeyCount() > 0 {
+				return nil
+			}
+			// Root has no keys, promote only child to root. A shared root is
+			// copied first; the reference of the copy passes to the root entry.
+			rootPageID, err := tx.unshareRoot(rootID, rootPageID)
+			if err != nil {
+				return err
+			}
+			if err := tx.pages.SetRootPage(rootID, internal.GetChild(0)); err != nil {
+				return err
+			}
+			if err := tx.pages.FreePage(rootPageID); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Root is leaf; an augmented root keeps it, as it carries the mode
+		leaf := bnode.NewLeafNode(rootData, false)
+		if leaf.KeyCount() > 0 || bnode.IsAugmented(rootData) {
+			return nil
+		}
+		// Tree is now empty
+		if err := tx.pages.SetRootPage(rootID, 0); err != nil {
+			return err
+		}
+		return tx.pages.FreePage(rootPageID)
+	}
+}
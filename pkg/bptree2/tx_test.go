@@ -0,0 +1,196 @@
+package bptree2_test
+
+import (
+	"bptree2"
+	"path/filepath"
+	"testing"
+)
+
+func TestTxCommitAcrossRoots(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	from, _ := tree.CreateRoot()
+	to, _ := tree.CreateRoot()
+	for i := uint64(0); i < 1000; i++ {
+		tree.Insert(from, i, i, i*10)
+	}
+
+	// Move every entry from one root to the other in a single transaction
+	tx, err := tree.Begin(true)
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	for i := uint64(0); i < 1000; i++ {
+		if deleted, err := tx.Delete(from, i, i); !deleted || err != nil {
+			t.Fatalf("Delete %d failed: %v %v", i, deleted, err)
+		}
+		if err := tx.Insert(to, i, i, i*10); err != nil {
+			t.Fatalf("Insert %d failed: %v", i, err)
+		}
+	}
+
+	// The transaction sees its own changes
+	if _, found, err := tx.Find(from, 500, 500); err != nil || found {
+		t.Error("transaction should not see the deleted key")
+	}
+	if val, found, err := tx.Find(to, 500, 500); err != nil || !found || val != 5000 {
+		t.Errorf("transaction should see the inserted key, got %d %v", val, found)
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if err := tx.Commit(); err != bptree2.ErrTxDone {
+		t.Errorf("second Commit should return ErrTxDone, got %v", err)
+	}
+
+	if count, err := tree.Count(from); err != nil || count != 0 {
+		t.Errorf("expected 0 entries in source root, got %d", count)
+	}
+	if count, err := tree.Count(to); err != nil || count != 1000 {
+		t.Errorf("expected 1000 entries in target root, got %d", count)
+	}
+
+	// Committed changes persist
+	tree.Close()
+	tree, err = bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	defer tree.Close()
+
+	if count, err := tree.Count(to); err != nil || count != 1000 {
+		t.Errorf("expected 1000 entries after reopen, got %d", count)
+	}
+}
+
+func TestTxRollback(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer tree.Close()
+
+	rootID, _ := tree.CreateRoot()
+	for i := uint64(0); i < 500; i++ {
+		tree.Insert(rootID, i, 0, i)
+	}
+
+	// Enough inserts and deletes to split and merge pages
+	tx, _ := tree.Begin(true)
+	for i := uint64(500); i < 5000; i++ {
+		tx.Insert(rootID, i, 0, i)
+	}
+	for i := uint64(0); i < 250; i++ {
+		tx.Delete(rootID, i, 0)
+	}
+	tx.Insert(rootID, 300, 0, 999)
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("Rollback failed: %v", err)
+	}
+
+	if count, err := tree.Count(rootID); err != nil || count != 500 {
+		t.Errorf("expected 500 entries after rollback, got %d", count)
+	}
+	for i := uint64(0); i < 500; i++ {
+		if val, found, err := tree.Find(rootID, i, 0); err != nil || !found || val != i {
+			t.Fatalf("key %d: expected %d, got %d (found=%v)", i, i, val, found)
+		}
+	}
+
+	// The tree is still writable after a rollback
+	if err := tree.Insert(rootID, 1000, 0, 1000); err != nil {
+		t.Fatalf("Insert after Rollback failed: %v", err)
+	}
+}
+
+func TestTxUncommittedInvisible(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer tree.Close()
+
+	rootID, _ := tree.CreateRoot()
+	tree.Insert(rootID, 1, 1, 100)
+
+	tx, _ := tree.Begin(true)
+	tx.Insert(rootID, 1, 1, 200)
+	tx.Insert(rootID, 2, 2, 300)
+
+	// Readers outside the transaction still see the committed state
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if val, _, err := tree.Find(rootID, 1, 1); err != nil || val != 100 {
+			t.Errorf("expected committed value 100, got %d", val)
+		}
+		if _, found, err := tree.Find(rootID, 2, 2); err != nil || found {
+			t.Error("uncommitted key should be invisible")
+		}
+	}()
+	<-done
+
+	tx.Commit()
+
+	if val, _, err := tree.Find(rootID, 1, 1); err != nil || val != 200 {
+		t.Errorf("expected committed value 200, got %d", val)
+	}
+	if _, found, err := tree.Find(rootID, 2, 2); err != nil || !found {
+		t.Error("committed key should be visible")
+	}
+}
+
+func TestReadOnlyTx(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer tree.Close()
+
+	rootID, _ := tree.CreateRoot()
+	for i := uint64(0); i < 100; i++ {
+		tree.Insert(rootID, i, 0, i)
+	}
+
+	tx, err := tree.Begin(false)
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+
+	if err := tx.Insert(rootID, 1000, 0, 1); err != bptree2.ErrTxReadOnly {
+		t.Errorf("Insert should return ErrTxReadOnly, got %v", err)
+	}
+	if _, err := tx.Delete(rootID, 1, 0); err != bptree2.ErrTxReadOnly {
+		t.Errorf("Delete should return ErrTxReadOnly, got %v", err)
+	}
+
+	count := 0
+	tx.FindRange(rootID, 0, 0, 49, ^uint64(0), func(key1, key2, value uint64) bool {
+		count++
+		return true
+	})
+	if count != 50 {
+		t.Errorf("expected 50 entries in range, got %d", count)
+	}
+
+	tx.Rollback()
+	if err := tx.FindRange(rootID, 0, 0, 1, 1, nil); err != bptree2.ErrTxDone {
+		t.Errorf("FindRange after Rollback should return ErrTxDone, got %v", err)
+	}
+}
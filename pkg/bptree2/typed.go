@@ -0,0 +1,59 @@
+package bptree2
+
+// Typed is a view of one root tree with keys of type K and values of type V,
+// translated to and from composite keys and uint64 values by codecs.
+//
+// Example:
+//
+//	events := bptree2.NewTyped(tree, rootID, bptree2.TimeCodec{}, bptree2.Int64Codec{})
+//	events.Insert(time.Now(), -42)
+//	events.FindRange(start, end, func(at time.Time, delta int64) bool {
+//	    fmt.Println(at, delta)
+//	    return true
+//	})
+type Typed[K, V any] struct {
+	tree   *BPTree
+	rootID RootID
+	keys   KeyCodec[K]
+	values ValueCodec[V]
+}
+
+// NewTyped returns a typed view of a root tree.
+func NewTyped[K, V any](tree *BPTree, rootID RootID, keys KeyCodec[K], values ValueCodec[V]) *Typed[K, V] {
+	return &Typed[K, V]{tree: tree, rootID: rootID, keys: keys, values: values}
+}
+
+// Find retrieves the value for a key.
+// Returns (value, true) if found, (zero value, false) otherwise.
+func (t *Typed[K, V]) Find(k K) (V, bool, error) {
+	key1, key2 := t.keys.EncodeKey(k)
+	value, found, err := t.tree.Find(t.rootID, key1, key2)
+	if !found || err != nil {
+		var zero V
+		return zero, false, err
+	}
+	return t.values.DecodeValue(value), true, nil
+}
+
+// Insert inserts or updates a key-value pair.
+func (t *Typed[K, V]) Insert(k K, v V) error {
+	key1, key2 := t.keys.EncodeKey(k)
+	return t.tree.Insert(t.rootID, key1, key2, t.values.EncodeValue(v))
+}
+
+// Delete removes a key.
+// Returns true if the key was found and removed.
+func (t *Typed[K, V]) Delete(k K) (bool, error) {
+	key1, key2 := t.keys.EncodeKey(k)
+	return t.tree.Delete(t.rootID, key1, key2)
+}
+
+// FindRange iterates over all key-value pairs where start <= key <= end,
+// in key order. Return false from fn to stop iteration.
+func (t *Typed[K, V]) FindRange(start, end K, fn func(k K, v V) bool) error {
+	start1, start2 := t.keys.EncodeKey(start)
+	end1, end2 := t.keys.EncodeKey(end)
+	return t.tree.FindRange(t.rootID, start1, start2, end1, end2, func(key1, key2, value uint64) bool {
+		return fn(t.keys.DecodeKey(key1, key2), t.values.DecodeValue(value))
+	})
+}
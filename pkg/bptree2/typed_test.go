@@ -0,0 +1,139 @@
+package bptree2_test
+
+import (
+	"bptree2"
+	"math"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestTyped(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer tree.Close()
+
+	rootID, _ := tree.CreateRoot()
+	typed := bptree2.NewTyped(tree, rootID, bptree2.Int64Codec{}, bptree2.Float64Codec{})
+
+	for i := int64(-1000); i < 1000; i++ {
+		if err := typed.Insert(i, float64(i)/4); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+	if v, found, err := typed.Find(-7); err != nil || !found || v != -1.75 {
+		t.Errorf("Find(-7) = %v, %v, expected -1.75, true", v, found)
+	}
+	first, err1 := typed.Delete(-7)
+	second, err2 := typed.Delete(-7)
+	if err1 != nil || err2 != nil || !first || second {
+		t.Errorf("Delete(-7) did not remove the key exactly once: %v, %v", err1, err2)
+	}
+	if _, found, err := typed.Find(-7); err != nil || found {
+		t.Errorf("Find(-7) found a deleted key")
+	}
+
+	// Negative keys sort before positive ones
+	var keys []int64
+	typed.FindRange(-10, 3, func(k int64, v float64) bool {
+		if v != float64(k)/4 {
+			t.Errorf("key %d: expected %v, got %v", k, float64(k)/4, v)
+		}
+		keys = append(keys, k)
+		return true
+	})
+	expected := []int64{-10, -9, -8, -6, -5, -4, -3, -2, -1, 0, 1, 2, 3}
+	if !slices.Equal(keys, expected) {
+		t.Errorf("expected keys %v, got %v", expected, keys)
+	}
+}
+
+func TestTypedTimeAndUUID(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer tree.Close()
+
+	timeRoot, _ := tree.CreateRoot()
+	events := bptree2.NewTyped(tree, timeRoot, bptree2.TimeCodec{}, bptree2.TimeCodec{})
+
+	base := time.Date(1969, 12, 31, 23, 59, 59, 999999998, time.UTC)
+	for i := 0; i < 100; i++ {
+		at := base.Add(time.Duration(i) * time.Nanosecond)
+		if err := events.Insert(at, at.Add(time.Hour)); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+	var times []time.Time
+	events.FindRange(base, base.Add(time.Hour), func(at, v time.Time) bool {
+		if !v.Equal(at.Add(time.Hour)) {
+			t.Errorf("%v: expected value %v, got %v", at, at.Add(time.Hour), v)
+		}
+		times = append(times, at)
+		return true
+	})
+	if len(times) != 100 {
+		t.Fatalf("expected 100 times, got %d", len(times))
+	}
+	for i, at := range times {
+		if !at.Equal(base.Add(time.Duration(i) * time.Nanosecond)) {
+			t.Fatalf("time %d: got %v", i, at)
+		}
+	}
+
+	uuidRoot, _ := tree.CreateRoot()
+	ids := bptree2.NewTyped(tree, uuidRoot, bptree2.UUIDCodec{}, bptree2.Uint64Codec{})
+	id := bptree2.UUID{0xff, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 0xfe}
+	ids.Insert(id, 42)
+	ids.Insert(bptree2.UUID{0xff}, 1)
+	ids.Insert(bptree2.UUID{0: 0xff, 15: 0xff}, 2)
+	if v, found, err := ids.Find(id); err != nil || !found || v != 42 {
+		t.Errorf("Find(%x) = %d, %v, expected 42, true", id, v, found)
+	}
+	var got []bptree2.UUID
+	ids.FindRange(bptree2.UUID{}, bptree2.UUID{0xff, 0xff}, func(k bptree2.UUID, v uint64) bool {
+		got = append(got, k)
+		return true
+	})
+	if len(got) != 3 || got[0] != (bptree2.UUID{0xff}) || got[2] != id {
+		t.Errorf("UUIDs out of order: %x", got)
+	}
+}
+
+func TestCodecOrder(t *testing.T) {
+	floats := []float64{math.Inf(-1), -math.MaxFloat64, -1.5, -math.SmallestNonzeroFloat64, math.Copysign(0, -1), 0,
+		math.SmallestNonzeroFloat64, 1, 1.5, math.MaxFloat64, math.Inf(1)}
+	var prev uint64
+	for i, f := range floats {
+		key1, _ := bptree2.Float64Codec{}.EncodeKey(f)
+		if i > 0 && key1 <= prev {
+			t.Errorf("%v does not sort after %v", f, floats[i-1])
+		}
+		if back := (bptree2.Float64Codec{}).DecodeKey(key1, 0); math.Float64bits(back) != math.Float64bits(f) {
+			t.Errorf("%v decoded as %v", f, back)
+		}
+		prev = key1
+	}
+
+	ints := []int64{math.MinInt64, -1, 0, 1, math.MaxInt64}
+	for i, n := range ints {
+		key1, _ := bptree2.Int64Codec{}.EncodeKey(n)
+		if i > 0 && key1 <= prev {
+			t.Errorf("%d does not sort after %d", n, ints[i-1])
+		}
+		if back := (bptree2.Int64Codec{}).DecodeKey(key1, 0); back != n {
+			t.Errorf("%d decoded as %d", n, back)
+		}
+		prev = key1
+	}
+}
@@ -0,0 +1,225 @@
+package bptree2
+
+import (
+	"errors"
+
+	"bptree2/bnode"
+)
+
+var (
+	// ErrKeyNotFound is returned by Replace when the key does not exist.
+	ErrKeyNotFound = errors.New("key not found")
+	// ErrNotValue is returned by the operations that read a value before
+	// they write it when the key holds a blob or a bucket.
+	ErrNotValue = errors.New("key holds a blob or a bucket")
+)
+
+// Update atomically replaces the value of a key with the result of fn.
+// fn is called with the current value, or with found == false and value 0
+// if the key does not exist, in which case the result is inserted.
+// See Tx.Update.
+func (t *BPTree) Update(rootID RootID, key1, key2 uint64, fn func(found bool, value uint64) uint64) error {
+	return t.update(func(tx *Tx) error {
+		return tx.Update(rootID, key1, key2, fn)
+	})
+}
+
+// CompareAndSwap atomically sets the value of a key to newValue if it
+// exists and holds oldValue. Returns true if the value was swapped, and
+// ErrNotValue if the key holds a blob or a bucket.
+func (t *BPTree) CompareAndSwap(rootID RootID, key1, key2, oldValue, newValue uint64) (bool, error) {
+	var swapped bool
+	err := t.update(func(tx *Tx) (err error) {
+		swapped, err = tx.CompareAndSwap(rootID, key1, key2, oldValue, newValue)
+		return err
+	})
+	return swapped, err
+}
+
+// Add atomically adds delta to the value of a key and returns the new value.
+// A missing key counts as 0. The sum wraps around on overflow; to subtract
+// n, pass ^uint64(n-1). Returns ErrNotValue if the key holds a blob or a
+// bucket.
+func (t *BPTree) Add(rootID RootID, key1, key2, delta uint64) (uint64, error) {
+	var value uint64
+	err := t.update(func(tx *Tx) (err error) {
+		value, err = tx.Add(rootID, key1, key2, delta)
+		return err
+	})
+	return value, err
+}
+
+// PutIfAbsent inserts a key-value pair unless the key exists.
+// Returns the current value and true if it does, in which case nothing
+// changes, and ErrNotValue if the key holds a blob or a bucket.
+func (t *BPTree) PutIfAbsent(rootID RootID, key1, key2, value uint64) (uint64, bool, error) {
+	var old uint64
+	var found bool
+	err := t.update(func(tx *Tx) (err error) {
+		old, found, err = tx.PutIfAbsent(rootID, key1, key2, value)
+		return err
+	})
+	return old, found, err
+}
+
+// Replace sets the value of an existing key and returns the old value.
+// Returns ErrKeyNotFound if the key does not exist, and ErrNotValue if it
+// holds a blob or a bucket.
+func (t *BPTree) Replace(rootID RootID, key1, key2, value uint64) (uint64, error) {
+	var old uint64
+	err := t.update(func(tx *Tx) (err error) {
+		old, err = tx.Replace(rootID, key1, key2, value)
+		return err
+	})
+	return old, err
+}
+
+// Swap inserts or updates a key-value pair and returns the old value and
+// whether the key existed. Returns ErrNotValue if the key holds a blob or a
+// bucket.
+func (t *BPTree) Swap(rootID RootID, key1, key2, value uint64) (uint64, bool, error) {
+	var old uint64
+	var found bool
+	err := t.update(func(tx *Tx) (err error) {
+		old, found, err = tx.Swap(rootID, key1, key2, value)
+		return err
+	})
+	return old, found, err
+}
+
+// Update replaces the value of a key with the result of fn, descending the
+// tree once. fn must not use the tree or the transaction.
+// Returns ErrNotValue, without calling fn, if the key holds a blob or a
+// bucket. If Update fails the transaction should be rolled back.
+func (tx *Tx) Update(rootID RootID, key1, key2 uint64, fn func(found bool, value uint64) uint64) error {
+	return tx.upsertValue(rootID, key1, key2, fn)
+}
+
+// CompareAndSwap sets the value of a key to newValue if it exists and holds
+// oldValue. Returns true if the value was swapped, and ErrNotValue if the
+// key holds a blob or a bucket.
+// If CompareAndSwap fails the transaction should be rolled back.
+func (tx *Tx) CompareAndSwap(rootID RootID, key1, key2, oldValue, newValue uint64) (bool, error) {
+	value, found, err := tx.lookup(rootID, key1, key2)
+	if err != nil || !found || value != oldValue {
+		return false, err
+	}
+	if err := tx.Insert(rootID, key1, key2, newValue); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Add adds delta to the value of a key and returns the new value.
+// A missing key counts as 0. Returns ErrNotValue if the key holds a blob or
+// a bucket.
+// If Add fails the transaction should be rolled back.
+func (tx *Tx) Add(rootID RootID, key1, key2, delta uint64) (uint64, error) {
+	var sum uint64
+	err := tx.upsertValue(rootID, key1, key2, func(_ bool, value uint64) uint64 {
+		sum = value + delta
+		return sum
+	})
+	if err != nil {
+		return 0, err
+	}
+	return sum, nil
+}
+
+// PutIfAbsent inserts a key-value pair unless the key exists.
+// Returns the current value and true if it does, in which case nothing
+// changes, and ErrNotValue if the key holds a blob or a bucket.
+// If PutIfAbsent fails the transaction should be rolled back.
+func (tx *Tx) PutIfAbsent(rootID RootID, key1, key2, value uint64) (uint64, bool, error) {
+	old, found, err := tx.lookup(rootID, key1, key2)
+	if err != nil || found {
+		return old, found, err
+	}
+	return 0, false, tx.Insert(rootID, key1, key2, value)
+}
+
+// Replace sets the value of an existing key and returns the old value.
+// Returns ErrKeyNotFound if the key does not exist, and ErrNotValue if it
+// holds a blob or a bucket.
+// If Replace fails the transaction should be rolled back.
+func (tx *Tx) Replace(rootID RootID, key1, key2, value uint64) (uint64, error) {
+	old, found, err := tx.lookup(rootID, key1, key2)
+	if err != nil {
+		return 0, err
+	}
+	if !found {
+		return 0, ErrKeyNotFound
+	}
+	if err := tx.Insert(rootID, key1, key2, value); err != nil {
+		return 0, err
+	}
+	return old, nil
+}
+
+// Swap inserts or updates a key-value pair and returns the old value and
+// whether the key existed. Returns ErrNotValue if the key holds a blob or a
+// bucket.
+// If Swap fails the transaction should be rolled back.
+func (tx *Tx) Swap(rootID RootID, key1, key2, value uint64) (uint64, bool, error) {
+	var old uint64
+	var found bool
+	err := tx.upsertValue(rootID, key1, key2, func(exists bool, current uint64) uint64 {
+		old, found = current, exists
+		return value
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	return old, found, nil
+}
+
+// upsertValue is upsert for keys that hold plain values: fn decides the new
+// value. If the key holds a blob or a bucket, fn is not called and
+// ErrNotValue is returned.
+func (tx *Tx) upsertValue(rootID RootID, key1, key2 uint64, fn func(found bool, value uint64) uint64) error {
+	notValue := false
+	err := tx.upsert(rootID, key1, key2, func(found bool, value uint64, flags bnode.EntryFlags) (uint64, bnode.EntryFlags, bool) {
+		if flags != 0 {
+			notValue = true
+			return 0, 0, false
+		}
+		return fn(found, value), 0, true
+	})
+	if err == nil && notValue {
+		err = ErrNotValue
+	}
+	return err
+}
+
+// lookup returns the value of a key, for the operations that decide whether
+// to write before they touch the tree, so that a tree that does not change
+// keeps its shared pages. Returns ErrNotValue if the key holds a blob or a
+// bucket.
+func (tx *Tx) lookup(rootID RootID, key1, key2 uint64) (_ uint64, _ bool, err error) {
+	defer recoverCorrupt(&err)
+	if err := tx.checkWritable(); err != nil {
+		return 0, false, err
+	}
+
+	rootPageID := tx.rootPage(rootID)
+	if rootPageID == 0 {
+		return 0, false, nil
+	}
+	if err := tx.checkKeyType(rootPageID, false); err != nil {
+		return 0, false, err
+	}
+	leafID, err := tx.findLeaf(rootPageID, key1, key2)
+	if err != nil {
+		return 0, false, err
+	}
+
+	leaf := bnode.NewLeafNode(tx.page(leafID), false)
+	idx, found := leaf.Search(key1, key2)
+	if !found {
+		return 0, false, nil
+	}
+	if leaf.GetFlagsAt(idx) != 0 {
+		return 0, false, ErrNotValue
+	}
+	return leaf.GetValueAt(idx), true, nil
+}
@@ -0,0 +1,334 @@
+package bptree2_test
+
+import (
+	"bptree2"
+	"bptree2/bpager"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestUpdate(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer tree.Close()
+
+	rootID, _ := tree.CreateRoot()
+
+	// A missing key is inserted with the result of fn
+	err = tree.Update(rootID, 1, 1, func(found bool, value uint64) uint64 {
+		if found || value != 0 {
+			t.Errorf("missing key: got found=%v value=%d", found, value)
+		}
+		return 10
+	})
+	if err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	err = tree.Update(rootID, 1, 1, func(found bool, value uint64) uint64 {
+		if !found || value != 10 {
+			t.Errorf("existing key: got found=%v value=%d", found, value)
+		}
+		return value * 2
+	})
+	if err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if val, _, err := tree.Find(rootID, 1, 1); err != nil || val != 20 {
+		t.Errorf("expected 20, got %d", val)
+	}
+
+	// CompareAndSwap only swaps an existing, matching value
+	if ok, _ := tree.CompareAndSwap(rootID, 1, 1, 10, 30); ok {
+		t.Error("CompareAndSwap with a stale value should fail")
+	}
+	if ok, _ := tree.CompareAndSwap(rootID, 1, 1, 20, 30); !ok {
+		t.Error("CompareAndSwap with the current value should succeed")
+	}
+	if ok, _ := tree.CompareAndSwap(rootID, 2, 2, 0, 1); ok {
+		t.Error("CompareAndSwap on a missing key should fail")
+	}
+	if _, found, err := tree.Find(rootID, 2, 2); err != nil || found {
+		t.Error("CompareAndSwap should not insert a missing key")
+	}
+
+	// Add counts a missing key as 0 and wraps around
+	if sum, _ := tree.Add(rootID, 3, 3, 5); sum != 5 {
+		t.Errorf("expected 5, got %d", sum)
+	}
+	if sum, _ := tree.Add(rootID, 3, 3, ^uint64(1)); sum != 3 {
+		t.Errorf("subtracting 2: expected 3, got %d", sum)
+	}
+
+}
+
+func TestUpdateNotValue(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer tree.Close()
+
+	rootID, _ := tree.CreateRoot()
+	if err := tree.InsertBlob(rootID, 1, 1, make([]byte, 10000)); err != nil {
+		t.Fatalf("InsertBlob failed: %v", err)
+	}
+	bucket, err := tree.CreateBucket(rootID, 2, 2)
+	if err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	if err := bucket.Insert(1, 1, 1); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+
+	// Every read-modify-write refuses a blob or a bucket and leaves it as it is
+	for _, key := range []uint64{1, 2} {
+		err := tree.Update(rootID, key, key, func(bool, uint64) uint64 {
+			t.Errorf("Update(%d) called fn", key)
+			return 0
+		})
+		if !errors.Is(err, bptree2.ErrNotValue) {
+			t.Errorf("Update(%d): expected ErrNotValue, got %v", key, err)
+		}
+		if _, err := tree.CompareAndSwap(rootID, key, key, 0, 1); !errors.Is(err, bptree2.ErrNotValue) {
+			t.Errorf("CompareAndSwap(%d): expected ErrNotValue, got %v", key, err)
+		}
+		if _, err := tree.Add(rootID, key, key, 1); !errors.Is(err, bptree2.ErrNotValue) {
+			t.Errorf("Add(%d): expected ErrNotValue, got %v", key, err)
+		}
+		if _, _, err := tree.PutIfAbsent(rootID, key, key, 1); !errors.Is(err, bptree2.ErrNotValue) {
+			t.Errorf("PutIfAbsent(%d): expected ErrNotValue, got %v", key, err)
+		}
+		if _, err := tree.Replace(rootID, key, key, 1); !errors.Is(err, bptree2.ErrNotValue) {
+			t.Errorf("Replace(%d): expected ErrNotValue, got %v", key, err)
+		}
+		if _, _, err := tree.Swap(rootID, key, key, 1); !errors.Is(err, bptree2.ErrNotValue) {
+			t.Errorf("Swap(%d): expected ErrNotValue, got %v", key, err)
+		}
+	}
+	if data, found, err := tree.FindBlob(rootID, 1, 1); err != nil || !found || len(data) != 10000 {
+		t.Errorf("blob after the updates: got %d bytes, %v, %v", len(data), found, err)
+	}
+	if b, err := tree.Bucket(rootID, 2, 2); err != nil {
+		t.Errorf("bucket after the updates: %v", err)
+	} else if val, found, err := b.Find(1, 1); err != nil || !found || val != 1 {
+		t.Errorf("bucket after the updates: got %d, %v, %v", val, found, err)
+	}
+
+	// Insert still replaces a blob with a plain value
+	if err := tree.Insert(rootID, 1, 1, 5); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	if sum, err := tree.Add(rootID, 1, 1, 1); err != nil || sum != 6 {
+		t.Errorf("Add after Insert: got %d, %v", sum, err)
+	}
+}
+
+func TestPutIfAbsentReplaceSwap(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer tree.Close()
+
+	rootID, _ := tree.CreateRoot()
+
+	// Replace never inserts
+	if _, err := tree.Replace(rootID, 1, 1, 10); !errors.Is(err, bptree2.ErrKeyNotFound) {
+		t.Errorf("Replace of a missing key: expected ErrKeyNotFound, got %v", err)
+	}
+	if _, found, err := tree.Find(rootID, 1, 1); err != nil || found {
+		t.Error("Replace should not insert a missing key")
+	}
+
+	// PutIfAbsent inserts once, then reports the existing value
+	if old, found, err := tree.PutIfAbsent(rootID, 1, 1, 10); err != nil || found || old != 0 {
+		t.Errorf("first PutIfAbsent: got %d, %v, %v", old, found, err)
+	}
+	if old, found, err := tree.PutIfAbsent(rootID, 1, 1, 20); err != nil || !found || old != 10 {
+		t.Errorf("second PutIfAbsent: got %d, %v, %v", old, found, err)
+	}
+	if val, _, err := tree.Find(rootID, 1, 1); err != nil || val != 10 {
+		t.Errorf("PutIfAbsent should keep 10, got %d", val)
+	}
+
+	if old, err := tree.Replace(rootID, 1, 1, 30); err != nil || old != 10 {
+		t.Errorf("Replace: got %d, %v", old, err)
+	}
+	if old, found, err := tree.Swap(rootID, 1, 1, 40); err != nil || !found || old != 30 {
+		t.Errorf("Swap of an existing key: got %d, %v, %v", old, found, err)
+	}
+	if old, found, err := tree.Swap(rootID, 2, 2, 50); err != nil || found || old != 0 {
+		t.Errorf("Swap of a missing key: got %d, %v, %v", old, found, err)
+	}
+	if val, _, err := tree.Find(rootID, 1, 1); err != nil || val != 40 {
+		t.Errorf("expected 40, got %d", val)
+	}
+	if val, _, err := tree.Find(rootID, 2, 2); err != nil || val != 50 {
+		t.Errorf("expected 50, got %d", val)
+	}
+
+	// Enough inserts to split leaves on the way
+	for i := uint64(0); i < 10000; i++ {
+		if _, found, err := tree.PutIfAbsent(rootID, 100, i, i); err != nil || found {
+			t.Fatalf("PutIfAbsent(100, %d): %v, %v", i, found, err)
+		}
+	}
+	if count, err := tree.Count(rootID); err != nil || count != 10002 {
+		t.Errorf("expected 10002 entries, got %d", count)
+	}
+}
+
+// forkRootPage returns the root page of a root in the file at path.
+func forkRootPage(t *testing.T, path string, rootID bptree2.RootID) bpager.PageID {
+	t.Helper()
+	p, err := bpager.Open(path)
+	if err != nil {
+		t.Fatalf("bpager.Open failed: %v", err)
+	}
+	defer p.Close()
+	pageID, err := p.GetRootPage(rootID)
+	if err != nil {
+		t.Fatalf("GetRootPage failed: %v", err)
+	}
+	return pageID
+}
+
+func TestConditionalWritesKeepSharedPages(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	srcID, _ := tree.CreateRoot()
+	for i := uint64(0); i < 1000; i++ {
+		tree.Insert(srcID, i, 0, i)
+	}
+	forkID, err := tree.ForkRoot(srcID)
+	if err != nil {
+		t.Fatalf("ForkRoot failed: %v", err)
+	}
+	tree.Close()
+	shared := forkRootPage(t, path, forkID)
+
+	// Writes that turn out not to be needed leave the fork sharing its root
+	tree, err = bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if _, found, err := tree.PutIfAbsent(forkID, 5, 0, 50); err != nil || !found {
+		t.Errorf("PutIfAbsent of an existing key: %v, %v", found, err)
+	}
+	if ok, err := tree.CompareAndSwap(forkID, 5, 0, 4, 50); err != nil || ok {
+		t.Errorf("CompareAndSwap with a stale value: %v, %v", ok, err)
+	}
+	if _, err := tree.Replace(forkID, 5000, 0, 50); !errors.Is(err, bptree2.ErrKeyNotFound) {
+		t.Errorf("Replace of a missing key: expected ErrKeyNotFound, got %v", err)
+	}
+	tree.Close()
+	if pageID := forkRootPage(t, path, forkID); pageID != shared {
+		t.Errorf("fork root moved from page %d to %d", shared, pageID)
+	}
+
+	// A write that happens copies it
+	tree, err = bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if _, found, err := tree.PutIfAbsent(forkID, 5000, 0, 50); err != nil || found {
+		t.Errorf("PutIfAbsent of a missing key: %v, %v", found, err)
+	}
+	tree.Close()
+	if pageID := forkRootPage(t, path, forkID); pageID == shared {
+		t.Error("PutIfAbsent of a missing key should copy the shared root")
+	}
+}
+
+func TestConcurrentAdd(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer tree.Close()
+
+	rootID, _ := tree.CreateRoot()
+
+	// Counters spread over enough keys that increments split leaves
+	numWriters := 8
+	perWriter := 2000
+	keys := 500
+	var wg sync.WaitGroup
+	for w := 0; w < numWriters; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				key := uint64(i % keys)
+				switch i % 3 {
+				case 0:
+					if _, err := tree.Add(rootID, key, 0, 1); err != nil {
+						t.Errorf("Add failed: %v", err)
+						return
+					}
+				case 1:
+					err := tree.Update(rootID, key, 0, func(found bool, value uint64) uint64 {
+						return value + 1
+					})
+					if err != nil {
+						t.Errorf("Update failed: %v", err)
+						return
+					}
+				default:
+					for {
+						old, found, err := tree.Find(rootID, key, 0)
+						if err != nil {
+							t.Errorf("Find failed: %v", err)
+							return
+						}
+						if !found {
+							if _, err := tree.Add(rootID, key, 0, 1); err != nil {
+								t.Errorf("Add failed: %v", err)
+							}
+							break
+						}
+						ok, err := tree.CompareAndSwap(rootID, key, 0, old, old+1)
+						if err != nil {
+							t.Errorf("CompareAndSwap failed: %v", err)
+							return
+						}
+						if ok {
+							break
+						}
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	var total uint64
+	tree.FindRange(rootID, 0, 0, ^uint64(0), ^uint64(0), func(key1, key2, value uint64) bool {
+		total += value
+		return true
+	})
+	if expected := uint64(numWriters * perWriter); total != expected {
+		t.Errorf("expected counters to sum to %d, got %d", expected, total)
+	}
+}
@@ -0,0 +1,196 @@
+package bptree2
+
+import (
+	"container/heap"
+	"fmt"
+	"iter"
+
+	"bptree2/bnode"
+	"bptree2/bpager"
+)
+
+// upgrade brings a version 2 file up to bpager.Version.
+// The upgrade runs in a single transaction and is flashed right away.
+func (t *BPTree) upgrade() error {
+	version := t.pager.Version()
+	if version == bpager.Version {
+		return nil
+	}
+
+	err := t.update(func(tx *Tx) error {
+		// Leaf entries carry flags now, and internal nodes route on
+		// composite keys and hold subtree counts, so every root is rebuilt
+		// from its leaves. SetVersion moves the roots from the meta page
+		// table into a root directory and has the Flash below give every
+		// page a checksum.
+		if err := tx.rebuildLegacyRoots(); err != nil {
+			return err
+		}
+		return tx.pages.SetVersion(bpager.Version)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upgrade file from version %d: %w", version, err)
+	}
+	return t.pager.Flash()
+}
+
+// rebuildLegacyRoots rebuilds every root from leaves in the version 2
+// format. The entries stream from the old leaves into BulkLoad (see
+// legacyEntries); the old pages are freed once the new tree is built.
+func (tx *Tx) rebuildLegacyRoots() error {
+	for rootID := range tx.pages.RootLimit() {
+		rootPageID := tx.rootPage(rootID)
+		if rootPageID == 0 {
+			continue
+		}
+
+		internals, leaves, err := tx.legacyPages(rootPageID)
+		if err != nil {
+			return err
+		}
+		if err := tx.pages.SetRootPage(rootID, bpager.ReservedMarker); err != nil {
+			return err
+		}
+		if err := tx.BulkLoad(rootID, tx.legacyEntries(leaves), nil); err != nil {
+			return fmt.Errorf("failed to rebuild root %d: %w", rootID, err)
+		}
+
+		for _, pageID := range append(internals, leaves...) {
+			if err := tx.pages.FreePage(pageID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// legacyPages returns the internal pages and, in key order, the leaves of
+// the subtree rooted at pageID. Only the child pointers of internal nodes
+// are read; their layout did not change.
+func (tx *Tx) legacyPages(pageID bpager.PageID) (internals, leaves []bpager.PageID, err error) {
+	data := tx.page(pageID)
+	if data == nil {
+		return nil, nil, fmt.Errorf("failed to get page %d", pageID)
+	}
+
+	if bnode.GetNodeType(data) != bnode.NodeTypeInternal {
+		return nil, []bpager.PageID{pageID}, nil
+	}
+
+	internals = []bpager.PageID{pageID}
+	internal := bnode.NewInternalNode(data, false)
+	for i := 0; i <= internal.KeyCount(); i++ {
+		childInternals, childLeaves, err := tx.legacyPages(internal.GetChild(i))
+		if err != nil {
+			return nil, nil, err
+		}
+		internals = append(internals, childInternals...)
+		leaves = append(leaves, childLeaves...)
+	}
+	return internals, leaves, nil
+}
+
+// legacyEntries yields the entries of version 2 leaves, given in tree
+// order, in key order.
+//
+// Version 2 routed keys by key1 alone. Once a key1 spread over several
+// leaves, its keys could land in any of them, so the leaves of such a run
+// are each sorted but may overlap, and a key may be stored in more than
+// one. The leaves of a run are merged; of a key stored twice the entry in
+// the rightmost leaf, which lookups found, is kept. Only a position in each
+// leaf of the current run is held in memory.
+func (tx *Tx) legacyEntries(leaves []bpager.PageID) iter.Seq2[Key, uint64] {
+	return func(yield func(Key, uint64) bool) {
+		for start := 0; start < len(leaves); {
+			// A run ends before a leaf that starts past the key1s of the run
+			end := start + 1
+			_, last, _ := tx.legacyKey1s(leaves[start])
+			for ; end < len(leaves); end++ {
+				first, next, ok := tx.legacyKey1s(leaves[end])
+				if ok && first > last {
+					break
+				}
+				last = max(last, next)
+			}
+
+			var h legacyHeap
+			for i, pageID := range leaves[start:end] {
+				c := &legacyCursor{index: i, pageID: pageID}
+				if c.next(tx) {
+					h = append(h, c)
+				}
+			}
+			heap.Init(&h)
+			for len(h) > 0 {
+				entry := h[0].entry
+				for len(h) > 0 && h[0].entry.Key1 == entry.Key1 && h[0].entry.Key2 == entry.Key2 {
+					if h[0].next(tx) {
+						heap.Fix(&h, 0)
+					} else {
+						heap.Pop(&h)
+					}
+				}
+				if !yield(Key{Key1: entry.Key1, Key2: entry.Key2}, entry.Value) {
+					return
+				}
+			}
+			start = end
+		}
+	}
+}
+
+// legacyKey1s returns the key1 of the first and the last entry of a
+// version 2 leaf. Returns false if the leaf is empty.
+func (tx *Tx) legacyKey1s(pageID bpager.PageID) (first, last uint64, ok bool) {
+	data := tx.page(pageID)
+	count := int(bnode.GetKeyCount(data))
+	if count == 0 {
+		return 0, 0, false
+	}
+	return bnode.LegacyLeafEntry(data, 0).Key1, bnode.LegacyLeafEntry(data, count-1).Key1, true
+}
+
+// legacyCursor is a position in a version 2 leaf, for legacyEntries.
+type legacyCursor struct {
+	index  int // position of the leaf in its run
+	pageID bpager.PageID
+	pos    int          // position of the next entry
+	entry  bnode.KVPair // current entry
+}
+
+// next moves the cursor to the next entry of its leaf.
+// Returns false at the end of the leaf.
+func (c *legacyCursor) next(tx *Tx) bool {
+	data := tx.page(c.pageID)
+	if c.pos >= int(bnode.GetKeyCount(data)) {
+		return false
+	}
+	c.entry = bnode.LegacyLeafEntry(data, c.pos)
+	c.pos++
+	return true
+}
+
+// legacyHeap orders the cursors of a run by key, and of equal keys puts
+// the cursor of the later leaf first.
+type legacyHeap []*legacyCursor
+
+func (h legacyHeap) Len() int { return len(h) }
+
+func (h legacyHeap) Less(i, j int) bool {
+	a, b := h[i].entry, h[j].entry
+	if c := (Key{Key1: a.Key1, Key2: a.Key2}).Compare(Key{Key1: b.Key1, Key2: b.Key2}); c != 0 {
+		return c < 0
+	}
+	return h[i].index > h[j].index
+}
+
+func (h legacyHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *legacyHeap) Push(x any) { *h = append(*h, x.(*legacyCursor)) }
+
+func (h *legacyHeap) Pop() any {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
@@ -0,0 +1,436 @@
+package bptree2_test
+
+import (
+	"bptree2"
+	"bptree2/bnode"
+	"bptree2/bpager"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// downgrade rewrites a file as version 2 wrote it: internal nodes with
+// key1-only separators and no subtree counts, and leaf entries without flags
+// in leaves linked to the next one.
+func downgrade(t *testing.T, path string) {
+	t.Helper()
+
+	p, err := bpager.Open(path)
+	if err != nil {
+		t.Fatalf("bpager.Open failed: %v", err)
+	}
+	defer p.Close()
+
+	tx := p.Begin(true)
+	for rootID := range tx.RootLimit() {
+		pageID := rootPage(t, tx, rootID)
+		if pageID == 0 {
+			continue
+		}
+		downgradeInternal(t, tx, pageID)
+
+		leaves := treeLeaves(t, tx, pageID)
+		for i, pageID := range leaves {
+			data := getPage(t, tx.GetPageForWrite, pageID)
+			leaf := bnode.NewLeafNode(data, false)
+
+			// Leaves were linked to the next leaf in bytes 3-10
+			var next uint64
+			if i+1 < len(leaves) {
+				next = leaves[i+1]
+			}
+			binary.BigEndian.PutUint64(data[3:11], next)
+
+			// Entries were 24 bytes, without the flags byte
+			entries := make([]byte, leaf.KeyCount()*bnode.LegacyLeafEntrySize)
+			for i := 0; i < leaf.KeyCount(); i++ {
+				off := i * bnode.LegacyLeafEntrySize
+				binary.BigEndian.PutUint64(entries[off:], leaf.GetKey1At(i))
+				binary.BigEndian.PutUint64(entries[off+8:], leaf.GetKey2At(i))
+				binary.BigEndian.PutUint64(entries[off+16:], leaf.GetValueAt(i))
+			}
+			copy(data[bnode.HeaderSize:], entries)
+		}
+	}
+	if err := tx.SetVersion(2); err != nil {
+		t.Fatalf("SetVersion failed: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+}
+
+// getPage returns a page read with get, failing the test on an error.
+func getPage(t *testing.T, get func(bpager.PageID) ([]byte, error), pageID bpager.PageID) []byte {
+	t.Helper()
+	data, err := get(pageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// rootPage returns the root page of rootID, failing the test on an error.
+func rootPage(t *testing.T, tx *bpager.Tx, rootID bpager.RootID) bpager.PageID {
+	t.Helper()
+	pageID, err := tx.GetRootPage(rootID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pageID
+}
+
+// treeLeaves returns the leaves of the subtree rooted at pageID in key order.
+func treeLeaves(t *testing.T, tx *bpager.Tx, pageID bpager.PageID) []bpager.PageID {
+	data := getPage(t, tx.GetPage, pageID)
+	if bnode.GetNodeType(data) != bnode.NodeTypeInternal {
+		return []bpager.PageID{pageID}
+	}
+	internal := bnode.NewInternalNode(data, false)
+	var leaves []bpager.PageID
+	for i := 0; i <= internal.KeyCount(); i++ {
+		leaves = append(leaves, treeLeaves(t, tx, internal.GetChild(i))...)
+	}
+	return leaves
+}
+
+// downgradeInternal rewrites the internal nodes of a subtree as version 2
+// wrote them: 8-byte key1 separators after room for 255 children, and no
+// subtree counts.
+func downgradeInternal(t *testing.T, tx *bpager.Tx, pageID bpager.PageID) {
+	data := getPage(t, tx.GetPage, pageID)
+	if bnode.GetNodeType(data) != bnode.NodeTypeInternal {
+		return
+	}
+	internal := bnode.NewInternalNode(data, false)
+	keys := make([]bnode.Key, internal.KeyCount())
+	for i := range keys {
+		keys[i] = internal.GetKey(i)
+	}
+	for i := 0; i <= internal.KeyCount(); i++ {
+		downgradeInternal(t, tx, internal.GetChild(i))
+	}
+
+	data = getPage(t, tx.GetPageForWrite, pageID)
+	clear(data[bnode.HeaderSize+(bnode.MaxInternalKeys+1)*8:])
+	for i, key := range keys {
+		binary.BigEndian.PutUint64(data[bnode.HeaderSize+255*8+i*8:], key.Key1)
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	root1, _ := tree.CreateRoot()
+	root2, _ := tree.CreateRoot()
+	for i := uint64(0); i < 5000; i++ {
+		tree.Insert(root1, i, 0, i)
+		tree.Insert(root2, i, 1, i*2)
+	}
+	tree.Close()
+
+	downgrade(t, path)
+
+	tree, err = bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open of version 2 file failed: %v", err)
+	}
+	defer tree.Close()
+
+	for _, rootID := range []bptree2.RootID{root1, root2} {
+		expected := uint64(4999)
+		count := 0
+		tree.FindRangeReverse(rootID, 0, 0, ^uint64(0), ^uint64(0), func(key1, key2, value uint64) bool {
+			if key1 != expected || value != key1*(rootID-root1+1) {
+				t.Fatalf("root %d: expected key1 %d, got (%d,%d)=%d", rootID, expected, key1, key2, value)
+			}
+			expected--
+			count++
+			return true
+		})
+		if count != 5000 {
+			t.Errorf("root %d: expected 5000 entries in reverse, got %d", rootID, count)
+		}
+	}
+
+	// The upgraded tree takes regular deletes
+	for i := uint64(0); i < 5000; i += 2 {
+		if deleted, err := tree.Delete(root1, i, 0); err != nil || !deleted {
+			t.Fatalf("Delete of %d failed", i)
+		}
+	}
+	if count, err := tree.Count(root1); err != nil || count != 2500 {
+		t.Errorf("expected 2500 entries after deletes, got %d", count)
+	}
+}
+
+func TestUpgradeRootTable(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	for i := uint64(0); i < 10; i++ {
+		rootID, _ := tree.CreateRoot()
+		tree.Insert(rootID, i, i, i)
+	}
+	tree.DeleteRoot(7)
+	tree.DeleteRoot(3)
+	tree.Close()
+
+	// Version 2 kept the roots in a table in the meta page
+	downgrade(t, path)
+
+	tree, err = bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open of version 2 file failed: %v", err)
+	}
+	defer tree.Close()
+
+	if count := tree.RootCount(); count != 8 {
+		t.Errorf("expected 8 roots, got %d", count)
+	}
+	for i := uint64(0); i < 10; i++ {
+		val, found, err := tree.Find(i, i, i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if deleted := i == 3 || i == 7; found == deleted || (found && val != i) {
+			t.Errorf("root %d: got %d, %v", i, val, found)
+		}
+	}
+
+	// Free IDs below the highest one are reused, lowest first, and the old
+	// limit of 500 roots is gone
+	for _, expected := range []bptree2.RootID{3, 7, 10} {
+		if rootID, _ := tree.CreateRoot(); rootID != expected {
+			t.Errorf("expected root %d, got %d", expected, rootID)
+		}
+	}
+	for i := uint64(11); i < 2000; i++ {
+		rootID, err := tree.CreateRoot()
+		if err != nil {
+			t.Fatalf("CreateRoot failed: %v", err)
+		}
+		tree.Insert(rootID, 1, 1, i)
+	}
+	if val, _, err := tree.Find(1999, 1, 1); err != nil || val != 1999 {
+		t.Errorf("root 1999: expected 1999, got %d", val)
+	}
+}
+
+func TestUpgradeChecksums(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	for i := uint64(0); i < 300; i++ {
+		rootID, _ := tree.CreateRoot()
+		tree.Insert(rootID, i, 0, i)
+	}
+	for i := uint64(0); i < 3000; i++ {
+		tree.Insert(0, 1000+i, 0, i)
+	}
+	tree.DeleteRoot(10)
+	tree.Close()
+
+	downgrade(t, path)
+
+	tree, err = bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open of version 2 file failed: %v", err)
+	}
+	check := func() {
+		t.Helper()
+		if count := tree.RootCount(); count != 299 {
+			t.Errorf("expected 299 roots, got %d", count)
+		}
+		for i := uint64(0); i < 300; i++ {
+			if v, found, err := tree.Find(i, i, 0); err != nil || found != (i != 10) || (found && v != i) {
+				t.Errorf("root %d: got %d (found=%v)", i, v, found)
+			}
+		}
+		if count, err := tree.Count(0); err != nil || count != 3001 {
+			t.Errorf("root 0: expected 3001 entries, got %d", count)
+		}
+	}
+	check()
+	tree.Close()
+
+	// Every page of the upgraded file carries its checksum
+	p, err := bpager.Open(path)
+	if err != nil {
+		t.Fatalf("bpager.Open failed: %v", err)
+	}
+	if v := p.Version(); v != bpager.Version {
+		t.Errorf("expected version %d, got %d", bpager.Version, v)
+	}
+	p.SetVerify(bpager.VerifyAlways)
+	for id := bpager.PageID(1); id < p.PageCount(); id++ {
+		if _, err := p.GetPage(id); err != nil {
+			t.Errorf("page %d: %v", id, err)
+		}
+	}
+	p.Close()
+
+	tree, err = bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	check()
+	tree.Close()
+}
+
+// writeVersion2File writes a file as version 2 wrote it: a single root
+// whose internal node routes by key1 alone, over leaves of 24-byte entries
+// linked to the next leaf.
+func writeVersion2File(t *testing.T, path string, leaves [][]bnode.KVPair) {
+	t.Helper()
+	buf := make([]byte, (2+len(leaves))*bpager.PageSize)
+
+	meta := buf[:bpager.PageSize]
+	binary.BigEndian.PutUint32(meta[8:12], bpager.Magic)
+	binary.BigEndian.PutUint32(meta[12:16], 2)
+	binary.BigEndian.PutUint64(meta[16:24], 1)                     // RootCount
+	binary.BigEndian.PutUint64(meta[24:32], uint64(2+len(leaves))) // PageCount
+	binary.BigEndian.PutUint64(meta[bpager.LegacyMetaPageHeaderSize:], 1)
+
+	internal := buf[bpager.PageSize : 2*bpager.PageSize]
+	internal[0] = byte(bnode.NodeTypeInternal)
+	binary.BigEndian.PutUint16(internal[1:3], uint16(len(leaves)-1))
+	for i, entries := range leaves {
+		pageID := uint64(2 + i)
+		binary.BigEndian.PutUint64(internal[bnode.HeaderSize+i*8:], pageID)
+		if i > 0 {
+			binary.BigEndian.PutUint64(internal[bnode.HeaderSize+255*8+(i-1)*8:], entries[0].Key1)
+		}
+
+		leaf := buf[pageID*bpager.PageSize : (pageID+1)*bpager.PageSize]
+		leaf[0] = byte(bnode.NodeTypeLeaf)
+		binary.BigEndian.PutUint16(leaf[1:3], uint16(len(entries)))
+		if i+1 < len(leaves) {
+			binary.BigEndian.PutUint64(leaf[3:11], pageID+1)
+		}
+		for j, e := range entries {
+			off := bnode.HeaderSize + j*bnode.LegacyLeafEntrySize
+			binary.BigEndian.PutUint64(leaf[off:], e.Key1)
+			binary.BigEndian.PutUint64(leaf[off+8:], e.Key2)
+			binary.BigEndian.PutUint64(leaf[off+16:], e.Value)
+		}
+	}
+	if err := os.WriteFile(path, buf, 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+}
+
+func TestUpgradeUnsortedVersion2(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	// A key1 spread over two leaves, as key1-only routing left it: the
+	// first leaf holds a key past the start of the second, and (1,150) is
+	// stored in both
+	var first, second, third []bnode.KVPair
+	for i := uint64(0); i < 100; i++ {
+		first = append(first, bnode.KVPair{Key1: 1, Key2: i, Value: i})
+		second = append(second, bnode.KVPair{Key1: 1, Key2: 100 + i, Value: 100 + i})
+	}
+	first = append(first, bnode.KVPair{Key1: 1, Key2: 150, Value: 999})
+	for i := uint64(0); i < 10; i++ {
+		third = append(third, bnode.KVPair{Key1: 2, Key2: i, Value: 1000 + i})
+	}
+	writeVersion2File(t, path, [][]bnode.KVPair{first, second, third})
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open of version 2 file failed: %v", err)
+	}
+	defer tree.Close()
+
+	if v, found, err := tree.Find(0, 1, 150); err != nil || !found || v != 150 {
+		t.Errorf("duplicate key: expected the value of the right leaf 150, got %d (found=%v)", v, found)
+	}
+	count := 0
+	var last bptree2.Key
+	err = tree.FindRange(0, 0, 0, ^uint64(0), ^uint64(0), func(key1, key2, value uint64) bool {
+		key := bptree2.Key{Key1: key1, Key2: key2}
+		if count > 0 && key.Compare(last) <= 0 {
+			t.Fatalf("keys out of order: %v after %v", key, last)
+		}
+		last = key
+		count++
+		return true
+	})
+	if err != nil {
+		t.Fatalf("FindRange failed: %v", err)
+	}
+	if count != 210 {
+		t.Errorf("expected 210 entries, got %d", count)
+	}
+}
+
+func TestUpgradeOverlappingLeaves(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "test.db")
+
+	// A key1 spread over three leaves that overlap: the even key2s, the odd
+	// ones, and every third one again, whose value must win
+	var evens, odds, thirds, rest []bnode.KVPair
+	for i := uint64(0); i < 150; i++ {
+		evens = append(evens, bnode.KVPair{Key1: 5, Key2: 2 * i, Value: 2 * i})
+		odds = append(odds, bnode.KVPair{Key1: 5, Key2: 2*i + 1, Value: 2*i + 1})
+	}
+	for i := uint64(0); i < 100; i++ {
+		thirds = append(thirds, bnode.KVPair{Key1: 5, Key2: 3 * i, Value: 1000 + 3*i})
+	}
+	thirds = append(thirds, bnode.KVPair{Key1: 6, Key2: 0, Value: 6})
+	for i := uint64(1); i < 50; i++ {
+		rest = append(rest, bnode.KVPair{Key1: 6, Key2: i, Value: 6})
+	}
+	writeVersion2File(t, path, [][]bnode.KVPair{evens, odds, thirds, rest})
+
+	tree, err := bptree2.Open(path)
+	if err != nil {
+		t.Fatalf("Open of version 2 file failed: %v", err)
+	}
+	defer tree.Close()
+
+	count := 0
+	next := bptree2.Key{Key1: 5}
+	err = tree.FindRange(0, 0, 0, ^uint64(0), ^uint64(0), func(key1, key2, value uint64) bool {
+		if key := (bptree2.Key{Key1: key1, Key2: key2}); key != next {
+			t.Fatalf("expected key %v, got %v", next, key)
+		}
+		expected := key2
+		if key1 == 6 {
+			expected = 6
+		} else if key2%3 == 0 && key2 < 300 {
+			expected = 1000 + key2
+		}
+		if value != expected {
+			t.Errorf("key (%d,%d): expected %d, got %d", key1, key2, expected, value)
+		}
+		if next.Key2++; key1 == 5 && next.Key2 == 300 {
+			next = bptree2.Key{Key1: 6}
+		}
+		count++
+		return true
+	})
+	if err != nil {
+		t.Fatalf("FindRange failed: %v", err)
+	}
+	if count != 350 {
+		t.Errorf("expected 350 entries, got %d", count)
+	}
+}